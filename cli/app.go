@@ -23,16 +23,17 @@ var (
 
 	_ = app.Flag("help-full", "Show help for all commands, including hidden").Action(helpFullAction).Bool()
 
-	repositoryCommands = app.Command("repository", "Commands to manipulate repository.").Alias("repo")
-	cacheCommands      = app.Command("cache", "Commands to manipulate local cache").Hidden()
-	snapshotCommands   = app.Command("snapshot", "Commands to manipulate snapshots.").Alias("snap")
-	policyCommands     = app.Command("policy", "Commands to manipulate snapshotting policies.").Alias("policies")
-	serverCommands     = app.Command("server", "Commands to control HTTP API server.")
-	manifestCommands   = app.Command("manifest", "Low-level commands to manipulate manifest items.").Hidden()
-	contentCommands    = app.Command("content", "Commands to manipulate content in repository.").Alias("contents").Hidden()
-	blobCommands       = app.Command("blob", "Commands to manipulate BLOBs.").Hidden()
-	indexCommands      = app.Command("index", "Commands to manipulate content index.").Hidden()
-	benchmarkCommands  = app.Command("benchmark", "Commands to test performance of algorithms.").Hidden()
+	repositoryCommands  = app.Command("repository", "Commands to manipulate repository.").Alias("repo")
+	cacheCommands       = app.Command("cache", "Commands to manipulate local cache").Hidden()
+	snapshotCommands    = app.Command("snapshot", "Commands to manipulate snapshots.").Alias("snap")
+	policyCommands      = app.Command("policy", "Commands to manipulate snapshotting policies.").Alias("policies")
+	serverCommands      = app.Command("server", "Commands to control HTTP API server.")
+	manifestCommands    = app.Command("manifest", "Low-level commands to manipulate manifest items.").Hidden()
+	contentCommands     = app.Command("content", "Commands to manipulate content in repository.").Alias("contents").Hidden()
+	blobCommands        = app.Command("blob", "Commands to manipulate BLOBs.").Hidden()
+	indexCommands       = app.Command("index", "Commands to manipulate content index.").Hidden()
+	benchmarkCommands   = app.Command("benchmark", "Commands to test performance of algorithms.").Hidden()
+	compressionCommands = app.Command("compression", "Commands to manipulate compression.")
 )
 
 func helpFullAction(ctx *kingpin.ParseContext) error {
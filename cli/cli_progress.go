@@ -26,11 +26,13 @@ type cliProgress struct {
 	cachedBytes            int64
 	hashedBytes            int64
 	nextOutputTimeUnixNano int64
+	queuedPackBytes        int64
 
 	cachedFiles       int32
 	inProgressHashing int32
 	hashedFiles       int32
 	uploadedFiles     int32
+	queuedPacks       int32
 
 	uploading      int32
 	uploadFinished int32
@@ -68,6 +70,11 @@ func (p *cliProgress) HashedBytes(numBytes int64) {
 	p.maybeOutput()
 }
 
+func (p *cliProgress) PackUploadQueue(numPacks int32, numBytes int64) {
+	atomic.StoreInt32(&p.queuedPacks, numPacks)
+	atomic.StoreInt64(&p.queuedPackBytes, numBytes)
+}
+
 func (p *cliProgress) CachedFile(fname string, numBytes int64) {
 	atomic.AddInt64(&p.cachedBytes, numBytes)
 	atomic.AddInt32(&p.cachedFiles, 1)
@@ -122,6 +129,10 @@ func (p *cliProgress) output() {
 		units.BytesStringBase10(uploadedBytes),
 	)
 
+	if queuedPacks := atomic.LoadInt32(&p.queuedPacks); queuedPacks > 0 {
+		line += fmt.Sprintf(", %v packs queued (%v)", queuedPacks, units.BytesStringBase10(atomic.LoadInt64(&p.queuedPackBytes)))
+	}
+
 	if p.previousTotalSize > 0 {
 		percent := (float64(hashedBytes+cachedBytes) * hundredPercent / float64(p.previousTotalSize))
 		if percent > hundredPercent {
@@ -197,4 +208,7 @@ func (p *cliProgress) Finish() {
 
 var progress = &cliProgress{}
 
-var _ snapshotfs.UploadProgress = (*cliProgress)(nil)
+var (
+	_ snapshotfs.UploadProgress          = (*cliProgress)(nil)
+	_ snapshotfs.PackUploadQueueProgress = (*cliProgress)(nil)
+)
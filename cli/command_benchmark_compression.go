@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"hash/fnv"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo/compression"
 
@@ -16,71 +20,179 @@ import (
 var (
 	benchmarkCompressionCommand      = benchmarkCommands.Command("compression", "Run compression benchmarks")
 	benchmarkCompressionBlockSize    = benchmarkCompressionCommand.Flag("block-size", "Size of a block to encrypt").Default("1MB").Bytes()
-	benchmarkCompressionRepeat       = benchmarkCompressionCommand.Flag("repeat", "Number of repetitions").Default("100").Int()
+	benchmarkCompressionRepeat       = benchmarkCompressionCommand.Flag("repeat", "Number of repetitions (0 = 100 for a single block, 1 for a directory)").Default("0").Int()
 	benchmarkCompressionDataFile     = benchmarkCompressionCommand.Flag("data-file", "Use data from the given file instead of empty").ExistingFile()
+	benchmarkCompressionDataDir      = benchmarkCompressionCommand.Flag("data-dir", "Use data from files in the given directory, split into blocks of --block-size").ExistingDir()
 	benchmarkCompressionBySize       = benchmarkCompressionCommand.Flag("by-size", "Sort results by size").Bool()
 	benchmarkCompressionVerifyStable = benchmarkCompressionCommand.Flag("verify-stable", "Verify that compression is stable").Bool()
 )
 
+const (
+	defaultCompressionBenchmarkRepeat          = 100
+	defaultCompressionBenchmarkDirectoryRepeat = 1
+)
+
+// readCompressionBenchmarkDirectory reads all files in the provided directory split into blocks of the provided size,
+// which approximates chunks compressed when taking snapshots.
+func readCompressionBenchmarkDirectory(dirname string, blockSize int) ([][]byte, error) {
+	var blocks [][]byte
+
+	err := filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path) //nolint:gosec
+		if err != nil {
+			return errors.Wrapf(err, "unable to read %v", path)
+		}
+
+		for len(b) > blockSize {
+			blocks = append(blocks, b[0:blockSize])
+			b = b[blockSize:]
+		}
+
+		if len(b) > 0 {
+			blocks = append(blocks, b)
+		}
+
+		return nil
+	})
+
+	return blocks, err
+}
+
+func benchmarkCompressionData() ([][]byte, error) {
+	switch {
+	case *benchmarkCompressionDataDir != "":
+		return readCompressionBenchmarkDirectory(*benchmarkCompressionDataDir, int(*benchmarkCompressionBlockSize))
+
+	case *benchmarkCompressionDataFile != "":
+		d, err := ioutil.ReadFile(*benchmarkCompressionDataFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return [][]byte{d}, nil
+
+	default:
+		return [][]byte{make([]byte, *benchmarkCompressionBlockSize)}, nil
+	}
+}
+
 func runBenchmarkCompressionAction(ctx *kingpin.ParseContext) error {
 	type benchResult struct {
-		compression    compression.Name
-		throughput     float64
-		compressedSize int64
+		compression             compression.Name
+		throughput              float64
+		decompressionThroughput float64
+		compressedSize          int64
+		ratio                   float64
 	}
 
 	var results []benchResult
 
-	data := make([]byte, *benchmarkCompressionBlockSize)
+	blocks, err := benchmarkCompressionData()
+	if err != nil {
+		return errors.Wrap(err, "unable to read data")
+	}
 
-	if *benchmarkCompressionDataFile != "" {
-		d, err := ioutil.ReadFile(*benchmarkCompressionDataFile)
-		if err != nil {
-			return err
-		}
+	var totalSize int64
 
-		data = d
+	for _, b := range blocks {
+		totalSize += int64(len(b))
 	}
 
-	for name, comp := range compression.ByName {
-		printStderr("Benchmarking compressor '%v' (%v x %v bytes)\n", name, *benchmarkCompressionRepeat, len(data))
+	if totalSize == 0 {
+		return errors.New("no data to compress")
+	}
 
-		t0 := time.Now()
+	cnt := *benchmarkCompressionRepeat
+	if cnt <= 0 {
+		cnt = defaultCompressionBenchmarkRepeat
 
-		var compressedSize int64
+		if *benchmarkCompressionDataDir != "" {
+			cnt = defaultCompressionBenchmarkDirectoryRepeat
+		}
+	}
+
+	for name, comp := range compression.ByName {
+		printStderr("Benchmarking compressor '%v' (%v x %v blocks, %v bytes)\n", name, cnt, len(blocks), totalSize)
 
-		var lastHash uint64
+		// sizes of blocks stored in the repository, which stores blocks uncompressed if compression does not help.
+		var compressedSize int64
 
-		cnt := *benchmarkCompressionRepeat
+		compressedBlocks := make([][]byte, len(blocks))
+		lastHashes := make([]uint64, len(blocks))
 
 		var compressed bytes.Buffer
 
+		t0 := time.Now()
+
 		for i := 0; i < cnt; i++ {
-			compressed.Reset()
+			for j, data := range blocks {
+				compressed.Reset()
 
-			if err := comp.Compress(&compressed, data); err != nil {
-				printStderr("compression %q failed: %v\n", name, err)
-				continue
+				if err := comp.Compress(&compressed, data); err != nil {
+					printStderr("compression %q failed: %v\n", name, err)
+					continue
+				}
+
+				if i == 0 {
+					compressedBlocks[j] = append([]byte(nil), compressed.Bytes()...)
+
+					if compressed.Len() < len(data) {
+						compressedSize += int64(compressed.Len())
+					} else {
+						compressedSize += int64(len(data))
+					}
+				}
+
+				if *benchmarkCompressionVerifyStable {
+					h := hashOf(compressed.Bytes())
+
+					if i == 0 {
+						lastHashes[j] = h
+					} else if h != lastHashes[j] {
+						printStderr("compression %q is not stable\n", name)
+					}
+				}
 			}
+		}
 
-			compressedSize = int64(compressed.Len())
+		compressionTime := time.Since(t0)
 
-			if *benchmarkCompressionVerifyStable {
-				h := hashOf(compressed.Bytes())
+		var decompressed bytes.Buffer
 
-				if i == 0 {
-					lastHash = h
-				} else if h != lastHash {
-					printStderr("compression %q is not stable\n", name)
+		t1 := time.Now()
+
+		for i := 0; i < cnt; i++ {
+			for j, data := range compressedBlocks {
+				decompressed.Reset()
+
+				if err := comp.Decompress(&decompressed, data); err != nil {
+					printStderr("decompression %q failed: %v\n", name, err)
 					continue
 				}
+
+				if i == 0 && !bytes.Equal(decompressed.Bytes(), blocks[j]) {
+					printStderr("decompression %q returned invalid data\n", name)
+				}
 			}
 		}
 
-		hashTime := time.Since(t0)
-		bytesPerSecond := float64(len(data)) * float64(cnt) / hashTime.Seconds()
+		decompressionTime := time.Since(t1)
 
-		results = append(results, benchResult{compression: name, throughput: bytesPerSecond, compressedSize: compressedSize})
+		results = append(results, benchResult{
+			compression:             name,
+			throughput:              float64(totalSize) * float64(cnt) / compressionTime.Seconds(),
+			decompressionThroughput: float64(totalSize) * float64(cnt) / decompressionTime.Seconds(),
+			compressedSize:          compressedSize,
+			ratio:                   float64(totalSize) / float64(compressedSize),
+		})
 	}
 
 	if *benchmarkCompressionBySize {
@@ -93,11 +205,17 @@ func runBenchmarkCompressionAction(ctx *kingpin.ParseContext) error {
 		})
 	}
 
-	printStdout("     %-30v %-15v %v\n", "Compression", "Compressed Size", "Throughput")
-	printStdout("-----------------------------------------------------------------\n")
+	printStdout("     %-30v %-15v %-8v %-20v %v\n", "Compression", "Compressed Size", "Ratio", "Throughput", "Decompression")
+	printStdout("--------------------------------------------------------------------------------------------------\n")
 
 	for ndx, r := range results {
-		printStdout("%3d. %-30v %-15v %v / second\n", ndx, r.compression, r.compressedSize, units.BytesStringBase2(int64(r.throughput)))
+		printStdout("%3d. %-30v %-15v %-8.3f %-20v %v\n",
+			ndx,
+			r.compression,
+			r.compressedSize,
+			r.ratio,
+			units.BytesStringBase2(int64(r.throughput))+" / second",
+			units.BytesStringBase2(int64(r.decompressionThroughput))+" / second")
 	}
 
 	return nil
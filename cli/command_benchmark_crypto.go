@@ -81,11 +81,11 @@ func runBenchmarkCryptoAction(ctx *kingpin.ParseContext) error {
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].throughput > results[j].throughput
 	})
-	printStdout("     %-20v %-20v %v\n", "Hash", "Encryption", "Throughput")
+	printStdout("     %-20v %-30v %v\n", "Hash", "Encryption", "Throughput")
 	printStdout("-----------------------------------------------------------------\n")
 
 	for ndx, r := range results {
-		printStdout("%3d. %-20v %-20v %v / second\n", ndx, r.hash, r.encryption, units.BytesStringBase2(int64(r.throughput)))
+		printStdout("%3d. %-20v %-30v %v / second\n", ndx, r.hash, r.encryption, units.BytesStringBase2(int64(r.throughput)))
 	}
 
 	return nil
@@ -1,26 +1,138 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sort"
 	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo/splitter"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	benchmarkSplitterCommand    = benchmarkCommands.Command("splitter", "Run splitter benchmarks")
+	benchmarkSplitterCommand    = benchmarkCommands.Command("splitter", "Run splitter benchmarks").Alias("splitters")
 	benchmarkSplitterRandSeed   = benchmarkSplitterCommand.Flag("rand-seed", "Random seed").Default("42").Int64()
 	benchmarkSplitterBlockSize  = benchmarkSplitterCommand.Flag("data-size", "Size of a data to split").Default("32MB").Bytes()
 	benchmarkSplitterBlockCount = benchmarkSplitterCommand.Flag("block-count", "Number of data blocks to split").Default("16").Int()
+
+	benchmarkSplitterCorpus           = benchmarkSplitterCommand.Flag("corpus", "Directory or file with data to split instead of random data, can be repeated to provide modified versions of the same data").ExistingFilesOrDirs()
+	benchmarkSplitterModifiedVersions = benchmarkSplitterCommand.Flag("modified-versions", "Number of randomly modified versions of the data to add").Default("0").Int()
+	benchmarkSplitterModifications    = benchmarkSplitterCommand.Flag("modifications", "Number of random edits in each modified version of a data block").Default("10").Int()
 )
 
+// readSplitterCorpus reads all files in the provided directories or files into memory.
+func readSplitterCorpus(paths []string) ([][]byte, error) {
+	var result [][]byte
+
+	for _, p := range paths {
+		if err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			b, err := ioutil.ReadFile(path) //nolint:gosec
+			if err != nil {
+				return errors.Wrapf(err, "unable to read %v", path)
+			}
+
+			result = append(result, b)
+
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// modifyRandomly returns a copy of the data with the provided number of random insertions,
+// deletions and overwrites of short runs of bytes.
+func modifyRandomly(rnd *rand.Rand, data []byte, modifications int) []byte {
+	const maxEditLength = 100
+
+	result := append([]byte(nil), data...)
+
+	for i := 0; i < modifications; i++ {
+		pos := rnd.Intn(len(result) + 1)
+		edit := make([]byte, 1+rnd.Intn(maxEditLength))
+		rnd.Read(edit) //nolint:errcheck
+
+		switch rnd.Intn(3) { //nolint:gomnd
+		case 0: // insert
+			result = append(result[0:pos], append(edit, result[pos:]...)...)
+
+		case 1: // delete
+			end := pos + len(edit)
+			if end > len(result) {
+				end = len(result)
+			}
+
+			result = append(result[0:pos], result[end:]...)
+
+		default: // overwrite
+			copy(result[pos:], edit)
+		}
+	}
+
+	return result
+}
+
+func benchmarkSplitterData() ([][]byte, error) {
+	var dataBlocks [][]byte
+
+	rnd := rand.New(rand.NewSource(*benchmarkSplitterRandSeed)) //nolint:gosec
+
+	if len(*benchmarkSplitterCorpus) > 0 {
+		d, err := readSplitterCorpus(*benchmarkSplitterCorpus)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read corpus")
+		}
+
+		dataBlocks = d
+	} else {
+		for i := 0; i < *benchmarkSplitterBlockCount; i++ {
+			b := make([]byte, *benchmarkSplitterBlockSize)
+			if _, err := rnd.Read(b); err != nil {
+				return nil, err
+			}
+
+			dataBlocks = append(dataBlocks, b)
+		}
+	}
+
+	originals := dataBlocks
+
+	for v := 0; v < *benchmarkSplitterModifiedVersions; v++ {
+		for _, b := range originals {
+			if len(b) > 0 {
+				dataBlocks = append(dataBlocks, modifyRandomly(rnd, b, *benchmarkSplitterModifications))
+			}
+		}
+	}
+
+	return dataBlocks, nil
+}
+
 func runBenchmarkSplitterAction(ctx *kingpin.ParseContext) error {
 	type benchResult struct {
 		splitter     string
 		duration     time.Duration
+		throughput   float64
+		dedupRatio   float64
+		uniqueBytes  int64
 		segmentCount int
 		min          int
 		p10          int
@@ -33,30 +145,34 @@ func runBenchmarkSplitterAction(ctx *kingpin.ParseContext) error {
 
 	var results []benchResult
 
-	// generate data blocks
-	var dataBlocks [][]byte
+	dataBlocks, err := benchmarkSplitterData()
+	if err != nil {
+		return err
+	}
 
-	rnd := rand.New(rand.NewSource(*benchmarkSplitterRandSeed))
+	var totalBytes int64
 
-	for i := 0; i < *benchmarkSplitterBlockCount; i++ {
-		b := make([]byte, *benchmarkSplitterBlockSize)
-		if _, err := rnd.Read(b); err != nil {
-			return err
-		}
+	for _, b := range dataBlocks {
+		totalBytes += int64(len(b))
+	}
 
-		dataBlocks = append(dataBlocks, b)
+	if totalBytes == 0 {
+		return errors.New("no data to split")
 	}
 
-	printStderr("splitting %v blocks of %v each\n", *benchmarkSplitterBlockCount, *benchmarkSplitterBlockSize)
+	printStderr("splitting %v blocks with total size of %v\n", len(dataBlocks), units.BytesStringBase10(totalBytes))
 
 	for _, sp := range splitter.SupportedAlgorithms() {
 		fact := splitter.GetFactory(sp)
 
 		var segmentLengths []int
 
+		// segment lengths of each block, in order
+		blockSegments := make([][]int, len(dataBlocks))
+
 		t0 := time.Now()
 
-		for _, data := range dataBlocks {
+		for i, data := range dataBlocks {
 			s := fact()
 			l := 0
 
@@ -64,23 +180,47 @@ func runBenchmarkSplitterAction(ctx *kingpin.ParseContext) error {
 				l++
 
 				if s.ShouldSplit(d) {
-					segmentLengths = append(segmentLengths, l)
+					blockSegments[i] = append(blockSegments[i], l)
 					l = 0
 				}
 			}
 
 			if l > 0 {
-				segmentLengths = append(segmentLengths, l)
+				blockSegments[i] = append(blockSegments[i], l)
 			}
 		}
 
 		dur := time.Since(t0)
 
+		// determine unique segments across all blocks
+		unique := map[[sha256.Size]byte]bool{}
+
+		var uniqueBytes int64
+
+		for i, data := range dataBlocks {
+			offset := 0
+
+			for _, l := range blockSegments[i] {
+				h := sha256.Sum256(data[offset : offset+l])
+				if !unique[h] {
+					unique[h] = true
+					uniqueBytes += int64(l)
+				}
+
+				offset += l
+			}
+
+			segmentLengths = append(segmentLengths, blockSegments[i]...)
+		}
+
 		sort.Ints(segmentLengths)
 
 		r := benchResult{
 			sp,
 			dur,
+			float64(totalBytes) / dur.Seconds(),
+			float64(totalBytes) / float64(uniqueBytes),
+			uniqueBytes,
 			len(segmentLengths),
 			segmentLengths[0],
 			segmentLengths[len(segmentLengths)*10/100],
@@ -91,9 +231,12 @@ func runBenchmarkSplitterAction(ctx *kingpin.ParseContext) error {
 			segmentLengths[len(segmentLengths)-1],
 		}
 
-		printStdout("%-25v %6v ms count:%v min:%v 10th:%v 25th:%v 50th:%v 75th:%v 90th:%v max:%v\n",
+		printStdout("%-25v %6v ms %v/s dedup:%.3fx unique:%v count:%v min:%v 10th:%v 25th:%v 50th:%v 75th:%v 90th:%v max:%v\n",
 			r.splitter,
 			r.duration.Nanoseconds()/1e6,
+			units.BytesStringBase10(int64(r.throughput)),
+			r.dedupRatio,
+			units.BytesStringBase10(r.uniqueBytes),
 			r.segmentCount,
 			r.min, r.p10, r.p25, r.p50, r.p75, r.p90, r.max)
 
@@ -101,15 +244,22 @@ func runBenchmarkSplitterAction(ctx *kingpin.ParseContext) error {
 	}
 
 	sort.Slice(results, func(i, j int) bool {
+		if results[i].dedupRatio != results[j].dedupRatio {
+			return results[i].dedupRatio > results[j].dedupRatio
+		}
+
 		return results[i].duration < results[j].duration
 	})
 	printStdout("-----------------------------------------------------------------\n")
 
 	for ndx, r := range results {
-		printStdout("%3v. %-25v %6v ms count:%v min:%v 10th:%v 25th:%v 50th:%v 75th:%v 90th:%v max:%v\n",
+		printStdout("%3v. %-25v %6v ms %v/s dedup:%.3fx unique:%v count:%v min:%v 10th:%v 25th:%v 50th:%v 75th:%v 90th:%v max:%v\n",
 			ndx,
 			r.splitter,
 			r.duration.Nanoseconds()/1e6,
+			units.BytesStringBase10(int64(r.throughput)),
+			r.dedupRatio,
+			units.BytesStringBase10(r.uniqueBytes),
 			r.segmentCount,
 			r.min, r.p10, r.p25, r.p50, r.p75, r.p90, r.max)
 	}
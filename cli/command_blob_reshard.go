@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/blob/sftp"
+	"github.com/kopia/kopia/repo/blob/sharded"
+	"github.com/kopia/kopia/repo/blob/webdav"
+)
+
+const reshardProgressInterval = 1000
+
+var (
+	blobReshardCommand = blobCommands.Command("reshard", "Move blobs into a new directory layout. The process can be interrupted and resumed by running the command again.")
+	blobReshardShards  = blobReshardCommand.Flag("shards", "Comma-separated lengths of shard directories (e.g. 2,2,2) or 'flat'. Defaults to resuming the migration in progress.").String()
+)
+
+func runBlobReshardCommand(ctx context.Context, rep *repo.Repository) error {
+	ci := rep.Blobs.ConnectionInfo()
+
+	st, rs, err := openResharder(ctx, ci)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		st.Close(ctx) //nolint:errcheck
+	}()
+
+	current, previous := rs.Layout()
+	target := current
+
+	if *blobReshardShards != "" {
+		if target, err = sharded.ParseShards(*blobReshardShards); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case previous != nil && !sameLayout(target, current):
+		return errors.Errorf("migration to %v is already in progress, run the command without --shards to complete it first", sharded.FormatShards(current))
+
+	case previous == nil && sameLayout(target, current):
+		printStderr("Storage already uses layout %v.\n", sharded.FormatShards(current))
+		return nil
+
+	case previous == nil:
+		// persist the new layout before moving any blobs, so that both layouts are readable
+		// by all clients if the process is interrupted.
+		if err = saveLayout(ctx, rep, rs, target, current); err != nil {
+			return err
+		}
+
+		st.Close(ctx) //nolint:errcheck
+
+		if st, rs, err = openResharder(ctx, ci); err != nil {
+			return err
+		}
+
+		previous = current
+	}
+
+	printStderr("Moving blobs from layout %v to %v...\n", sharded.FormatShards(previous), sharded.FormatShards(target))
+
+	moved := 0
+
+	cnt, err := rs.Reshard(ctx, func(blobID blob.ID) {
+		moved++
+		if moved%reshardProgressInterval == 0 {
+			printStderr("  moved %v blobs\n", moved)
+		}
+	})
+	if err != nil {
+		return errors.Wrapf(err, "re-sharding failed after moving %v blobs, run the command again to resume", cnt)
+	}
+
+	if err := saveLayout(ctx, rep, rs, target, nil); err != nil {
+		return err
+	}
+
+	printStderr("Moved %v blobs, storage now uses layout %v.\n", cnt, sharded.FormatShards(target))
+
+	return nil
+}
+
+// openResharder opens the provided storage, which must support re-sharding.
+func openResharder(ctx context.Context, ci blob.ConnectionInfo) (blob.Storage, sharded.Resharder, error) {
+	st, err := blob.NewStorage(ctx, ci)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to open storage")
+	}
+
+	rs, ok := st.(sharded.Resharder)
+	if !ok {
+		st.Close(ctx) //nolint:errcheck
+		return nil, nil, errors.Errorf("storage type %v does not support re-sharding", ci.Type)
+	}
+
+	return st, rs, nil
+}
+
+// saveLayout persists the provided directory layout in the repository connection configuration and in the storage itself,
+// where it's picked up by other clients the next time they open it.
+func saveLayout(ctx context.Context, rep *repo.Repository, rs sharded.Resharder, shards, previousShards []int) error {
+	ci, err := withShards(rep.Blobs.ConnectionInfo(), shards, previousShards)
+	if err != nil {
+		return err
+	}
+
+	if err := rs.SetLayout(ctx, shards, previousShards); err != nil {
+		return errors.Wrap(err, "unable to save directory layout")
+	}
+
+	if err := rep.SetStorageConnectionInfo(ctx, ci); err != nil {
+		return errors.Wrap(err, "unable to save storage configuration")
+	}
+
+	return nil
+}
+
+// withShards returns a copy of the connection info with the provided directory layout.
+func withShards(ci blob.ConnectionInfo, shards, previousShards []int) (blob.ConnectionInfo, error) {
+	switch o := ci.Config.(type) {
+	case *filesystem.Options:
+		opt := *o
+		opt.DirectoryShards, opt.PreviousDirectoryShards = shards, previousShards
+		ci.Config = &opt
+
+	case *webdav.Options:
+		opt := *o
+		opt.DirectoryShards, opt.PreviousDirectoryShards = shards, previousShards
+		ci.Config = &opt
+
+	case *sftp.Options:
+		opt := *o
+		opt.DirectoryShards, opt.PreviousDirectoryShards = shards, previousShards
+		ci.Config = &opt
+
+	default:
+		return ci, errors.Errorf("storage type %v does not support re-sharding", ci.Type)
+	}
+
+	return ci, nil
+}
+
+func sameLayout(a, b []int) bool {
+	return sharded.FormatShards(a) == sharded.FormatShards(b)
+}
+
+func init() {
+	blobReshardCommand.Action(repositoryAction(runBlobReshardCommand))
+}
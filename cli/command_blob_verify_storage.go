@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/kopia/kopia/internal/blobverify"
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+var (
+	blobVerifyStorageCommand         = blobCommands.Command("verify-storage", "Verify that the storage provides guarantees required by Kopia")
+	blobVerifyStoragePrefix          = blobVerifyStorageCommand.Flag("prefix", "Blob ID prefix under which to run the checks (default: random)").String()
+	blobVerifyStorageConcurrent      = blobVerifyStorageCommand.Flag("concurrent", "Verify concurrent access").Default("true").Bool()
+	blobVerifyStorageWorkers         = blobVerifyStorageCommand.Flag("concurrent-workers", "Number of concurrent workers of each kind").Default("4").Int()
+	blobVerifyStorageIterations      = blobVerifyStorageCommand.Flag("concurrent-iterations", "Number of iterations of each concurrent worker").Default("20").Int()
+	blobVerifyStorageMaxClockSkew    = blobVerifyStorageCommand.Flag("max-clock-skew", "Maximum allowed difference between local and storage clocks").Default("5m").Duration()
+	blobVerifyStorageLargeBlobSize   = blobVerifyStorageCommand.Flag("large-blob-size", "Size of a large blob to verify").Default("64MB").Bytes()
+	blobVerifyStorageBenchmark       = blobVerifyStorageCommand.Flag("benchmark", "Run throughput benchmark").Default("true").Bool()
+	blobVerifyStorageBenchmarkSize   = blobVerifyStorageCommand.Flag("benchmark-blob-size", "Size of blobs used in throughput benchmark").Default("20MB").Bytes()
+	blobVerifyStorageBenchmarkCount  = blobVerifyStorageCommand.Flag("benchmark-blob-count", "Number of blobs used in throughput benchmark").Default("16").Int()
+	blobVerifyStorageBenchmarkWorker = blobVerifyStorageCommand.Flag("benchmark-parallel", "Number of parallel uploads and downloads in throughput benchmark").Default("4").Int()
+)
+
+func runVerifyStorageCheck(name string, check func() error) (passed bool) {
+	t0 := time.Now()
+
+	printStderr("Verifying %v...\n", name)
+
+	err := check()
+
+	status := "PASS"
+	if err != nil {
+		printStderr("    error: %v\n", err)
+
+		status = "FAIL"
+	}
+
+	printStdout("%-30v %v (%v)\n", name, status, time.Since(t0).Truncate(time.Millisecond))
+
+	return err == nil
+}
+
+func runBlobVerifyStorageCommand(ctx context.Context, rep *repo.Repository) error {
+	prefix := blob.ID(*blobVerifyStoragePrefix)
+	if prefix == "" {
+		b := make([]byte, 8)
+		if _, err := cryptorand.Read(b); err != nil {
+			return errors.Wrap(err, "unable to generate random prefix")
+		}
+
+		prefix = blob.ID(fmt.Sprintf("zz-verify-%x-", b))
+	}
+
+	st := blobverify.NewPrefixStorage(rep.Blobs, prefix)
+
+	printStderr("Running storage checks using blobs with prefix %q\n", prefix)
+
+	defer cleanupVerifyStorage(ctx, rep.Blobs, prefix)
+
+	checks := []struct {
+		name    string
+		enabled bool
+		check   func() error
+	}{
+		{"basic operations", true, func() error {
+			return blobverify.VerifyStorage(ctx, st)
+		}},
+		{"timestamps", true, func() error {
+			return blobverify.VerifyTimestamps(ctx, st, *blobVerifyStorageMaxClockSkew)
+		}},
+		{"range reads", true, func() error {
+			return blobverify.VerifyRangeReads(ctx, st)
+		}},
+		{"large blob", *blobVerifyStorageLargeBlobSize > 0, func() error {
+			return blobverify.VerifyLargeBlob(ctx, st, int(*blobVerifyStorageLargeBlobSize))
+		}},
+		{"concurrent access", *blobVerifyStorageConcurrent, func() error {
+			return blobverify.VerifyConcurrentAccess(ctx, blobverify.NewPrefixStorage(st, "concurrent-"), blobverify.ConcurrentAccessOptions{
+				NumBlobs:                        16,
+				Getters:                         *blobVerifyStorageWorkers,
+				Putters:                         *blobVerifyStorageWorkers,
+				Deleters:                        *blobVerifyStorageWorkers,
+				Listers:                         *blobVerifyStorageWorkers,
+				Iterations:                      *blobVerifyStorageIterations,
+				RangeGetPercentage:              10,
+				NonExistentListPrefixPercentage: 10,
+			})
+		}},
+	}
+
+	var failed int
+
+	for _, c := range checks {
+		if !c.enabled {
+			continue
+		}
+
+		if !runVerifyStorageCheck(c.name, c.check) {
+			failed++
+		}
+	}
+
+	if *blobVerifyStorageBenchmark {
+		if err := runStorageThroughputBenchmark(ctx, blobverify.NewPrefixStorage(st, "benchmark-")); err != nil {
+			return errors.Wrap(err, "throughput benchmark failed")
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%v storage checks failed", failed)
+	}
+
+	printStderr("All storage checks passed.\n")
+
+	return nil
+}
+
+func runStorageThroughputBenchmark(ctx context.Context, st blob.Storage) error {
+	count := *blobVerifyStorageBenchmarkCount
+	size := *blobVerifyStorageBenchmarkSize
+
+	data := make([]byte, size)
+	if _, err := cryptorand.Read(data); err != nil {
+		return errors.Wrap(err, "unable to generate random data")
+	}
+
+	var ids []blob.ID
+	for i := 0; i < count; i++ {
+		ids = append(ids, blob.ID(fmt.Sprintf("%020d", i)))
+	}
+
+	printStderr("Benchmarking throughput using %v blobs of %v...\n", count, units.BytesStringBase10(int64(size)))
+
+	for _, op := range []struct {
+		name string
+		run  func(id blob.ID) error
+	}{
+		{"upload", func(id blob.ID) error {
+			return st.PutBlob(ctx, id, data)
+		}},
+		{"download", func(id blob.ID) error {
+			b, err := st.GetBlob(ctx, id, 0, -1)
+			if err == nil && len(b) != len(data) {
+				err = errors.Errorf("invalid length of %v: %v", id, len(b))
+			}
+			return err
+		}},
+		{"delete", func(id blob.ID) error {
+			return st.DeleteBlob(ctx, id)
+		}},
+	} {
+		dur, err := runInParallel(ids, *blobVerifyStorageBenchmarkWorker, op.run)
+		if err != nil {
+			return errors.Wrapf(err, "%v failed", op.name)
+		}
+
+		if op.name == "delete" {
+			printStdout("%-30v %v per blob\n", "benchmark "+op.name, (dur / time.Duration(count)).Truncate(time.Microsecond))
+			continue
+		}
+
+		bytesPerSecond := float64(size) * float64(count) / dur.Seconds()
+		printStdout("%-30v %v / second\n", "benchmark "+op.name, units.BytesStringBase10(int64(bytesPerSecond)))
+	}
+
+	return nil
+}
+
+func runInParallel(ids []blob.ID, parallel int, run func(id blob.ID) error) (time.Duration, error) {
+	var eg errgroup.Group
+
+	work := make(chan blob.ID, len(ids))
+	for _, id := range ids {
+		work <- id
+	}
+
+	close(work)
+
+	t0 := time.Now()
+
+	for i := 0; i < parallel; i++ {
+		eg.Go(func() error {
+			for id := range work {
+				if err := run(id); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	err := eg.Wait()
+
+	return time.Since(t0), err
+}
+
+func cleanupVerifyStorage(ctx context.Context, st blob.Storage, prefix blob.ID) {
+	blobs, err := blob.ListAllBlobs(ctx, st, prefix)
+	if err != nil {
+		log(ctx).Warningf("unable to list blobs with prefix %q: %v", prefix, err)
+		return
+	}
+
+	for _, bm := range blobs {
+		if err := st.DeleteBlob(ctx, bm.BlobID); err != nil {
+			log(ctx).Warningf("unable to delete %v: %v", bm.BlobID, err)
+		}
+	}
+}
+
+func init() {
+	blobVerifyStorageCommand.Action(repositoryAction(runBlobVerifyStorageCommand))
+}
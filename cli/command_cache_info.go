@@ -29,8 +29,9 @@ func runCacheInfoCommand(ctx context.Context, rep *repo.Repository) error {
 	}
 
 	path2Limit := map[string]int64{
-		"contents": rep.Content.CachingOptions.MaxCacheSizeBytes,
-		"metadata": rep.Content.CachingOptions.MaxMetadataCacheSizeBytes,
+		"contents":   rep.Content.CachingOptions.MaxCacheSizeBytes,
+		"metadata":   rep.Content.CachingOptions.MaxMetadataCacheSizeBytes,
+		"seektables": rep.Content.CachingOptions.MaxSeekTableCacheSizeBytes,
 	}
 
 	for _, ent := range entries {
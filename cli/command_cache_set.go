@@ -12,10 +12,12 @@ import (
 var (
 	cacheSetParamsCommand = cacheCommands.Command("set", "Sets parameters local caching of repository data")
 
-	cacheSetDirectory              = cacheSetParamsCommand.Flag("cache-directory", "Directory where to store cache files").String()
-	cacheSetContentCacheSizeMB     = cacheSetParamsCommand.Flag("content-cache-size-mb", "Size of local content cache").PlaceHolder("MB").Default("-1").Int64()
-	cacheSetMaxMetadataCacheSizeMB = cacheSetParamsCommand.Flag("metadata-cache-size-mb", "Size of local metadata cache").PlaceHolder("MB").Default("-1").Int64()
-	cacheSetMaxListCacheDuration   = cacheSetParamsCommand.Flag("max-list-cache-duration", "Duration of index cache").Default("-1ns").Duration()
+	cacheSetDirectory               = cacheSetParamsCommand.Flag("cache-directory", "Directory where to store cache files").String()
+	cacheSetContentCacheSizeMB      = cacheSetParamsCommand.Flag("content-cache-size-mb", "Size of local content cache").PlaceHolder("MB").Default("-1").Int64()
+	cacheSetMaxMetadataCacheSizeMB  = cacheSetParamsCommand.Flag("metadata-cache-size-mb", "Size of local metadata cache").PlaceHolder("MB").Default("-1").Int64()
+	cacheSetMaxSeekTableCacheSizeMB = cacheSetParamsCommand.Flag("seek-table-cache-size-mb", "Size of local cache of seek tables of large objects").PlaceHolder("MB").Default("-1").Int64()
+	cacheSetMaxListCacheDuration    = cacheSetParamsCommand.Flag("max-list-cache-duration", "Duration of index cache").Default("-1ns").Duration()
+	cacheSetPackSpool               = cacheSetParamsCommand.Flag("pack-spool", "Persist packs in the cache directory before uploading them").Enum("true", "false")
 )
 
 func runCacheSetCommand(ctx context.Context, rep *repo.Repository) error {
@@ -43,12 +45,25 @@ func runCacheSetCommand(ctx context.Context, rep *repo.Repository) error {
 		changed++
 	}
 
+	if v := *cacheSetMaxSeekTableCacheSizeMB; v != -1 {
+		v *= 1e6 // convert MB to bytes
+		log(ctx).Infof("changing seek table cache size to %v", units.BytesStringBase10(v))
+		opts.MaxSeekTableCacheSizeBytes = v
+		changed++
+	}
+
 	if v := *cacheSetMaxListCacheDuration; v != -1 {
 		log(ctx).Infof("changing list cache duration to %v", v)
 		opts.MaxListCacheDurationSec = int(v.Seconds())
 		changed++
 	}
 
+	if v := *cacheSetPackSpool; v != "" {
+		log(ctx).Infof("setting pack spool to %v", v)
+		opts.PackSpool = v == "true"
+		changed++
+	}
+
 	if changed == 0 {
 		return errors.Errorf("no changes")
 	}
@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+var (
+	trainDictionaryCommand         = compressionCommands.Command("train-dictionary", "Train zstd dictionary from directories and small files in snapshots.")
+	trainDictionaryMaxSamples      = trainDictionaryCommand.Flag("max-samples", "Maximum number of samples").Default("1000").Int()
+	trainDictionaryIncludeFiles    = trainDictionaryCommand.Flag("include-files", "Include small files in addition to directories").Bool()
+	trainDictionaryMaxFileSize     = trainDictionaryCommand.Flag("max-file-size", "Maximum size of files to include").Default("16KB").Bytes()
+	trainDictionarySize            = trainDictionaryCommand.Flag("dictionary-size", "Maximum dictionary size").Default("64KB").Bytes()
+	trainDictionarySetGlobalPolicy = trainDictionaryCommand.Flag("set-global-policy", "Use the dictionary in the global policy").Bool()
+)
+
+var errEnoughSamples = errors.New("enough samples")
+
+func collectDictionarySamples(ctx context.Context, rep *repo.Repository) ([][]byte, error) {
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshot manifest IDs")
+	}
+
+	manifests, err := snapshot.LoadSnapshots(ctx, rep, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load manifest IDs")
+	}
+
+	var (
+		mu      sync.Mutex
+		samples [][]byte
+	)
+
+	w := snapshotfs.NewTreeWalker()
+	w.EntryID = func(e fs.Entry) interface{} { return e.(object.HasObjectID).ObjectID() }
+
+	for _, m := range manifests {
+		root, err := snapshotfs.SnapshotRoot(rep, m)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get snapshot root")
+		}
+
+		w.RootEntries = append(w.RootEntries, root)
+	}
+
+	w.ObjectCallback = func(entry fs.Entry) error {
+		if _, isDir := entry.(fs.Directory); !isDir {
+			if _, isFile := entry.(fs.File); !isFile || !*trainDictionaryIncludeFiles || entry.Size() > int64(*trainDictionaryMaxFileSize) {
+				return nil
+			}
+		}
+
+		r, err := rep.Objects.Open(ctx, entry.(object.HasObjectID).ObjectID())
+		if err != nil {
+			return errors.Wrap(err, "unable to open object")
+		}
+		defer r.Close() //nolint:errcheck
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "unable to read object")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(samples) >= *trainDictionaryMaxSamples {
+			return errEnoughSamples
+		}
+
+		samples = append(samples, data)
+
+		return nil
+	}
+
+	if err := w.Run(ctx); err != nil && errors.Cause(err) != errEnoughSamples {
+		return nil, errors.Wrap(err, "error walking snapshot tree")
+	}
+
+	return samples, nil
+}
+
+// compressedSizes returns total size of samples compressed with and without the provided dictionary.
+func compressedSizes(samples [][]byte, dict []byte) (withDictionary, withoutDictionary int64, err error) {
+	comp := compression.ByName["zstd"]
+
+	dictComp, err := compression.NewDictionaryCompressor(comp, "test", dict)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, s := range samples {
+		var b1, b2 bytes.Buffer
+
+		if err := dictComp.Compress(&b1, s); err != nil {
+			return 0, 0, err
+		}
+
+		if err := comp.Compress(&b2, s); err != nil {
+			return 0, 0, err
+		}
+
+		withDictionary += int64(b1.Len())
+		withoutDictionary += int64(b2.Len())
+	}
+
+	return withDictionary, withoutDictionary, nil
+}
+
+func runTrainDictionaryCommand(ctx context.Context, rep *repo.Repository) error {
+	samples, err := collectDictionarySamples(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	if len(samples) == 0 {
+		return errors.New("no samples found in snapshots")
+	}
+
+	dict := compression.TrainDictionary(samples, int(*trainDictionarySize))
+	if len(dict) == 0 {
+		return errors.New("samples have no content in common")
+	}
+
+	withDictionary, withoutDictionary, err := compressedSizes(samples, dict)
+	if err != nil {
+		return errors.Wrap(err, "unable to evaluate dictionary")
+	}
+
+	printStderr("Trained %v dictionary from %v samples, which compress to %v with the dictionary and %v without it.\n",
+		units.BytesStringBase2(int64(len(dict))),
+		len(samples),
+		units.BytesStringBase2(withDictionary),
+		units.BytesStringBase2(withoutDictionary))
+
+	dictionaryID, err := rep.Objects.WriteDictionary(ctx, dict)
+	if err != nil {
+		return errors.Wrap(err, "unable to write dictionary")
+	}
+
+	printStdout("%v\n", dictionaryID)
+
+	if !*trainDictionarySetGlobalPolicy {
+		return nil
+	}
+
+	p, err := policy.GetDefinedPolicy(ctx, rep, policy.GlobalPolicySourceInfo)
+	if err == policy.ErrPolicyNotFound {
+		p = &policy.Policy{}
+	} else if err != nil {
+		return errors.Wrap(err, "unable to get global policy")
+	}
+
+	p.CompressionPolicy.Dictionary = dictionaryID
+
+	if err := policy.SetPolicy(ctx, rep, policy.GlobalPolicySourceInfo, p); err != nil {
+		return errors.Wrap(err, "unable to set global policy")
+	}
+
+	printStderr("Global policy updated to use the dictionary.\n")
+
+	return nil
+}
+
+func init() {
+	trainDictionaryCommand.Action(repositoryAction(runTrainDictionaryCommand))
+}
@@ -24,6 +24,8 @@ var (
 func runContentListCommand(ctx context.Context, rep *repo.Repository) error {
 	var totalSize stats.CountSum
 
+	var totalOriginalSize int64
+
 	err := rep.Content.IterateContents(
 		ctx,
 		content.IterateOptions{
@@ -36,18 +38,27 @@ func runContentListCommand(ctx context.Context, rep *repo.Repository) error {
 			}
 
 			totalSize.Add(int64(b.Length))
+			totalOriginalSize += int64(b.OriginalLength)
 
 			if *contentListLong {
 				optionalDeleted := ""
 				if b.Deleted {
 					optionalDeleted = " (deleted)"
 				}
-				fmt.Printf("%v %v %v %v+%v%v\n",
+
+				optionalCompression := ""
+				if n := compressorName(b); n != "" {
+					optionalCompression = " " + n
+				}
+
+				fmt.Printf("%v %v %v %v+%v %v%v%v\n",
 					b.ID,
 					formatTimestamp(b.Timestamp()),
 					b.PackBlobID,
 					b.PackOffset,
 					maybeHumanReadableBytes(*contentListHuman, int64(b.Length)),
+					maybeHumanReadableBytes(*contentListHuman, int64(b.OriginalLength)),
+					optionalCompression,
 					optionalDeleted)
 			} else {
 				fmt.Printf("%v\n", b.ID)
@@ -62,9 +73,11 @@ func runContentListCommand(ctx context.Context, rep *repo.Repository) error {
 
 	if *contentListSummary {
 		count, sz := totalSize.Approximate()
-		fmt.Printf("Total: %v contents, %v total size\n",
+		fmt.Printf("Total: %v contents, %v total size, %v original size (stored %v of original)\n",
 			maybeHumanReadableCount(*contentListHuman, int64(count)),
-			maybeHumanReadableBytes(*contentListHuman, sz))
+			maybeHumanReadableBytes(*contentListHuman, sz),
+			maybeHumanReadableBytes(*contentListHuman, totalOriginalSize),
+			formatCompressionRatio(sz, totalOriginalSize))
 	}
 
 	return nil
@@ -31,8 +31,11 @@ type contentInfoOrError struct {
 }
 
 func runContentRewriteCommand(ctx context.Context, rep *repo.Repository) error {
-	cnt := getContentToRewrite(ctx, rep)
+	return rewriteContents(ctx, rep, getContentToRewrite(ctx, rep), *contentRewriteParallelism, *contentRewriteDryRun)
+}
 
+// rewriteContents rewrites the provided contents using the provided number of parallel workers.
+func rewriteContents(ctx context.Context, rep *repo.Repository, cnt <-chan contentInfoOrError, parallelism int, dryRun bool) error {
 	var (
 		mu          sync.Mutex
 		totalBytes  int64
@@ -41,7 +44,7 @@ func runContentRewriteCommand(ctx context.Context, rep *repo.Repository) error {
 
 	var wg sync.WaitGroup
 
-	for i := 0; i < *contentRewriteParallelism; i++ {
+	for i := 0; i < parallelism; i++ {
 		wg.Add(1)
 
 		go func() {
@@ -67,7 +70,7 @@ func runContentRewriteCommand(ctx context.Context, rep *repo.Repository) error {
 				totalBytes += int64(c.Length)
 				mu.Unlock()
 
-				if *contentRewriteDryRun {
+				if dryRun {
 					continue
 				}
 
@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/kopia/kopia/internal/units"
@@ -29,14 +30,24 @@ func runContentStatsCommand(ctx context.Context, rep *repo.Repository) error {
 		sizeThreshold *= 10
 	}
 
-	var totalSize, count int64
+	var totalSize, totalOriginalSize, count int64
+
+	// stored and original sizes of contents compressed by the content manager, by compressor name.
+	compressedSize := map[string]int64{}
+	compressedOriginalSize := map[string]int64{}
 
 	if err := rep.Content.IterateContents(
 		ctx,
 		content.IterateOptions{},
 		func(b content.Info) error {
 			totalSize += int64(b.Length)
+			totalOriginalSize += int64(b.OriginalLength)
 			count++
+			if n := compressorName(b); n != "" {
+				compressedSize[n] += int64(b.Length)
+				compressedOriginalSize[n] += int64(b.OriginalLength)
+			}
+
 			for s := range countMap {
 				if b.Length < s {
 					countMap[s]++
@@ -55,6 +66,24 @@ func runContentStatsCommand(ctx context.Context, rep *repo.Repository) error {
 
 	fmt.Println("Count:", count)
 	fmt.Println("Total:", sizeToString(totalSize))
+	fmt.Println("Original:", sizeToString(totalOriginalSize))
+	fmt.Println("Stored/original:", formatCompressionRatio(totalSize, totalOriginalSize))
+
+	var compressorNames []string
+	for n := range compressedSize {
+		compressorNames = append(compressorNames, n)
+	}
+
+	sort.Strings(compressorNames)
+
+	for _, n := range compressorNames {
+		fmt.Printf("  %v: %v stored, %v original (%v), saved %v\n",
+			n,
+			sizeToString(compressedSize[n]),
+			sizeToString(compressedOriginalSize[n]),
+			formatCompressionRatio(compressedSize[n], compressedOriginalSize[n]),
+			sizeToString(compressedOriginalSize[n]-compressedSize[n]))
+	}
 
 	if count == 0 {
 		return nil
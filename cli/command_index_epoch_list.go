@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+)
+
+var (
+	indexEpochCommands     = indexCommands.Command("epoch", "Commands to manage index epochs.")
+	indexEpochListCommand  = indexEpochCommands.Command("list", "List index epochs").Alias("ls").Default()
+	indexEpochListMaintain = indexEpochListCommand.Flag("maintain", "Advance and compact epochs as needed before listing").Bool()
+)
+
+func runIndexEpochListAction(ctx context.Context, rep *repo.Repository) error {
+	em, ok := rep.Content.EpochManager()
+	if !ok {
+		return errors.Errorf("index epochs are not enabled for this repository")
+	}
+
+	if *indexEpochListMaintain {
+		// index compaction performs epoch maintenance, including migration of index blobs not managed by epochs.
+		if err := rep.Content.CompactIndexes(ctx, content.CompactOptions{}); err != nil {
+			return errors.Wrap(err, "error performing epoch maintenance")
+		}
+	}
+
+	if err := em.Refresh(ctx); err != nil {
+		return errors.Wrap(err, "unable to determine current epoch")
+	}
+
+	cs, err := em.Current(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to determine current epoch")
+	}
+
+	fmt.Printf("Current write epoch: %v\n", cs.WriteEpoch)
+
+	for n := cs.WriteEpoch; n >= 0; n-- {
+		startTime := "-"
+		if t, ok := cs.EpochStartTime[n]; ok {
+			startTime = formatTimestamp(t)
+		}
+
+		uncompacted := cs.UncompactedEpochSets[n]
+		compacted := cs.SingleEpochCompactionSets[n]
+
+		fmt.Printf("%5v %-25v uncompacted: %v blobs (%v)  compacted: %v blobs (%v)\n",
+			n, startTime,
+			len(uncompacted), units.BytesStringBase10(totalBlobSize(uncompacted)),
+			len(compacted), units.BytesStringBase10(totalBlobSize(compacted)))
+	}
+
+	return nil
+}
+
+func totalBlobSize(bms []blob.Metadata) int64 {
+	var total int64
+
+	for _, bm := range bms {
+		total += bm.Length
+	}
+
+	return total
+}
+
+func init() {
+	indexEpochListCommand.Action(repositoryAction(runIndexEpochListAction))
+}
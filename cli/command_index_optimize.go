@@ -12,6 +12,7 @@ var (
 	optimizeMaxSmallBlobs        = optimizeCommand.Flag("max-small-blobs", "Maximum number of small index blobs that can be left after compaction.").Default("1").Int()
 	optimizeSkipDeletedOlderThan = optimizeCommand.Flag("skip-deleted-older-than", "Skip deleted blobs above given age").Duration()
 	optimizeAllIndexes           = optimizeCommand.Flag("all", "Optimize all indexes, even those above maximum size.").Bool()
+	optimizeConvertV1            = optimizeCommand.Flag("convert-v1", "Rewrite indexes written in the v1 format using the current format.").Bool()
 )
 
 func runOptimizeCommand(ctx context.Context, rep *repo.Repository) error {
@@ -19,6 +20,7 @@ func runOptimizeCommand(ctx context.Context, rep *repo.Repository) error {
 		MaxSmallBlobs:        *optimizeMaxSmallBlobs,
 		AllIndexes:           *optimizeAllIndexes,
 		SkipDeletedOlderThan: *optimizeSkipDeletedOlderThan,
+		ConvertV1Indexes:     *optimizeConvertV1,
 	})
 }
 
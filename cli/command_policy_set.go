@@ -10,6 +10,8 @@ import (
 
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/splitter"
 	"github.com/kopia/kopia/snapshot/policy"
 )
 
@@ -38,9 +40,12 @@ var (
 	policySetClearIgnore  = policySetCommand.Flag("clear-ignore", "Clear list of paths in the ignore list").Bool()
 
 	// Name of compression algorithm.
-	policySetCompressionAlgorithm = policySetCommand.Flag("compression", "Compression algorithm").Enum(supportedCompressionAlgorithms()...)
-	policySetCompressionMinSize   = policySetCommand.Flag("compression-min-size", "Min size of file to attempt compression for").String()
-	policySetCompressionMaxSize   = policySetCommand.Flag("compression-max-size", "Max size of file to attempt compression for").String()
+	policySetCompressionAlgorithm  = policySetCommand.Flag("compression", "Compression algorithm").Enum(supportedCompressionAlgorithms()...)
+	policySetCompressionMinSize    = policySetCommand.Flag("compression-min-size", "Min size of file to attempt compression for").String()
+	policySetCompressionMaxSize    = policySetCommand.Flag("compression-max-size", "Max size of file to attempt compression for").String()
+	policySetCompressionAdaptive   = policySetCommand.Flag("compression-adaptive", "Store chunks that are estimated to be incompressible or compress poorly without compression ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
+	policySetCompressionDictionary = policySetCommand.Flag("compression-dictionary", "ID of the dictionary to use with zstd compression and for compressing directories (or 'inherit')").PlaceHolder("ID").String()
+	policySetCompressionMinSaving  = policySetCommand.Flag("compression-min-savings", "Minimum percentage of size reduction for compressed chunks to be stored in adaptive mode (or 'inherit')").PlaceHolder("PERCENT").String()
 
 	// Files to only compress.
 	policySetAddOnlyCompress    = policySetCommand.Flag("add-only-compress", "List of extensions to add to the only-compress list").PlaceHolder("PATTERN").Strings()
@@ -52,6 +57,21 @@ var (
 	policySetRemoveNeverCompress = policySetCommand.Flag("remove-never-compress", "List of extensions to remove from the never compress list").PlaceHolder("PATTERN").Strings()
 	policySetClearNeverCompress  = policySetCommand.Flag("clear-never-compress", "Clear list of extensions in the never compress list").Bool()
 
+	// Name of splitter algorithm.
+	policySetSplitterAlgorithm = policySetCommand.Flag("splitter", "Splitter algorithm overriding the repository splitter").Enum(supportedSplitterAlgorithms()...)
+	policySetSplitterMinSize   = policySetCommand.Flag("splitter-min-size", "Min size of file to use the splitter for").String()
+	policySetSplitterMaxSize   = policySetCommand.Flag("splitter-max-size", "Max size of file to use the splitter for").String()
+
+	// Files to only use the splitter for.
+	policySetAddOnlySplitter    = policySetCommand.Flag("add-only-splitter", "List of extensions to add to the only-splitter list").PlaceHolder("PATTERN").Strings()
+	policySetRemoveOnlySplitter = policySetCommand.Flag("remove-only-splitter", "List of extensions to remove from the only-splitter list").PlaceHolder("PATTERN").Strings()
+	policySetClearOnlySplitter  = policySetCommand.Flag("clear-only-splitter", "Clear list of extensions in the only-splitter list").Bool()
+
+	// Files to never use the splitter for.
+	policySetAddNeverSplitter    = policySetCommand.Flag("add-never-splitter", "List of extensions to add to the never-splitter list").PlaceHolder("PATTERN").Strings()
+	policySetRemoveNeverSplitter = policySetCommand.Flag("remove-never-splitter", "List of extensions to remove from the never-splitter list").PlaceHolder("PATTERN").Strings()
+	policySetClearNeverSplitter  = policySetCommand.Flag("clear-never-splitter", "Clear list of extensions in the never-splitter list").Bool()
+
 	// Dot-ignore files to look at.
 	policySetAddDotIgnore    = policySetCommand.Flag("add-dot-ignore", "List of paths to add to the dot-ignore list").PlaceHolder("FILENAME").Strings()
 	policySetRemoveDotIgnore = policySetCommand.Flag("remove-dot-ignore", "List of paths to remove from the dot-ignore list").PlaceHolder("FILENAME").Strings()
@@ -97,6 +117,12 @@ func setPolicy(ctx context.Context, rep *repo.Repository) error {
 			return errors.New("no changes specified")
 		}
 
+		if d := p.CompressionPolicy.Dictionary; d != "" {
+			if _, err := rep.Objects.ReadDictionary(ctx, d); err != nil {
+				return errors.Wrapf(err, "invalid compression dictionary %v", d)
+			}
+		}
+
 		if err := policy.SetPolicy(ctx, rep, target, p); err != nil {
 			return errors.Wrapf(err, "can't save policy for %v", target)
 		}
@@ -120,6 +146,10 @@ func setPolicyFromFlags(p *policy.Policy, changeCount *int) error {
 		return errors.Wrap(err, "compression policy")
 	}
 
+	if err := setSplitterPolicyFromFlags(&p.SplitterPolicy, changeCount); err != nil {
+		return errors.Wrap(err, "splitter policy")
+	}
+
 	if err := setSchedulingPolicyFromFlags(&p.SchedulingPolicy, changeCount); err != nil {
 		return errors.Wrap(err, "scheduling policy")
 	}
@@ -281,6 +311,49 @@ func setCompressionPolicyFromFlags(p *policy.CompressionPolicy, changeCount *int
 		return errors.Wrap(err, "maximum file size subject to compression")
 	}
 
+	switch {
+	case *policySetCompressionAdaptive == "":
+	case *policySetCompressionAdaptive == inheritPolicyString:
+		*changeCount++
+
+		p.Adaptive = nil
+
+		printStderr(" - inherit adaptive compression from parent\n")
+	default:
+		val, err := strconv.ParseBool(*policySetCompressionAdaptive)
+		if err != nil {
+			return err
+		}
+
+		*changeCount++
+
+		p.Adaptive = &val
+
+		printStderr(" - setting adaptive compression to %v\n", val)
+	}
+
+	if err := applyPolicyNumber("minimum savings of adaptive compression", &p.MinSavingsPercent, *policySetCompressionMinSaving, changeCount); err != nil {
+		return errors.Wrap(err, "minimum savings of adaptive compression")
+	}
+
+	if v := p.MinSavingsPercent; v != nil && (*v < 0 || *v >= 100) {
+		return errors.Errorf("minimum savings of adaptive compression must be between 0 and 99")
+	}
+
+	if v := *policySetCompressionDictionary; v != "" {
+		*changeCount++
+
+		if v == inheritPolicyString {
+			printStderr(" - resetting compression dictionary to default value inherited from parent\n")
+
+			p.Dictionary = ""
+		} else {
+			printStderr(" - setting compression dictionary to %v\n", v)
+
+			p.Dictionary = content.ID(v)
+		}
+	}
+
 	if v := *policySetCompressionAlgorithm; v != "" {
 		*changeCount++
 
@@ -320,6 +393,54 @@ func setCompressionPolicyFromFlags(p *policy.CompressionPolicy, changeCount *int
 	return nil
 }
 
+func setSplitterPolicyFromFlags(p *policy.SplitterPolicy, changeCount *int) error {
+	if err := applyPolicyNumber64("minimum file size subject to splitter override", &p.MinSize, *policySetSplitterMinSize, changeCount); err != nil {
+		return errors.Wrap(err, "minimum file size subject to splitter override")
+	}
+
+	if err := applyPolicyNumber64("maximum file size subject to splitter override", &p.MaxSize, *policySetSplitterMaxSize, changeCount); err != nil {
+		return errors.Wrap(err, "maximum file size subject to splitter override")
+	}
+
+	if v := *policySetSplitterAlgorithm; v != "" {
+		*changeCount++
+
+		if v == inheritPolicyString {
+			printStderr(" - resetting splitter algorithm to default value inherited from parent\n")
+
+			p.SplitterName = ""
+		} else {
+			printStderr(" - setting splitter algorithm to %v\n", v)
+
+			p.SplitterName = v
+		}
+	}
+
+	if *policySetClearOnlySplitter {
+		*changeCount++
+
+		p.OnlyExtensions = nil
+
+		printStderr(" - removing all only-splitter extensions\n")
+	} else {
+		p.OnlyExtensions = addRemoveDedupeAndSort("only-splitter extensions",
+			p.OnlyExtensions, *policySetAddOnlySplitter, *policySetRemoveOnlySplitter, changeCount)
+	}
+
+	if *policySetClearNeverSplitter {
+		*changeCount++
+
+		p.NeverExtensions = nil
+
+		printStderr(" - removing all never-splitter extensions\n")
+	} else {
+		p.NeverExtensions = addRemoveDedupeAndSort("never-splitter extensions",
+			p.NeverExtensions, *policySetAddNeverSplitter, *policySetRemoveNeverSplitter, changeCount)
+	}
+
+	return nil
+}
+
 func addRemoveDedupeAndSort(desc string, base, add, remove []string, changeCount *int) []string {
 	entries := map[string]bool{}
 	for _, b := range base {
@@ -411,6 +532,11 @@ func applyPolicyNumber64(desc string, val *int64, str string, changeCount *int)
 }
 
 func supportedCompressionAlgorithms() []string {
+	return append([]string{inheritPolicyString}, compressionAlgorithmNames()...)
+}
+
+// compressionAlgorithmNames returns sorted names of all compressors, preceded by "none".
+func compressionAlgorithmNames() []string {
 	var res []string
 	for name := range compression.ByName {
 		res = append(res, string(name))
@@ -418,5 +544,9 @@ func supportedCompressionAlgorithms() []string {
 
 	sort.Strings(res)
 
-	return append([]string{inheritPolicyString, "none"}, res...)
+	return append([]string{"none"}, res...)
+}
+
+func supportedSplitterAlgorithms() []string {
+	return append([]string{inheritPolicyString}, splitter.SupportedAlgorithms()...)
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot/policy"
 )
 
@@ -84,6 +85,8 @@ func printPolicy(p *policy.Policy, parents []*policy.Policy) {
 	printSchedulingPolicy(p, parents)
 	printStdout("\n")
 	printCompressionPolicy(p, parents)
+	printStdout("\n")
+	printSplitterPolicy(p, parents)
 }
 
 func printRetentionPolicy(p *policy.Policy, parents []*policy.Policy) {
@@ -217,6 +220,13 @@ func printCompressionPolicy(p *policy.Policy, parents []*policy.Policy) {
 		}))
 	} else {
 		printStdout("Compression disabled.\n")
+
+		if d := p.CompressionPolicy.Dictionary; d != "" {
+			printStdout("  Directories compressed using dictionary: %v %v\n", d, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+				return pol.CompressionPolicy.Dictionary != ""
+			}))
+		}
+
 		return
 	}
 
@@ -255,6 +265,68 @@ func printCompressionPolicy(p *policy.Policy, parents []*policy.Policy) {
 	default:
 		printStdout("  Compress files of all sizes.\n")
 	}
+
+	if d := p.CompressionPolicy.Dictionary; d != "" {
+		printStdout("  Dictionary: %v %v\n", d, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.CompressionPolicy.Dictionary != ""
+		}))
+	}
+
+	if p.CompressionPolicy.AdaptiveOrDefault(false) {
+		printStdout("  Adaptive: store chunks saving less than %v%% uncompressed %v\n",
+			p.CompressionPolicy.MinSavingsPercentOrDefault(object.DefaultMinCompressionSavingsPercent),
+			getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+				return pol.CompressionPolicy.Adaptive != nil
+			}))
+	}
+}
+
+func printSplitterPolicy(p *policy.Policy, parents []*policy.Policy) {
+	if p.SplitterPolicy.SplitterName == "" {
+		printStdout("Splitter: repository default.\n")
+		return
+	}
+
+	printStdout("Splitter:\n")
+	printStdout("  Splitter: %q %v\n", p.SplitterPolicy.SplitterName, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+		return pol.SplitterPolicy.SplitterName != ""
+	}))
+
+	switch {
+	case len(p.SplitterPolicy.OnlyExtensions) > 0:
+		printStdout("  Only use for files with the following extensions:\n")
+
+		for _, rule := range p.SplitterPolicy.OnlyExtensions {
+			rule := rule
+			printStdout("    %-30v %v\n", rule, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+				return containsString(pol.SplitterPolicy.OnlyExtensions, rule)
+			}))
+		}
+
+	case len(p.SplitterPolicy.NeverExtensions) > 0:
+		printStdout("  Use for all files except the following extensions:\n")
+
+		for _, rule := range p.SplitterPolicy.NeverExtensions {
+			rule := rule
+			printStdout("    %-30v %v\n", rule, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+				return containsString(pol.SplitterPolicy.NeverExtensions, rule)
+			}))
+		}
+
+	default:
+		printStdout("  Use for files regardless of extensions.\n")
+	}
+
+	switch {
+	case p.SplitterPolicy.MaxSize > 0:
+		printStdout("  Only use for files between %v and %v.\n", units.BytesStringBase10(p.SplitterPolicy.MinSize), units.BytesStringBase10(p.SplitterPolicy.MaxSize))
+
+	case p.SplitterPolicy.MinSize > 0:
+		printStdout("  Only use for files bigger than %v.\n", units.BytesStringBase10(p.SplitterPolicy.MinSize))
+
+	default:
+		printStdout("  Use for files of all sizes.\n")
+	}
 }
 
 func valueOrNotSet(p *int) string {
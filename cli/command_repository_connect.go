@@ -14,15 +14,17 @@ import (
 )
 
 var (
-	connectCommand                = repositoryCommands.Command("connect", "Connect to a repository.")
-	connectPersistCredentials     bool
-	connectCacheDirectory         string
-	connectMaxCacheSizeMB         int64
-	connectMaxMetadataCacheSizeMB int64
-	connectMaxListCacheDuration   time.Duration
-	connectHostname               string
-	connectUsername               string
-	connectCheckForUpdates        bool
+	connectCommand                 = repositoryCommands.Command("connect", "Connect to a repository.")
+	connectPersistCredentials      bool
+	connectCacheDirectory          string
+	connectMaxCacheSizeMB          int64
+	connectMaxMetadataCacheSizeMB  int64
+	connectMaxSeekTableCacheSizeMB int64
+	connectMaxListCacheDuration    time.Duration
+	connectHostname                string
+	connectUsername                string
+	connectCheckForUpdates         bool
+	connectPackSpool               bool
 )
 
 func setupConnectOptions(cmd *kingpin.CmdClause) {
@@ -32,20 +34,24 @@ func setupConnectOptions(cmd *kingpin.CmdClause) {
 	cmd.Flag("cache-directory", "Cache directory").PlaceHolder("PATH").StringVar(&connectCacheDirectory)
 	cmd.Flag("content-cache-size-mb", "Size of local content cache").PlaceHolder("MB").Default("5000").Int64Var(&connectMaxCacheSizeMB)
 	cmd.Flag("metadata-cache-size-mb", "Size of local metadata cache").PlaceHolder("MB").Default("5000").Int64Var(&connectMaxMetadataCacheSizeMB)
+	cmd.Flag("seek-table-cache-size-mb", "Size of local cache of seek tables of large objects").PlaceHolder("MB").Default("50").Int64Var(&connectMaxSeekTableCacheSizeMB)
 	cmd.Flag("max-list-cache-duration", "Duration of index cache").Default("600s").Hidden().DurationVar(&connectMaxListCacheDuration)
 	cmd.Flag("override-hostname", "Override hostname used by this repository connection").Hidden().StringVar(&connectHostname)
 	cmd.Flag("override-username", "Override username used by this repository connection").Hidden().StringVar(&connectUsername)
 	cmd.Flag("check-for-updates", "Periodically check for Kopia updates on GitHub").Default("true").Envar(checkForUpdatesEnvar).BoolVar(&connectCheckForUpdates)
+	cmd.Flag("pack-spool", "Persist packs in the cache directory before uploading them, so they survive crashes and storage outages").BoolVar(&connectPackSpool)
 }
 
 func connectOptions() *repo.ConnectOptions {
 	return &repo.ConnectOptions{
 		PersistCredentials: connectPersistCredentials,
 		CachingOptions: content.CachingOptions{
-			CacheDirectory:            connectCacheDirectory,
-			MaxCacheSizeBytes:         connectMaxCacheSizeMB << 20,         //nolint:gomnd
-			MaxMetadataCacheSizeBytes: connectMaxMetadataCacheSizeMB << 20, //nolint:gomnd
-			MaxListCacheDurationSec:   int(connectMaxListCacheDuration.Seconds()),
+			CacheDirectory:             connectCacheDirectory,
+			MaxCacheSizeBytes:          connectMaxCacheSizeMB << 20,          //nolint:gomnd
+			MaxMetadataCacheSizeBytes:  connectMaxMetadataCacheSizeMB << 20,  //nolint:gomnd
+			MaxSeekTableCacheSizeBytes: connectMaxSeekTableCacheSizeMB << 20, //nolint:gomnd
+			MaxListCacheDurationSec:    int(connectMaxListCacheDuration.Seconds()),
+			PackSpool:                  connectPackSpool,
 		},
 		HostnameOverride: connectHostname,
 		UsernameOverride: connectUsername,
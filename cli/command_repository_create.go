@@ -2,11 +2,14 @@ package cli
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/epoch"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/encryption"
 	"github.com/kopia/kopia/repo/hashing"
@@ -21,6 +24,11 @@ var (
 	createBlockHashFormat       = createCommand.Flag("block-hash", "Content hash algorithm.").PlaceHolder("ALGO").Default(hashing.DefaultAlgorithm).Enum(hashing.SupportedAlgorithms()...)
 	createBlockEncryptionFormat = createCommand.Flag("encryption", "Content encryption algorithm.").PlaceHolder("ALGO").Default(encryption.DefaultAlgorithm).Enum(encryption.SupportedAlgorithms(false)...)
 	createSplitter              = createCommand.Flag("object-splitter", "The splitter to use for new objects in the repository").Default(splitter.DefaultAlgorithm).Enum(splitter.SupportedAlgorithms()...)
+	createMetadataCompression   = createCommand.Flag("metadata-compression", "Compression algorithm for metadata contents, such as directory listings.").Default("none").Enum(compressionAlgorithmNames()...)
+	createMaxPackSizeMB         = createCommand.Flag("max-pack-size-mb", "Maximum size of pack blobs.").PlaceHolder("MB").Default("20").Int()
+	createFormatVersion         = createCommand.Flag("format-version", "Repository format version. Older versions can be opened by older clients, but don't support all features.").Default(strconv.Itoa(content.DefaultFormatVersion)).Int()
+
+	createEnableIndexEpochs = createCommand.Flag("enable-index-epochs", "Manage index blobs in epochs, which does not require list-after-write consistency of the storage.").Bool()
 
 	createOnly = createCommand.Flag("create-only", "Create repository, but don't connect to it.").Short('c').Bool()
 )
@@ -30,16 +38,34 @@ func init() {
 }
 
 func newRepositoryOptionsFromFlags() *repo.NewRepositoryOptions {
-	return &repo.NewRepositoryOptions{
+	opt := &repo.NewRepositoryOptions{
 		BlockFormat: content.FormattingOptions{
-			Hash:       *createBlockHashFormat,
-			Encryption: *createBlockEncryptionFormat,
+			Hash:                *createBlockHashFormat,
+			Encryption:          *createBlockEncryptionFormat,
+			MetadataCompression: metadataCompressionFromFlag(*createMetadataCompression),
+			MaxPackSize:         *createMaxPackSizeMB << 20, //nolint:gomnd
+			Version:             *createFormatVersion,
 		},
 
 		ObjectFormat: object.Format{
 			Splitter: *createSplitter,
 		},
 	}
+
+	if *createEnableIndexEpochs {
+		p := epoch.DefaultParameters()
+		opt.BlockFormat.EpochParameters = &p
+	}
+
+	return opt
+}
+
+func metadataCompressionFromFlag(v string) compression.Name {
+	if v == "none" {
+		return ""
+	}
+
+	return compression.Name(v)
 }
 
 func ensureEmpty(ctx context.Context, s blob.Storage) error {
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+)
+
+var (
+	rotateKeyCommand     = repositoryCommands.Command("rotate-key", "Rotate the master encryption key and re-encrypt all contents.")
+	rotateKeyResume      = rotateKeyCommand.Flag("resume", "Resume re-encryption of contents without creating a new key").Bool()
+	rotateKeyReencrypt   = rotateKeyCommand.Flag("reencrypt", "Re-encrypt contents written using previous keys").Default("true").Bool()
+	rotateKeyRetire      = rotateKeyCommand.Flag("retire", "Destroy previous keys once all contents and indexes are re-encrypted").Bool()
+	rotateKeyGracePeriod = rotateKeyCommand.Flag("retire-grace-period", "Minimum time since rotation before previous keys can be retired, use 0 if all clients have reconnected since").Default("24h").Duration()
+	rotateKeyParallelism = rotateKeyCommand.Flag("parallelism", "Number of parallel workers").Default("16").Int()
+)
+
+func runRotateKeyCommand(ctx context.Context, rep *repo.Repository) error {
+	if !*rotateKeyResume {
+		keyID, err := rep.RotateEncryptionKey(ctx)
+		if err != nil {
+			return errors.Wrap(err, "unable to rotate encryption key")
+		}
+
+		log(ctx).Infof("created new encryption key %v, other clients will use it after reconnecting", keyID)
+
+		// reopen the repository, so that contents are re-encrypted using the new key.
+		newRep, err := openRepository(ctx, nil, true)
+		if err != nil {
+			return errors.Wrap(err, "unable to reopen repository")
+		}
+
+		defer newRep.Close(ctx) //nolint:errcheck
+
+		rep = newRep
+	}
+
+	if *rotateKeyReencrypt {
+		if err := rewriteContents(ctx, rep, findContentWithPreviousEncryptionKeys(ctx, rep), *rotateKeyParallelism, false); err != nil {
+			return errors.Wrap(err, "unable to re-encrypt contents, run 'kopia repository rotate-key --resume' to retry")
+		}
+
+		if err := rep.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
+			return errors.Wrap(err, "unable to flush repository")
+		}
+	}
+
+	if !*rotateKeyRetire {
+		if n := len(rep.Content.Format.PreviousMasterKeys); n > 0 {
+			printStderr("%v previous keys can still be used to decrypt the repository, retire them using 'kopia repository rotate-key --resume --retire' once all clients have reconnected.\n", n)
+		}
+
+		return nil
+	}
+
+	if err := rep.RetirePreviousEncryptionKeys(ctx, *rotateKeyGracePeriod); err != nil {
+		return errors.Wrap(err, "unable to retire previous keys")
+	}
+
+	printStderr("Previous keys retired, pack blobs encrypted using them are no longer referenced and can be removed using 'kopia blob gc'.\n")
+
+	return nil
+}
+
+// findContentWithPreviousEncryptionKeys returns non-deleted contents encrypted using master keys other than the current one.
+func findContentWithPreviousEncryptionKeys(ctx context.Context, rep *repo.Repository) <-chan contentInfoOrError {
+	ch := make(chan contentInfoOrError)
+
+	go func() {
+		defer close(ch)
+
+		if err := rep.Content.IterateContents(
+			ctx,
+			content.IterateOptions{},
+			func(b content.Info) error {
+				if b.EncryptionKeyID != rep.Content.Format.EncryptionKeyID {
+					ch <- contentInfoOrError{Info: b}
+				}
+				return nil
+			}); err != nil {
+			ch <- contentInfoOrError{err: err}
+		}
+	}()
+
+	return ch
+}
+
+func init() {
+	rotateKeyCommand.Action(repositoryAction(runRotateKeyCommand))
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+var (
+	setParametersCommand = repositoryCommands.Command("set-parameters", "Set repository parameters.")
+
+	setParametersMaxPackSizeMB = setParametersCommand.Flag("max-pack-size-mb", "Set maximum size of pack blobs").PlaceHolder("MB").Int()
+	setParametersUpgrade       = setParametersCommand.Flag("upgrade", "Upgrade repository to the latest format version. Clients that don't support it will no longer be able to open the repository.").Bool()
+	setParametersIndexEpochs   = setParametersCommand.Flag("enable-index-epochs", "Enable epoch-based management of index blobs (requires format version 2)").Bool()
+)
+
+func runSetParametersCommand(ctx context.Context, rep *repo.Repository) error {
+	if *setParametersMaxPackSizeMB == 0 && !*setParametersUpgrade && !*setParametersIndexEpochs {
+		return errors.New("no changes")
+	}
+
+	if *setParametersMaxPackSizeMB != 0 {
+		v := *setParametersMaxPackSizeMB << 20 //nolint:gomnd
+
+		if err := policy.SetMaxPackSize(ctx, rep, v); err != nil {
+			return errors.Wrap(err, "unable to set max pack size")
+		}
+
+		log(ctx).Infof("changed maximum pack size to %v, other clients will use it after reconnecting", units.BytesStringBase2(int64(v)))
+	}
+
+	if *setParametersUpgrade {
+		if err := rep.UpgradeFormatVersion(ctx); err != nil {
+			return errors.Wrap(err, "unable to upgrade repository format")
+		}
+
+		log(ctx).Infof("upgraded repository format, other clients will use it after reconnecting")
+	}
+
+	if *setParametersIndexEpochs {
+		if err := rep.EnableIndexEpochs(ctx); err != nil {
+			return errors.Wrap(err, "unable to enable index epochs")
+		}
+
+		log(ctx).Infof("enabled index epochs, existing index blobs will be moved into epochs by 'kopia index optimize', other clients will use epochs after reconnecting")
+	}
+
+	return nil
+}
+
+func init() {
+	setParametersCommand.Action(repositoryAction(runSetParametersCommand))
+}
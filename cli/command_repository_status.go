@@ -13,6 +13,7 @@ import (
 	"github.com/kopia/kopia/internal/scrubber"
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
 )
 
 var (
@@ -27,10 +28,26 @@ func runStatusCommand(ctx context.Context, rep *repo.Repository) error {
 	ci := rep.Blobs.ConnectionInfo()
 	fmt.Printf("Storage type:        %v\n", ci.Type)
 
-	if cjson, err := json.MarshalIndent(scrubber.ScrubSensitiveData(reflect.ValueOf(ci.Config)).Interface(), "                     ", "  "); err == nil {
+	scrubbedConfig := scrubber.ScrubSensitiveData(reflect.ValueOf(ci.Config)).Interface()
+
+	if cjson, err := json.MarshalIndent(scrubbedConfig, "                     ", "  "); err == nil {
 		fmt.Printf("Storage config:      %v\n", string(cjson))
 	}
 
+	if u, err := blob.FormatURL(blob.ConnectionInfo{Type: ci.Type, Config: scrubbedConfig}); err == nil {
+		fmt.Printf("Storage URL:         %v\n", u)
+	}
+
+	switch c, err := blob.GetCapacity(ctx, rep.Blobs); err {
+	case nil:
+		fmt.Printf("Storage capacity:    %v\n", units.BytesStringBase10(int64(c.SizeB)))
+		fmt.Printf("Storage available:   %v\n", units.BytesStringBase10(int64(c.FreeB)))
+	case blob.ErrCapacityNotSupported:
+		// storage does not report capacity.
+	default:
+		fmt.Printf("Storage capacity:    unknown (%v)\n", err)
+	}
+
 	fmt.Println()
 	fmt.Printf("Unique ID:           %x\n", rep.UniqueID)
 	fmt.Printf("Hostname:            %v\n", rep.Hostname)
@@ -38,8 +55,28 @@ func runStatusCommand(ctx context.Context, rep *repo.Repository) error {
 	fmt.Println()
 	fmt.Printf("Hash:                %v\n", rep.Content.Format.Hash)
 	fmt.Printf("Encryption:          %v\n", rep.Content.Format.Encryption)
+
+	if n := len(rep.Content.Format.PreviousMasterKeys); n > 0 {
+		fmt.Printf("Encryption key:      %v (%v previous keys not retired)\n", rep.Content.Format.EncryptionKeyID, n)
+	} else if rep.Content.Format.EncryptionKeyID != 0 {
+		fmt.Printf("Encryption key:      %v\n", rep.Content.Format.EncryptionKeyID)
+	}
+
 	fmt.Printf("Splitter:            %v\n", rep.Objects.Format.Splitter)
+
+	metadataCompression := string(rep.Content.Format.MetadataCompression)
+	if metadataCompression == "" {
+		metadataCompression = "none"
+	}
+
+	fmt.Printf("Metadata compressor: %v\n", metadataCompression)
 	fmt.Printf("Format version:      %v\n", rep.Content.Format.Version)
+
+	if rep.Content.Format.EpochParameters.IsEnabled() {
+		fmt.Printf("Index epochs:        enabled\n")
+	} else {
+		fmt.Printf("Index epochs:        disabled\n")
+	}
 	fmt.Printf("Max pack length:     %v\n", units.BytesStringBase2(int64(rep.Content.Format.MaxPackSize)))
 
 	if *statusReconnectToken {
@@ -12,9 +12,7 @@ import (
 	"os"
 	"strings"
 
-	"contrib.go.opencensus.io/exporter/prometheus"
 	"github.com/pkg/errors"
-	prom "github.com/prometheus/client_golang/prometheus"
 
 	"github.com/kopia/kopia/internal/server"
 	"github.com/kopia/kopia/repo"
@@ -39,11 +37,13 @@ func runServer(ctx context.Context, rep *repo.Repository) error {
 	srv, err := server.New(ctx, rep, server.Options{
 		ConfigFile:      repositoryConfigFileName(),
 		ConnectOptions:  connectOptions(),
+		OpenOptions:     applyOptionsFromFlags(ctx, nil),
 		RefreshInterval: *serverStartRefreshInterval,
 	})
 	if err != nil {
 		return errors.Wrap(err, "unable to initialize server")
 	}
+	defer srv.Close()
 
 	if err = srv.SetRepository(ctx, rep); err != nil {
 		return errors.Wrap(err, "error connecting to repository")
@@ -101,24 +101,12 @@ func runServer(ctx context.Context, rep *repo.Repository) error {
 }
 
 func initPrometheus(mux *http.ServeMux) error {
-	reg := prom.NewRegistry()
-	if err := reg.Register(prom.NewProcessCollector(prom.ProcessCollectorOpts{})); err != nil {
-		return errors.Wrap(err, "error registering process collector")
-	}
-
-	if err := reg.Register(prom.NewGoCollector()); err != nil {
-		return errors.Wrap(err, "error registering go collector")
-	}
-
-	pe, err := prometheus.NewExporter(prometheus.Options{
-		Registry: reg,
-	})
-
+	h, err := server.MetricsHandler()
 	if err != nil {
-		return errors.Wrap(err, "unable to initialize prometheus exporter")
+		return err
 	}
 
-	mux.Handle("/metrics", pe)
+	mux.Handle("/metrics", h)
 
 	return nil
 }
@@ -8,7 +8,9 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
@@ -25,6 +27,7 @@ var (
 	snapshotCreateSources                 = snapshotCreateCommand.Arg("source", "Files or directories to create snapshot(s) of.").ExistingFilesOrDirs()
 	snapshotCreateAll                     = snapshotCreateCommand.Flag("all", "Create snapshots for files or directories previously backed up by this user on this computer").Bool()
 	snapshotCreateCheckpointUploadLimitMB = snapshotCreateCommand.Flag("upload-limit-mb", "Stop the backup process after the specified amount of data (in MB) has been uploaded.").PlaceHolder("MB").Default("0").Int64()
+	snapshotCreateMinFreeSpaceMB          = snapshotCreateCommand.Flag("min-free-space-mb", "Abort the backup process when free space in the storage falls below the specified amount (in MB).").PlaceHolder("MB").Default("0").Int64()
 	snapshotCreateDescription             = snapshotCreateCommand.Flag("description", "Free-form snapshot description.").String()
 	snapshotCreateForceHash               = snapshotCreateCommand.Flag("force-hash", "Force hashing of source files for a given percentage of files [0..100]").Default("0").Int()
 	snapshotCreateParallelUploads         = snapshotCreateCommand.Flag("parallel", "Upload N files in parallel").PlaceHolder("N").Default("0").Int()
@@ -52,6 +55,7 @@ func runBackupCommand(ctx context.Context, rep *repo.Repository) error {
 
 	u := snapshotfs.NewUploader(rep)
 	u.MaxUploadBytes = *snapshotCreateCheckpointUploadLimitMB << 20 //nolint:gomnd
+	u.MinFreeSpaceBytes = *snapshotCreateMinFreeSpaceMB << 20       //nolint:gomnd
 	u.ForceHashPercentage = *snapshotCreateForceHash
 	u.ParallelUploads = *snapshotCreateParallelUploads
 	onCtrlC(u.Cancel)
@@ -199,7 +203,11 @@ func snapshotSingleSource(ctx context.Context, rep *repo.Repository, u *snapshot
 	}
 
 	if ferr := rep.Flush(ctx); ferr != nil {
-		return errors.Wrap(ferr, "flush error")
+		if !content.IsPacksSpooled(ferr) {
+			return errors.Wrap(ferr, "flush error")
+		}
+
+		log(ctx).Warningf("snapshot has not been fully uploaded yet, remaining packs will be uploaded from the local spool when the repository is opened again")
 	}
 
 	progress.Finish()
@@ -211,6 +219,22 @@ func snapshotSingleSource(ctx context.Context, rep *repo.Repository, u *snapshot
 
 	printStderr("\nCreated%v snapshot with root %v and ID %v in %v\n", maybePartial, manifest.RootObjectID(), snapID, time.Since(t0).Truncate(time.Second))
 
+	if st := manifest.Stats; st.CompressedBytes > 0 || st.IncompressibleBytes > 0 || st.CompressionSkippedBytes > 0 {
+		printStderr("Compression: %v compressed to %v, %v did not compress well, %v skipped as incompressible\n",
+			units.BytesStringBase10(st.CompressedBytes),
+			units.BytesStringBase10(st.CompressedSize),
+			units.BytesStringBase10(st.IncompressibleBytes),
+			units.BytesStringBase10(st.CompressionSkippedBytes))
+	}
+
+	for name, cs := range rep.Content.Stats.Compression() {
+		printStderr("Metadata compression (%v): %v compressed to %v, %v contents did not compress well\n",
+			name,
+			units.BytesStringBase10(cs.OriginalBytes),
+			units.BytesStringBase10(cs.CompressedBytes),
+			cs.IncompressibleContents)
+	}
+
 	return err
 }
 
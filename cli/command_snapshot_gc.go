@@ -22,6 +22,10 @@ func runSnapshotGCCommand(ctx context.Context, rep *repo.Repository) error {
 	log(ctx).Infof("GC found %v in-use contents (%v bytes)", st.InUseCount, units.BytesStringBase2(st.InUseBytes))
 	log(ctx).Infof("GC found %v in-use system-contents (%v bytes)", st.SystemCount, units.BytesStringBase2(st.SystemBytes))
 
+	if st.RetentionExtendedCount > 0 {
+		log(ctx).Infof("GC extended retention of %v blobs", st.RetentionExtendedCount)
+	}
+
 	return err
 }
 
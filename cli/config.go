@@ -23,7 +23,13 @@ var (
 	traceLocalFS       = app.Flag("trace-localfs", "Enables tracing of local filesystem operations").Envar("KOPIA_TRACE_FS").Bool()
 	enableCaching      = app.Flag("caching", "Enables caching of objects (disable with --no-caching)").Default("true").Hidden().Bool()
 	enableListCaching  = app.Flag("list-caching", "Enables caching of list results (disable with --no-list-caching)").Default("true").Hidden().Bool()
-	metricsListenAddr  = app.Flag("metrics-listen-addr", "Expose Prometheus metrics on a given host:port").Hidden().String()
+	metricsListenAddr  = app.Flag("metrics-listen-addr", "Expose Prometheus metrics on a given host:port while the command is running").String()
+
+	packUploadParallelism      = app.Flag("pack-upload-parallelism", "Number of packs uploaded in parallel in the background (0 == upload synchronously)").Default("4").Int()
+	maxInFlightPackUploadBytes = app.Flag("max-in-flight-pack-upload-mb", "Maximum size of packs waiting to be uploaded in the background (0 == automatic)").PlaceHolder("MB").Default("0").Int64()
+	readAheadChunks            = app.Flag("read-ahead-chunks", "Number of chunks of large objects fetched in parallel ahead of sequential readers (0 == disabled)").Default("4").Int()
+	readAheadMaxMB             = app.Flag("read-ahead-mb", "Maximum size of chunks fetched ahead by a single object reader").PlaceHolder("MB").Default("64").Int64()
+	objectWriteParallelism     = app.Flag("object-write-parallelism", "Number of chunks of large objects compressed and written in parallel").Default("4").Int()
 
 	configPath = app.Flag("config-file", "Specify the config file to use.").Default(defaultConfigFileName()).Envar("KOPIA_CONFIG_PATH").String()
 )
@@ -92,6 +98,13 @@ func applyOptionsFromFlags(ctx context.Context, opts *repo.Options) *repo.Option
 		opts.ObjectManagerOptions.Trace = log(ctx).Debugf
 	}
 
+	opts.ObjectManagerOptions.ReadAheadChunks = *readAheadChunks
+	opts.ObjectManagerOptions.ReadAheadMaxBytes = *readAheadMaxMB << 20 //nolint:gomnd
+	opts.ObjectManagerOptions.WriteParallelism = *objectWriteParallelism
+
+	opts.PackUploadParallelism = *packUploadParallelism
+	opts.MaxInFlightPackUploadBytes = *maxInFlightPackUploadBytes << 20 //nolint:gomnd
+
 	return opts
 }
 
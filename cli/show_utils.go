@@ -15,6 +15,8 @@ import (
 
 	"github.com/kopia/kopia/internal/iocopy"
 	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
 )
 
 var (
@@ -105,3 +107,25 @@ func convertTimezone(ts time.Time) time.Time {
 		return ts
 	}
 }
+
+// formatCompressionRatio returns the stored size as a percentage of the original size.
+func formatCompressionRatio(stored, original int64) string {
+	if original == 0 {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%.1f%%", 100*float64(stored)/float64(original))
+}
+
+// compressorName returns the name of the compressor used for the content or an empty string if not compressed.
+func compressorName(i content.Info) string {
+	if i.CompressionHeaderID == 0 {
+		return ""
+	}
+
+	if n, ok := compression.HeaderIDToName[i.CompressionHeaderID]; ok {
+		return string(n)
+	}
+
+	return fmt.Sprintf("unknown-%x", uint32(i.CompressionHeaderID))
+}
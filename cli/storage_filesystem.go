@@ -24,6 +24,7 @@ var (
 	connectFileMode string
 	connectDirMode  string
 	connectFlat     bool
+	connectSync     bool
 )
 
 func connect(ctx context.Context, isNew bool) (blob.Storage, error) {
@@ -43,6 +44,8 @@ func connect(ctx context.Context, isNew bool) (blob.Storage, error) {
 		fso.DirectoryShards = []int{}
 	}
 
+	fso.DoNotSync = !connectSync
+
 	if isNew {
 		log(ctx).Debugf("creating directory for repository: %v dir mode: %v", fso.Path, fso.DirectoryMode)
 
@@ -65,6 +68,7 @@ func init() {
 			cmd.Flag("file-mode", "File mode for newly created files (0600)").PlaceHolder("MODE").StringVar(&connectFileMode)
 			cmd.Flag("dir-mode", "Mode of newly directory files (0700)").PlaceHolder("MODE").StringVar(&connectDirMode)
 			cmd.Flag("flat", "Use flat directory structure").BoolVar(&connectFlat)
+			cmd.Flag("sync", "Flush file data and directories to disk after each write").Default("true").BoolVar(&connectSync)
 		},
 		connect)
 }
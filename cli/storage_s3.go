@@ -27,6 +27,8 @@ func init() {
 			cmd.Flag("disable-tls-verification", "Disable TLS (HTTPS) certificate verification").BoolVar(&s3options.DoNotVerifyTLS)
 			cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&s3options.MaxDownloadSpeedBytesPerSecond)
 			cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&s3options.MaxUploadSpeedBytesPerSecond)
+			cmd.Flag("retention-mode", "Object Lock retention mode for new blobs (requires bucket with Object Lock enabled, disabled by default). Retention in COMPLIANCE mode can't be shortened or removed by anyone").EnumVar(&s3options.RetentionMode, "GOVERNANCE", "COMPLIANCE")
+			cmd.Flag("retention-period", "Object Lock retention period for new blobs, required with --retention-mode. Garbage collection extends the retention of blobs still in use to the longest period retained by snapshot retention policies").DurationVar(&s3options.RetentionPeriod)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
 			return s3.New(ctx, &s3options)
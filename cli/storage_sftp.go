@@ -28,13 +28,18 @@ func init() {
 			cmd.Flag("username", "SFTP/SSH server username").Required().StringVar(&options.Username)
 			cmd.Flag("keyfile", "path to private key file for SFTP/SSH server").StringVar(&options.Keyfile)
 			cmd.Flag("key-data", "private key data").StringVar(&options.KeyData)
+			cmd.Flag("sftp-password", "SFTP/SSH server password (also used for keyboard-interactive authentication)").Envar("KOPIA_SFTP_PASSWORD").StringVar(&options.Password)
+			cmd.Flag("ssh-agent", "Authenticate using keys provided by ssh-agent").BoolVar(&options.UseSSHAgent)
+			cmd.Flag("known-hosts", "path to known_hosts file").StringVar(&options.KnownHostsFile)
+			cmd.Flag("trust-on-first-use", "Trust the server key on first connection and add it to known_hosts").BoolVar(&options.TrustOnFirstUse)
+			cmd.Flag("max-connections", "Maximum number of concurrent SSH connections").IntVar(&options.MaxConnections)
 			cmd.Flag("embed-credentials", "Embed key and known_hosts in Kopia configuration").BoolVar(&embedCredentials)
 			cmd.Flag("flat", "Use flat directory structure").BoolVar(&connectFlat)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
 			sftpo := options
 
-			if embedCredentials {
+			if embedCredentials && sftpo.Keyfile != "" {
 				d, err := ioutil.ReadFile(sftpo.Keyfile)
 				if err != nil {
 					return nil, err
@@ -44,8 +49,8 @@ func init() {
 				sftpo.Keyfile = ""
 			}
 
-			if sftpo.KeyData == "" && sftpo.Keyfile == "" {
-				return nil, errors.Errorf("must provide either key file or key data")
+			if sftpo.KeyData == "" && sftpo.Keyfile == "" && sftpo.Password == "" && !sftpo.UseSSHAgent {
+				return nil, errors.Errorf("must provide key file, key data, password or use ssh-agent")
 			}
 
 			if connectFlat {
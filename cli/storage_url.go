@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func init() {
+	var storageURL string
+
+	RegisterStorageConnectFlags(
+		"url",
+		"a storage specified by URL",
+		func(cmd *kingpin.CmdClause) {
+			cmd.Flag("url", "Storage URL ("+strings.Join(blob.SupportedURLSchemes(), ", ")+")").Required().Envar("KOPIA_STORAGE_URL").StringVar(&storageURL)
+		},
+		func(ctx context.Context, isNew bool) (blob.Storage, error) {
+			ci, err := blob.ParseURL(storageURL)
+			if err != nil {
+				return nil, err
+			}
+
+			return blob.NewStorage(ctx, ci)
+		})
+}
@@ -10,6 +10,7 @@ require (
 	github.com/Azure/azure-pipeline-go v0.2.2 // indirect
 	github.com/Azure/azure-storage-blob-go v0.8.0
 	github.com/alecthomas/units v0.0.0-20190924025748-f65c72e2690d // indirect
+	github.com/andybalholm/brotli v1.0.5
 	github.com/aws/aws-sdk-go v1.28.13
 	github.com/bgentry/speakeasy v0.1.0
 	github.com/chmduquesne/rollinghash v4.0.0+incompatible
@@ -19,7 +20,7 @@ require (
 	github.com/google/fswalker v0.2.0
 	github.com/google/wire v0.4.0 // indirect
 	github.com/gorilla/mux v1.7.4
-	github.com/klauspost/compress v1.10.3
+	github.com/klauspost/compress v1.15.15
 	github.com/klauspost/pgzip v1.2.2
 	github.com/kylelemons/godebug v1.1.0
 	github.com/mattn/go-colorable v0.1.6 // indirect
@@ -29,6 +30,7 @@ require (
 	github.com/mmcloughlin/avo v0.0.0-20200303042253-6df701fe672f // indirect
 	github.com/natefinch/atomic v0.0.0-20150920032501-a62ce929ffcc
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
+	github.com/pierrec/lz4/v4 v4.1.17
 	github.com/pkg/errors v0.9.1
 	github.com/pkg/profile v1.4.0
 	github.com/pkg/sftp v1.11.0
@@ -52,4 +54,5 @@ require (
 	google.golang.org/grpc v1.28.0 // indirect
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/ini.v1 v1.55.0 // indirect
+	lukechampine.com/blake3 v1.1.7
 )
@@ -0,0 +1,37 @@
+package blobtesting
+
+import (
+	"context"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobverify"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// VerifyTimestamps verifies that the timestamps reported by ListBlobs() don't go back in time
+// and are within maxClockSkew of the local clock.
+func VerifyTimestamps(ctx context.Context, t testingT, r blob.Storage, maxClockSkew time.Duration) {
+	t.Helper()
+
+	if err := blobverify.VerifyTimestamps(ctx, r, maxClockSkew); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// VerifyLargeBlob verifies that a blob of the provided size can be written, read back and deleted.
+func VerifyLargeBlob(ctx context.Context, t testingT, r blob.Storage, size int) {
+	t.Helper()
+
+	if err := blobverify.VerifyLargeBlob(ctx, r, size); err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
+// VerifyRangeReads verifies that partial reads of a blob return correct data, including edge cases.
+func VerifyRangeReads(ctx context.Context, t testingT, r blob.Storage) {
+	t.Helper()
+
+	if err := blobverify.VerifyRangeReads(ctx, r); err != nil {
+		t.Errorf("%v", err)
+	}
+}
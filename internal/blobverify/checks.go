@@ -0,0 +1,118 @@
+package blobverify
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// CheckGetBlob ensures that the specified BLOB has correct content, including when read partially.
+func CheckGetBlob(ctx context.Context, s blob.Storage, blobID blob.ID, expected []byte) error {
+	b, err := s.GetBlob(ctx, blobID, 0, -1)
+	if err != nil {
+		return errors.Errorf("GetBlob(%v) returned error %v, expected data: %v", blobID, err, expected)
+	}
+
+	if !bytes.Equal(b, expected) {
+		return errors.Errorf("GetBlob(%v) returned %x, but expected %x", blobID, b, expected)
+	}
+
+	half := int64(len(expected) / 2) //nolint:gomnd
+	if half == 0 {
+		return nil
+	}
+
+	b, err = s.GetBlob(ctx, blobID, 0, 0)
+	if err != nil {
+		return errors.Errorf("GetBlob(%v) returned error %v, expected data: %v", blobID, err, expected)
+	}
+
+	if len(b) != 0 {
+		return errors.Errorf("GetBlob(%v) returned non-zero length: %v", blobID, len(b))
+	}
+
+	b, err = s.GetBlob(ctx, blobID, 0, half)
+	if err != nil {
+		return errors.Errorf("GetBlob(%v) returned error %v, expected data: %v", blobID, err, expected)
+	}
+
+	if !bytes.Equal(b, expected[0:half]) {
+		return errors.Errorf("GetBlob(%v) returned %x, but expected %x", blobID, b, expected[0:half])
+	}
+
+	b, err = s.GetBlob(ctx, blobID, half, int64(len(expected))-half)
+	if err != nil {
+		return errors.Errorf("GetBlob(%v) returned error %v, expected data: %v", blobID, err, expected)
+	}
+
+	if !bytes.Equal(b, expected[len(expected)-int(half):]) {
+		return errors.Errorf("GetBlob(%v) returned %x, but expected %x", blobID, b, expected[len(expected)-int(half):])
+	}
+
+	for _, rng := range []struct{ offset, length int64 }{
+		{-3, 1},
+		{int64(len(expected)), 3},
+		{int64(len(expected) - 1), 3},
+		{int64(len(expected) + 1), 3},
+	} {
+		if err := CheckInvalidOffsetLength(ctx, s, blobID, rng.offset, rng.length); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckInvalidOffsetLength ensures that the given combination of (offset,length) fails on GetBlob().
+func CheckInvalidOffsetLength(ctx context.Context, s blob.Storage, blobID blob.ID, offset, length int64) error {
+	if _, err := s.GetBlob(ctx, blobID, offset, length); err == nil {
+		return errors.Errorf("GetBlob(%v,%v,%v) did not return error for invalid offset/length", blobID, offset, length)
+	}
+
+	return nil
+}
+
+// CheckGetBlobNotFound ensures that GetBlob() for specified blobID returns ErrBlobNotFound.
+func CheckGetBlobNotFound(ctx context.Context, s blob.Storage, blobID blob.ID) error {
+	b, err := s.GetBlob(ctx, blobID, 0, -1)
+	if err != blob.ErrBlobNotFound || b != nil {
+		return errors.Errorf("GetBlob(%v) returned %v, %v but expected ErrNotFound", blobID, b, err)
+	}
+
+	return nil
+}
+
+// CheckListResults ensures that the list results with given prefix return the specified list of names.
+func CheckListResults(ctx context.Context, s blob.Storage, prefix blob.ID, want ...blob.ID) error {
+	var names []blob.ID
+
+	if err := s.ListBlobs(ctx, prefix, func(e blob.Metadata) error {
+		names = append(names, e.BlobID)
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "ListBlobs(%v) returned error", prefix)
+	}
+
+	names = sorted(names)
+	want = sorted(want)
+
+	if !reflect.DeepEqual(names, want) {
+		return errors.Errorf("ListBlobs(%v) returned %v, but wanted %v", prefix, names, want)
+	}
+
+	return nil
+}
+
+func sorted(s []blob.ID) []blob.ID {
+	x := append([]blob.ID(nil), s...)
+	sort.Slice(x, func(i, j int) bool {
+		return x[i] < x[j]
+	})
+
+	return x
+}
@@ -0,0 +1,2 @@
+// Package blobverify verifies that BLOB storage implementations provide guarantees required by Kopia.
+package blobverify
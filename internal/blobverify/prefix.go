@@ -0,0 +1,46 @@
+package blobverify
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+type prefixStorage struct {
+	base   blob.Storage
+	prefix blob.ID
+}
+
+func (s *prefixStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	return s.base.GetBlob(ctx, s.prefix+id, offset, length)
+}
+
+func (s *prefixStorage) PutBlob(ctx context.Context, id blob.ID, data []byte) error {
+	return s.base.PutBlob(ctx, s.prefix+id, data)
+}
+
+func (s *prefixStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	return s.base.DeleteBlob(ctx, s.prefix+id)
+}
+
+func (s *prefixStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	return s.base.ListBlobs(ctx, s.prefix+prefix, func(bm blob.Metadata) error {
+		bm.BlobID = bm.BlobID[len(s.prefix):]
+		return callback(bm)
+	})
+}
+
+func (s *prefixStorage) Close(ctx context.Context) error {
+	// the underlying storage is owned by the caller.
+	return nil
+}
+
+func (s *prefixStorage) ConnectionInfo() blob.ConnectionInfo {
+	return s.base.ConnectionInfo()
+}
+
+// NewPrefixStorage returns a Storage wrapper that transparently adds the provided prefix to all blob IDs,
+// which allows running verification against a live storage without affecting other blobs.
+func NewPrefixStorage(base blob.Storage, prefix blob.ID) blob.Storage {
+	return &prefixStorage{base: base, prefix: prefix}
+}
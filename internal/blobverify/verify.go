@@ -0,0 +1,272 @@
+package blobverify
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const rangeReadsPerBlob = 20
+
+// VerifyStorage verifies the behavior of basic operations of the specified storage.
+func VerifyStorage(ctx context.Context, r blob.Storage) error {
+	blocks := []struct {
+		blk      blob.ID
+		contents []byte
+	}{
+		{blk: "abcdbbf4f0507d054ed5a80a5b65086f602b", contents: []byte{}},
+		{blk: "zxce0e35630770c54668a8cfb4e414c6bf8f", contents: []byte{1}},
+		{blk: "abff4585856ebf0748fd989e1dd623a8963d", contents: bytes.Repeat([]byte{1}, 1000)},
+		{blk: "abgc3dca496d510f492c858a2df1eb824e62", contents: bytes.Repeat([]byte{1}, 10000)},
+		{blk: "kopia.repository", contents: bytes.Repeat([]byte{2}, 100)},
+	}
+
+	// First verify that blocks don't exist.
+	for _, b := range blocks {
+		if err := CheckGetBlobNotFound(ctx, r, b.blk); err != nil {
+			return err
+		}
+	}
+
+	// Now add and then overwrite blocks.
+	for i := 0; i < 2; i++ {
+		for _, b := range blocks {
+			if err := r.PutBlob(ctx, b.blk, b.contents); err != nil {
+				return errors.Wrap(err, "can't put blob")
+			}
+
+			if err := CheckGetBlob(ctx, r, b.blk, b.contents); err != nil {
+				return err
+			}
+		}
+
+		if err := CheckListResults(ctx, r, "", blocks[0].blk, blocks[1].blk, blocks[2].blk, blocks[3].blk, blocks[4].blk); err != nil {
+			return err
+		}
+
+		if err := CheckListResults(ctx, r, "ab", blocks[0].blk, blocks[2].blk, blocks[3].blk); err != nil {
+			return err
+		}
+	}
+
+	if err := r.DeleteBlob(ctx, blocks[0].blk); err != nil {
+		return errors.Wrap(err, "unable to delete block")
+	}
+
+	if err := r.DeleteBlob(ctx, blocks[0].blk); err != nil {
+		return errors.Wrap(err, "invalid error when deleting deleted block")
+	}
+
+	if err := CheckListResults(ctx, r, "ab", blocks[2].blk, blocks[3].blk); err != nil {
+		return err
+	}
+
+	return CheckListResults(ctx, r, "", blocks[1].blk, blocks[2].blk, blocks[3].blk, blocks[4].blk)
+}
+
+// VerifyTimestamps verifies that the timestamps reported by ListBlobs() don't go back in time
+// and are within maxClockSkew of the local clock.
+func VerifyTimestamps(ctx context.Context, r blob.Storage, maxClockSkew time.Duration) error {
+	const blobCount = 5
+
+	var (
+		ids       []blob.ID
+		lastTime  time.Time
+		startTime = time.Now() // allow:no-inject-time
+	)
+
+	for i := 0; i < blobCount; i++ {
+		id := blob.ID(fmt.Sprintf("ts%020d", i))
+
+		if err := r.PutBlob(ctx, id, []byte(id)); err != nil {
+			return errors.Wrapf(err, "can't put blob %v", id)
+		}
+
+		ids = append(ids, id)
+
+		bm, err := getBlobMetadata(ctx, r, id)
+		if err != nil {
+			return err
+		}
+
+		if bm.Timestamp.Before(lastTime) {
+			return errors.Errorf("timestamp of %v (%v) is before timestamp of previously written blob (%v)", id, bm.Timestamp, lastTime)
+		}
+
+		if skew := bm.Timestamp.Sub(startTime); skew < -maxClockSkew || skew > maxClockSkew+time.Since(startTime) {
+			return errors.Errorf("timestamp of %v (%v) differs too much from the local clock (%v)", id, bm.Timestamp, startTime)
+		}
+
+		lastTime = bm.Timestamp
+	}
+
+	// overwriting a blob must not make its timestamp go back in time.
+	before, err := getBlobMetadata(ctx, r, ids[0])
+	if err != nil {
+		return err
+	}
+
+	if err := r.PutBlob(ctx, ids[0], []byte(ids[0])); err != nil {
+		return errors.Wrapf(err, "can't overwrite blob %v", ids[0])
+	}
+
+	after, err := getBlobMetadata(ctx, r, ids[0])
+	if err != nil {
+		return err
+	}
+
+	if after.Timestamp.Before(before.Timestamp) {
+		return errors.Errorf("timestamp of %v went back in time after overwrite: %v, was %v", ids[0], after.Timestamp, before.Timestamp)
+	}
+
+	for _, id := range ids {
+		if err := r.DeleteBlob(ctx, id); err != nil {
+			return errors.Wrapf(err, "unable to delete blob %v", id)
+		}
+	}
+
+	return nil
+}
+
+// VerifyLargeBlob verifies that a blob of the provided size can be written, read back and deleted.
+func VerifyLargeBlob(ctx context.Context, r blob.Storage, size int) error {
+	id := blob.ID(fmt.Sprintf("large%020d", size))
+
+	data, err := randomData(size)
+	if err != nil {
+		return err
+	}
+
+	if err := r.PutBlob(ctx, id, data); err != nil {
+		return errors.Wrapf(err, "can't put blob of %v bytes", size)
+	}
+
+	if err := CheckGetBlob(ctx, r, id, data); err != nil {
+		return err
+	}
+
+	bm, err := getBlobMetadata(ctx, r, id)
+	if err != nil {
+		return err
+	}
+
+	if bm.Length != int64(size) {
+		return errors.Errorf("invalid length of %v reported by ListBlobs(): %v, want %v", id, bm.Length, size)
+	}
+
+	if err := verifyRandomRanges(ctx, r, id, data); err != nil {
+		return err
+	}
+
+	if err := r.DeleteBlob(ctx, id); err != nil {
+		return errors.Wrapf(err, "unable to delete blob %v", id)
+	}
+
+	return CheckGetBlobNotFound(ctx, r, id)
+}
+
+// VerifyRangeReads verifies that partial reads of a blob return correct data, including edge cases.
+func VerifyRangeReads(ctx context.Context, r blob.Storage) error {
+	const blobSize = 65537
+
+	id := blob.ID("range-read-verification-blob")
+
+	data, err := randomData(blobSize)
+	if err != nil {
+		return err
+	}
+
+	if err := r.PutBlob(ctx, id, data); err != nil {
+		return errors.Wrap(err, "can't put blob")
+	}
+
+	for _, rng := range []struct{ offset, length int64 }{
+		{0, 0},
+		{0, 1},
+		{1, 1},
+		{blobSize - 1, 1},
+		{blobSize - 1, 0},
+		{0, blobSize},
+		{1, blobSize - 1},
+	} {
+		if err := verifyRange(ctx, r, id, data, rng.offset, rng.length); err != nil {
+			return err
+		}
+	}
+
+	if err := verifyRandomRanges(ctx, r, id, data); err != nil {
+		return err
+	}
+
+	if err := CheckInvalidOffsetLength(ctx, r, id, 0, blobSize+1); err != nil {
+		return err
+	}
+
+	if err := CheckInvalidOffsetLength(ctx, r, id, blobSize, 1); err != nil {
+		return err
+	}
+
+	return errors.Wrapf(r.DeleteBlob(ctx, id), "unable to delete blob %v", id)
+}
+
+func verifyRandomRanges(ctx context.Context, r blob.Storage, id blob.ID, data []byte) error {
+	for i := 0; i < rangeReadsPerBlob; i++ {
+		offset := rand.Int63n(int64(len(data)))
+		length := rand.Int63n(int64(len(data)) - offset + 1)
+
+		if err := verifyRange(ctx, r, id, data, offset, length); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func verifyRange(ctx context.Context, r blob.Storage, id blob.ID, data []byte, offset, length int64) error {
+	b, err := r.GetBlob(ctx, id, offset, length)
+	if err != nil {
+		return errors.Wrapf(err, "GetBlob(%v,%v,%v) returned error", id, offset, length)
+	}
+
+	if !bytes.Equal(b, data[offset:offset+length]) {
+		return errors.Errorf("GetBlob(%v,%v,%v) returned invalid data (%v bytes)", id, offset, length, len(b))
+	}
+
+	return nil
+}
+
+func getBlobMetadata(ctx context.Context, r blob.Storage, id blob.ID) (blob.Metadata, error) {
+	var result []blob.Metadata
+
+	if err := r.ListBlobs(ctx, id, func(bm blob.Metadata) error {
+		if bm.BlobID == id {
+			result = append(result, bm)
+		}
+
+		return nil
+	}); err != nil {
+		return blob.Metadata{}, errors.Wrapf(err, "ListBlobs(%v) returned error", id)
+	}
+
+	if len(result) != 1 {
+		return blob.Metadata{}, errors.Errorf("ListBlobs(%v) returned %v matching entries, expected 1", id, len(result))
+	}
+
+	return result[0], nil
+}
+
+func randomData(size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := cryptorand.Read(data); err != nil {
+		return nil, errors.Wrap(err, "unable to generate random data")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,54 @@
+package blobverify_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/blobverify"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestVerifyMapStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	r := blobverify.NewPrefixStorage(blobtesting.NewMapStorage(data, nil, nil), "some-prefix-")
+
+	if err := blobverify.VerifyStorage(ctx, r); err != nil {
+		t.Errorf("basic operations: %v", err)
+	}
+
+	if err := blobverify.VerifyTimestamps(ctx, r, time.Minute); err != nil {
+		t.Errorf("timestamps: %v", err)
+	}
+
+	if err := blobverify.VerifyRangeReads(ctx, r); err != nil {
+		t.Errorf("range reads: %v", err)
+	}
+
+	if err := blobverify.VerifyLargeBlob(ctx, r, 1<<20); err != nil {
+		t.Errorf("large blob: %v", err)
+	}
+
+	for k := range data {
+		if !strings.HasPrefix(string(k), "some-prefix-") {
+			t.Errorf("unexpected blob outside of prefix: %v", k)
+		}
+	}
+}
+
+func TestVerifyFaultyStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := &blobtesting.FaultyStorage{
+		Base: blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil),
+		Faults: map[string][]*blobtesting.Fault{
+			"GetBlob": {{Repeat: 1000, Err: blob.ErrBlobNotFound}},
+		},
+	}
+
+	if err := blobverify.VerifyRangeReads(ctx, st); err == nil {
+		t.Errorf("unexpected success verifying storage that doesn't return written blobs")
+	}
+}
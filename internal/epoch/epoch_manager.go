@@ -0,0 +1,437 @@
+// Package epoch manages index blobs grouped into numbered epochs, which allows index blobs to be
+// listed and compacted safely on storage that does not provide list-after-write consistency.
+//
+// Writers append index blobs to the current epoch. Once the current epoch has enough index blobs
+// (and has lasted long enough), the previous epoch is compacted into a single blob and an epoch marker
+// blob is written that starts the next epoch, which seals the compacted epoch. Uncompacted index blobs
+// of sealed epochs are deleted following a safety interval. Because of that, readers only need to list
+// epoch markers, compacted blobs and uncompacted blobs of the current and previous epoch.
+package epoch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/epoch")
+
+// Blob ID prefixes used by the epoch manager.
+const (
+	// EpochMarkerIndexBlobPrefix is the prefix of blobs that mark the start of each epoch (xe<epoch>).
+	EpochMarkerIndexBlobPrefix blob.ID = "xe"
+
+	// UncompactedIndexBlobPrefix is the prefix of index blobs written by clients (xn<epoch>_<hash>).
+	UncompactedIndexBlobPrefix blob.ID = "xn"
+
+	// SingleEpochCompactionBlobPrefix is the prefix of index blobs holding compacted contents
+	// of a single sealed epoch (xs<epoch>_<hash>).
+	SingleEpochCompactionBlobPrefix blob.ID = "xs"
+)
+
+const epochMarkerContents = "epoch"
+
+// Parameters encapsulates all parameters that influence the behavior of epoch manager.
+type Parameters struct {
+	// Enabled determines whether the repository uses epoch-based index management.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// EpochRefreshFrequency is how frequently each client re-lists blobs to determine the current epoch.
+	EpochRefreshFrequency time.Duration `json:"epochRefreshFrequency,omitempty"`
+
+	// MinEpochDuration is the minimum duration of an epoch.
+	MinEpochDuration time.Duration `json:"minEpochDuration,omitempty"`
+
+	// EpochAdvanceOnCountThreshold advances the epoch once the number of its index blobs reaches this value.
+	EpochAdvanceOnCountThreshold int `json:"epochAdvanceOnCount,omitempty"`
+
+	// EpochAdvanceOnTotalSizeBytesThreshold advances the epoch once the total size of its index blobs reaches this value.
+	EpochAdvanceOnTotalSizeBytesThreshold int64 `json:"epochAdvanceOnSizeBytes,omitempty"`
+
+	// CleanupSafetyMargin is the amount of time uncompacted index blobs are kept after compaction
+	// so that readers with a stale list of blobs can still read them.
+	CleanupSafetyMargin time.Duration `json:"cleanupSafetyMargin,omitempty"`
+}
+
+// Validate validates epoch parameters, nil parameters are valid and mean that epochs are disabled.
+func (p *Parameters) Validate() error {
+	if !p.IsEnabled() {
+		return nil
+	}
+
+	if p.EpochRefreshFrequency <= 0 {
+		return errors.Errorf("epoch refresh frequency must be positive")
+	}
+
+	// a client that has not refreshed the current epoch may write to an epoch that is one behind,
+	// so epochs must last long enough for all clients to notice the change before they get sealed.
+	if p.MinEpochDuration < 3*p.EpochRefreshFrequency { // nolint:gomnd
+		return errors.Errorf("minimum epoch duration (%v) must be at least 3 times the refresh frequency (%v)", p.MinEpochDuration, p.EpochRefreshFrequency)
+	}
+
+	if p.EpochAdvanceOnCountThreshold < 2 { // nolint:gomnd
+		return errors.Errorf("epoch advance count threshold too low: %v", p.EpochAdvanceOnCountThreshold)
+	}
+
+	if p.CleanupSafetyMargin < 3*p.EpochRefreshFrequency { // nolint:gomnd
+		return errors.Errorf("cleanup safety margin (%v) must be at least 3 times the refresh frequency (%v)", p.CleanupSafetyMargin, p.EpochRefreshFrequency)
+	}
+
+	return nil
+}
+
+// IsEnabled returns true if epoch-based index management is enabled.
+func (p *Parameters) IsEnabled() bool {
+	return p != nil && p.Enabled
+}
+
+// DefaultParameters returns the default epoch manager parameters.
+func DefaultParameters() Parameters {
+	return Parameters{
+		Enabled:                               true,
+		EpochRefreshFrequency:                 20 * time.Minute, // nolint:gomnd
+		MinEpochDuration:                      24 * time.Hour,   // nolint:gomnd
+		EpochAdvanceOnCountThreshold:          20,               // nolint:gomnd
+		EpochAdvanceOnTotalSizeBytesThreshold: 10 << 20,         // nolint:gomnd
+		CleanupSafetyMargin:                   4 * time.Hour,    // nolint:gomnd
+	}
+}
+
+// CompactionFunc merges the contents of the provided index blobs into a new blob with the provided prefix.
+type CompactionFunc func(ctx context.Context, blobIDs []blob.ID, outputPrefix blob.ID) error
+
+// CurrentSnapshot captures the state of epochs as of a single point in time.
+type CurrentSnapshot struct {
+	WriteEpoch                int                     `json:"writeEpoch"`
+	EpochStartTime            map[int]time.Time       `json:"epochStartTimes"`
+	UncompactedEpochSets      map[int][]blob.Metadata `json:"uncompacted"`
+	SingleEpochCompactionSets map[int][]blob.Metadata `json:"singleEpochCompactionSets"`
+	ValidUntil                time.Time               `json:"validUntil"`
+}
+
+// IndexBlobs returns the set of index blobs that together hold all contents of the repository:
+// compacted blobs of sealed epochs and uncompacted blobs of the current and previous epoch.
+func (cs *CurrentSnapshot) IndexBlobs() []blob.Metadata {
+	var result []blob.Metadata
+
+	for n := 0; n <= cs.WriteEpoch; n++ {
+		if cs.isSealed(n) {
+			result = append(result, cs.SingleEpochCompactionSets[n]...)
+		} else {
+			result = append(result, cs.UncompactedEpochSets[n]...)
+		}
+	}
+
+	return result
+}
+
+// isSealed returns true if the epoch no longer receives index blobs, which means that it is represented by its
+// compacted blobs.
+func (cs *CurrentSnapshot) isSealed(epoch int) bool {
+	return epoch < cs.WriteEpoch-1
+}
+
+// isCompacted returns true if the epoch is sealed and has been compacted.
+func (cs *CurrentSnapshot) isCompacted(epoch int) bool {
+	return cs.isSealed(epoch) && len(cs.SingleEpochCompactionSets[epoch]) > 0
+}
+
+// epochStartTime returns the start time of the provided epoch. The first epoch has no marker,
+// so it is deemed to have started when its first index blob was written.
+func (cs *CurrentSnapshot) epochStartTime(epoch int) (time.Time, bool) {
+	if t, ok := cs.EpochStartTime[epoch]; ok {
+		return t, true
+	}
+
+	if epoch == 0 && len(cs.UncompactedEpochSets[0]) > 0 {
+		return blobSetMinTime(cs.UncompactedEpochSets[0]), true
+	}
+
+	return time.Time{}, false
+}
+
+// Manager manages the repository epochs.
+type Manager struct {
+	Params Parameters
+
+	st      blob.Storage
+	compact CompactionFunc
+	timeNow func() time.Time
+
+	mu             sync.Mutex
+	lastKnownState CurrentSnapshot
+}
+
+// Current returns the current snapshot of epochs, refreshing it if it's older than EpochRefreshFrequency.
+func (e *Manager) Current(ctx context.Context) (CurrentSnapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.timeNow().After(e.lastKnownState.ValidUntil) {
+		if err := e.refreshLocked(ctx); err != nil {
+			return CurrentSnapshot{}, err
+		}
+	}
+
+	return e.lastKnownState, nil
+}
+
+// Refresh lists the storage to determine the current state of epochs.
+func (e *Manager) Refresh(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.refreshLocked(ctx)
+}
+
+// Invalidate causes the next call to Current() to refresh the state of epochs.
+func (e *Manager) Invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastKnownState = CurrentSnapshot{}
+}
+
+// GetCompleteIndexSet returns the set of index blobs that together hold all contents of the repository.
+func (e *Manager) GetCompleteIndexSet(ctx context.Context) ([]blob.Metadata, error) {
+	cs, err := e.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return cs.IndexBlobs(), nil
+}
+
+// WriteIndexBlobPrefix returns the blob ID prefix to be used for new index blobs, which places them
+// in the current epoch.
+func (e *Manager) WriteIndexBlobPrefix(ctx context.Context) (blob.ID, error) {
+	cs, err := e.Current(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return uncompactedEpochBlobPrefix(cs.WriteEpoch), nil
+}
+
+func (e *Manager) refreshLocked(ctx context.Context) error {
+	cs := CurrentSnapshot{
+		EpochStartTime:            map[int]time.Time{},
+		UncompactedEpochSets:      map[int][]blob.Metadata{},
+		SingleEpochCompactionSets: map[int][]blob.Metadata{},
+		ValidUntil:                e.timeNow().Add(e.Params.EpochRefreshFrequency),
+	}
+
+	markers, err := blob.ListAllBlobs(ctx, e.st, EpochMarkerIndexBlobPrefix)
+	if err != nil {
+		return errors.Wrap(err, "error listing epoch markers")
+	}
+
+	for _, m := range markers {
+		n, ok := epochNumberFromBlobID(m.BlobID)
+		if !ok {
+			continue
+		}
+
+		cs.EpochStartTime[n] = m.Timestamp
+
+		if n > cs.WriteEpoch {
+			cs.WriteEpoch = n
+		}
+	}
+
+	compacted, err := blob.ListAllBlobs(ctx, e.st, SingleEpochCompactionBlobPrefix)
+	if err != nil {
+		return errors.Wrap(err, "error listing compacted index blobs")
+	}
+
+	cs.SingleEpochCompactionSets = groupByEpochNumber(compacted)
+
+	// older epochs are compacted before they are sealed, so uncompacted blobs only need to be listed
+	// for the current and previous epoch.
+	for n := cs.WriteEpoch - 1; n <= cs.WriteEpoch; n++ {
+		if n < 0 {
+			continue
+		}
+
+		bms, err := blob.ListAllBlobs(ctx, e.st, uncompactedEpochBlobPrefix(n))
+		if err != nil {
+			return errors.Wrapf(err, "error listing index blobs of epoch %v", n)
+		}
+
+		cs.UncompactedEpochSets[n] = bms
+	}
+
+	log(ctx).Debugf("current epoch %v, %v index blobs", cs.WriteEpoch, len(cs.IndexBlobs()))
+
+	e.lastKnownState = cs
+
+	return nil
+}
+
+// Maintain compacts the previous epoch and advances the current epoch if needed and removes index blobs
+// superseded by compaction. It is meant to be invoked periodically as part of repository maintenance.
+func (e *Manager) Maintain(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.refreshLocked(ctx); err != nil {
+		return err
+	}
+
+	if e.shouldAdvance(e.lastKnownState) {
+		// advancing the epoch seals the previous one, after which readers no longer list its uncompacted
+		// blobs, so it must be compacted first. Since the current epoch has lasted for at least MinEpochDuration,
+		// all clients have noticed it by now and no longer write to the previous epoch.
+		if err := e.compactUpToEpochLocked(ctx, e.lastKnownState.WriteEpoch-1); err != nil {
+			return err
+		}
+
+		if err := e.advanceEpochLocked(ctx); err != nil {
+			return err
+		}
+	}
+
+	return e.cleanupLocked(ctx)
+}
+
+func (e *Manager) shouldAdvance(cs CurrentSnapshot) bool {
+	startTime, ok := cs.epochStartTime(cs.WriteEpoch)
+	if !ok || e.timeNow().Sub(startTime) < e.Params.MinEpochDuration {
+		return false
+	}
+
+	bms := cs.UncompactedEpochSets[cs.WriteEpoch]
+
+	if len(bms) >= e.Params.EpochAdvanceOnCountThreshold {
+		return true
+	}
+
+	return e.Params.EpochAdvanceOnTotalSizeBytesThreshold > 0 && blobSetTotalSize(bms) >= e.Params.EpochAdvanceOnTotalSizeBytesThreshold
+}
+
+func (e *Manager) advanceEpochLocked(ctx context.Context) error {
+	next := e.lastKnownState.WriteEpoch + 1
+
+	log(ctx).Debugf("advancing epoch to %v", next)
+
+	if err := e.st.PutBlob(ctx, epochMarkerBlobID(next), []byte(epochMarkerContents)); err != nil {
+		return errors.Wrapf(err, "error writing marker of epoch %v", next)
+	}
+
+	return e.refreshLocked(ctx)
+}
+
+// compactUpToEpochLocked compacts all epochs up to and including the provided one that have not been compacted yet.
+func (e *Manager) compactUpToEpochLocked(ctx context.Context, maxEpoch int) error {
+	cs := e.lastKnownState
+
+	for n := 0; n <= maxEpoch; n++ {
+		if len(cs.SingleEpochCompactionSets[n]) > 0 {
+			continue
+		}
+
+		bms, err := blob.ListAllBlobs(ctx, e.st, uncompactedEpochBlobPrefix(n))
+		if err != nil {
+			return errors.Wrapf(err, "error listing index blobs of epoch %v", n)
+		}
+
+		if len(bms) == 0 {
+			continue
+		}
+
+		log(ctx).Debugf("compacting %v index blobs of epoch %v", len(bms), n)
+
+		if err := e.compact(ctx, blobIDs(bms), singleEpochCompactionBlobPrefix(n)); err != nil {
+			return errors.Wrapf(err, "unable to compact epoch %v", n)
+		}
+	}
+
+	return nil
+}
+
+// cleanupLocked removes uncompacted index blobs of sealed epochs that have been compacted for longer than
+// the safety margin. Blobs written to a sealed epoch after it was compacted are not included in its compacted
+// blobs and are not listed by readers, so they are compacted into an additional compacted blob of the epoch
+// and removed after the safety margin, like all other blobs.
+func (e *Manager) cleanupLocked(ctx context.Context) error {
+	cs := e.lastKnownState
+
+	uncompacted, err := blob.ListAllBlobs(ctx, e.st, UncompactedIndexBlobPrefix)
+	if err != nil {
+		return errors.Wrap(err, "error listing index blobs")
+	}
+
+	compactedLateBlobs := false
+
+	for n, bms := range groupByEpochNumber(uncompacted) {
+		if !cs.isCompacted(n) {
+			continue
+		}
+
+		var late []blob.Metadata
+
+		for _, bm := range bms {
+			compactedAt, ok := firstWrittenAfter(cs.SingleEpochCompactionSets[n], bm.Timestamp)
+			if !ok {
+				late = append(late, bm)
+				continue
+			}
+
+			if e.timeNow().Sub(compactedAt) < e.Params.CleanupSafetyMargin {
+				continue
+			}
+
+			log(ctx).Debugf("deleting index blob %v of compacted epoch %v", bm.BlobID, n)
+
+			if err := e.st.DeleteBlob(ctx, bm.BlobID); err != nil && err != blob.ErrBlobNotFound {
+				return errors.Wrapf(err, "unable to delete compacted index blob %v", bm.BlobID)
+			}
+		}
+
+		if len(late) == 0 {
+			continue
+		}
+
+		log(ctx).Warningf("compacting %v index blobs written to epoch %v after it was compacted", len(late), n)
+
+		if err := e.compact(ctx, blobIDs(late), singleEpochCompactionBlobPrefix(n)); err != nil {
+			return errors.Wrapf(err, "unable to compact late index blobs of epoch %v", n)
+		}
+
+		compactedLateBlobs = true
+	}
+
+	if compactedLateBlobs {
+		return e.refreshLocked(ctx)
+	}
+
+	return nil
+}
+
+func epochMarkerBlobID(epoch int) blob.ID {
+	return EpochMarkerIndexBlobPrefix + blob.ID(fmt.Sprintf("%v", epoch))
+}
+
+func uncompactedEpochBlobPrefix(epoch int) blob.ID {
+	return UncompactedIndexBlobPrefix + blob.ID(fmt.Sprintf("%v_", epoch))
+}
+
+func singleEpochCompactionBlobPrefix(epoch int) blob.ID {
+	return SingleEpochCompactionBlobPrefix + blob.ID(fmt.Sprintf("%v_", epoch))
+}
+
+// NewManager creates new epoch manager.
+func NewManager(st blob.Storage, params Parameters, compactor CompactionFunc, timeNow func() time.Time) *Manager {
+	return &Manager{
+		Params:  params,
+		st:      st,
+		compact: compactor,
+		timeNow: timeNow,
+	}
+}
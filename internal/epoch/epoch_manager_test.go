@@ -0,0 +1,344 @@
+package epoch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+var testParams = Parameters{
+	Enabled:                               true,
+	EpochRefreshFrequency:                 20 * time.Minute,
+	MinEpochDuration:                      2 * time.Hour,
+	EpochAdvanceOnCountThreshold:          10,
+	EpochAdvanceOnTotalSizeBytesThreshold: 1 << 20,
+	CleanupSafetyMargin:                   time.Hour,
+}
+
+type epochTest struct {
+	st      blob.Storage
+	ta      *faketime.TimeAdvance
+	written map[string]bool
+}
+
+func newEpochTest() *epochTest {
+	ta := faketime.NewTimeAdvance(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	return &epochTest{
+		st:      blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, ta.NowFunc()),
+		ta:      ta,
+		written: map[string]bool{},
+	}
+}
+
+// compact is a test compaction function that merges lines of all index blobs.
+func (te *epochTest) compact(ctx context.Context, blobIDs []blob.ID, outputPrefix blob.ID) error {
+	lines, err := te.readLines(ctx, blobIDs)
+	if err != nil {
+		return err
+	}
+
+	return te.writeBlob(ctx, outputPrefix, lines)
+}
+
+func (te *epochTest) writeBlob(ctx context.Context, prefix blob.ID, lines []string) error {
+	sort.Strings(lines)
+
+	data := []byte(strings.Join(lines, "\n"))
+
+	return te.st.PutBlob(ctx, prefix+blob.ID(fmt.Sprintf("%x", sha256.Sum256(data))), data)
+}
+
+func (te *epochTest) readLines(ctx context.Context, blobIDs []blob.ID) ([]string, error) {
+	var result []string
+
+	for _, id := range blobIDs {
+		data, err := te.st.GetBlob(ctx, id, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, strings.Split(string(data), "\n")...)
+	}
+
+	return result, nil
+}
+
+func (te *epochTest) newManager() *Manager {
+	return NewManager(te.st, testParams, te.compact, te.ta.NowFunc())
+}
+
+func (te *epochTest) writeIndexBlob(ctx context.Context, t *testing.T, m *Manager, item string) {
+	t.Helper()
+
+	prefix, err := m.WriteIndexBlobPrefix(ctx)
+	if err != nil {
+		t.Fatalf("unable to get write prefix: %v", err)
+	}
+
+	if err := te.writeBlob(ctx, prefix, []string{item}); err != nil {
+		t.Fatalf("unable to write index blob: %v", err)
+	}
+
+	te.written[item] = true
+}
+
+// verifyAllWrittenVisible verifies that a fresh reader sees all items that have been written.
+func (te *epochTest) verifyAllWrittenVisible(ctx context.Context, t *testing.T) CurrentSnapshot {
+	t.Helper()
+
+	reader := te.newManager()
+
+	bms, err := reader.GetCompleteIndexSet(ctx)
+	if err != nil {
+		t.Fatalf("unable to get index set: %v", err)
+	}
+
+	lines, err := te.readLines(ctx, blobIDs(bms))
+	if err != nil {
+		t.Fatalf("unable to read index blobs: %v", err)
+	}
+
+	seen := map[string]bool{}
+	for _, l := range lines {
+		seen[l] = true
+	}
+
+	for item := range te.written {
+		if !seen[item] {
+			t.Fatalf("item %v not found in index blobs", item)
+		}
+	}
+
+	cs, err := reader.Current(ctx)
+	if err != nil {
+		t.Fatalf("unable to get current state: %v", err)
+	}
+
+	return cs
+}
+
+func TestEpochManager(t *testing.T) {
+	ctx := testlogging.Context(t)
+	te := newEpochTest()
+
+	writer := te.newManager()
+	maintainer := te.newManager()
+
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 3; j++ {
+			te.writeIndexBlob(ctx, t, writer, fmt.Sprintf("item-%v-%v", i, j))
+		}
+
+		if err := maintainer.Maintain(ctx); err != nil {
+			t.Fatalf("maintenance error: %v", err)
+		}
+
+		te.verifyAllWrittenVisible(ctx, t)
+		te.ta.Advance(15 * time.Minute)
+	}
+
+	cs := te.verifyAllWrittenVisible(ctx, t)
+
+	if cs.WriteEpoch < 5 {
+		t.Fatalf("epoch has not advanced enough: %v", cs.WriteEpoch)
+	}
+
+	// all sealed epochs must be compacted and their uncompacted blobs removed.
+	for n := 0; n < cs.WriteEpoch-1; n++ {
+		if !cs.isCompacted(n) {
+			t.Errorf("epoch %v not compacted", n)
+		}
+	}
+
+	remaining, err := blob.ListAllBlobs(ctx, te.st, UncompactedIndexBlobPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := range groupByEpochNumber(remaining) {
+		if n < cs.WriteEpoch-2 {
+			t.Errorf("uncompacted blobs of epoch %v have not been removed", n)
+		}
+	}
+
+	// readers only need to list current and previous epoch.
+	for n := range cs.UncompactedEpochSets {
+		if n < cs.WriteEpoch-1 {
+			t.Errorf("unexpected listing of uncompacted epoch %v", n)
+		}
+	}
+}
+
+func TestEpochManagerStaleWriter(t *testing.T) {
+	ctx := testlogging.Context(t)
+	te := newEpochTest()
+
+	maintainer := te.newManager()
+
+	for i := 0; i < 10; i++ {
+		te.writeIndexBlob(ctx, t, maintainer, fmt.Sprintf("item-%v", i))
+	}
+
+	te.ta.Advance(testParams.MinEpochDuration)
+
+	// writer determines the current epoch before it advances.
+	writer := te.newManager()
+	if err := writer.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := maintainer.Maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if cs, _ := maintainer.Current(ctx); cs.WriteEpoch != 1 {
+		t.Fatalf("epoch has not advanced: %v", cs.WriteEpoch)
+	}
+
+	// items written to the previous epoch are still visible.
+	te.writeIndexBlob(ctx, t, writer, "stale-item")
+	te.verifyAllWrittenVisible(ctx, t)
+
+	// after refresh, writer writes to the new epoch.
+	te.ta.Advance(testParams.EpochRefreshFrequency + time.Second)
+
+	prefix, err := writer.WriteIndexBlobPrefix(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := prefix, uncompactedEpochBlobPrefix(1); got != want {
+		t.Errorf("unexpected write prefix %v, want %v", got, want)
+	}
+}
+
+func TestEpochManagerCompactsLateWrites(t *testing.T) {
+	ctx := testlogging.Context(t)
+	te := newEpochTest()
+
+	m := te.newManager()
+
+	// advance twice, which compacts epoch 0 and seals it.
+	for e := 0; e < 2; e++ {
+		for i := 0; i < 10; i++ {
+			te.writeIndexBlob(ctx, t, m, fmt.Sprintf("item-%v-%v", e, i))
+		}
+
+		te.ta.Advance(testParams.MinEpochDuration)
+
+		if err := m.Maintain(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cs, err := m.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cs.isCompacted(0) {
+		t.Fatalf("epoch 0 has not been compacted")
+	}
+
+	// simulate a blob written to epoch 0 by a client that has not noticed the compaction.
+	te.ta.Advance(time.Second)
+
+	if err := te.writeBlob(ctx, uncompactedEpochBlobPrefix(0), []string{"late-item"}); err != nil {
+		t.Fatal(err)
+	}
+
+	te.written["late-item"] = true
+
+	te.ta.Advance(testParams.CleanupSafetyMargin)
+
+	// the late blob is compacted into another compacted blob of epoch 0, which makes it visible to readers.
+	if err := m.Maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if cs = te.verifyAllWrittenVisible(ctx, t); len(cs.SingleEpochCompactionSets[0]) != 2 {
+		t.Fatalf("unexpected compacted blobs of epoch 0: %v", cs.SingleEpochCompactionSets[0])
+	}
+
+	remaining, err := blob.ListAllBlobs(ctx, te.st, uncompactedEpochBlobPrefix(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(remaining), 1; got != want {
+		t.Fatalf("unexpected number of remaining blobs of epoch 0: %v, want %v", got, want)
+	}
+
+	// the late blob is removed after the safety margin and is not compacted again.
+	te.ta.Advance(testParams.CleanupSafetyMargin)
+
+	if err := m.Maintain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining, err = blob.ListAllBlobs(ctx, te.st, uncompactedEpochBlobPrefix(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 0 {
+		t.Fatalf("late blobs of epoch 0 have not been removed: %v", remaining)
+	}
+
+	if cs = te.verifyAllWrittenVisible(ctx, t); len(cs.SingleEpochCompactionSets[0]) != 2 {
+		t.Fatalf("unexpected compacted blobs of epoch 0: %v", cs.SingleEpochCompactionSets[0])
+	}
+}
+
+func TestEpochNumberFromBlobID(t *testing.T) {
+	cases := []struct {
+		input blob.ID
+		want  int
+		ok    bool
+	}{
+		{"xe0", 0, true},
+		{"xe123", 123, true},
+		{"xn5_abcd", 5, true},
+		{"xs15_1234", 15, true},
+		{"xn_abcd", 0, false},
+		{"xnabc_1", 0, false},
+	}
+
+	for _, tc := range cases {
+		got, ok := epochNumberFromBlobID(tc.input)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("invalid result for %v: %v %v, want %v %v", tc.input, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParametersValidate(t *testing.T) {
+	if err := (&Parameters{}).Validate(); err != nil {
+		t.Errorf("disabled parameters should be valid: %v", err)
+	}
+
+	p := DefaultParameters()
+	if err := p.Validate(); err != nil {
+		t.Errorf("default parameters should be valid: %v", err)
+	}
+
+	p.MinEpochDuration = p.EpochRefreshFrequency
+	if err := p.Validate(); err == nil {
+		t.Errorf("expected error for too short epochs")
+	}
+
+	p = DefaultParameters()
+	p.CleanupSafetyMargin = p.EpochRefreshFrequency
+	if err := p.Validate(); err == nil {
+		t.Errorf("expected error for too short cleanup safety margin")
+	}
+}
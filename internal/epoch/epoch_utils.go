@@ -0,0 +1,99 @@
+package epoch
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// epochNumberFromBlobID extracts the epoch number from a string formatted as <prefix><epochNumber>_<remainder>.
+func epochNumberFromBlobID(blobID blob.ID) (int, bool) {
+	s := string(blobID)
+
+	if p := strings.IndexByte(s, '_'); p >= 0 {
+		s = s[0:p]
+	}
+
+	for len(s) > 0 && !isDigit(s[0]) {
+		s = s[1:]
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// groupByEpochNumber groups blobs by the epoch number encoded in their IDs, ignoring blobs whose IDs
+// don't contain a valid epoch number.
+func groupByEpochNumber(bms []blob.Metadata) map[int][]blob.Metadata {
+	result := map[int][]blob.Metadata{}
+
+	for _, bm := range bms {
+		if n, ok := epochNumberFromBlobID(bm.BlobID); ok {
+			result[n] = append(result[n], bm)
+		}
+	}
+
+	return result
+}
+
+func blobSetTotalSize(bms []blob.Metadata) int64 {
+	var total int64
+
+	for _, bm := range bms {
+		total += bm.Length
+	}
+
+	return total
+}
+
+func blobSetMinTime(bms []blob.Metadata) time.Time {
+	var min time.Time
+
+	for i, bm := range bms {
+		if i == 0 || bm.Timestamp.Before(min) {
+			min = bm.Timestamp
+		}
+	}
+
+	return min
+}
+
+func blobIDs(bms []blob.Metadata) []blob.ID {
+	var result []blob.ID
+
+	for _, bm := range bms {
+		result = append(result, bm.BlobID)
+	}
+
+	return result
+}
+
+// firstWrittenAfter returns the timestamp of the earliest blob in the set that was not written before the provided time.
+func firstWrittenAfter(bms []blob.Metadata, t time.Time) (time.Time, bool) {
+	var (
+		result time.Time
+		found  bool
+	)
+
+	for _, bm := range bms {
+		if bm.Timestamp.Before(t) {
+			continue
+		}
+
+		if !found || bm.Timestamp.Before(result) {
+			result, found = bm.Timestamp, true
+		}
+	}
+
+	return result, found
+}
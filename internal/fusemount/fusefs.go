@@ -6,8 +6,9 @@
 package fusemount
 
 import (
-	"io/ioutil"
+	"io"
 	"os"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -38,14 +39,52 @@ type fuseFileNode struct {
 	fuseNode
 }
 
-func (f *fuseFileNode) ReadAll(ctx context.Context) ([]byte, error) {
+func (f *fuseFileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
 	reader, err := f.entry.(fs.File).Open(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close() //nolint:errcheck
 
-	return ioutil.ReadAll(reader)
+	return &fuseFileHandle{reader: reader}, nil
+}
+
+// fuseFileHandle reads portions of the file requested by the kernel, so that random reads of large files
+// only fetch the chunks that are needed.
+type fuseFileHandle struct {
+	mu     sync.Mutex // protects reader when it does not support io.ReaderAt
+	reader fs.Reader
+}
+
+func (h *fuseFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+
+	n, err := h.readAt(buf, req.Offset)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	resp.Data = buf[0:n]
+
+	return nil
+}
+
+func (h *fuseFileHandle) readAt(buf []byte, off int64) (int, error) {
+	if ra, ok := h.reader.(io.ReaderAt); ok {
+		return ra.ReadAt(buf, off)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.reader.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(h.reader, buf)
+}
+
+func (h *fuseFileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return h.reader.Close()
 }
 
 type fuseDirectoryNode struct {
@@ -12,6 +12,7 @@ import (
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/encryption"
 	"github.com/kopia/kopia/repo/hashing"
 	"github.com/kopia/kopia/repo/splitter"
@@ -25,7 +26,7 @@ func (s *Server) handleRepoStatus(ctx context.Context, r *http.Request) (interfa
 		}, nil
 	}
 
-	return &serverapi.StatusResponse{
+	st := &serverapi.StatusResponse{
 		Connected:   true,
 		ConfigFile:  s.rep.ConfigFile,
 		CacheDir:    s.rep.Content.CachingOptions.CacheDirectory,
@@ -34,10 +35,27 @@ func (s *Server) handleRepoStatus(ctx context.Context, r *http.Request) (interfa
 		MaxPackSize: s.rep.Content.Format.MaxPackSize,
 		Splitter:    s.rep.Objects.Format.Splitter,
 		Storage:     s.rep.Blobs.ConnectionInfo().Type,
-	}, nil
+	}
+
+	if c, err := blob.GetCapacity(ctx, s.rep.Blobs); err == nil {
+		st.Capacity = &c
+	} else if err != blob.ErrCapacityNotSupported {
+		log(ctx).Warningf("unable to get storage capacity: %v", err)
+	}
+
+	return st, nil
 }
 
-func maybeDecodeToken(req *serverapi.ConnectRepositoryRequest) *apiError {
+func resolveStorageConnectionInfo(req *serverapi.ConnectRepositoryRequest) *apiError {
+	if req.StorageURL != "" {
+		ci, err := blob.ParseURL(req.StorageURL)
+		if err != nil {
+			return requestError(serverapi.ErrorStorageConnection, "invalid storage URL: "+err.Error())
+		}
+
+		req.Storage = ci
+	}
+
 	if req.Token != "" {
 		ci, password, err := repo.DecodeToken(req.Token)
 		if err != nil {
@@ -64,7 +82,7 @@ func (s *Server) handleRepoCreate(ctx context.Context, r *http.Request) (interfa
 		return nil, requestError(serverapi.ErrorMalformedRequest, "unable to decode request: "+err.Error())
 	}
 
-	if err := maybeDecodeToken(&req.ConnectRepositoryRequest); err != nil {
+	if err := resolveStorageConnectionInfo(&req.ConnectRepositoryRequest); err != nil {
 		return nil, err
 	}
 
@@ -86,7 +104,7 @@ func (s *Server) handleRepoCreate(ctx context.Context, r *http.Request) (interfa
 		return nil, internalServerError(errors.Wrap(err, "set global policy"))
 	}
 
-	if err := s.rep.Flush(ctx); err != nil {
+	if err := s.rep.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
 		return nil, internalServerError(errors.Wrap(err, "flush"))
 	}
 
@@ -104,7 +122,7 @@ func (s *Server) handleRepoConnect(ctx context.Context, r *http.Request) (interf
 		return nil, requestError(serverapi.ErrorMalformedRequest, "unable to decode request: "+err.Error())
 	}
 
-	if err := maybeDecodeToken(&req); err != nil {
+	if err := resolveStorageConnectionInfo(&req); err != nil {
 		return nil, err
 	}
 
@@ -147,7 +165,7 @@ func (s *Server) connectAndOpen(ctx context.Context, conn blob.ConnectionInfo, p
 		return repoErrorToAPIError(err)
 	}
 
-	rep, err := repo.Open(ctx, s.options.ConfigFile, password, nil)
+	rep, err := repo.Open(ctx, s.options.ConfigFile, password, s.options.OpenOptions)
 	if err != nil {
 		return repoErrorToAPIError(err)
 	}
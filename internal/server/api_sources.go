@@ -11,6 +11,7 @@ import (
 
 	"github.com/kopia/kopia/internal/ctxutil"
 	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
 )
@@ -84,7 +85,7 @@ func (s *Server) handleSourcesCreate(ctx context.Context, r *http.Request) (inte
 			return nil, internalServerError(errors.Wrap(err, "unable to set initial policy"))
 		}
 
-		if err = s.rep.Flush(ctx); err != nil {
+		if err = s.rep.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
 			return nil, internalServerError(errors.Wrap(err, "unable to flush"))
 		}
 
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"go.opencensus.io/metric/metricproducer"
 
 	"github.com/kopia/kopia/internal/serverapi"
 	"github.com/kopia/kopia/repo"
@@ -34,6 +35,8 @@ type Server struct {
 	mu              sync.RWMutex
 	sourceManagers  map[snapshot.SourceInfo]*sourceManager
 	uploadSemaphore chan struct{}
+
+	metricsProducer metricproducer.Producer
 }
 
 // APIHandlers handles API requests.
@@ -331,6 +334,7 @@ func (s *Server) syncSourcesLocked(ctx context.Context) error {
 type Options struct {
 	ConfigFile      string
 	ConnectOptions  *repo.ConnectOptions
+	OpenOptions     *repo.Options // options used when opening repositories connected through the API
 	RefreshInterval time.Duration
 }
 
@@ -343,5 +347,13 @@ func New(ctx context.Context, rep *repo.Repository, options Options) (*Server, e
 		uploadSemaphore: make(chan struct{}, 1),
 	}
 
+	s.metricsProducer = sourceMetricsProducer{s}
+	metricproducer.GlobalManager().AddProducer(s.metricsProducer)
+
 	return s, nil
 }
+
+// Close releases resources associated with the server, it must be called when the server is shut down.
+func (s *Server) Close() {
+	metricproducer.GlobalManager().DeleteProducer(s.metricsProducer)
+}
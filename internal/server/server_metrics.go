@@ -0,0 +1,179 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"contrib.go.opencensus.io/exporter/prometheus"
+	"github.com/pkg/errors"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/metric/metricproducer"
+
+	"github.com/kopia/kopia/internal/serverapi"
+)
+
+var sourceLabelKeys = []metricdata.LabelKey{{Key: "source", Description: "Snapshot source (user@host:path)"}}
+
+// MetricsHandler returns a handler that exposes all registered metrics in Prometheus format.
+func MetricsHandler() (http.Handler, error) {
+	reg := prom.NewRegistry()
+	if err := reg.Register(prom.NewProcessCollector(prom.ProcessCollectorOpts{})); err != nil {
+		return nil, errors.Wrap(err, "error registering process collector")
+	}
+
+	if err := reg.Register(prom.NewGoCollector()); err != nil {
+		return nil, errors.Wrap(err, "error registering go collector")
+	}
+
+	pe, err := prometheus.NewExporter(prometheus.Options{
+		Registry: reg,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to initialize prometheus exporter")
+	}
+
+	return pe, nil
+}
+
+// sourceMetricsProducer reports the state of all sources managed by the server as gauges.
+type sourceMetricsProducer struct {
+	server *Server
+}
+
+type sourceGauge struct {
+	name        string
+	description string
+	unit        metricdata.Unit
+	value       func(st *serverapi.SourceStatus) (int64, bool)
+}
+
+var sourceGauges = []sourceGauge{
+	{
+		"kopia/source/last_snapshot_time", "Start time of the last snapshot (seconds since Unix epoch)", metricdata.UnitDimensionless,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.LastSnapshotTime == nil {
+				return 0, false
+			}
+
+			return st.LastSnapshotTime.Unix(), true
+		},
+	},
+	{
+		"kopia/source/last_snapshot_size", "Total size of files in the last complete snapshot", metricdata.UnitBytes,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.LastSnapshotSize == nil {
+				return 0, false
+			}
+
+			return *st.LastSnapshotSize, true
+		},
+	},
+	{
+		"kopia/source/next_snapshot_time", "Time of the next scheduled snapshot (seconds since Unix epoch)", metricdata.UnitDimensionless,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.NextSnapshotTime == nil {
+				return 0, false
+			}
+
+			return st.NextSnapshotTime.Unix(), true
+		},
+	},
+	{
+		"kopia/source/uploading", "Whether a snapshot of the source is being uploaded (1) or not (0)", metricdata.UnitDimensionless,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.UploadCounters != nil {
+				return 1, true
+			}
+
+			return 0, true
+		},
+	},
+	{
+		"kopia/source/upload_hashed_bytes", "Number of bytes hashed by the upload in progress", metricdata.UnitBytes,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.UploadCounters == nil {
+				return 0, false
+			}
+
+			return st.UploadCounters.TotalHashedBytes, true
+		},
+	},
+	{
+		"kopia/source/upload_cached_bytes", "Number of bytes of cached files found by the upload in progress", metricdata.UnitBytes,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.UploadCounters == nil {
+				return 0, false
+			}
+
+			return st.UploadCounters.TotalCachedBytes, true
+		},
+	},
+	{
+		"kopia/source/upload_hashed_files", "Number of files hashed by the upload in progress", metricdata.UnitDimensionless,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.UploadCounters == nil {
+				return 0, false
+			}
+
+			return int64(st.UploadCounters.TotalHashedFiles), true
+		},
+	},
+	{
+		"kopia/source/upload_cached_files", "Number of cached files found by the upload in progress", metricdata.UnitDimensionless,
+		func(st *serverapi.SourceStatus) (int64, bool) {
+			if st.UploadCounters == nil {
+				return 0, false
+			}
+
+			return int64(st.UploadCounters.TotalCachedFiles), true
+		},
+	},
+}
+
+// Read implements metricproducer.Producer.
+func (p sourceMetricsProducer) Read() []*metricdata.Metric {
+	now := time.Now()
+
+	var statuses []*serverapi.SourceStatus
+
+	p.server.mu.RLock()
+	for _, sm := range p.server.sourceManagers {
+		statuses = append(statuses, sm.Status())
+	}
+	p.server.mu.RUnlock()
+
+	var result []*metricdata.Metric
+
+	for _, g := range sourceGauges {
+		m := &metricdata.Metric{
+			Descriptor: metricdata.Descriptor{
+				Name:        g.name,
+				Description: g.description,
+				Unit:        g.unit,
+				Type:        metricdata.TypeGaugeInt64,
+				LabelKeys:   sourceLabelKeys,
+			},
+		}
+
+		for _, st := range statuses {
+			v, ok := g.value(st)
+			if !ok {
+				continue
+			}
+
+			m.TimeSeries = append(m.TimeSeries, &metricdata.TimeSeries{
+				LabelValues: []metricdata.LabelValue{metricdata.NewLabelValue(st.Source.String())},
+				Points:      []metricdata.Point{metricdata.NewInt64Point(now, v)},
+			})
+		}
+
+		if len(m.TimeSeries) > 0 {
+			result = append(result, m)
+		}
+	}
+
+	return result
+}
+
+var _ metricproducer.Producer = sourceMetricsProducer{}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	s, err := New(ctx, nil, Options{})
+	if err != nil {
+		t.Fatalf("unable to create server: %v", err)
+	}
+
+	src := snapshot.SourceInfo{UserName: "user", Host: "host", Path: "/some/path"}
+	sm := newSourceManager(src, s)
+	sm.lastSnapshot = &snapshot.Manifest{StartTime: time.Unix(1500000000, 0)}
+	sm.lastCompleteSnapshot = &snapshot.Manifest{Stats: snapshot.Stats{TotalFileSize: 12345}}
+	s.sourceManagers[src] = sm
+
+	h, err := MetricsHandler()
+	if err != nil {
+		t.Fatalf("unable to create metrics handler: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("unable to read response: %v", err)
+	}
+
+	for _, want := range []string{
+		`kopia_source_last_snapshot_time{source="user@host:/some/path"} 1.5e+09`,
+		`kopia_source_last_snapshot_size{source="user@host:/some/path"} 12345`,
+		`kopia_source_uploading{source="user@host:/some/path"} 0`,
+		`kopia_content_cache_hit_ratio`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output does not contain %q:\n%s", want, body)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/kopia/kopia/fs/localfs"
 	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
@@ -257,8 +258,12 @@ func (s *sourceManager) snapshot(ctx context.Context) {
 	log(ctx).Infof("created snapshot %v", snapshotID)
 
 	if err := s.server.rep.Flush(ctx); err != nil {
-		log(ctx).Errorf("unable to flush: %v", err)
-		return
+		if !content.IsPacksSpooled(err) {
+			log(ctx).Errorf("unable to flush: %v", err)
+			return
+		}
+
+		log(ctx).Warningf("snapshot %v has not been fully uploaded yet, remaining packs will be uploaded from the local spool", snapshotID)
 	}
 }
 
@@ -23,6 +23,9 @@ type StatusResponse struct {
 	Splitter    string `json:"splitter,omitempty"`
 	MaxPackSize int    `json:"maxPackSize,omitempty"`
 	Storage     string `json:"storage,omitempty"`
+
+	// Capacity is only present if supported by the storage.
+	Capacity *blob.Capacity `json:"capacity,omitempty"`
 }
 
 // SourcesResponse is the response of 'sources' HTTP API command.
@@ -102,9 +105,10 @@ type CreateRepositoryRequest struct {
 
 // ConnectRepositoryRequest contains request to connect to a repository.
 type ConnectRepositoryRequest struct {
-	Storage  blob.ConnectionInfo `json:"storage"`
-	Password string              `json:"password"`
-	Token    string              `json:"token"` // when set, overrides Storage and Password
+	Storage    blob.ConnectionInfo `json:"storage"`
+	StorageURL string              `json:"storageURL,omitempty"` // when set, overrides Storage
+	Password   string              `json:"password"`
+	Token      string              `json:"token"` // when set, overrides Storage, StorageURL and Password
 }
 
 // SupportedAlgorithmsResponse returns the list of supported algorithms for repository creation.
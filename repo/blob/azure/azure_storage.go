@@ -260,4 +260,5 @@ func init() {
 		func(ctx context.Context, o interface{}) (blob.Storage, error) {
 			return New(ctx, o.(*Options))
 		})
+	blob.AddSupportedURLScheme(azURLScheme, azStorageType, parseURL, formatURL)
 }
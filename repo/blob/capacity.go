@@ -0,0 +1,31 @@
+package blob
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCapacityNotSupported is returned by GetCapacity when the storage can't report its capacity.
+var ErrCapacityNotSupported = errors.New("capacity reporting is not supported by the storage")
+
+// Capacity describes the size and free space of a storage, in bytes.
+type Capacity struct {
+	SizeB uint64 `json:"capacity"`
+	FreeB uint64 `json:"available"`
+}
+
+// CapacityReporter is implemented by storage providers that can report their capacity, such as
+// local filesystem or SFTP.
+type CapacityReporter interface {
+	GetCapacity(ctx context.Context) (Capacity, error)
+}
+
+// GetCapacity returns the capacity of the provided storage if supported and ErrCapacityNotSupported otherwise.
+func GetCapacity(ctx context.Context, st Storage) (Capacity, error) {
+	if cr, ok := st.(CapacityReporter); ok {
+		return cr.GetCapacity(ctx)
+	}
+
+	return Capacity{}, ErrCapacityNotSupported
+}
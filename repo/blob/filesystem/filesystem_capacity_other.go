@@ -0,0 +1,14 @@
+// +build !linux,!darwin,!freebsd,!windows
+
+package filesystem
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// GetCapacity implements blob.CapacityReporter.
+func (fs *fsStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	return blob.Capacity{}, blob.ErrCapacityNotSupported
+}
@@ -0,0 +1,26 @@
+// +build linux darwin freebsd
+
+package filesystem
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// GetCapacity implements blob.CapacityReporter.
+func (fs *fsStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	var st syscall.Statfs_t
+
+	if err := syscall.Statfs(fs.RootPath, &st); err != nil {
+		return blob.Capacity{}, errors.Wrap(err, "unable to get filesystem statistics")
+	}
+
+	return blob.Capacity{
+		SizeB: uint64(st.Blocks) * uint64(st.Bsize), //nolint:unconvert
+		FreeB: uint64(st.Bavail) * uint64(st.Bsize), //nolint:unconvert
+	}, nil
+}
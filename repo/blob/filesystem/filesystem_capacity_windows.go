@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	"context"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetCapacity implements blob.CapacityReporter.
+func (fs *fsStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	p, err := syscall.UTF16PtrFromString(fs.RootPath)
+	if err != nil {
+		return blob.Capacity{}, errors.Wrap(err, "invalid path")
+	}
+
+	var freeBytesAvailable, totalBytes, totalFreeBytes uint64
+
+	if r, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)),
+		uintptr(unsafe.Pointer(&totalBytes)),
+		uintptr(unsafe.Pointer(&totalFreeBytes))); r == 0 {
+		return blob.Capacity{}, errors.Wrap(err, "unable to get disk free space")
+	}
+
+	return blob.Capacity{
+		SizeB: totalBytes,
+		FreeB: freeBytesAvailable,
+	}, nil
+}
@@ -8,11 +8,19 @@ type Options struct {
 
 	DirectoryShards []int `json:"dirShards"`
 
+	// PreviousDirectoryShards is set while blobs are being migrated from a different layout.
+	// Layout stored in the storage itself takes precedence over both DirectoryShards and PreviousDirectoryShards.
+	PreviousDirectoryShards []int `json:"previousDirShards,omitempty"`
+
 	FileMode      os.FileMode `json:"fileMode,omitempty"`
 	DirectoryMode os.FileMode `json:"dirMode,omitempty"`
 
 	FileUID *int `json:"uid,omitempty"`
 	FileGID *int `json:"gid,omitempty"`
+
+	// DoNotSync disables flushing of file data and directory entries to disk after each write,
+	// which is faster but may lose recently written blobs on power loss.
+	DoNotSync bool `json:"doNotSync,omitempty"`
 }
 
 func (fso *Options) fileMode() os.FileMode {
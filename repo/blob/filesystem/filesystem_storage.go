@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -27,6 +28,13 @@ const (
 
 	fsDefaultFileMode os.FileMode = 0600
 	fsDefaultDirMode  os.FileMode = 0700
+
+	// tempFileInfix separates blob file name from the random suffix of a temporary file.
+	tempFileInfix = ".tmp."
+
+	// staleTempFileAge is the age after which temporary files are assumed to be left behind
+	// by a crashed writer and are removed.
+	staleTempFileAge = 24 * time.Hour
 )
 
 var fsDefaultShards = []int{3, 3}
@@ -37,6 +45,10 @@ type fsStorage struct {
 
 type fsImpl struct {
 	Options
+
+	// permissionsWarning ensures that failures to apply configured permissions, which typically affect
+	// every file, are only logged once.
+	permissionsWarning sync.Once
 }
 
 func isRetriable(err error) bool {
@@ -111,22 +123,17 @@ func (fs *fsImpl) PutBlobInPath(ctx context.Context, dirPath, path string, data
 			defer progressCallback(path, int64(len(data)), int64(len(data)))
 		}
 
-		tempFile := fmt.Sprintf("%s.tmp.%x", path, randSuffix)
+		tempFile := fmt.Sprintf("%s%s%x", path, tempFileInfix, randSuffix)
 
-		f, err := fs.createTempFileAndDir(tempFile)
-		if err != nil {
-			return errors.Wrap(err, "cannot create temporary file")
-		}
-
-		if _, err = f.Write(data); err != nil {
-			return errors.Wrap(err, "can't write temporary file")
-		}
+		if err := fs.writeTempFile(ctx, tempFile, data); err != nil {
+			if removeErr := os.Remove(tempFile); removeErr != nil && !os.IsNotExist(removeErr) {
+				log(ctx).Warningf("can't remove temp file: %v", removeErr)
+			}
 
-		if err = f.Close(); err != nil {
-			return errors.Wrap(err, "can't close temporary file")
+			return err
 		}
 
-		err = os.Rename(tempFile, path)
+		err := os.Rename(tempFile, path)
 		if err != nil {
 			if removeErr := os.Remove(tempFile); removeErr != nil {
 				log(ctx).Warningf("can't remove temp file: %v", removeErr)
@@ -135,29 +142,118 @@ func (fs *fsImpl) PutBlobInPath(ctx context.Context, dirPath, path string, data
 			return err
 		}
 
-		if fs.FileUID != nil && fs.FileGID != nil && os.Geteuid() == 0 {
-			if chownErr := os.Chown(path, *fs.FileUID, *fs.FileGID); chownErr != nil {
-				log(ctx).Warningf("can't change file permissions: %v", chownErr)
-			}
+		// make sure the directory entry created by rename survives power loss.
+		return fs.syncDir(filepath.Dir(path))
+	}, isRetriable)
+}
+
+// writeTempFile writes the provided data to a new temporary file, creating parent directories as needed.
+func (fs *fsImpl) writeTempFile(ctx context.Context, tempFile string, data []byte) error {
+	f, err := fs.createTempFileAndDir(ctx, tempFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot create temporary file")
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close() //nolint:errcheck
+		return errors.Wrap(err, "can't write temporary file")
+	}
+
+	if !fs.DoNotSync {
+		if err = f.Sync(); err != nil {
+			f.Close() //nolint:errcheck
+			return errors.Wrap(err, "can't sync temporary file")
 		}
+	}
 
-		return nil
-	}, isRetriable)
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "can't close temporary file")
+	}
+
+	return nil
 }
 
-func (fs *fsImpl) createTempFileAndDir(tempFile string) (*os.File, error) {
+func (fs *fsImpl) createTempFileAndDir(ctx context.Context, tempFile string) (*os.File, error) {
 	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
 
 	f, err := os.OpenFile(tempFile, flags, fs.fileMode())
 	if os.IsNotExist(err) {
-		if err = os.MkdirAll(filepath.Dir(tempFile), fs.dirMode()); err != nil {
+		if err = fs.mkdirAll(ctx, filepath.Dir(tempFile)); err != nil {
 			return nil, errors.Wrap(err, "cannot create directory")
 		}
 
-		return os.OpenFile(tempFile, flags, fs.fileMode())
+		f, err = os.OpenFile(tempFile, flags, fs.fileMode())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	fs.applyPermissions(ctx, f.Name(), fs.FileMode)
+
+	return f, nil
+}
+
+// mkdirAll creates the provided directory and its missing parents with the configured
+// permissions and ownership and makes their directory entries durable.
+func (fs *fsImpl) mkdirAll(ctx context.Context, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := fs.mkdirAll(ctx, parent); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dir, fs.dirMode()); err != nil {
+		if os.IsExist(err) {
+			// created concurrently.
+			return nil
+		}
+
+		return err
+	}
+
+	fs.applyPermissions(ctx, dir, fs.DirectoryMode)
+
+	return fs.syncDir(parent)
+}
+
+// applyPermissions sets the explicitly configured mode, which would otherwise be subject to umask,
+// and the owner of a newly created file or directory. It does nothing when neither is configured.
+func (fs *fsImpl) applyPermissions(ctx context.Context, path string, mode os.FileMode) {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			fs.warnPermissionsOnce(ctx, "can't change mode of %v: %v", path, err)
+		}
+	}
+
+	if fs.FileUID == nil && fs.FileGID == nil {
+		return
+	}
+
+	uid, gid := -1, -1
+
+	if fs.FileUID != nil {
+		uid = *fs.FileUID
 	}
 
-	return f, err
+	if fs.FileGID != nil {
+		gid = *fs.FileGID
+	}
+
+	if err := os.Chown(path, uid, gid); err != nil {
+		fs.warnPermissionsOnce(ctx, "can't change owner of %v: %v", path, err)
+	}
+}
+
+func (fs *fsImpl) warnPermissionsOnce(ctx context.Context, msg string, args ...interface{}) {
+	fs.permissionsWarning.Do(func() {
+		log(ctx).Warningf(msg+" (further permission errors will not be reported)", args...)
+	})
 }
 
 func (fs *fsImpl) DeleteBlobInPath(ctx context.Context, dirPath, path string) error {
@@ -181,7 +277,12 @@ func (fs *fsImpl) ReadDir(ctx context.Context, dirname string) ([]os.FileInfo, e
 		return nil, err
 	}
 
-	return v.([]os.FileInfo), nil
+	return fs.removeStaleTempFiles(ctx, dirname, v.([]os.FileInfo)), nil
+}
+
+// RemoveEmptyDirInPath implements sharded.DirRemover.
+func (fs *fsImpl) RemoveEmptyDirInPath(ctx context.Context, dirPath string) error {
+	return os.Remove(dirPath)
 }
 
 // TouchBlob updates file modification time to current time if it's sufficiently old.
@@ -224,14 +325,21 @@ func New(ctx context.Context, opts *Options) (blob.Storage, error) {
 		return nil, errors.Wrap(err, "cannot access storage path")
 	}
 
-	return &fsStorage{
+	r := &fsStorage{
 		sharded.Storage{
-			Impl:     &fsImpl{Options: *opts},
-			RootPath: opts.Path,
-			Suffix:   fsStorageChunkSuffix,
-			Shards:   opts.shards(),
+			Impl:           &fsImpl{Options: *opts},
+			RootPath:       opts.Path,
+			Suffix:         fsStorageChunkSuffix,
+			Shards:         opts.shards(),
+			PreviousShards: opts.PreviousDirectoryShards,
 		},
-	}, nil
+	}
+
+	if err := r.LoadLayout(ctx); err != nil {
+		return nil, err
+	}
+
+	return r, nil
 }
 
 func init() {
@@ -241,4 +349,5 @@ func init() {
 		func(ctx context.Context, o interface{}) (blob.Storage, error) {
 			return New(ctx, o.(*Options))
 		})
+	blob.AddSupportedURLScheme(fsURLScheme, fsStorageType, parseURL, formatURL)
 }
@@ -3,6 +3,7 @@ package filesystem
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/sharded"
 
 	"github.com/kopia/kopia/internal/blobtesting"
 	"github.com/kopia/kopia/internal/testlogging"
@@ -29,6 +31,7 @@ func TestFileStorage(t *testing.T) {
 		{1, 1},
 		{1, 2},
 		{2, 2, 2},
+		{20, 20}, // longer than blob IDs
 	} {
 		path, _ := ioutil.TempDir("", "r-fs")
 		defer os.RemoveAll(path)
@@ -127,6 +130,226 @@ func TestFileStorageConcurrency(t *testing.T) {
 	})
 }
 
+func TestFileStorageReshard(t *testing.T) {
+	t.Parallel()
+
+	ctx := testlogging.Context(t)
+
+	path, _ := ioutil.TempDir("", "r-fs")
+	defer os.RemoveAll(path)
+
+	old, err := New(ctx, &Options{
+		Path:            path,
+		DirectoryShards: []int{3, 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range []blob.ID{t1, t2, t3, "short"} {
+		assertNoError(t, old.PutBlob(ctx, b, []byte(b)))
+	}
+
+	assertNoError(t, old.(sharded.Resharder).SetLayout(ctx, []int{2, 2, 2}, []int{3, 3}))
+
+	// layout stored in the storage takes precedence over options.
+	migrating, err := New(ctx, &Options{
+		Path:            path,
+		DirectoryShards: []int{3, 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cur, prev := migrating.(sharded.Resharder).Layout(); sharded.FormatShards(cur) != "2,2,2" || sharded.FormatShards(prev) != "3,3" {
+		t.Fatalf("unexpected layout: %v, previous %v", cur, prev)
+	}
+
+	// blobs written during migration go to the new layout, all blobs are readable and listed once.
+	assertNoError(t, migrating.PutBlob(ctx, t1, []byte(t1)))
+	verifyBlobs(t, migrating, t1, t2, t3, "short")
+
+	rs := migrating.(sharded.Resharder)
+
+	cnt, err := rs.Reshard(ctx, nil)
+	assertNoError(t, err)
+
+	if got, want := cnt, 3; got != want {
+		t.Errorf("unexpected number of moved blobs: %v, want %v", got, want)
+	}
+
+	// re-running is a no-op.
+	cnt, err = rs.Reshard(ctx, nil)
+	assertNoError(t, err)
+
+	if cnt != 0 {
+		t.Errorf("unexpected number of moved blobs: %v", cnt)
+	}
+
+	assertNoError(t, rs.SetLayout(ctx, []int{2, 2, 2}, nil))
+
+	migrated, err := New(ctx, &Options{
+		Path: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyBlobs(t, migrated, t1, t2, t3, "short")
+
+	if _, err := os.Stat(filepath.Join(path, "39", "2e", "e1", "bc299db9f235e046a62625afb84902.f")); err != nil {
+		t.Errorf("blob not found in the new layout: %v", err)
+	}
+
+	assertNoError(t, migrating.DeleteBlob(ctx, t2))
+
+	if _, err := migrated.GetBlob(ctx, t2, 0, -1); err != blob.ErrBlobNotFound {
+		t.Errorf("unexpected error when getting deleted blob: %v", err)
+	}
+}
+
+func verifyBlobs(t *testing.T, st blob.Storage, want ...blob.ID) {
+	t.Helper()
+
+	ctx := testlogging.Context(t)
+
+	blobs, err := blob.ListAllBlobs(ctx, st, "")
+	if err != nil {
+		t.Fatalf("error listing blobs: %v", err)
+	}
+
+	var got []blob.ID
+	for _, b := range blobs {
+		got = append(got, b.BlobID)
+	}
+
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected blobs: %v, want %v", got, want)
+	}
+
+	for _, b := range want {
+		data, err := st.GetBlob(ctx, b, 0, -1)
+		if err != nil || string(data) != string(b) {
+			t.Errorf("invalid contents of %v: %v %v", b, data, err)
+		}
+	}
+}
+
+func TestFileStorageStaleTempFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := testlogging.Context(t)
+
+	path, _ := ioutil.TempDir("", "r-fs")
+	defer os.RemoveAll(path)
+
+	st, err := New(ctx, &Options{
+		Path:            path,
+		DirectoryShards: []int{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertNoError(t, st.PutBlob(ctx, t1, []byte(t1)))
+
+	staleTemp := filepath.Join(path, t2+fsStorageChunkSuffix+tempFileInfix+"0123456789abcdef")
+	recentTemp := filepath.Join(path, t3+fsStorageChunkSuffix+tempFileInfix+"fedcba9876543210")
+
+	assertNoError(t, ioutil.WriteFile(staleTemp, []byte{1}, 0600))
+	assertNoError(t, ioutil.WriteFile(recentTemp, []byte{1}, 0600))
+
+	old := time.Now().Add(-2 * staleTempFileAge)
+	assertNoError(t, os.Chtimes(staleTemp, old, old))
+
+	verifyBlobs(t, st, t1)
+
+	if _, err := os.Stat(staleTemp); !os.IsNotExist(err) {
+		t.Errorf("stale temporary file was not removed: %v", err)
+	}
+
+	if _, err := os.Stat(recentTemp); err != nil {
+		t.Errorf("recent temporary file was removed: %v", err)
+	}
+}
+
+func TestFileStoragePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on Windows")
+	}
+
+	t.Parallel()
+
+	ctx := testlogging.Context(t)
+
+	path, _ := ioutil.TempDir("", "r-fs")
+	defer os.RemoveAll(path)
+
+	gid := os.Getgid()
+
+	st, err := New(ctx, &Options{
+		Path:          path,
+		FileMode:      0664,
+		DirectoryMode: 0775,
+		FileGID:       &gid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertNoError(t, st.PutBlob(ctx, t1, []byte{1}))
+
+	_, filePath := st.(*fsStorage).GetShardedPathAndFilePath(t1)
+
+	// modes are applied regardless of umask.
+	for p, want := range map[string]os.FileMode{
+		filePath:                             0664,
+		filepath.Dir(filePath):               0775 | os.ModeDir,
+		filepath.Dir(filepath.Dir(filePath)): 0775 | os.ModeDir,
+	} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := fi.Mode(); got != want {
+			t.Errorf("invalid mode of %v: %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestFileStorageCapacity(t *testing.T) {
+	t.Parallel()
+
+	ctx := testlogging.Context(t)
+
+	path, _ := ioutil.TempDir("", "r-fs")
+	defer os.RemoveAll(path)
+
+	st, err := New(ctx, &Options{
+		Path: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := blob.GetCapacity(ctx, st)
+	if err == blob.ErrCapacityNotSupported {
+		t.Skip("capacity not supported on this platform")
+	}
+
+	if err != nil {
+		t.Fatalf("unable to get capacity: %v", err)
+	}
+
+	if c.SizeB == 0 || c.FreeB > c.SizeB {
+		t.Errorf("invalid capacity: %+v", c)
+	}
+}
+
 func verifyBlobTimestampOrder(t *testing.T, st blob.Storage, want ...blob.ID) {
 	blobs, err := blob.ListAllBlobs(testlogging.Context(t), st, "")
 	if err != nil {
@@ -0,0 +1,61 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var tempFileNameRegexp = regexp.MustCompile(regexp.QuoteMeta(tempFileInfix) + "[0-9a-f]{16}$")
+
+// syncDir flushes the directory to disk, making the entries created or renamed in it durable.
+func (fs *fsImpl) syncDir(dir string) error {
+	if fs.DoNotSync || runtime.GOOS == "windows" {
+		// directories can't be opened for syncing on Windows.
+		return nil
+	}
+
+	d, err := os.Open(dir) //nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "can't open directory")
+	}
+	defer d.Close() //nolint:errcheck
+
+	if err := d.Sync(); err != nil {
+		return errors.Wrap(err, "can't sync directory")
+	}
+
+	return nil
+}
+
+// removeStaleTempFiles removes temporary files left behind by writers that crashed before renaming them
+// and returns remaining directory entries. Recent temporary files may belong to writes in progress and are kept.
+func (fs *fsImpl) removeStaleTempFiles(ctx context.Context, dirname string, entries []os.FileInfo) []os.FileInfo {
+	result := entries[:0]
+
+	for _, e := range entries {
+		if e.IsDir() || !tempFileNameRegexp.MatchString(e.Name()) {
+			result = append(result, e)
+			continue
+		}
+
+		if time.Since(e.ModTime()) < staleTempFileAge { // allow:no-inject-time
+			continue
+		}
+
+		fname := filepath.Join(dirname, e.Name())
+
+		log(ctx).Infof("removing stale temporary file %v", fname)
+
+		if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+			log(ctx).Warningf("unable to remove stale temporary file %v: %v", fname, err)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,129 @@
+package filesystem
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob/sharded"
+)
+
+const fsURLScheme = "file"
+
+// parseURL parses URLs of the form 'file:///path/to/repo?shards=3,3&fileMode=0600&dirMode=0700&uid=1000&gid=1000&sync=false'.
+func parseURL(u *url.URL) (interface{}, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, errors.Errorf("remote hosts are not supported: %v", u.Host)
+	}
+
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		// file:///C:/path on Windows
+		p = p[1:]
+	}
+
+	if p == "" {
+		return nil, errors.New("path must be specified")
+	}
+
+	q := u.Query()
+
+	opt := &Options{
+		Path:      filepath.FromSlash(p),
+		DoNotSync: q.Get("sync") == "false",
+	}
+
+	var err error
+
+	if opt.DirectoryShards, err = sharded.ParseShards(q.Get("shards")); err != nil {
+		return nil, err
+	}
+
+	if opt.FileMode, err = parseFileMode(q.Get("fileMode")); err != nil {
+		return nil, err
+	}
+
+	if opt.DirectoryMode, err = parseFileMode(q.Get("dirMode")); err != nil {
+		return nil, err
+	}
+
+	if opt.FileUID, err = parseOptionalInt(q.Get("uid")); err != nil {
+		return nil, err
+	}
+
+	if opt.FileGID, err = parseOptionalInt(q.Get("gid")); err != nil {
+		return nil, err
+	}
+
+	return opt, nil
+}
+
+func formatURL(o interface{}) (*url.URL, error) {
+	opt := o.(*Options)
+
+	p := filepath.ToSlash(opt.Path)
+	if len(p) >= 2 && p[1] == ':' {
+		p = "/" + p
+	}
+
+	q := url.Values{}
+
+	if s := sharded.FormatShards(opt.DirectoryShards); s != "" {
+		q.Set("shards", s)
+	}
+
+	if opt.FileMode != 0 {
+		q.Set("fileMode", "0"+strconv.FormatUint(uint64(opt.FileMode), 8))
+	}
+
+	if opt.DirectoryMode != 0 {
+		q.Set("dirMode", "0"+strconv.FormatUint(uint64(opt.DirectoryMode), 8))
+	}
+
+	if opt.FileUID != nil {
+		q.Set("uid", strconv.Itoa(*opt.FileUID))
+	}
+
+	if opt.FileGID != nil {
+		q.Set("gid", strconv.Itoa(*opt.FileGID))
+	}
+
+	if opt.DoNotSync {
+		q.Set("sync", "false")
+	}
+
+	return &url.URL{
+		Scheme:   fsURLScheme,
+		Path:     p,
+		RawQuery: q.Encode(),
+	}, nil
+}
+
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid file mode: %q", s)
+	}
+
+	return os.FileMode(v), nil
+}
+
+func parseOptionalInt(s string) (*int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, errors.Errorf("invalid number: %q", s)
+	}
+
+	return &v, nil
+}
@@ -298,4 +298,5 @@ func init() {
 		func(ctx context.Context, o interface{}) (blob.Storage, error) {
 			return New(ctx, o.(*Options))
 		})
+	blob.AddSupportedURLScheme(gcsURLScheme, gcsStorageType, parseURL, formatURL)
 }
@@ -0,0 +1,74 @@
+package gcs
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const gcsURLScheme = "gcs"
+
+// parseURL parses URLs of the form 'gcs://bucket/prefix?credentialsFile=/path/to/credentials.json&readOnly=true'.
+func parseURL(u *url.URL) (interface{}, error) {
+	if u.Host == "" {
+		return nil, errors.New("bucket name must be specified")
+	}
+
+	q := u.Query()
+
+	opt := &Options{
+		BucketName:                    u.Host,
+		Prefix:                        strings.TrimPrefix(u.Path, "/"),
+		ServiceAccountCredentialsFile: q.Get("credentialsFile"),
+		ReadOnly:                      q.Get("readOnly") == "true",
+	}
+
+	var err error
+
+	if opt.MaxUploadSpeedBytesPerSecond, err = blob.ParseURLSpeed(q, "maxUploadSpeed"); err != nil {
+		return nil, err
+	}
+
+	if opt.MaxDownloadSpeedBytesPerSecond, err = blob.ParseURLSpeed(q, "maxDownloadSpeed"); err != nil {
+		return nil, err
+	}
+
+	return opt, nil
+}
+
+func formatURL(o interface{}) (*url.URL, error) {
+	opt := o.(*Options)
+
+	if len(opt.ServiceAccountCredentialJSON) > 0 {
+		return nil, errors.New("embedded credentials can't be represented as URL")
+	}
+
+	q := url.Values{}
+
+	if opt.ServiceAccountCredentialsFile != "" {
+		q.Set("credentialsFile", opt.ServiceAccountCredentialsFile)
+	}
+
+	if opt.ReadOnly {
+		q.Set("readOnly", "true")
+	}
+
+	if opt.MaxUploadSpeedBytesPerSecond != 0 {
+		q.Set("maxUploadSpeed", strconv.Itoa(opt.MaxUploadSpeedBytesPerSecond))
+	}
+
+	if opt.MaxDownloadSpeedBytesPerSecond != 0 {
+		q.Set("maxDownloadSpeed", strconv.Itoa(opt.MaxDownloadSpeedBytesPerSecond))
+	}
+
+	return &url.URL{
+		Scheme:   gcsURLScheme,
+		Host:     opt.BucketName,
+		Path:     "/" + opt.Prefix,
+		RawQuery: q.Encode(),
+	}, nil
+}
@@ -60,6 +60,28 @@ func (s *loggingStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback
 	return err
 }
 
+func (s *loggingStorage) BlobRetentionPeriod() time.Duration {
+	return blob.BlobRetentionPeriod(s.base)
+}
+
+func (s *loggingStorage) ExtendBlobRetention(ctx context.Context, id blob.ID, until time.Time) error {
+	t0 := time.Now()
+	err := blob.ExtendBlobRetention(ctx, s.base, id, until)
+	dt := time.Since(t0)
+	s.printf(s.prefix+"ExtendBlobRetention(%q,%v)=%#v took %v", id, until, err, dt)
+
+	return err
+}
+
+func (s *loggingStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	t0 := time.Now()
+	c, err := blob.GetCapacity(ctx, s.base)
+	dt := time.Since(t0)
+	s.printf(s.prefix+"GetCapacity()=%#v,%#v took %v", c, err, dt)
+
+	return c, err
+}
+
 func (s *loggingStorage) Close(ctx context.Context) error {
 	t0 := time.Now()
 	err := s.base.Close(ctx)
@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRetentionNotSupported is returned by ExtendBlobRetention when the storage does not support blob retention.
+var ErrRetentionNotSupported = errors.New("blob retention is not supported by the storage")
+
+// RetentionExtender is implemented by storage providers that can protect blobs against deletion and overwrite
+// for a period of time (such as S3 Object Lock).
+type RetentionExtender interface {
+	// BlobRetentionPeriod returns the period of time newly written blobs are protected for, 0 if retention is disabled.
+	BlobRetentionPeriod() time.Duration
+
+	// ExtendBlobRetention ensures the blob is protected at least until the provided time.
+	// Retention is never shortened.
+	ExtendBlobRetention(ctx context.Context, blobID ID, until time.Time) error
+}
+
+// BlobRetentionPeriod returns the period of time newly written blobs are protected for by the provided storage
+// or 0 if the storage does not support or does not have blob retention enabled.
+func BlobRetentionPeriod(st Storage) time.Duration {
+	if re, ok := st.(RetentionExtender); ok {
+		return re.BlobRetentionPeriod()
+	}
+
+	return 0
+}
+
+// ExtendBlobRetention extends the retention of the provided blob if supported by the storage and
+// returns ErrRetentionNotSupported otherwise.
+func ExtendBlobRetention(ctx context.Context, st Storage, blobID ID, until time.Time) error {
+	if re, ok := st.(RetentionExtender); ok {
+		return re.ExtendBlobRetention(ctx, blobID, until)
+	}
+
+	return ErrRetentionNotSupported
+}
@@ -1,5 +1,7 @@
 package s3
 
+import "time"
+
 // Options defines options for S3-based storage.
 type Options struct {
 	// BucketName is the name of the bucket where data is stored.
@@ -22,4 +24,11 @@ type Options struct {
 	MaxUploadSpeedBytesPerSecond int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
 
 	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+
+	// RetentionMode is the Object Lock retention mode (GOVERNANCE or COMPLIANCE) applied to newly written blobs.
+	// The bucket must have been created with Object Lock enabled.
+	RetentionMode string `json:"retentionMode,omitempty"`
+
+	// RetentionPeriod is the minimum period of time newly written blobs are protected against deletion.
+	RetentionPeriod time.Duration `json:"retentionPeriod,omitempty"`
 }
@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	minio "github.com/minio/minio-go/v6"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func validateRetention(opt *Options) error {
+	if opt.RetentionMode == "" {
+		return nil
+	}
+
+	if !minio.RetentionMode(opt.RetentionMode).IsValid() {
+		return errors.Errorf("invalid retention mode %q, must be %v or %v", opt.RetentionMode, minio.Governance, minio.Compliance)
+	}
+
+	if opt.RetentionPeriod <= 0 {
+		return errors.New("retention period must be specified when using retention mode")
+	}
+
+	return nil
+}
+
+// applyRetention sets Object Lock parameters on the provided object options if retention is enabled.
+func (s *s3Storage) applyRetention(opt *minio.PutObjectOptions) {
+	if s.RetentionMode == "" {
+		return
+	}
+
+	mode := minio.RetentionMode(s.RetentionMode)
+	until := time.Now().Add(s.RetentionPeriod).UTC() // allow:no-inject-time
+
+	opt.Mode = &mode
+	opt.RetainUntilDate = &until
+}
+
+// BlobRetentionPeriod implements blob.RetentionExtender.
+func (s *s3Storage) BlobRetentionPeriod() time.Duration {
+	if s.RetentionMode == "" {
+		return 0
+	}
+
+	return s.RetentionPeriod
+}
+
+// ExtendBlobRetention implements blob.RetentionExtender.
+func (s *s3Storage) ExtendBlobRetention(ctx context.Context, b blob.ID, until time.Time) error {
+	if s.RetentionMode == "" {
+		return blob.ErrRetentionNotSupported
+	}
+
+	attempt := func() (interface{}, error) {
+		mode := minio.RetentionMode(s.RetentionMode)
+		until := until.UTC()
+
+		// Object Lock rejects shortening of retention, in which case the blob is already protected long enough.
+		// This avoids fetching the current retention of each blob, which is rarely needed.
+		err := s.cli.PutObjectRetention(s.BucketName, s.getObjectNameString(b), minio.PutObjectRetentionOptions{
+			Mode:            &mode,
+			RetainUntilDate: &until,
+		})
+		if err == nil || !isAccessDeniedError(err) {
+			return nil, err
+		}
+
+		_, current, gerr := s.cli.GetObjectRetention(s.BucketName, s.getObjectNameString(b), "")
+		if gerr == nil && current != nil && !current.Before(until) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	_, err := exponentialBackoff(ctx, fmt.Sprintf("ExtendBlobRetention(%q)", b), attempt)
+
+	return translateError(err)
+}
+
+func isAccessDeniedError(err error) bool {
+	me, ok := err.(minio.ErrorResponse)
+
+	return ok && (me.StatusCode == http.StatusForbidden || me.Code == "AccessDenied")
+}
@@ -126,16 +126,18 @@ func (s *s3Storage) PutBlob(ctx context.Context, b blob.ID, data []byte) error {
 			defer progressCallback(string(b), int64(len(data)), int64(len(data)))
 		}
 
-		n, err := s.cli.PutObject(s.BucketName, s.getObjectNameString(b), throttled, int64(len(data)), minio.PutObjectOptions{
+		opt := minio.PutObjectOptions{
 			ContentType: "application/x-kopia",
 			Progress:    newProgressReader(progressCallback, string(b), int64(len(data))),
-		})
+		}
+		s.applyRetention(&opt)
+
+		n, err := s.cli.PutObject(s.BucketName, s.getObjectNameString(b), throttled, int64(len(data)), opt)
 
 		if err == io.EOF && n == 0 {
 			// special case empty stream
-			_, err = s.cli.PutObject(s.BucketName, s.getObjectNameString(b), bytes.NewBuffer(nil), 0, minio.PutObjectOptions{
-				ContentType: "application/x-kopia",
-			})
+			opt.Progress = nil
+			_, err = s.cli.PutObject(s.BucketName, s.getObjectNameString(b), bytes.NewBuffer(nil), 0, opt)
 		}
 
 		return err
@@ -147,6 +149,8 @@ func (s *s3Storage) DeleteBlob(ctx context.Context, b blob.ID) error {
 		return nil, s.cli.RemoveObject(s.BucketName, s.getObjectNameString(b))
 	}
 
+	// buckets with Object Lock are versioned, so deleting a blob that's under retention succeeds
+	// by adding a delete marker, while the locked version remains stored until its retention expires.
 	_, err := exponentialBackoff(ctx, fmt.Sprintf("DeleteBlob(%q)", b), attempt)
 
 	return translateError(err)
@@ -240,6 +244,10 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		return nil, errors.New("bucket name must be specified")
 	}
 
+	if err := validateRetention(opt); err != nil {
+		return nil, err
+	}
+
 	cli, err := minio.NewWithCredentials(opt.Endpoint, credentials.NewStaticV4(opt.AccessKeyID, opt.SecretAccessKey, opt.SessionToken), !opt.DoNotUseTLS, opt.Region)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create client")
@@ -279,4 +287,5 @@ func init() {
 		func(ctx context.Context, o interface{}) (blob.Storage, error) {
 			return New(ctx, o.(*Options))
 		})
+	blob.AddSupportedURLScheme(s3URLScheme, s3storageType, parseURL, formatURL)
 }
@@ -373,3 +373,103 @@ func cleanupOldData(ctx context.Context, t *testutil.RetriableT, options *Option
 		return nil
 	})
 }
+
+func TestS3StorageMinioObjectLock(t *testing.T) {
+	t.Parallel()
+
+	testutil.Retry(t, func(t *testutil.RetriableT) {
+		ctx := testlogging.Context(t)
+
+		options := &Options{
+			Endpoint:        minioEndpoint,
+			AccessKeyID:     minioAccessKeyID,
+			SecretAccessKey: minioSecretAccessKey,
+			BucketName:      minioBucketName + "-lock",
+			Region:          minioRegion,
+			DoNotUseTLS:     !minioUseSSL,
+			RetentionMode:   string(minio.Governance),
+			RetentionPeriod: time.Minute,
+			Prefix:          generateName("lock") + "-",
+		}
+
+		if !endpointReachable(options.Endpoint) {
+			t.Skip("endpoint not reachable")
+		}
+
+		minioClient, err := minio.New(options.Endpoint, options.AccessKeyID, options.SecretAccessKey, !options.DoNotUseTLS)
+		if err != nil {
+			t.Fatalf("can't initialize minio client: %v", err)
+		}
+
+		// ignore error
+		_ = minioClient.MakeBucketWithObjectLock(options.BucketName, options.Region)
+
+		st, err := New(ctx, options)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		defer st.Close(ctx)
+
+		blobID := blob.ID("locked-blob")
+
+		if err := st.PutBlob(ctx, blobID, []byte{1, 2, 3}); err != nil {
+			t.Fatalf("unable to put blob: %v", err)
+		}
+
+		s3st := st.(*s3Storage)
+		if until := s3st.lockedUntil(blobID); time.Until(until) <= 0 {
+			t.Fatalf("blob is not locked: %v", until)
+		}
+
+		want := time.Now().Add(time.Hour)
+		if err := blob.ExtendBlobRetention(ctx, st, blobID, want); err != nil {
+			t.Fatalf("unable to extend retention: %v", err)
+		}
+
+		if until := s3st.lockedUntil(blobID); until.Before(want.Add(-time.Second)) {
+			t.Errorf("retention was not extended: %v, want %v", until, want)
+		}
+
+		// extending to an earlier time must not shorten the retention.
+		if err := blob.ExtendBlobRetention(ctx, st, blobID, time.Now()); err != nil {
+			t.Fatalf("unable to extend retention: %v", err)
+		}
+
+		if until := s3st.lockedUntil(blobID); until.Before(want.Add(-time.Second)) {
+			t.Errorf("retention was shortened: %v, want %v", until, want)
+		}
+
+		if got, want := blob.BlobRetentionPeriod(st), time.Minute; got != want {
+			t.Errorf("unexpected retention period: %v, want %v", got, want)
+		}
+
+		// deleting the blob adds a delete marker, the locked version is retained.
+		if err := st.DeleteBlob(ctx, blobID); err != nil {
+			t.Errorf("deleting locked blob returned error: %v", err)
+		}
+	})
+}
+
+func TestRetentionValidation(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	for _, opt := range []*Options{
+		{BucketName: "some-bucket", RetentionMode: "INVALID", RetentionPeriod: time.Hour},
+		{BucketName: "some-bucket", RetentionMode: string(minio.Governance)},
+	} {
+		if _, err := New(ctx, opt); err == nil {
+			t.Errorf("expected error for retention mode %q and period %v", opt.RetentionMode, opt.RetentionPeriod)
+		}
+	}
+}
+
+// lockedUntil returns the time until which the provided object is locked or zero time if it's not locked.
+func (s *s3Storage) lockedUntil(b blob.ID) time.Time {
+	_, until, err := s.cli.GetObjectRetention(s.BucketName, s.getObjectNameString(b), "")
+	if err != nil || until == nil {
+		return time.Time{}
+	}
+
+	return *until
+}
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	s3URLScheme     = "s3"
+	defaultEndpoint = "s3.amazonaws.com"
+)
+
+// parseURL parses URLs of the form
+// 's3://[accessKeyID:secretAccessKey@]bucket/prefix?endpoint=host:port&region=region&disableTLS=true&retentionMode=GOVERNANCE&retentionPeriod=720h'.
+func parseURL(u *url.URL) (interface{}, error) {
+	if u.Host == "" {
+		return nil, errors.New("bucket name must be specified")
+	}
+
+	q := u.Query()
+
+	opt := &Options{
+		BucketName:     u.Host,
+		Prefix:         strings.TrimPrefix(u.Path, "/"),
+		Endpoint:       q.Get("endpoint"),
+		Region:         q.Get("region"),
+		SessionToken:   q.Get("sessionToken"),
+		DoNotUseTLS:    q.Get("disableTLS") == "true",
+		DoNotVerifyTLS: q.Get("disableTLSVerification") == "true",
+		RetentionMode:  q.Get("retentionMode"),
+	}
+
+	if opt.Endpoint == "" {
+		opt.Endpoint = defaultEndpoint
+	}
+
+	if u.User != nil {
+		opt.AccessKeyID = u.User.Username()
+		opt.SecretAccessKey, _ = u.User.Password()
+	}
+
+	var err error
+
+	if v := q.Get("retentionPeriod"); v != "" {
+		if opt.RetentionPeriod, err = time.ParseDuration(v); err != nil {
+			return nil, errors.Errorf("invalid retention period: %q", v)
+		}
+	}
+
+	if opt.MaxUploadSpeedBytesPerSecond, err = blob.ParseURLSpeed(q, "maxUploadSpeed"); err != nil {
+		return nil, err
+	}
+
+	if opt.MaxDownloadSpeedBytesPerSecond, err = blob.ParseURLSpeed(q, "maxDownloadSpeed"); err != nil {
+		return nil, err
+	}
+
+	return opt, nil
+}
+
+func formatURL(o interface{}) (*url.URL, error) {
+	opt := o.(*Options)
+
+	q := url.Values{}
+
+	if opt.Endpoint != "" && opt.Endpoint != defaultEndpoint {
+		q.Set("endpoint", opt.Endpoint)
+	}
+
+	if opt.Region != "" {
+		q.Set("region", opt.Region)
+	}
+
+	if opt.SessionToken != "" {
+		q.Set("sessionToken", opt.SessionToken)
+	}
+
+	if opt.DoNotUseTLS {
+		q.Set("disableTLS", "true")
+	}
+
+	if opt.DoNotVerifyTLS {
+		q.Set("disableTLSVerification", "true")
+	}
+
+	if opt.RetentionMode != "" {
+		q.Set("retentionMode", opt.RetentionMode)
+	}
+
+	if opt.RetentionPeriod != 0 {
+		q.Set("retentionPeriod", opt.RetentionPeriod.String())
+	}
+
+	if opt.MaxUploadSpeedBytesPerSecond != 0 {
+		q.Set("maxUploadSpeed", strconv.Itoa(opt.MaxUploadSpeedBytesPerSecond))
+	}
+
+	if opt.MaxDownloadSpeedBytesPerSecond != 0 {
+		q.Set("maxDownloadSpeed", strconv.Itoa(opt.MaxDownloadSpeedBytesPerSecond))
+	}
+
+	u := &url.URL{
+		Scheme:   s3URLScheme,
+		Host:     opt.BucketName,
+		Path:     "/" + opt.Prefix,
+		RawQuery: q.Encode(),
+	}
+
+	if opt.AccessKeyID != "" {
+		u.User = url.UserPassword(opt.AccessKeyID, opt.SecretAccessKey)
+	}
+
+	return u, nil
+}
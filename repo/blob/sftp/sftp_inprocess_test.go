@@ -0,0 +1,454 @@
+package sftp_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	psftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/sftp"
+)
+
+const (
+	testUser     = "testuser"
+	testPassword = "testpassword"
+)
+
+// testServer is an in-process SSH server exposing the local filesystem over SFTP.
+type testServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+	config   *ssh.ServerConfig
+
+	mu          sync.Mutex
+	conns       []net.Conn
+	totalConns  int
+	maxInFlight int
+	inFlight    int
+}
+
+func newTestServer(t *testing.T, authorizedKey ssh.PublicKey) *testServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate host key: %v", err)
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("unable to create host key signer: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(pass) == testPassword {
+				return nil, nil
+			}
+
+			return nil, ssh.ErrNoAuth
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if authorizedKey != nil && c.User() == testUser && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	s := &testServer{listener: l, hostKey: hostKey, config: config}
+
+	go s.acceptLoop()
+
+	t.Cleanup(func() {
+		l.Close() //nolint:errcheck
+		s.dropConnections()
+	})
+
+	return s
+}
+
+func (s *testServer) acceptLoop() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.totalConns++
+		s.mu.Unlock()
+
+		go s.handleConn(c)
+	}
+}
+
+func (s *testServer) handleConn(c net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type") //nolint:errcheck
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil) //nolint:errcheck
+			}
+		}(requests)
+
+		go s.serveSFTP(channel)
+	}
+}
+
+func (s *testServer) serveSFTP(channel ssh.Channel) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	server, err := psftp.NewServer(channel)
+	if err != nil {
+		return
+	}
+
+	server.Serve() //nolint:errcheck
+	server.Close() //nolint:errcheck
+}
+
+// dropConnections abruptly closes all connections, simulating network failure.
+func (s *testServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close() //nolint:errcheck
+	}
+
+	s.conns = nil
+}
+
+func (s *testServer) stats() (total, maxInFlight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.totalConns, s.maxInFlight
+}
+
+func (s *testServer) knownHostsData() string {
+	return knownhosts.Line([]string{s.listener.Addr().String()}, s.hostKey.PublicKey())
+}
+
+func (s *testServer) options(t *testing.T) *sftp.Options {
+	addr := s.listener.Addr().(*net.TCPAddr)
+
+	dir, err := ioutil.TempDir("", "sftp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) }) //nolint:errcheck
+
+	return &sftp.Options{
+		Path:           filepath.ToSlash(dir),
+		Host:           addr.IP.String(),
+		Port:           addr.Port,
+		Username:       testUser,
+		KnownHostsData: s.knownHostsData(),
+	}
+}
+
+func newClientKey(t *testing.T) (*ecdsa.PrivateKey, ssh.PublicKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return priv, sshPub
+}
+
+func verifyInProcessStorage(t *testing.T, opt *sftp.Options) {
+	t.Helper()
+
+	ctx := testlogging.Context(t)
+
+	st, err := sftp.New(ctx, opt)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	defer st.Close(ctx) //nolint:errcheck
+
+	blobtesting.VerifyStorage(ctx, t, st)
+}
+
+func TestSFTPPasswordAuth(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	opt := srv.options(t)
+	opt.Password = testPassword
+
+	verifyInProcessStorage(t, opt)
+
+	opt.Password = "wrong-password"
+
+	if _, err := sftp.New(testlogging.Context(t), opt); err == nil {
+		t.Errorf("unexpected success with invalid password")
+	}
+}
+
+func TestSFTPKeyboardInteractiveAuth(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	// only allow keyboard-interactive authentication.
+	srv.config.PasswordCallback = nil
+	srv.config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := client(c.User(), "", []string{"Password: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(answers) == 1 && answers[0] == testPassword {
+			return nil, nil
+		}
+
+		return nil, ssh.ErrNoAuth
+	}
+
+	opt := srv.options(t)
+	opt.Password = testPassword
+
+	verifyInProcessStorage(t, opt)
+}
+
+func TestSFTPKeyAuth(t *testing.T) {
+	priv, pub := newClientKey(t)
+	srv := newTestServer(t, pub)
+
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := srv.options(t)
+	opt.KeyData = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+
+	verifyInProcessStorage(t, opt)
+}
+
+func TestSFTPAgentAuth(t *testing.T) {
+	priv, pub := newClientKey(t)
+	srv := newTestServer(t, pub)
+
+	dir, err := ioutil.TempDir("", "sftp-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	sock := filepath.Join(dir, "agent.sock")
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	defer l.Close() //nolint:errcheck
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go agent.ServeAgent(keyring, c) //nolint:errcheck
+		}
+	}()
+
+	oldSock := os.Getenv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", sock)          //nolint:errcheck
+	defer os.Setenv("SSH_AUTH_SOCK", oldSock) //nolint:errcheck
+
+	opt := srv.options(t)
+	opt.UseSSHAgent = true
+
+	verifyInProcessStorage(t, opt)
+}
+
+func TestSFTPTrustOnFirstUse(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	ctx := testlogging.Context(t)
+
+	opt := srv.options(t)
+	opt.Password = testPassword
+	opt.KnownHostsData = ""
+	opt.KnownHostsFile = filepath.Join(opt.Path, "ssh", "known_hosts")
+
+	if _, err := sftp.New(ctx, opt); err == nil {
+		t.Fatalf("unexpected success connecting to unknown host")
+	}
+
+	opt.TrustOnFirstUse = true
+
+	st, err := sftp.New(ctx, opt)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	st.Close(ctx) //nolint:errcheck
+
+	data, err := ioutil.ReadFile(opt.KnownHostsFile)
+	if err != nil {
+		t.Fatalf("known hosts file not written: %v", err)
+	}
+
+	if !strings.Contains(string(data), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(srv.hostKey.PublicKey())))) {
+		t.Errorf("host key not found in known hosts file: %s", data)
+	}
+
+	// now the key is known and trust on first use is no longer needed.
+	opt.TrustOnFirstUse = false
+
+	st, err = sftp.New(ctx, opt)
+	if err != nil {
+		t.Fatalf("unable to connect to known host: %v", err)
+	}
+
+	st.Close(ctx) //nolint:errcheck
+
+	// a different server with the same address must be rejected.
+	other := newTestServer(t, nil)
+	otherOpt := other.options(t)
+	opt.Port = otherOpt.Port
+	opt.TrustOnFirstUse = true
+
+	if _, err := sftp.New(ctx, opt); err == nil {
+		t.Errorf("unexpected success connecting to host with a different key")
+	}
+}
+
+func TestSFTPConnectionPool(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	ctx := testlogging.Context(t)
+
+	opt := srv.options(t)
+	opt.Password = testPassword
+	opt.MaxConnections = 3
+
+	st, err := sftp.New(ctx, opt)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	defer st.Close(ctx) //nolint:errcheck
+
+	blobtesting.VerifyConcurrentAccess(t, st, blobtesting.ConcurrentAccessOptions{
+		NumBlobs:   16,
+		Getters:    4,
+		Putters:    4,
+		Deleters:   2,
+		Listers:    2,
+		Iterations: 50,
+	})
+
+	total, maxInFlight := srv.stats()
+	if maxInFlight > opt.MaxConnections || total > opt.MaxConnections {
+		t.Errorf("too many connections: total %v, concurrent %v, max %v", total, maxInFlight, opt.MaxConnections)
+	}
+
+	if maxInFlight < 2 {
+		t.Errorf("connections were not used concurrently: %v", maxInFlight)
+	}
+
+	// break all connections, storage must reconnect transparently.
+	srv.dropConnections()
+
+	if err := st.PutBlob(ctx, "some-blob", []byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("unable to write after connections were dropped: %v", err)
+	}
+
+	blobtesting.AssertGetBlob(ctx, t, st, blob.ID("some-blob"), []byte{1, 2, 3, 4})
+}
+
+func TestSFTPCapacity(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	ctx := testlogging.Context(t)
+
+	opt := srv.options(t)
+	opt.Password = testPassword
+
+	st, err := sftp.New(ctx, opt)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	defer st.Close(ctx) //nolint:errcheck
+
+	c, err := blob.GetCapacity(ctx, st)
+	if err != nil {
+		t.Skipf("statvfs not supported: %v", err)
+	}
+
+	if c.SizeB == 0 || c.FreeB > c.SizeB {
+		t.Errorf("invalid capacity: %+v", c)
+	}
+}
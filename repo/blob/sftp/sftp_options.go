@@ -17,7 +17,20 @@ type Options struct {
 	KnownHostsFile string `json:"knownHostsFile,omitempty"`
 	KnownHostsData string `json:"knownHostsData,omitempty"`
 
+	Password    string `json:"password,omitempty" kopia:"sensitive"`
+	UseSSHAgent bool   `json:"useSSHAgent,omitempty"`
+
+	// TrustOnFirstUse accepts the key of a server not present in known hosts and adds it to KnownHostsFile.
+	TrustOnFirstUse bool `json:"trustOnFirstUse,omitempty"`
+
+	// MaxConnections is the maximum number of concurrent SSH connections to the server.
+	MaxConnections int `json:"maxConnections,omitempty"`
+
 	DirectoryShards []int `json:"dirShards"`
+
+	// PreviousDirectoryShards is set while blobs are being migrated from a different layout.
+	// Layout stored in the storage itself takes precedence over both DirectoryShards and PreviousDirectoryShards.
+	PreviousDirectoryShards []int `json:"previousDirShards,omitempty"`
 }
 
 func (sftpo *Options) shards() []int {
@@ -28,6 +41,14 @@ func (sftpo *Options) shards() []int {
 	return sftpo.DirectoryShards
 }
 
+func (sftpo *Options) maxConnections() int {
+	if sftpo.MaxConnections <= 0 {
+		return sftpDefaultMaxConnections
+	}
+
+	return sftpo.MaxConnections
+}
+
 func (sftpo *Options) knownHostsFile() string {
 	if sftpo.KnownHostsFile == "" {
 		d, _ := os.UserHomeDir()
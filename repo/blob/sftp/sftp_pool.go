@@ -0,0 +1,198 @@
+package sftp
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	psftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// sftpConnection is a single SSH connection with an SFTP session.
+type sftpConnection struct {
+	conn *ssh.Client
+	cli  *psftp.Client
+}
+
+func (c *sftpConnection) close() error {
+	cliErr := c.cli.Close()
+	connErr := c.conn.Close()
+
+	if cliErr != nil {
+		return errors.Wrap(cliErr, "closing SFTP client")
+	}
+
+	if connErr != nil {
+		return errors.Wrap(connErr, "closing SFTP connection")
+	}
+
+	return nil
+}
+
+// isAlive determines whether the connection is still usable by sending a keepalive request.
+func (c *sftpConnection) isAlive() bool {
+	_, _, err := c.conn.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+// connectionPool maintains up to a given number of SSH connections, established on demand
+// and re-established when broken.
+type connectionPool struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	// auth returns authentication methods for a new connection and a function releasing their resources
+	// once the connection is established. This ensures that connections to ssh-agent are not shared
+	// between SSH connections and are re-established when needed.
+	auth func() ([]ssh.AuthMethod, func() error, error)
+
+	// semaphore limiting the number of connections in use.
+	sem chan struct{}
+
+	mu   sync.Mutex
+	idle []*sftpConnection
+}
+
+func newConnectionPool(addr string, config *ssh.ClientConfig, maxConnections int, auth func() ([]ssh.AuthMethod, func() error, error)) *connectionPool {
+	return &connectionPool{
+		addr:   addr,
+		config: config,
+		auth:   auth,
+		sem:    make(chan struct{}, maxConnections),
+	}
+}
+
+func (p *connectionPool) dial() (*sftpConnection, error) {
+	auth, closeAuth, err := p.auth()
+	if err != nil {
+		return nil, err
+	}
+
+	// authentication methods are only used during the handshake.
+	defer closeAuth() //nolint:errcheck
+
+	config := *p.config
+	config.Auth = auth
+
+	conn, err := ssh.Dial("tcp", p.addr, &config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to dial [%s]", p.addr)
+	}
+
+	cli, err := psftp.NewClient(conn, psftp.MaxPacket(packetSize))
+	if err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, errors.Wrapf(err, "unable to create sftp client")
+	}
+
+	return &sftpConnection{conn, cli}, nil
+}
+
+func (p *connectionPool) get(ctx context.Context) (*sftpConnection, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		return c, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.dial()
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (p *connectionPool) release(c *sftpConnection, broken bool) {
+	if broken {
+		c.close() //nolint:errcheck
+
+		// idle connections were most likely broken by the same network failure.
+		p.closeIdle() //nolint:errcheck
+	} else {
+		p.mu.Lock()
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+	}
+
+	<-p.sem
+}
+
+// withClient invokes the provided function with an SFTP client from the pool. If the function fails
+// because the connection was broken, it is retried once on a new connection.
+func (p *connectionPool) withClient(ctx context.Context, fn func(cli *psftp.Client) error) error {
+	const maxAttempts = 2
+
+	var err error
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var c *sftpConnection
+
+		c, err = p.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(c.cli)
+
+		broken := isConnectionError(err) && !c.isAlive()
+		p.release(c, broken)
+
+		if !broken {
+			return err
+		}
+
+		log(ctx).Debugf("SFTP connection to %v broken, reconnecting: %v", p.addr, err)
+	}
+
+	return err
+}
+
+func (p *connectionPool) closeIdle() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	var lastErr error
+
+	for _, c := range idle {
+		if err := c.close(); err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func (p *connectionPool) close() error {
+	return p.closeIdle()
+}
+
+// isConnectionError returns true if the error may have been caused by a broken connection,
+// as opposed to a status returned by the server.
+func isConnectionError(err error) bool {
+	if err == nil || err == blob.ErrBlobNotFound {
+		return false
+	}
+
+	if _, ok := errors.Cause(err).(*psftp.StatusError); ok {
+		return false
+	}
+
+	return !isNotExist(err)
+}
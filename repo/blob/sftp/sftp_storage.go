@@ -9,16 +9,23 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	psftp "github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 
 	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/blob/sharded"
+	"github.com/kopia/kopia/repo/logging"
 )
 
 const (
@@ -26,10 +33,16 @@ const (
 	fsStorageChunkSuffix = ".f"
 
 	packetSize = 1 << 15
+
+	sftpDefaultMaxConnections = 4
 )
 
 var (
+	log = logging.GetContextLoggerFunc("repo/sftp")
+
 	sftpDefaultShards = []int{3, 3}
+
+	errHostKeyNotFound = errors.New("no hostkey found")
 )
 
 // sftpStorage implements blob.Storage on top of sftp.
@@ -40,44 +53,57 @@ type sftpStorage struct {
 type sftpImpl struct {
 	Options
 
-	conn *ssh.Client
-	cli  *psftp.Client
+	pool *connectionPool
 }
 
 func (s *sftpImpl) GetBlobFromPath(ctx context.Context, dirPath, fullPath string, offset, length int64) ([]byte, error) {
-	r, err := s.cli.Open(fullPath)
-	if isNotExist(err) {
-		return nil, blob.ErrBlobNotFound
-	}
+	var result []byte
 
-	if err != nil {
-		return nil, err
-	}
-	defer r.Close() //nolint:errcheck
+	err := s.pool.withClient(ctx, func(cli *psftp.Client) error {
+		r, err := cli.Open(fullPath)
+		if isNotExist(err) {
+			return blob.ErrBlobNotFound
+		}
 
-	// pkg/sftp doesn't have a `ioutil.Readall`, so we WriteTo to a buffer
-	// and either return it all or return the offset/length bytes
-	buf := new(bytes.Buffer)
-	n, err := r.WriteTo(buf)
+		if err != nil {
+			return err
+		}
+		defer r.Close() //nolint:errcheck
 
-	if err != nil {
-		return nil, err
-	}
+		// pkg/sftp doesn't have a `ioutil.Readall`, so we WriteTo to a buffer
+		// and either return it all or return the offset/length bytes
+		buf := new(bytes.Buffer)
+		n, err := r.WriteTo(buf)
 
-	if length < 0 {
-		return buf.Bytes(), nil
-	}
+		if isNotExist(err) {
+			// deleted concurrently.
+			return blob.ErrBlobNotFound
+		}
 
-	if offset > n || offset < 0 {
-		return nil, errors.New("invalid offset")
-	}
+		if err != nil {
+			return err
+		}
 
-	data := buf.Bytes()[offset:]
-	if int(length) > len(data) {
-		return nil, errors.New("invalid length")
-	}
+		if length < 0 {
+			result = buf.Bytes()
+			return nil
+		}
+
+		if offset > n || offset < 0 {
+			return errors.New("invalid offset")
+		}
 
-	return data[0:length], nil
+		data := buf.Bytes()[offset:]
+		if int(length) > len(data) {
+			return errors.New("invalid length")
+		}
+
+		result = data[0:length]
+
+		return nil
+	})
+
+	return result, err
 }
 
 func (s *sftpImpl) PutBlobInPath(ctx context.Context, dirPath, fullPath string, data []byte) error {
@@ -95,42 +121,45 @@ func (s *sftpImpl) PutBlobInPath(ctx context.Context, dirPath, fullPath string,
 
 	tempFile := fmt.Sprintf("%s.tmp.%x", fullPath, randSuffix)
 
-	f, err := s.createTempFileAndDir(tempFile)
-	if err != nil {
-		return errors.Wrap(err, "cannot create temporary file")
-	}
-
-	if _, err = f.Write(data); err != nil {
-		return errors.Wrap(err, "can't write temporary file")
-	}
+	return s.pool.withClient(ctx, func(cli *psftp.Client) error {
+		f, err := createTempFileAndDir(cli, tempFile)
+		if err != nil {
+			return errors.Wrap(err, "cannot create temporary file")
+		}
 
-	if err = f.Close(); err != nil {
-		return errors.Wrap(err, "can't close temporary file")
-	}
+		if _, err = f.Write(data); err != nil {
+			f.Close() //nolint:errcheck
+			return errors.Wrap(err, "can't write temporary file")
+		}
 
-	err = s.cli.PosixRename(tempFile, fullPath)
-	if err != nil {
-		if removeErr := s.cli.Remove(tempFile); removeErr != nil {
-			fmt.Printf("warning: can't remove temp file: %v", removeErr)
+		if err = f.Close(); err != nil {
+			return errors.Wrap(err, "can't close temporary file")
 		}
 
-		return err
-	}
+		err = cli.PosixRename(tempFile, fullPath)
+		if err != nil {
+			if removeErr := cli.Remove(tempFile); removeErr != nil {
+				log(ctx).Warningf("can't remove temp file: %v", removeErr)
+			}
 
-	return nil
+			return err
+		}
+
+		return nil
+	})
 }
 
-func (s *sftpImpl) createTempFileAndDir(tempFile string) (*psftp.File, error) {
+func createTempFileAndDir(cli *psftp.Client, tempFile string) (*psftp.File, error) {
 	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
 
-	f, err := s.cli.OpenFile(tempFile, flags)
+	f, err := cli.OpenFile(tempFile, flags)
 	if isNotExist(err) {
 		parentDir := path.Dir(tempFile)
-		if err = s.cli.MkdirAll(parentDir); err != nil {
+		if err = cli.MkdirAll(parentDir); err != nil {
 			return nil, errors.Wrap(err, "cannot create directory")
 		}
 
-		return s.cli.OpenFile(tempFile, flags)
+		return cli.OpenFile(tempFile, flags)
 	}
 
 	return f, err
@@ -145,16 +174,58 @@ func isNotExist(err error) bool {
 }
 
 func (s *sftpImpl) DeleteBlobInPath(ctx context.Context, dirPath, fullPath string) error {
-	err := s.cli.Remove(fullPath)
-	if err == nil || isNotExist(err) {
-		return nil
-	}
+	return s.pool.withClient(ctx, func(cli *psftp.Client) error {
+		err := cli.Remove(fullPath)
+		if err == nil || isNotExist(err) {
+			return nil
+		}
 
-	return err
+		return err
+	})
 }
 
 func (s *sftpImpl) ReadDir(ctx context.Context, dirname string) ([]os.FileInfo, error) {
-	return s.cli.ReadDir(dirname)
+	var result []os.FileInfo
+
+	err := s.pool.withClient(ctx, func(cli *psftp.Client) error {
+		var err error
+
+		result, err = cli.ReadDir(dirname)
+
+		return err
+	})
+
+	return result, err
+}
+
+// RemoveEmptyDirInPath implements sharded.DirRemover.
+func (s *sftpImpl) RemoveEmptyDirInPath(ctx context.Context, dirPath string) error {
+	return s.pool.withClient(ctx, func(cli *psftp.Client) error {
+		return cli.RemoveDirectory(dirPath)
+	})
+}
+
+// GetCapacity implements blob.CapacityReporter using the statvfs@openssh.com extension.
+func (s *sftpStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	var c blob.Capacity
+
+	impl := s.Impl.(*sftpImpl)
+
+	err := impl.pool.withClient(ctx, func(cli *psftp.Client) error {
+		st, err := cli.StatVFS(impl.Path)
+		if err != nil {
+			return errors.Wrap(err, "unable to get filesystem statistics")
+		}
+
+		c = blob.Capacity{
+			SizeB: st.TotalSpace(),
+			FreeB: st.Frsize * st.Bavail,
+		}
+
+		return nil
+	})
+
+	return c, err
 }
 
 func (s *sftpStorage) ConnectionInfo() blob.ConnectionInfo {
@@ -165,15 +236,7 @@ func (s *sftpStorage) ConnectionInfo() blob.ConnectionInfo {
 }
 
 func (s *sftpStorage) Close(ctx context.Context) error {
-	if err := s.Impl.(*sftpImpl).cli.Close(); err != nil {
-		return errors.Wrap(err, "closing SFTP client")
-	}
-
-	if err := s.Impl.(*sftpImpl).conn.Close(); err != nil {
-		return errors.Wrap(err, "closing SFTP connection")
-	}
-
-	return nil
+	return s.Impl.(*sftpImpl).pool.close()
 }
 
 // example host strings: [localhost]:2222, [xyz.example.com], [192.168.1.1]:2210, 192.168.1.1
@@ -208,6 +271,10 @@ func getHostKey(opt *Options) (ssh.PublicKey, error) {
 		reader = strings.NewReader(opt.KnownHostsData)
 	} else {
 		file, err := os.Open(opt.knownHostsFile()) //nolint:gosec
+		if os.IsNotExist(err) {
+			return nil, errHostKeyNotFound
+		}
+
 		if err != nil {
 			return nil, err
 		}
@@ -228,15 +295,11 @@ func getHostKey(opt *Options) (ssh.PublicKey, error) {
 		}
 	}
 
-	return nil, errors.Errorf("no hostkey found for %s", opt.Host)
+	return nil, errHostKeyNotFound
 }
 
 // getSigner parses and returns a signer for the user-entered private key
 func getSigner(opts *Options) (ssh.Signer, error) {
-	if opts.Keyfile == "" && opts.KeyData == "" {
-		return nil, errors.New("must specify the location of the ssh server private key or the key data")
-	}
-
 	var privateKeyData []byte
 
 	if opts.KeyData != "" {
@@ -258,70 +321,163 @@ func getSigner(opts *Options) (ssh.Signer, error) {
 	return key, nil
 }
 
-func createSSHConfig(opts *Options) (*ssh.ClientConfig, error) {
+// getHostKeyCallback returns the callback verifying the server key against known_hosts, optionally
+// trusting and remembering the key of a server that is not known yet.
+func getHostKeyCallback(ctx context.Context, opts *Options) (ssh.HostKeyCallback, error) {
 	hostKey, err := getHostKey(opts)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to getHostKey: %s", opts.Host)
+	if err == nil {
+		return ssh.FixedHostKey(hostKey), nil
 	}
 
-	signer, err := getSigner(opts)
+	if err != errHostKeyNotFound || !opts.TrustOnFirstUse || opts.KnownHostsData != "" {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		trusted ssh.PublicKey
+	)
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if trusted != nil {
+			// all connections in the pool must present the key trusted by the first one.
+			return ssh.FixedHostKey(trusted)(hostname, remote, key)
+		}
+
+		log(ctx).Infof("trusting new host key %v for %v", ssh.FingerprintSHA256(key), hostname)
+
+		if err := appendKnownHost(opts.knownHostsFile(), hostname, key); err != nil {
+			return err
+		}
+
+		trusted = key
+
+		return nil
+	}, nil
+}
+
+func appendKnownHost(fname, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return errors.Wrap(err, "unable to create known hosts directory")
+	}
+
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600) //nolint:gosec
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to getSigner")
+		return errors.Wrap(err, "unable to open known hosts file")
 	}
+	defer f.Close() //nolint:errcheck
 
-	config := &ssh.ClientConfig{
-		User: opts.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		return errors.Wrap(err, "unable to write known hosts file")
 	}
 
-	return config, nil
+	return nil
 }
 
-// New creates new ssh-backed storage in a specified host.
-func New(ctx context.Context, opts *Options) (blob.Storage, error) {
-	config, err := createSSHConfig(opts)
-	if err != nil {
-		return nil, err
+// getAuthMethods returns the authentication methods enabled in the provided options and a function
+// releasing resources they use.
+func getAuthMethods(opts *Options) (methods []ssh.AuthMethod, closeFunc func() error, err error) {
+	closeFunc = func() error { return nil }
+
+	if opts.Keyfile != "" || opts.KeyData != "" {
+		signer, err := getSigner(opts)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to getSigner")
+		}
+
+		methods = append(methods, ssh.PublicKeys(signer))
 	}
 
-	addr := fmt.Sprintf("%s:%d", opts.Host, opts.Port)
+	if opts.UseSSHAgent {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, nil, errors.New("ssh-agent is not running (SSH_AUTH_SOCK not set)")
+		}
 
-	conn, err := ssh.Dial("tcp", addr, config)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to dial [%s]: %+v", addr, config)
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to connect to ssh-agent")
+		}
+
+		closeFunc = conn.Close
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
 	}
 
-	c, err := psftp.NewClient(conn, psftp.MaxPacket(packetSize))
+	if opts.Password != "" {
+		methods = append(methods,
+			ssh.Password(opts.Password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				// answer all password prompts with the password.
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = opts.Password
+				}
+
+				return answers, nil
+			}))
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, errors.New("must specify the private key, password or use ssh-agent")
+	}
+
+	return methods, closeFunc, nil
+}
+
+// New creates new ssh-backed storage in a specified host.
+func New(ctx context.Context, opts *Options) (blob.Storage, error) {
+	hostKeyCallback, err := getHostKeyCallback(ctx, opts)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to create sftp client")
+		return nil, errors.Wrapf(err, "unable to getHostKey: %s", opts.Host)
 	}
 
-	if _, err = c.Stat(opts.Path); err != nil {
-		if isNotExist(err) {
-			if err = c.MkdirAll(opts.Path); err != nil {
-				return nil, errors.Wrap(err, "cannot create path")
+	config := &ssh.ClientConfig{
+		User:            opts.Username,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	pool := newConnectionPool(net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)), config, opts.maxConnections(), func() ([]ssh.AuthMethod, func() error, error) {
+		return getAuthMethods(opts)
+	})
+
+	if err := pool.withClient(ctx, func(cli *psftp.Client) error {
+		if _, err = cli.Stat(opts.Path); err != nil {
+			if isNotExist(err) {
+				if err = cli.MkdirAll(opts.Path); err != nil {
+					return errors.Wrap(err, "cannot create path")
+				}
+			} else {
+				return errors.Wrapf(err, "path doesn't exist: %s", opts.Path)
 			}
-		} else {
-			return nil, errors.Wrapf(err, "path doesn't exist: %s", opts.Path)
 		}
+
+		return nil
+	}); err != nil {
+		pool.close() //nolint:errcheck
+		return nil, err
 	}
 
 	r := &sftpStorage{
 		sharded.Storage{
 			Impl: &sftpImpl{
 				Options: *opts,
-				conn:    conn,
-				cli:     c,
+				pool:    pool,
 			},
-			RootPath: opts.Path,
-			Suffix:   fsStorageChunkSuffix,
-			Shards:   opts.shards(),
+			RootPath:       opts.Path,
+			Suffix:         fsStorageChunkSuffix,
+			Shards:         opts.shards(),
+			PreviousShards: opts.PreviousDirectoryShards,
 		},
 	}
 
+	if err := r.LoadLayout(ctx); err != nil {
+		pool.close() //nolint:errcheck
+		return nil, err
+	}
+
 	return r, nil
 }
 
@@ -332,4 +488,5 @@ func init() {
 		func(ctx context.Context, o interface{}) (blob.Storage, error) {
 			return New(ctx, o.(*Options))
 		})
+	blob.AddSupportedURLScheme(sftpURLScheme, sftpStorageType, parseURL, formatURL)
 }
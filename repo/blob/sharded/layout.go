@@ -0,0 +1,62 @@
+package sharded
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// layoutFileName is the name of the file in the root directory of the storage that stores the directory layout
+// shared by all clients. When present, it takes precedence over the layout in the client configuration.
+const layoutFileName = ".shards"
+
+type storedLayout struct {
+	Shards []int `json:"shards"`
+
+	// PreviousShards is nil when there is no migration in progress and empty when migrating from a flat layout.
+	PreviousShards []int `json:"previousShards"`
+}
+
+// LoadLayout replaces Shards and PreviousShards with the directory layout stored in the storage, if any.
+func (s *Storage) LoadLayout(ctx context.Context) error {
+	dirPath, filePath := s.layoutPath()
+
+	b, err := s.Impl.GetBlobFromPath(ctx, dirPath, filePath, 0, -1)
+	if err == blob.ErrBlobNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "unable to read directory layout")
+	}
+
+	var l storedLayout
+
+	if err := json.Unmarshal(b, &l); err != nil {
+		return errors.Wrap(err, "invalid directory layout")
+	}
+
+	s.Shards, s.PreviousShards = l.Shards, l.PreviousShards
+
+	return nil
+}
+
+// SetLayout implements Resharder.
+func (s Storage) SetLayout(ctx context.Context, shards, previousShards []int) error {
+	b, err := json.Marshal(storedLayout{shards, previousShards})
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize directory layout")
+	}
+
+	dirPath, filePath := s.layoutPath()
+
+	return s.Impl.PutBlobInPath(ctx, dirPath, filePath, b)
+}
+
+func (s Storage) layoutPath() (dirPath, filePath string) {
+	return s.RootPath, path.Join(s.RootPath, layoutFileName)
+}
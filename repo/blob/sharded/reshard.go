@@ -0,0 +1,163 @@
+package sharded
+
+import (
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// Resharder is implemented by storage that supports moving blobs into a new directory layout.
+type Resharder interface {
+	// Layout returns the current directory layout and the layout being migrated from (nil if none).
+	Layout() (shards, previousShards []int)
+
+	// SetLayout stores the provided directory layout in the storage, where it is picked up by all clients
+	// the next time they open it.
+	SetLayout(ctx context.Context, shards, previousShards []int) error
+
+	// Reshard moves all blobs that are not stored in the current layout into it and returns the number of blobs moved.
+	Reshard(ctx context.Context, progress func(blobID blob.ID)) (int, error)
+}
+
+// DirRemover is optionally implemented by Impl to remove directories left empty after re-sharding.
+type DirRemover interface {
+	// RemoveEmptyDirInPath removes the provided directory, failing if it is not empty.
+	RemoveEmptyDirInPath(ctx context.Context, dirPath string) error
+}
+
+// Layout implements Resharder.
+func (s Storage) Layout() (shards, previousShards []int) {
+	return s.Shards, s.PreviousShards
+}
+
+// Reshard implements Resharder.
+//
+// Each blob is first written to its location in the current layout and only then removed from the old one,
+// so the process can be interrupted at any time and safely resumed by calling Reshard again.
+func (s Storage) Reshard(ctx context.Context, progress func(blobID blob.ID)) (int, error) {
+	type misplacedBlob struct {
+		blobID   blob.ID
+		dirPath  string
+		filePath string
+	}
+
+	var misplaced []misplacedBlob
+
+	if err := s.walk(ctx, "", func(dirPath, filePath string, shards []int, m blob.Metadata) error {
+		if !sameShards(shards, s.shardsFor(m.BlobID)) {
+			misplaced = append(misplaced, misplacedBlob{m.BlobID, dirPath, filePath})
+		}
+
+		return nil
+	}); err != nil {
+		return 0, errors.Wrap(err, "error listing blobs")
+	}
+
+	for i, mb := range misplaced {
+		if err := s.moveBlob(ctx, mb.blobID, mb.dirPath, mb.filePath); err != nil {
+			return i, errors.Wrapf(err, "error moving blob %v", mb.blobID)
+		}
+
+		if progress != nil {
+			progress(mb.blobID)
+		}
+	}
+
+	if dr, ok := s.Impl.(DirRemover); ok {
+		var dirs []string
+		for _, mb := range misplaced {
+			dirs = append(dirs, mb.dirPath)
+		}
+
+		s.removeEmptyDirs(ctx, dr, dirs)
+	}
+
+	return len(misplaced), nil
+}
+
+// removeEmptyDirs removes provided directories and their parents that are no longer in use, best-effort.
+func (s Storage) removeEmptyDirs(ctx context.Context, dr DirRemover, dirs []string) {
+	removed := map[string]bool{}
+
+	for _, d := range dirs {
+		for d = path.Clean(d); d != path.Clean(s.RootPath) && d != "." && d != "/" && !removed[d]; d = path.Dir(d) {
+			if dr.RemoveEmptyDirInPath(ctx, d) != nil {
+				// not empty, neither are its parents.
+				break
+			}
+
+			removed[d] = true
+		}
+	}
+}
+
+// shardsFor returns the lengths of shard directories used for the provided blob in the current layout.
+func (s Storage) shardsFor(blobID blob.ID) []int {
+	if len(blobID) < minShardedBlobIDLength {
+		return nil
+	}
+
+	var result []int
+
+	remaining := len(blobID)
+
+	for _, v := range s.Shards {
+		// must match getShardDirectory, which leaves at least one character for the file name.
+		if remaining <= v {
+			break
+		}
+
+		// zero-length shards don't create directories.
+		if v > 0 {
+			result = append(result, v)
+		}
+
+		remaining -= v
+	}
+
+	return result
+}
+
+func (s Storage) moveBlob(ctx context.Context, blobID blob.ID, oldDirPath, oldFilePath string) error {
+	dirPath, filePath := s.GetShardedPathAndFilePath(blobID)
+	if path.Clean(filePath) == path.Clean(oldFilePath) {
+		return nil
+	}
+
+	data, err := s.Impl.GetBlobFromPath(ctx, oldDirPath, oldFilePath, 0, -1)
+	if err == blob.ErrBlobNotFound {
+		// already moved.
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "error reading blob")
+	}
+
+	if err := s.Impl.PutBlobInPath(ctx, dirPath, filePath, data); err != nil {
+		return errors.Wrap(err, "error writing blob")
+	}
+
+	if err := s.Impl.DeleteBlobInPath(ctx, oldDirPath, oldFilePath); err != nil && err != blob.ErrBlobNotFound {
+		return errors.Wrap(err, "error removing blob")
+	}
+
+	return nil
+}
+
+func sameShards(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -27,11 +27,23 @@ type Storage struct {
 	RootPath string
 	Suffix   string
 	Shards   []int
+
+	// PreviousShards is the layout being migrated from, nil if there is no migration in progress.
+	// When set, blobs not found in the current layout are looked up in the previous one.
+	PreviousShards []int
 }
 
 // GetBlob implements blob.Storage
 func (s Storage) GetBlob(ctx context.Context, blobID blob.ID, offset, length int64) ([]byte, error) {
 	dirPath, filePath := s.GetShardedPathAndFilePath(blobID)
+
+	b, err := s.Impl.GetBlobFromPath(ctx, dirPath, filePath, offset, length)
+	if err != blob.ErrBlobNotFound || s.PreviousShards == nil {
+		return b, err
+	}
+
+	dirPath, filePath = s.previousLayout().GetShardedPathAndFilePath(blobID)
+
 	return s.Impl.GetBlobFromPath(ctx, dirPath, filePath, offset, length)
 }
 
@@ -49,9 +61,32 @@ func (s Storage) makeFileName(blobID blob.ID) string {
 
 // ListBlobs implements blob.Storage
 func (s Storage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
-	var walkDir func(string, string) error
+	if s.PreviousShards == nil {
+		return s.walk(ctx, prefix, func(_, _ string, _ []int, m blob.Metadata) error {
+			return callback(m)
+		})
+	}
+
+	// during migration the same blob may be present in both layouts, report it once.
+	seen := map[blob.ID]bool{}
+
+	return s.walk(ctx, prefix, func(_, _ string, _ []int, m blob.Metadata) error {
+		if seen[m.BlobID] {
+			return nil
+		}
+
+		seen[m.BlobID] = true
+
+		return callback(m)
+	})
+}
+
+// walk invokes the provided callback for each blob with a given prefix, regardless of the layout
+// it is stored in, along with its location and the lengths of the shard directories leading to it.
+func (s Storage) walk(ctx context.Context, prefix blob.ID, callback func(dirPath, filePath string, shards []int, m blob.Metadata) error) error {
+	var walkDir func(string, string, []int) error
 
-	walkDir = func(directory string, currentPrefix string) error {
+	walkDir = func(directory string, currentPrefix string, shards []int) error {
 		entries, err := s.Impl.ReadDir(ctx, directory)
 		if err != nil {
 			return err
@@ -69,13 +104,15 @@ func (s Storage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(bl
 				}
 
 				if match {
-					if err := walkDir(directory+"/"+e.Name(), currentPrefix+e.Name()); err != nil {
+					subShards := append(append([]int(nil), shards...), len(e.Name()))
+
+					if err := walkDir(directory+"/"+e.Name(), currentPrefix+e.Name(), subShards); err != nil {
 						return err
 					}
 				}
 			} else if fullID, ok := s.getBlobIDFromFileName(currentPrefix + e.Name()); ok {
 				if strings.HasPrefix(string(fullID), string(prefix)) {
-					if err := callback(blob.Metadata{
+					if err := callback(directory, directory+"/"+e.Name(), shards, blob.Metadata{
 						BlobID:    fullID,
 						Length:    e.Size(),
 						Timestamp: e.ModTime(),
@@ -89,7 +126,7 @@ func (s Storage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(bl
 		return nil
 	}
 
-	return walkDir(s.RootPath, "")
+	return walkDir(s.RootPath, "", nil)
 }
 
 // PutBlob implements blob.Storage
@@ -102,7 +139,28 @@ func (s Storage) PutBlob(ctx context.Context, blobID blob.ID, data []byte) error
 // DeleteBlob implements blob.Storage
 func (s Storage) DeleteBlob(ctx context.Context, blobID blob.ID) error {
 	dirPath, filePath := s.GetShardedPathAndFilePath(blobID)
-	return s.Impl.DeleteBlobInPath(ctx, dirPath, filePath)
+
+	err := s.Impl.DeleteBlobInPath(ctx, dirPath, filePath)
+	if s.PreviousShards == nil || (err != nil && err != blob.ErrBlobNotFound) {
+		return err
+	}
+
+	dirPath, filePath = s.previousLayout().GetShardedPathAndFilePath(blobID)
+
+	prevErr := s.Impl.DeleteBlobInPath(ctx, dirPath, filePath)
+	if prevErr == blob.ErrBlobNotFound && err == nil {
+		// blob was only present in the current layout.
+		return nil
+	}
+
+	return prevErr
+}
+
+func (s Storage) previousLayout() Storage {
+	s.Shards = s.PreviousShards
+	s.PreviousShards = nil
+
+	return s
 }
 
 func (s Storage) getShardDirectory(blobID blob.ID) (string, blob.ID) {
@@ -113,6 +171,11 @@ func (s Storage) getShardDirectory(blobID blob.ID) (string, blob.ID) {
 	}
 
 	for _, size := range s.Shards {
+		// always leave at least one character for the file name.
+		if len(blobID) <= size {
+			break
+		}
+
 		shardPath = path.Join(shardPath, string(blobID[0:size]))
 		blobID = blobID[size:]
 	}
@@ -0,0 +1,62 @@
+package sharded
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// flatShards is the string representation of a flat (non-sharded) directory layout.
+const flatShards = "flat"
+
+// ParseShards parses comma-separated list of shard lengths (such as "3,3") as returned by FormatShards.
+// Empty string returns nil, which means default sharding. The total length of all shards must be shorter
+// than the shortest sharded blob ID.
+func ParseShards(s string) ([]int, error) {
+	switch s {
+	case "":
+		return nil, nil
+	case flatShards:
+		return []int{}, nil
+	}
+
+	var (
+		result []int
+		total  int
+	)
+
+	for _, p := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(p)
+		if err != nil || v <= 0 {
+			return nil, errors.Errorf("invalid shard length: %q", p)
+		}
+
+		result = append(result, v)
+		total += v
+	}
+
+	if total >= minShardedBlobIDLength {
+		return nil, errors.Errorf("total length of shards must be less than %v: %q", minShardedBlobIDLength, s)
+	}
+
+	return result, nil
+}
+
+// FormatShards returns the string representation of the provided shards.
+func FormatShards(shards []int) string {
+	if shards == nil {
+		return ""
+	}
+
+	if len(shards) == 0 {
+		return flatShards
+	}
+
+	var parts []string
+	for _, v := range shards {
+		parts = append(parts, strconv.Itoa(v))
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,65 @@
+package storagemetrics
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	tagKeyStorage = tag.MustNewKey("storage")
+	tagKeyMethod  = tag.MustNewKey("method")
+)
+
+// storage operation metrics
+var (
+	metricStorageOperationCount = stats.Int64(
+		"kopia/blob/operation_count",
+		"Number of storage operations",
+		stats.UnitDimensionless,
+	)
+
+	metricStorageOperationErrorCount = stats.Int64(
+		"kopia/blob/operation_error_count",
+		"Number of storage operations that returned an error",
+		stats.UnitDimensionless,
+	)
+
+	metricStorageOperationLatency = stats.Float64(
+		"kopia/blob/operation_latency",
+		"Latency of storage operations",
+		stats.UnitMilliseconds,
+	)
+
+	metricStorageBytes = stats.Int64(
+		"kopia/blob/bytes",
+		"Number of bytes read from or written to the storage",
+		stats.UnitBytes,
+	)
+)
+
+// latencyDistribution has buckets from 1ms to ~100s.
+var latencyDistribution = view.Distribution(
+	1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000,
+)
+
+func aggregateByMethod(m stats.Measure, agg *view.Aggregation) *view.View {
+	return &view.View{
+		Name:        m.Name(),
+		Aggregation: agg,
+		Description: m.Description(),
+		Measure:     m,
+		TagKeys:     []tag.Key{tagKeyStorage, tagKeyMethod},
+	}
+}
+
+func init() {
+	if err := view.Register(
+		aggregateByMethod(metricStorageOperationCount, view.Count()),
+		aggregateByMethod(metricStorageOperationErrorCount, view.Count()),
+		aggregateByMethod(metricStorageOperationLatency, latencyDistribution),
+		aggregateByMethod(metricStorageBytes, view.Sum()),
+	); err != nil {
+		panic("unable to register opencensus views: " + err.Error())
+	}
+}
@@ -0,0 +1,109 @@
+// Package storagemetrics implements wrapper around Storage that records metrics of all operations.
+package storagemetrics
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+type metricsStorage struct {
+	base        blob.Storage
+	storageType string
+}
+
+func (s *metricsStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	t0 := time.Now()
+	result, err := s.base.GetBlob(ctx, id, offset, length)
+	s.record(ctx, "GetBlob", t0, int64(len(result)), err)
+
+	return result, err
+}
+
+func (s *metricsStorage) PutBlob(ctx context.Context, id blob.ID, data []byte) error {
+	t0 := time.Now()
+	err := s.base.PutBlob(ctx, id, data)
+	s.record(ctx, "PutBlob", t0, int64(len(data)), err)
+
+	return err
+}
+
+func (s *metricsStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	t0 := time.Now()
+	err := s.base.DeleteBlob(ctx, id)
+	s.record(ctx, "DeleteBlob", t0, 0, err)
+
+	return err
+}
+
+func (s *metricsStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	t0 := time.Now()
+	err := s.base.ListBlobs(ctx, prefix, callback)
+	s.record(ctx, "ListBlobs", t0, 0, err)
+
+	return err
+}
+
+func (s *metricsStorage) BlobRetentionPeriod() time.Duration {
+	return blob.BlobRetentionPeriod(s.base)
+}
+
+func (s *metricsStorage) ExtendBlobRetention(ctx context.Context, id blob.ID, until time.Time) error {
+	t0 := time.Now()
+	err := blob.ExtendBlobRetention(ctx, s.base, id, until)
+
+	if err != blob.ErrRetentionNotSupported {
+		s.record(ctx, "ExtendBlobRetention", t0, 0, err)
+	}
+
+	return err
+}
+
+func (s *metricsStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	t0 := time.Now()
+	c, err := blob.GetCapacity(ctx, s.base)
+
+	if err != blob.ErrCapacityNotSupported {
+		s.record(ctx, "GetCapacity", t0, 0, err)
+	}
+
+	return c, err
+}
+
+func (s *metricsStorage) Close(ctx context.Context) error {
+	return s.base.Close(ctx)
+}
+
+func (s *metricsStorage) ConnectionInfo() blob.ConnectionInfo {
+	return s.base.ConnectionInfo()
+}
+
+func (s *metricsStorage) record(ctx context.Context, method string, t0 time.Time, bytes int64, err error) {
+	measurements := []stats.Measurement{
+		metricStorageOperationCount.M(1),
+		metricStorageOperationLatency.M(float64(time.Since(t0)) / float64(time.Millisecond)),
+	}
+
+	if bytes > 0 {
+		measurements = append(measurements, metricStorageBytes.M(bytes))
+	}
+
+	// missing blobs are expected and not counted as errors.
+	if err != nil && err != blob.ErrBlobNotFound {
+		measurements = append(measurements, metricStorageOperationErrorCount.M(1))
+	}
+
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{
+		tag.Upsert(tagKeyStorage, s.storageType),
+		tag.Upsert(tagKeyMethod, method),
+	}, measurements...)
+}
+
+// NewWrapper returns a Storage wrapper that records latency, bytes transferred and errors of all storage operations.
+func NewWrapper(wrapped blob.Storage) blob.Storage {
+	return &metricsStorage{base: wrapped, storageType: wrapped.ConnectionInfo().Type}
+}
@@ -0,0 +1,87 @@
+package storagemetrics
+
+import (
+	"testing"
+
+	"go.opencensus.io/stats/view"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestMetricsStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	data := blobtesting.DataMap{}
+	underlying := blobtesting.NewMapStorage(data, nil, nil)
+
+	st := NewWrapper(underlying)
+	blobtesting.VerifyStorage(ctx, t, st)
+
+	// missing blobs are not errors.
+	errorsBefore := totalCount(t, metricStorageOperationErrorCount.Name())
+
+	if _, err := st.GetBlob(ctx, "no-such-blob", 0, -1); err != blob.ErrBlobNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := totalCount(t, metricStorageOperationErrorCount.Name()); got != errorsBefore {
+		t.Errorf("unexpected error count %v, want %v", got, errorsBefore)
+	}
+
+	if got, want := st.ConnectionInfo().Type, underlying.ConnectionInfo().Type; got != want {
+		t.Errorf("unexpected connection info %v, want %v", got, want)
+	}
+
+	if err := st.Close(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	counts := map[string]int64{}
+
+	rows, err := view.RetrieveData(metricStorageOperationCount.Name())
+	if err != nil {
+		t.Fatalf("unable to retrieve data: %v", err)
+	}
+
+	for _, r := range rows {
+		for _, tg := range r.Tags {
+			if tg.Key == tagKeyMethod {
+				counts[tg.Value] += r.Data.(*view.CountData).Value
+			}
+		}
+	}
+
+	for _, method := range []string{"GetBlob", "PutBlob", "DeleteBlob", "ListBlobs"} {
+		if counts[method] == 0 {
+			t.Errorf("no operations recorded for %v: %v", method, counts)
+		}
+	}
+
+	byteRows, err := view.RetrieveData(metricStorageBytes.Name())
+	if err != nil {
+		t.Fatalf("unable to retrieve data: %v", err)
+	}
+
+	if len(byteRows) == 0 {
+		t.Errorf("no bytes recorded")
+	}
+}
+
+func totalCount(t *testing.T, viewName string) int64 {
+	t.Helper()
+
+	rows, err := view.RetrieveData(viewName)
+	if err != nil {
+		t.Fatalf("unable to retrieve data: %v", err)
+	}
+
+	var total int64
+
+	for _, r := range rows {
+		total += r.Data.(*view.CountData).Value
+	}
+
+	return total
+}
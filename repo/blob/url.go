@@ -0,0 +1,109 @@
+package blob
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	urlParsers    = map[string]*urlParser{}
+	urlFormatters = map[string]URLFormatFunc{}
+)
+
+// URLParseFunc parses the provided storage URL and returns storage-specific options,
+// of the same type as returned by the default config function passed to AddSupportedStorage.
+type URLParseFunc func(u *url.URL) (interface{}, error)
+
+// URLFormatFunc returns the URL representing the provided storage-specific options.
+type URLFormatFunc func(o interface{}) (*url.URL, error)
+
+type urlParser struct {
+	storageType string
+	parse       URLParseFunc
+}
+
+// AddSupportedURLScheme registers a parser of storage URLs with a given scheme (such as 's3' in 's3://bucket/prefix')
+// and a formatter that converts options of a given storage type back into a URL.
+// A single storage type may be registered with multiple URL schemes.
+func AddSupportedURLScheme(scheme, storageType string, parse URLParseFunc, format URLFormatFunc) {
+	urlParsers[scheme] = &urlParser{
+		storageType: storageType,
+		parse:       parse,
+	}
+
+	if format != nil {
+		urlFormatters[storageType] = format
+	}
+}
+
+// SupportedURLSchemes returns the sorted list of registered storage URL schemes.
+func SupportedURLSchemes() []string {
+	var result []string
+
+	for k := range urlParsers {
+		result = append(result, k)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// ParseURL parses the provided storage URL and returns ConnectionInfo that can be passed to NewStorage.
+// The URL scheme must be previously registered using AddSupportedURLScheme.
+func ParseURL(s string) (ConnectionInfo, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ConnectionInfo{}, errors.Wrap(err, "invalid storage URL")
+	}
+
+	p := urlParsers[u.Scheme]
+	if p == nil {
+		return ConnectionInfo{}, errors.Errorf("unsupported storage URL scheme: %q", u.Scheme)
+	}
+
+	opt, err := p.parse(u)
+	if err != nil {
+		return ConnectionInfo{}, errors.Wrapf(err, "unable to parse %v URL", u.Scheme)
+	}
+
+	return ConnectionInfo{
+		Type:   p.storageType,
+		Config: opt,
+	}, nil
+}
+
+// FormatURL returns the URL representing the provided ConnectionInfo.
+// Note that the URL may include credentials stored in the connection info.
+func FormatURL(ci ConnectionInfo) (string, error) {
+	f := urlFormatters[ci.Type]
+	if f == nil {
+		return "", errors.Errorf("storage type '%v' can't be represented as URL", ci.Type)
+	}
+
+	u, err := f(ci.Config)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to format %v URL", ci.Type)
+	}
+
+	return u.String(), nil
+}
+
+// ParseURLSpeed returns the throughput limit in bytes per second stored in the provided query parameter
+// of a storage URL, or 0 (unlimited) if the parameter is not set.
+func ParseURLSpeed(q url.Values, name string) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid %v: %q", name, s)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,86 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// quotaRequestBody requests quota properties defined in RFC 4331.
+const quotaRequestBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:quota-available-bytes/><d:quota-used-bytes/></d:prop></d:propfind>`
+
+type quotaMultiStatus struct {
+	Responses []struct {
+		PropStats []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				Available string `xml:"quota-available-bytes"`
+				Used      string `xml:"quota-used-bytes"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// GetCapacity implements blob.CapacityReporter using WebDAV quota properties.
+func (d *davStorage) GetCapacity(ctx context.Context) (blob.Capacity, error) {
+	impl := d.Impl.(*davStorageImpl)
+	opt := impl.Options
+
+	req, err := http.NewRequest("PROPFIND", opt.URL, strings.NewReader(quotaRequestBody))
+	if err != nil {
+		return blob.Capacity{}, errors.Wrap(err, "unable to create request")
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml;charset=UTF-8")
+
+	if opt.Username != "" {
+		req.SetBasicAuth(opt.Username, opt.Password)
+	}
+
+	resp, err := impl.httpClient.Do(req)
+	if err != nil {
+		return blob.Capacity{}, errors.Wrap(err, "unable to get quota")
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return blob.Capacity{}, errors.Errorf("unable to get quota: %v", resp.Status)
+	}
+
+	var ms quotaMultiStatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return blob.Capacity{}, errors.Wrap(err, "unable to parse quota response")
+	}
+
+	for _, r := range ms.Responses {
+		for _, ps := range r.PropStats {
+			if ps.Prop.Available == "" || !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+
+			// negative or missing values mean that the quota is unknown.
+			available, err := strconv.ParseUint(ps.Prop.Available, 10, 64)
+			if err != nil {
+				return blob.Capacity{}, blob.ErrCapacityNotSupported
+			}
+
+			used, _ := strconv.ParseUint(ps.Prop.Used, 10, 64)
+
+			return blob.Capacity{
+				SizeB: available + used,
+				FreeB: available,
+			}, nil
+		}
+	}
+
+	return blob.Capacity{}, blob.ErrCapacityNotSupported
+}
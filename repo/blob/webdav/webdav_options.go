@@ -6,6 +6,10 @@ type Options struct {
 	DirectoryShards []int  `json:"dirShards"`
 	Username        string `json:"username,omitempty"`
 	Password        string `json:"password,omitempty" kopia:"sensitive"`
+
+	// PreviousDirectoryShards is set while blobs are being migrated from a different layout.
+	// Layout stored in the storage itself takes precedence over both DirectoryShards and PreviousDirectoryShards.
+	PreviousDirectoryShards []int `json:"previousDirShards,omitempty"`
 }
 
 func (fso *Options) shards() []int {
@@ -42,6 +42,9 @@ type davStorageImpl struct {
 	Options
 
 	cli *gowebdav.Client
+
+	// httpClient is used for requests not supported by cli and shares its transport.
+	httpClient *http.Client
 }
 
 func (d *davStorageImpl) GetBlobFromPath(ctx context.Context, dirPath, path string, offset, length int64) ([]byte, error) {
@@ -163,17 +166,30 @@ func isRetriable(err error) bool {
 
 // New creates new WebDAV-backed storage in a specified URL.
 func New(ctx context.Context, opts *Options) (blob.Storage, error) {
-	return &davStorage{
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	cli := gowebdav.NewClient(opts.URL, opts.Username, opts.Password)
+	cli.SetTransport(transport)
+
+	r := &davStorage{
 		sharded.Storage{
 			Impl: &davStorageImpl{
-				Options: *opts,
-				cli:     gowebdav.NewClient(opts.URL, opts.Username, opts.Password),
+				Options:    *opts,
+				cli:        cli,
+				httpClient: &http.Client{Transport: transport},
 			},
-			RootPath: "",
-			Suffix:   fsStorageChunkSuffix,
-			Shards:   opts.shards(),
+			RootPath:       "",
+			Suffix:         fsStorageChunkSuffix,
+			Shards:         opts.shards(),
+			PreviousShards: opts.PreviousDirectoryShards,
 		},
-	}, nil
+	}
+
+	if err := r.LoadLayout(ctx); err != nil {
+		return nil, err
+	}
+
+	return r, nil
 }
 
 func init() {
@@ -183,4 +199,6 @@ func init() {
 		func(ctx context.Context, o interface{}) (blob.Storage, error) {
 			return New(ctx, o.(*Options))
 		})
+	blob.AddSupportedURLScheme(davURLScheme, davStorageType, parseURL, formatURL)
+	blob.AddSupportedURLScheme(davsURLScheme, davStorageType, parseURL, formatURL)
 }
@@ -87,6 +87,65 @@ func TestWebDAVStorageBuiltInServer(t *testing.T) {
 	}
 }
 
+func TestWebDAVStorageCapacity(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, _ := ioutil.TempDir("", "webdav")
+	defer os.RemoveAll(tmpDir)
+
+	h := &webdav.Handler{
+		FileSystem: webdav.Dir(tmpDir),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	quota := ""
+
+	// the built-in server does not support quota properties, add them to the root.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.URL.Path != "/" || quota == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:"><D:response><D:href>/</D:href><D:propstat><D:prop>%v</D:prop>
+<D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`, quota)
+	})))
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := testlogging.Context(t)
+
+	st, err := New(ctx, &Options{
+		URL:      server.URL,
+		Username: "user",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	quota = "<D:quota-available-bytes>3000</D:quota-available-bytes><D:quota-used-bytes>1000</D:quota-used-bytes>"
+
+	c, err := blob.GetCapacity(ctx, st)
+	if err != nil {
+		t.Fatalf("unable to get capacity: %v", err)
+	}
+
+	if want := (blob.Capacity{SizeB: 4000, FreeB: 3000}); c != want {
+		t.Errorf("unexpected capacity: %v, want %v", c, want)
+	}
+
+	quota = "<D:quota-available-bytes>-3</D:quota-available-bytes>"
+
+	if _, err := blob.GetCapacity(ctx, st); err != blob.ErrCapacityNotSupported {
+		t.Errorf("unexpected error for unknown quota: %v", err)
+	}
+}
+
 func verifyWebDAVStorage(t *testing.T, url, username, password string, shardSpec []int) {
 	ctx := testlogging.Context(t)
 
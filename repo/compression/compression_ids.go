@@ -14,6 +14,12 @@ const (
 	headerZstdBetterCompression HeaderID = 0x1102
 	headerZstdBestCompression   HeaderID = 0x1103
 
+	// zstd with dictionary, the ID of the dictionary follows the header.
+	headerZstdDictionaryDefault           HeaderID = 0x1110
+	headerZstdDictionaryFastest           HeaderID = 0x1111
+	headerZstdDictionaryBetterCompression HeaderID = 0x1112
+	headerZstdDictionaryBestCompression   HeaderID = 0x1113
+
 	headerS2Default   HeaderID = 0x1200
 	headerS2Better    HeaderID = 0x1201
 	headerS2Parallel4 HeaderID = 0x1202
@@ -22,4 +28,11 @@ const (
 	headerPgzipDefault         HeaderID = 0x1300
 	headerPgzipBestSpeed       HeaderID = 0x1301
 	headerPgzipBestCompression HeaderID = 0x1302
+
+	headerLZ4Default         HeaderID = 0x1400
+	headerLZ4HighCompression HeaderID = 0x1401
+
+	headerBrotliDefault         HeaderID = 0x1500
+	headerBrotliFastest         HeaderID = 0x1501
+	headerBrotliBestCompression HeaderID = 0x1502
 )
@@ -0,0 +1,74 @@
+package compression
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/iocopy"
+)
+
+func init() {
+	RegisterCompressor("brotli", newBrotliCompressor(headerBrotliDefault, brotli.DefaultCompression))
+	RegisterCompressor("brotli-fastest", newBrotliCompressor(headerBrotliFastest, brotli.BestSpeed))
+	RegisterCompressor("brotli-best-compression", newBrotliCompressor(headerBrotliBestCompression, brotli.BestCompression))
+}
+
+func newBrotliCompressor(id HeaderID, level int) Compressor {
+	return &brotliCompressor{id, compressionHeader(id), sync.Pool{
+		New: func() interface{} {
+			return brotli.NewWriterLevel(bytes.NewBuffer(nil), level)
+		},
+	}}
+}
+
+type brotliCompressor struct {
+	id     HeaderID
+	header []byte
+	pool   sync.Pool
+}
+
+func (c *brotliCompressor) HeaderID() HeaderID {
+	return c.id
+}
+
+func (c *brotliCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	if _, err := output.Write(c.header); err != nil {
+		return errors.Wrap(err, "unable to write header")
+	}
+
+	w := c.pool.Get().(*brotli.Writer)
+	defer c.pool.Put(w)
+
+	w.Reset(output)
+
+	if _, err := w.Write(input); err != nil {
+		return errors.Wrap(err, "compression error")
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "compression close error")
+	}
+
+	return nil
+}
+
+func (c *brotliCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	if len(input) < compressionHeaderSize {
+		return errors.Errorf("invalid compression header")
+	}
+
+	if !bytes.Equal(input[0:compressionHeaderSize], c.header) {
+		return errors.Errorf("invalid compression header")
+	}
+
+	r := brotli.NewReader(bytes.NewReader(input[compressionHeaderSize:]))
+
+	if _, err := iocopy.Copy(output, r); err != nil {
+		return errors.Wrap(err, "decompression error")
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package compression
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/iocopy"
+)
+
+func init() {
+	RegisterCompressor("lz4", newLZ4Compressor(headerLZ4Default, lz4.Fast))
+	RegisterCompressor("lz4-high-compression", newLZ4Compressor(headerLZ4HighCompression, lz4.Level9))
+}
+
+func newLZ4Compressor(id HeaderID, level lz4.CompressionLevel) Compressor {
+	return &lz4Compressor{id, compressionHeader(id), sync.Pool{
+		New: func() interface{} {
+			w := lz4.NewWriter(bytes.NewBuffer(nil))
+			mustSucceed(w.Apply(lz4.CompressionLevelOption(level)))
+			return w
+		},
+	}}
+}
+
+type lz4Compressor struct {
+	id     HeaderID
+	header []byte
+	pool   sync.Pool
+}
+
+func (c *lz4Compressor) HeaderID() HeaderID {
+	return c.id
+}
+
+func (c *lz4Compressor) Compress(output *bytes.Buffer, input []byte) error {
+	if _, err := output.Write(c.header); err != nil {
+		return errors.Wrap(err, "unable to write header")
+	}
+
+	w := c.pool.Get().(*lz4.Writer)
+	defer c.pool.Put(w)
+
+	w.Reset(output)
+
+	if _, err := w.Write(input); err != nil {
+		return errors.Wrap(err, "compression error")
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "compression close error")
+	}
+
+	return nil
+}
+
+func (c *lz4Compressor) Decompress(output *bytes.Buffer, input []byte) error {
+	if len(input) < compressionHeaderSize {
+		return errors.Errorf("invalid compression header")
+	}
+
+	if !bytes.Equal(input[0:compressionHeaderSize], c.header) {
+		return errors.Errorf("invalid compression header")
+	}
+
+	r := lz4.NewReader(bytes.NewReader(input[compressionHeaderSize:]))
+
+	if _, err := iocopy.Copy(output, r); err != nil {
+		return errors.Wrap(err, "decompression error")
+	}
+
+	return nil
+}
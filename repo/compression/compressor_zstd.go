@@ -18,7 +18,7 @@ func init() {
 }
 
 func newZstdCompressor(id HeaderID, level zstd.EncoderLevel) Compressor {
-	return &zstdCompressor{id, compressionHeader(id), sync.Pool{
+	return &zstdCompressor{id, compressionHeader(id), level, sync.Pool{
 		New: func() interface{} {
 			w, err := zstd.NewWriter(bytes.NewBuffer(nil), zstd.WithEncoderLevel(level))
 			mustSucceed(err)
@@ -30,6 +30,7 @@ func newZstdCompressor(id HeaderID, level zstd.EncoderLevel) Compressor {
 type zstdCompressor struct {
 	id     HeaderID
 	header []byte
+	level  zstd.EncoderLevel
 	pool   sync.Pool
 }
 
@@ -0,0 +1,159 @@
+package compression
+
+import (
+	"bytes"
+	"hash/fnv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// offset between header IDs of zstd compressors and their counterparts using a dictionary.
+const zstdDictionaryHeaderOffset = headerZstdDictionaryDefault - headerZstdDefault
+
+const maxDictionaryIDLength = 255
+
+// NewDictionaryCompressor returns a compressor that uses the provided dictionary along with the compression level
+// of the provided zstd compressor. The ID of the dictionary is stored in the header of the compressed data,
+// and can be retrieved using DictionaryID().
+func NewDictionaryCompressor(base Compressor, dictionaryID string, dictionary []byte) (Compressor, error) {
+	zc, ok := base.(*zstdCompressor)
+	if !ok {
+		return nil, errors.Errorf("compressor %x does not support dictionaries", base.HeaderID())
+	}
+
+	if len(dictionaryID) == 0 || len(dictionaryID) > maxDictionaryIDLength {
+		return nil, errors.Errorf("invalid dictionary ID: %q", dictionaryID)
+	}
+
+	if len(dictionary) == 0 {
+		return nil, errors.Errorf("empty dictionary")
+	}
+
+	id := zc.id + zstdDictionaryHeaderOffset
+
+	header := compressionHeader(id)
+	header = append(header, byte(len(dictionaryID)))
+	header = append(header, dictionaryID...)
+
+	// the numeric dictionary ID stored in zstd frames, which must not be zero.
+	h := fnv.New32a()
+	h.Write([]byte(dictionaryID)) //nolint:errcheck
+
+	frameDictID := h.Sum32() | 1
+
+	c := &zstdDictionaryCompressor{id: id, header: header}
+
+	c.encoders.New = func() interface{} {
+		w, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zc.level), zstd.WithEncoderDictRaw(frameDictID, dictionary))
+		mustSucceed(err)
+
+		return w
+	}
+
+	c.newDecoder = func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDictRaw(frameDictID, dictionary))
+	}
+
+	return c, nil
+}
+
+// DictionaryID returns the ID of the dictionary used to compress the provided data, if any.
+func DictionaryID(b []byte) (string, bool) {
+	id, err := IDFromHeader(b)
+	if err != nil || !isDictionaryHeaderID(id) {
+		return "", false
+	}
+
+	if len(b) <= compressionHeaderSize {
+		return "", false
+	}
+
+	n := int(b[compressionHeaderSize])
+	if len(b) < compressionHeaderSize+1+n {
+		return "", false
+	}
+
+	return string(b[compressionHeaderSize+1 : compressionHeaderSize+1+n]), true
+}
+
+func isDictionaryHeaderID(id HeaderID) bool {
+	return id >= headerZstdDictionaryDefault && id <= headerZstdDictionaryBestCompression
+}
+
+type zstdDictionaryCompressor struct {
+	id       HeaderID
+	header   []byte
+	encoders sync.Pool
+
+	newDecoder   func() (*zstd.Decoder, error)
+	decoderMutex sync.Mutex
+	decoder      *zstd.Decoder
+}
+
+func (c *zstdDictionaryCompressor) HeaderID() HeaderID {
+	return c.id
+}
+
+func (c *zstdDictionaryCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	if _, err := output.Write(c.header); err != nil {
+		return errors.Wrap(err, "unable to write header")
+	}
+
+	w := c.encoders.Get().(*zstd.Encoder)
+	defer c.encoders.Put(w)
+
+	w.Reset(output)
+
+	if _, err := w.Write(input); err != nil {
+		return errors.Wrap(err, "compression error")
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "compression close error")
+	}
+
+	return nil
+}
+
+func (c *zstdDictionaryCompressor) getDecoder() (*zstd.Decoder, error) {
+	c.decoderMutex.Lock()
+	defer c.decoderMutex.Unlock()
+
+	if c.decoder == nil {
+		d, err := c.newDecoder()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create decoder")
+		}
+
+		c.decoder = d
+	}
+
+	return c.decoder, nil
+}
+
+func (c *zstdDictionaryCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	// the compression level does not affect decompression, only the dictionary must match.
+	if len(input) < len(c.header) || !bytes.Equal(input[compressionHeaderSize:len(c.header)], c.header[compressionHeaderSize:]) {
+		return errors.Errorf("invalid compression header")
+	}
+
+	if id, err := IDFromHeader(input); err != nil || !isDictionaryHeaderID(id) {
+		return errors.Errorf("invalid compression header")
+	}
+
+	d, err := c.getDecoder()
+	if err != nil {
+		return err
+	}
+
+	result, err := d.DecodeAll(input[len(c.header):], nil)
+	if err != nil {
+		return errors.Wrap(err, "decompression error")
+	}
+
+	output.Write(result) //nolint:errcheck
+
+	return nil
+}
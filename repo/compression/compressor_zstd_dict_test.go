@@ -0,0 +1,102 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func makeDirectoryLikeSamples(count int) [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+
+	var samples [][]byte
+
+	for i := 0; i < count; i++ {
+		var b bytes.Buffer
+
+		fmt.Fprintf(&b, `{"stream":"kopia:directory","entries":[`)
+
+		for j := 0; j < 1+rnd.Intn(5); j++ {
+			fmt.Fprintf(&b, `{"name":"file%v.txt","type":"f","mode":"0644","mtime":"2020-03-%02dT10:%02d:00Z","uid":501,"gid":20,"obj":"D%032x","size":%v},`,
+				rnd.Intn(1000), 1+rnd.Intn(28), rnd.Intn(60), rnd.Int63(), rnd.Intn(100000))
+		}
+
+		fmt.Fprintf(&b, `],"summary":{"size":%v,"files":%v,"dirs":0}}`, rnd.Intn(1000000), rnd.Intn(10))
+
+		samples = append(samples, b.Bytes())
+	}
+
+	return samples
+}
+
+func TestDictionaryCompressor(t *testing.T) {
+	samples := makeDirectoryLikeSamples(500)
+
+	dict := TrainDictionary(samples[0:400], 16384)
+	if len(dict) == 0 || len(dict) > 16384 {
+		t.Fatalf("unexpected dictionary size: %v", len(dict))
+	}
+
+	comp, err := NewDictionaryCompressor(ByName["zstd"], "dict1", dict)
+	if err != nil {
+		t.Fatalf("unable to create compressor: %v", err)
+	}
+
+	otherComp, err := NewDictionaryCompressor(ByName["zstd-fastest"], "dict2", dict)
+	if err != nil {
+		t.Fatalf("unable to create compressor: %v", err)
+	}
+
+	var withDictionary, withoutDictionary int
+
+	for _, data := range samples[400:] {
+		var cData, plain bytes.Buffer
+
+		if err := comp.Compress(&cData, data); err != nil {
+			t.Fatalf("compression error %v", err)
+		}
+
+		if err := ByName["zstd"].Compress(&plain, data); err != nil {
+			t.Fatalf("compression error %v", err)
+		}
+
+		withDictionary += cData.Len()
+		withoutDictionary += plain.Len()
+
+		if got, ok := DictionaryID(cData.Bytes()); !ok || got != "dict1" {
+			t.Fatalf("unexpected dictionary ID: %v %v", got, ok)
+		}
+
+		if _, ok := DictionaryID(plain.Bytes()); ok {
+			t.Fatalf("unexpected dictionary ID of data compressed without dictionary")
+		}
+
+		var data2 bytes.Buffer
+		if err := comp.Decompress(&data2, cData.Bytes()); err != nil {
+			t.Fatalf("decompression error %v", err)
+		}
+
+		if !bytes.Equal(data, data2.Bytes()) {
+			t.Fatalf("invalid decompressed data %x, wanted %x", data2.Bytes(), data)
+		}
+
+		if err := otherComp.Decompress(&data2, cData.Bytes()); err == nil {
+			t.Fatalf("decompressed data using wrong dictionary")
+		}
+
+		if err := ByName["zstd"].Decompress(&data2, cData.Bytes()); err == nil {
+			t.Fatalf("decompressed data without dictionary")
+		}
+	}
+
+	t.Logf("compressed with dictionary: %v, without: %v", withDictionary, withoutDictionary)
+
+	if withDictionary >= withoutDictionary*3/4 {
+		t.Errorf("dictionary not effective: %v vs %v", withDictionary, withoutDictionary)
+	}
+
+	if _, err := NewDictionaryCompressor(ByName["gzip"], "dict3", dict); err == nil {
+		t.Errorf("expected error creating dictionary compressor from gzip")
+	}
+}
@@ -0,0 +1,131 @@
+package compression
+
+import (
+	"container/heap"
+	"encoding/binary"
+)
+
+const (
+	// length of byte sequences whose frequency across samples determines the value of dictionary segments.
+	dictionaryGramSize = 8
+
+	// size of segments of samples that are candidates for inclusion in the dictionary.
+	dictionarySegmentSize = 256
+)
+
+type dictionarySegment struct {
+	data  []byte
+	score int
+}
+
+type dictionarySegmentHeap []*dictionarySegment
+
+func (h dictionarySegmentHeap) Len() int            { return len(h) }
+func (h dictionarySegmentHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h dictionarySegmentHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *dictionarySegmentHeap) Push(x interface{}) { *h = append(*h, x.(*dictionarySegment)) }
+func (h *dictionarySegmentHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+
+	return x
+}
+
+// TrainDictionary builds a dictionary of at most maxSize bytes from segments of the provided samples
+// that contain byte sequences occurring in many samples, which is a simplified version of the cover
+// algorithm used by zstd. Returns nil if samples have nothing in common.
+func TrainDictionary(samples [][]byte, maxSize int) []byte {
+	// number of samples containing each gram.
+	frequency := map[uint64]int{}
+	lastSeenInSample := map[uint64]int{}
+
+	for i, s := range samples {
+		for p := 0; p+dictionaryGramSize <= len(s); p++ {
+			g := binary.LittleEndian.Uint64(s[p:])
+
+			if last, ok := lastSeenInSample[g]; !ok || last != i {
+				lastSeenInSample[g] = i
+				frequency[g]++
+			}
+		}
+	}
+
+	used := map[uint64]bool{}
+
+	score := func(seg []byte) int {
+		result := 0
+
+		for p := 0; p+dictionaryGramSize <= len(seg); p++ {
+			g := binary.LittleEndian.Uint64(seg[p:])
+			if f := frequency[g]; f > 1 && !used[g] {
+				result += f
+			}
+		}
+
+		return result
+	}
+
+	var h dictionarySegmentHeap
+
+	for _, s := range samples {
+		for p := 0; p < len(s); p += dictionarySegmentSize {
+			end := p + dictionarySegmentSize
+			if end > len(s) {
+				end = len(s)
+			}
+
+			if seg := s[p:end]; len(seg) >= dictionaryGramSize {
+				h = append(h, &dictionarySegment{seg, score(seg)})
+			}
+		}
+	}
+
+	heap.Init(&h)
+
+	var (
+		selected [][]byte
+		size     int
+	)
+
+	// lazy greedy selection - scores only decrease as grams are used, so a segment whose recomputed
+	// score is still at least the best score of remaining segments is the best choice.
+	for h.Len() > 0 && size < maxSize {
+		seg := heap.Pop(&h).(*dictionarySegment)
+
+		seg.score = score(seg.data)
+		if seg.score == 0 {
+			continue
+		}
+
+		if h.Len() > 0 && seg.score < h[0].score {
+			heap.Push(&h, seg)
+			continue
+		}
+
+		for p := 0; p+dictionaryGramSize <= len(seg.data); p++ {
+			used[binary.LittleEndian.Uint64(seg.data[p:])] = true
+		}
+
+		selected = append(selected, seg.data)
+		size += len(seg.data)
+	}
+
+	if len(selected) == 0 {
+		return nil
+	}
+
+	// place most valuable segments at the end of the dictionary, closest to the compressed data.
+	var result []byte
+
+	for i := len(selected) - 1; i >= 0; i-- {
+		result = append(result, selected[i]...)
+	}
+
+	if len(result) > maxSize {
+		result = result[len(result)-maxSize:]
+	}
+
+	return result
+}
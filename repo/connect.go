@@ -128,7 +128,9 @@ func setupCaching(ctx context.Context, configPath string, lc *LocalConfig, opt c
 
 	lc.Caching.MaxCacheSizeBytes = opt.MaxCacheSizeBytes
 	lc.Caching.MaxMetadataCacheSizeBytes = opt.MaxMetadataCacheSizeBytes
+	lc.Caching.MaxSeekTableCacheSizeBytes = opt.MaxSeekTableCacheSizeBytes
 	lc.Caching.MaxListCacheDurationSec = opt.MaxListCacheDurationSec
+	lc.Caching.PackSpool = opt.PackSpool
 
 	log(ctx).Debugf("Creating cache directory '%v' with max size %v", lc.Caching.CacheDirectory, lc.Caching.MaxCacheSizeBytes)
 
@@ -3,13 +3,19 @@ package content
 import (
 	"bytes"
 	"context"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
 )
 
 const verySmallContentFraction = 20 // blobs less than 1/verySmallContentFraction of maxPackSize are considered 'very small'
 
+// legacyIndexBlobMigrationMarker follows the epoch prefix in IDs of index blobs holding migrated legacy index blobs.
+const legacyIndexBlobMigrationMarker = "legacy_"
+
 var autoCompactionOptions = CompactOptions{
 	MaxSmallBlobs: 4 * parallelFetches, // nolint:gomnd
 }
@@ -19,12 +25,22 @@ type CompactOptions struct {
 	MaxSmallBlobs        int
 	AllIndexes           bool
 	SkipDeletedOlderThan time.Duration
+	ConvertV1Indexes     bool
+	ReencryptIndexes     bool // rewrite index blobs encrypted using previous master keys
 }
 
 // CompactIndexes performs compaction of index blobs ensuring that # of small index blobs is below opt.maxSmallBlobs
 func (bm *Manager) CompactIndexes(ctx context.Context, opt CompactOptions) error {
 	log(ctx).Debugf("CompactIndexes(%+v)", opt)
 
+	if opt.ConvertV1Indexes && bm.indexVersion() < indexVersion2 {
+		return errors.Errorf("converting v1 indexes requires repository format version %v", FormatVersion2)
+	}
+
+	if bm.epochMgr != nil {
+		return bm.maintainEpochs(ctx, opt)
+	}
+
 	bm.lock()
 	defer bm.unlock()
 
@@ -35,6 +51,30 @@ func (bm *Manager) CompactIndexes(ctx context.Context, opt CompactOptions) error
 
 	contentsToCompact := bm.getContentsToCompact(ctx, indexBlobs, opt)
 
+	if opt.ConvertV1Indexes {
+		v1Blobs, err := bm.getV1IndexBlobs(ctx, indexBlobs)
+		if err != nil {
+			return errors.Wrap(err, "error finding v1 indexes")
+		}
+
+		contentsToCompact = mergeIndexBlobInfos(contentsToCompact, v1Blobs)
+
+		// nothing to convert, don't rewrite a single index blob.
+		opt.ConvertV1Indexes = len(v1Blobs) > 0
+	}
+
+	if opt.ReencryptIndexes {
+		blobs, err := bm.getIndexBlobsEncryptedWithPreviousKeys(ctx, indexBlobs)
+		if err != nil {
+			return errors.Wrap(err, "error finding index blobs encrypted with previous keys")
+		}
+
+		contentsToCompact = mergeIndexBlobInfos(contentsToCompact, blobs)
+
+		// nothing to re-encrypt, don't rewrite a single index blob.
+		opt.ReencryptIndexes = len(blobs) > 0
+	}
+
 	if err := bm.compactAndDeleteIndexBlobs(ctx, contentsToCompact, opt); err != nil {
 		log(ctx).Warningf("error performing quick compaction: %v", err)
 	}
@@ -82,8 +122,82 @@ func (bm *Manager) getContentsToCompact(ctx context.Context, indexBlobs []IndexB
 	return nonCompactedBlobs
 }
 
+// getV1IndexBlobs returns index blobs written in the v1 format, based on their locally cached copies.
+func (bm *Manager) getV1IndexBlobs(ctx context.Context, indexBlobs []IndexBlobInfo) ([]IndexBlobInfo, error) {
+	// normally a no-op, since all index blobs are cached when they are loaded.
+	if err := bm.tryLoadPackIndexBlobsUnlocked(ctx, indexBlobs); err != nil {
+		return nil, errors.Wrap(err, "unable to load index blobs")
+	}
+
+	var result []IndexBlobInfo
+
+	for _, b := range indexBlobs {
+		v, err := bm.committedContents.cache.indexBlobVersion(ctx, b.BlobID)
+		if err != nil {
+			return nil, err
+		}
+
+		if v == indexVersion1 {
+			result = append(result, b)
+		}
+	}
+
+	formatLog(ctx).Debugf("found %v v1 index blobs", len(result))
+
+	return result, nil
+}
+
+// getIndexBlobsEncryptedWithPreviousKeys returns index blobs which can't be decrypted using the current master key.
+func (bm *Manager) getIndexBlobsEncryptedWithPreviousKeys(ctx context.Context, indexBlobs []IndexBlobInfo) ([]IndexBlobInfo, error) {
+	var result []IndexBlobInfo
+
+	for _, b := range indexBlobs {
+		ok, err := bm.isEncryptedWithCurrentKey(ctx, b.BlobID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			result = append(result, b)
+		}
+	}
+
+	formatLog(ctx).Debugf("found %v index blobs encrypted with previous keys", len(result))
+
+	return result, nil
+}
+
+// IndexBlobsEncryptedWithPreviousKeys returns active index blobs which can't be decrypted using the current master key.
+func (bm *Manager) IndexBlobsEncryptedWithPreviousKeys(ctx context.Context) ([]IndexBlobInfo, error) {
+	indexBlobs, err := bm.IndexBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return bm.getIndexBlobsEncryptedWithPreviousKeys(ctx, indexBlobs)
+}
+
+func mergeIndexBlobInfos(a, b []IndexBlobInfo) []IndexBlobInfo {
+	seen := map[blob.ID]bool{}
+
+	var result []IndexBlobInfo
+
+	for _, list := range [][]IndexBlobInfo{a, b} {
+		for _, ib := range list {
+			if !seen[ib.BlobID] {
+				seen[ib.BlobID] = true
+
+				result = append(result, ib)
+			}
+		}
+	}
+
+	return result
+}
+
 func (bm *Manager) compactAndDeleteIndexBlobs(ctx context.Context, indexBlobs []IndexBlobInfo, opt CompactOptions) error {
-	if len(indexBlobs) <= 1 {
+	// a single index blob is only rewritten when it needs to be converted to the current format.
+	if len(indexBlobs) == 0 || (len(indexBlobs) == 1 && !opt.ConvertV1Indexes && !opt.ReencryptIndexes) {
 		return nil
 	}
 
@@ -99,7 +213,7 @@ func (bm *Manager) compactAndDeleteIndexBlobs(ctx context.Context, indexBlobs []
 	}
 
 	var buf bytes.Buffer
-	if err := bld.Build(&buf); err != nil {
+	if err := bld.Build(&buf, bm.indexVersion()); err != nil {
 		return errors.Wrap(err, "unable to build an index")
 	}
 
@@ -112,6 +226,8 @@ func (bm *Manager) compactAndDeleteIndexBlobs(ctx context.Context, indexBlobs []
 
 	for _, indexBlob := range indexBlobs {
 		if indexBlob.BlobID == compactedIndexBlob {
+			// the blob has been rewritten in place, for example using a different encryption key.
+			bm.contentCache.removeContent(ctx, cacheKey(indexBlob.BlobID))
 			continue
 		}
 
@@ -125,13 +241,123 @@ func (bm *Manager) compactAndDeleteIndexBlobs(ctx context.Context, indexBlobs []
 	return nil
 }
 
+// maintainEpochs moves index blobs written before index epochs were enabled into the current epoch and
+// performs epoch maintenance, which compacts index blobs per epoch.
+func (bm *Manager) maintainEpochs(ctx context.Context, opt CompactOptions) error {
+	if opt.AllIndexes || opt.ConvertV1Indexes || opt.ReencryptIndexes || opt.SkipDeletedOlderThan > 0 {
+		return errors.Errorf("only epoch maintenance is supported when index epochs are enabled")
+	}
+
+	if err := bm.migrateLegacyIndexBlobs(ctx); err != nil {
+		return errors.Wrap(err, "error migrating index blobs to epochs")
+	}
+
+	return errors.Wrap(bm.epochMgr.Maintain(ctx), "error performing epoch maintenance")
+}
+
+// migrateLegacyIndexBlobs merges index blobs that are not managed by epochs, which were written before epochs were enabled
+// or by clients that have not reconnected since, into a single index blob of the current epoch. Migrated blobs are
+// removed once the migrated index blob has existed for CleanupSafetyMargin, so that clients with a stale list of
+// index blobs can still read them.
+func (bm *Manager) migrateLegacyIndexBlobs(ctx context.Context) error {
+	bm.listCache.deleteListCache()
+
+	legacyBlobs, err := bm.listCache.listIndexBlobs(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error listing index blobs")
+	}
+
+	if len(legacyBlobs) == 0 {
+		return nil
+	}
+
+	if err = bm.epochMgr.Refresh(ctx); err != nil {
+		return errors.Wrap(err, "error refreshing epochs")
+	}
+
+	epochBlobs, err := bm.epochMgr.GetCompleteIndexSet(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error listing epoch index blobs")
+	}
+
+	var migratedAt time.Time
+
+	for _, it := range epochBlobs {
+		if strings.Contains(string(it.BlobID), legacyIndexBlobMigrationMarker) && it.Timestamp.After(migratedAt) {
+			migratedAt = it.Timestamp
+		}
+	}
+
+	var pending, migrated []blob.ID
+
+	for _, it := range legacyBlobs {
+		if it.Timestamp.Before(migratedAt) {
+			migrated = append(migrated, it.BlobID)
+		} else {
+			pending = append(pending, it.BlobID)
+		}
+	}
+
+	if len(pending) > 0 {
+		prefix, err := bm.epochMgr.WriteIndexBlobPrefix(ctx)
+		if err != nil {
+			return errors.Wrap(err, "error determining current epoch")
+		}
+
+		log(ctx).Infof("moving %v index blobs to the current index epoch", len(pending))
+
+		if err := bm.compactEpochIndexBlobs(ctx, pending, prefix+legacyIndexBlobMigrationMarker); err != nil {
+			return err
+		}
+	}
+
+	if len(migrated) == 0 || bm.timeNow().Sub(migratedAt) < bm.epochMgr.Params.CleanupSafetyMargin {
+		return nil
+	}
+
+	bm.listCache.deleteListCache()
+
+	for _, id := range migrated {
+		if err := bm.st.DeleteBlob(ctx, id); err != nil && err != blob.ErrBlobNotFound {
+			log(ctx).Warningf("unable to delete migrated index blob %q: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+// compactEpochIndexBlobs merges the provided index blobs of a sealed epoch into a single index blob with the provided prefix.
+func (bm *Manager) compactEpochIndexBlobs(ctx context.Context, blobIDs []blob.ID, outputPrefix blob.ID) error {
+	bld := make(packIndexBuilder)
+
+	for _, indexBlobID := range blobIDs {
+		if err := bm.addIndexBlobsToBuilder(ctx, bld, IndexBlobInfo{BlobID: indexBlobID}, CompactOptions{}); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := bld.Build(&buf, bm.indexVersion()); err != nil {
+		return errors.Wrap(err, "unable to build an index")
+	}
+
+	compactedIndexBlob, err := bm.encryptAndWriteBlobNotLocked(ctx, buf.Bytes(), outputPrefix)
+	if err != nil {
+		return errors.Wrap(err, "unable to write compacted index")
+	}
+
+	formatLog(ctx).Debugf("compacted %v index blobs into %v", len(blobIDs), compactedIndexBlob)
+
+	return nil
+}
+
 func (bm *Manager) addIndexBlobsToBuilder(ctx context.Context, bld packIndexBuilder, indexBlob IndexBlobInfo, opt CompactOptions) error {
 	data, err := bm.getIndexBlobInternal(ctx, indexBlob.BlobID)
 	if err != nil {
 		return err
 	}
 
-	index, err := openPackIndex(bytes.NewReader(data))
+	index, err := openPackIndex(bytes.NewReader(data), bm.perContentOverhead())
 	if err != nil {
 		return errors.Wrapf(err, "unable to open index blob %q", indexBlob)
 	}
@@ -15,7 +15,13 @@ const (
 	packHeaderSize = 8
 	deletedMarker  = 0x80000000
 
+	indexVersion1 = 1
+	indexVersion2 = 2
+
 	entryFixedHeaderLength = 20
+	entryV2Length          = 27
+
+	maxV2CompressionHeaderID = 0xffff
 )
 
 // packIndexBuilder prepares and writes content index.
@@ -61,6 +67,7 @@ func (b packIndexBuilder) sortedContents() []*Info {
 }
 
 type indexLayout struct {
+	version           byte
 	packBlobIDOffsets map[blob.ID]uint32
 	entryCount        int
 	keyLength         int
@@ -68,13 +75,14 @@ type indexLayout struct {
 	extraDataOffset   uint32
 }
 
-// Build writes the pack index to the provided output.
-func (b packIndexBuilder) Build(output io.Writer) error {
+// Build writes the pack index in the provided format version to the output.
+func (b packIndexBuilder) Build(output io.Writer, version byte) error {
 	allContents := b.sortedContents()
 	layout := &indexLayout{
+		version:           version,
 		packBlobIDOffsets: map[blob.ID]uint32{},
 		keyLength:         -1,
-		entryLength:       entryFixedHeaderLength,
+		entryLength:       minEntryLength(version),
 		entryCount:        len(allContents),
 	}
 
@@ -85,7 +93,7 @@ func (b packIndexBuilder) Build(output io.Writer) error {
 
 	// write header
 	header := make([]byte, packHeaderSize)
-	header[0] = 1 // version
+	header[0] = version
 	header[1] = byte(layout.keyLength)
 	binary.BigEndian.PutUint16(header[2:4], uint16(layout.entryLength))
 	binary.BigEndian.PutUint32(header[4:8], uint32(layout.entryCount))
@@ -181,5 +189,85 @@ func formatEntry(entry []byte, it *Info, layout *indexLayout) error {
 	timestampAndFlags |= uint64(len(it.PackBlobID))
 	binary.BigEndian.PutUint64(entryTimestampAndFlags, timestampAndFlags)
 
+	if layout.version < indexVersion2 {
+		return nil
+	}
+
+	if it.CompressionHeaderID > maxV2CompressionHeaderID {
+		return errors.Errorf("compression header ID %x of %v can't be stored in the index", it.CompressionHeaderID, it.ID)
+	}
+
+	binary.BigEndian.PutUint32(entry[20:24], it.OriginalLength)
+	binary.BigEndian.PutUint16(entry[24:26], uint16(it.CompressionHeaderID))
+	entry[26] = it.EncryptionKeyID
+
 	return nil
 }
+
+// buildMerged writes the contents of the provided index in the v2 format to the output
+// without materializing all entries in memory. The index is iterated twice, once to compute
+// the layout and once to write sorted entries, so it must not change in the meantime.
+func buildMerged(output io.Writer, ndx packIndex) error {
+	layout := &indexLayout{
+		version:           indexVersion2,
+		packBlobIDOffsets: map[blob.ID]uint32{},
+		keyLength:         -1,
+		entryLength:       minEntryLength(indexVersion2),
+	}
+
+	var (
+		extraData []byte
+		hashBuf   [maxContentIDSize]byte
+	)
+
+	if err := ndx.Iterate("", func(i Info) error {
+		if layout.entryCount == 0 {
+			layout.keyLength = len(contentIDToBytes(hashBuf[:0], i.ID))
+		}
+
+		layout.entryCount++
+
+		if _, ok := layout.packBlobIDOffsets[i.PackBlobID]; !ok && i.PackBlobID != "" {
+			layout.packBlobIDOffsets[i.PackBlobID] = uint32(len(extraData))
+			extraData = append(extraData, []byte(i.PackBlobID)...)
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "unable to compute index layout")
+	}
+
+	layout.extraDataOffset = uint32(packHeaderSize + layout.entryCount*(layout.keyLength+layout.entryLength))
+
+	w := bufio.NewWriter(output)
+
+	header := make([]byte, packHeaderSize)
+	header[0] = layout.version
+	header[1] = byte(layout.keyLength)
+	binary.BigEndian.PutUint16(header[2:4], uint16(layout.entryLength))
+	binary.BigEndian.PutUint32(header[4:8], uint32(layout.entryCount))
+
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "unable to write header")
+	}
+
+	entry := make([]byte, layout.entryLength)
+	written := 0
+
+	if err := ndx.Iterate("", func(i Info) error {
+		written++
+		return writeEntry(w, &i, layout, entry)
+	}); err != nil {
+		return errors.Wrap(err, "unable to write entry")
+	}
+
+	if written != layout.entryCount {
+		return errors.Errorf("index changed while being merged (%v vs %v entries)", written, layout.entryCount)
+	}
+
+	if _, err := w.Write(extraData); err != nil {
+		return errors.Wrap(err, "error writing extra data")
+	}
+
+	return w.Flush()
+}
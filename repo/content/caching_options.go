@@ -2,10 +2,12 @@ package content
 
 // CachingOptions specifies configuration of local cache.
 type CachingOptions struct {
-	CacheDirectory            string `json:"cacheDirectory,omitempty"`
-	MaxCacheSizeBytes         int64  `json:"maxCacheSize,omitempty"`
-	MaxMetadataCacheSizeBytes int64  `json:"maxMetadataCacheSize,omitempty"`
-	MaxListCacheDurationSec   int    `json:"maxListCacheDuration,omitempty"`
-	IgnoreListCache           bool   `json:"-"`
-	HMACSecret                []byte `json:"-"`
+	CacheDirectory             string `json:"cacheDirectory,omitempty"`
+	MaxCacheSizeBytes          int64  `json:"maxCacheSize,omitempty"`
+	MaxMetadataCacheSizeBytes  int64  `json:"maxMetadataCacheSize,omitempty"`
+	MaxSeekTableCacheSizeBytes int64  `json:"maxSeekTableCacheSize,omitempty"` // 0 == default
+	MaxListCacheDurationSec    int    `json:"maxListCacheDuration,omitempty"`
+	PackSpool                  bool   `json:"packSpool,omitempty"` // persist packs in the cache directory before uploading them
+	IgnoreListCache            bool   `json:"-"`
+	HMACSecret                 []byte `json:"-"`
 }
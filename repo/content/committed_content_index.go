@@ -14,7 +14,7 @@ type committedContentIndex struct {
 	cache committedContentIndexCache
 
 	mu     sync.Mutex
-	inUse  map[blob.ID]packIndex
+	inUse  map[blob.ID]bool
 	merged mergedIndex
 }
 
@@ -22,6 +22,8 @@ type committedContentIndexCache interface {
 	hasIndexBlobID(ctx context.Context, indexBlob blob.ID) (bool, error)
 	addContentToCache(ctx context.Context, indexBlob blob.ID, data []byte) error
 	openIndex(ctx context.Context, indexBlob blob.ID) (packIndex, error)
+	indexBlobVersion(ctx context.Context, indexBlob blob.ID) (byte, error)
+	openMergedIndex(ctx context.Context, indexBlobs []blob.ID) (mergedIndex, error)
 	expireUnused(ctx context.Context, used []blob.ID) error
 }
 
@@ -53,7 +55,7 @@ func (b *committedContentIndex) addContent(ctx context.Context, indexBlobID blob
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.inUse[indexBlobID] != nil {
+	if b.inUse[indexBlobID] {
 		return nil
 	}
 
@@ -62,7 +64,7 @@ func (b *committedContentIndex) addContent(ctx context.Context, indexBlobID blob
 		return errors.Wrapf(err, "unable to open pack index %q", indexBlobID)
 	}
 
-	b.inUse[indexBlobID] = ndx
+	b.inUse[indexBlobID] = true
 	b.merged = append(b.merged, ndx)
 
 	return nil
@@ -82,7 +84,7 @@ func (b *committedContentIndex) packFilesChanged(packFiles []blob.ID) bool {
 	}
 
 	for _, packFile := range packFiles {
-		if b.inUse[packFile] == nil {
+		if !b.inUse[packFile] {
 			return true
 		}
 	}
@@ -103,22 +105,15 @@ func (b *committedContentIndex) use(ctx context.Context, packFiles []blob.ID) (b
 
 	log(ctx).Debugf("set of index files has changed (had %v, now %v)", len(b.inUse), len(packFiles))
 
-	var newMerged mergedIndex
-
-	newInUse := map[blob.ID]packIndex{}
+	newMerged, err := b.cache.openMergedIndex(ctx, packFiles)
+	if err != nil {
+		return false, err
+	}
 
-	defer func() {
-		newMerged.Close() //nolint:errcheck
-	}()
+	newInUse := map[blob.ID]bool{}
 
 	for _, e := range packFiles {
-		ndx, err := b.cache.openIndex(ctx, e)
-		if err != nil {
-			return false, errors.Wrapf(err, "unable to open pack index %q", e)
-		}
-
-		newMerged = append(newMerged, ndx)
-		newInUse[e] = ndx
+		newInUse[e] = true
 	}
 
 	b.merged = newMerged
@@ -128,25 +123,24 @@ func (b *committedContentIndex) use(ctx context.Context, packFiles []blob.ID) (b
 		log(ctx).Warningf("unable to expire unused content index files: %v", err)
 	}
 
-	newMerged = nil // prevent closing newMerged indices
-
 	return true, nil
 }
 
-func newCommittedContentIndex(caching CachingOptions) *committedContentIndex {
+func newCommittedContentIndex(caching CachingOptions, v1PerContentOverhead uint32) *committedContentIndex {
 	var cache committedContentIndexCache
 
 	if caching.CacheDirectory != "" {
 		dirname := filepath.Join(caching.CacheDirectory, "indexes")
-		cache = &diskCommittedContentIndexCache{dirname}
+		cache = &diskCommittedContentIndexCache{dirname, v1PerContentOverhead}
 	} else {
 		cache = &memoryCommittedContentIndexCache{
-			contents: map[blob.ID]packIndex{},
+			contents:             map[blob.ID]packIndex{},
+			v1PerContentOverhead: v1PerContentOverhead,
 		}
 	}
 
 	return &committedContentIndex{
 		cache: cache,
-		inUse: map[blob.ID]packIndex{},
+		inUse: map[blob.ID]bool{},
 	}
 }
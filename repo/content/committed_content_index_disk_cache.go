@@ -20,7 +20,8 @@ const (
 )
 
 type diskCommittedContentIndexCache struct {
-	dirname string
+	dirname              string
+	v1PerContentOverhead uint32
 }
 
 func (c *diskCommittedContentIndexCache) indexBlobPath(indexBlobID blob.ID) string {
@@ -35,7 +36,22 @@ func (c *diskCommittedContentIndexCache) openIndex(ctx context.Context, indexBlo
 		return nil, err
 	}
 
-	return openPackIndex(f)
+	return openPackIndex(f, c.v1PerContentOverhead)
+}
+
+func (c *diskCommittedContentIndexCache) indexBlobVersion(ctx context.Context, indexBlobID blob.ID) (byte, error) {
+	f, err := os.Open(c.indexBlobPath(indexBlobID))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	h, err := readHeader(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to read header of index %v", indexBlobID)
+	}
+
+	return h.version, nil
 }
 
 // mmapOpenWithRetry attempts mmap.Open() with exponential back-off to work around rare issue specific to Windows where
@@ -162,5 +178,5 @@ func (c *diskCommittedContentIndexCache) expireUnused(ctx context.Context, used
 		}
 	}
 
-	return nil
+	return c.expireUnusedMergedIndexes(ctx, used)
 }
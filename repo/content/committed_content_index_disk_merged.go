@@ -0,0 +1,311 @@
+package content
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	mergedIndexPrefix         = "merged-"
+	mergedIndexSuffix         = ".mndx"
+	mergedIndexBlobListSuffix = ".mblobs"
+
+	// maxUnmergedIndexBlobs is the number of index blobs that may be used on top of the
+	// merged index before it is rebuilt.
+	maxUnmergedIndexBlobs = 16
+)
+
+// mergedIndexFile describes a locally-built index file that holds the merged contents
+// of a set of index blobs.
+type mergedIndexFile struct {
+	name       string
+	indexBlobs map[blob.ID]bool
+	modTime    time.Time
+}
+
+// mergedIndexName returns the deterministic name of the merged index for the provided set of index blobs.
+func mergedIndexName(indexBlobs []blob.ID) string {
+	sorted := append([]blob.ID(nil), indexBlobs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	h := sha256.New()
+
+	for _, b := range sorted {
+		h.Write([]byte(b))    //nolint:errcheck
+		h.Write([]byte{'\n'}) //nolint:errcheck
+	}
+
+	return mergedIndexPrefix + hex.EncodeToString(h.Sum(nil)[0:16])
+}
+
+func (c *diskCommittedContentIndexCache) mergedIndexPath(name string) string {
+	return filepath.Join(c.dirname, name+mergedIndexSuffix)
+}
+
+func (c *diskCommittedContentIndexCache) mergedIndexBlobListPath(name string) string {
+	return filepath.Join(c.dirname, name+mergedIndexBlobListSuffix)
+}
+
+// openMergedIndex returns the index that merges the provided index blobs, which must all be present
+// in the cache. When possible, a single locally-built merged index file is used, possibly
+// combined with a small number of index blobs that were added since it was built.
+func (c *diskCommittedContentIndexCache) openMergedIndex(ctx context.Context, indexBlobs []blob.ID) (mergedIndex, error) {
+	name := mergedIndexName(indexBlobs)
+
+	if _, err := os.Stat(c.mergedIndexPath(name)); err == nil {
+		ndx, err := c.openMergedIndexFile(ctx, name)
+		if err == nil {
+			return mergedIndex{ndx}, nil
+		}
+
+		log(ctx).Warningf("unable to open merged index %v: %v", name, err)
+	}
+
+	base, _, err := c.findMergedIndexFiles(indexBlobs)
+	if err != nil {
+		log(ctx).Warningf("unable to list merged indexes: %v", err)
+	}
+
+	var uncovered []blob.ID
+
+	for _, b := range indexBlobs {
+		if base == nil || !base.indexBlobs[b] {
+			uncovered = append(uncovered, b)
+		}
+	}
+
+	if len(uncovered) <= maxUnmergedIndexBlobs {
+		if base == nil {
+			return c.openIndividualIndexes(ctx, uncovered, nil)
+		}
+
+		if ndx, err := c.openMergedIndexFile(ctx, base.name); err == nil {
+			return c.openIndividualIndexes(ctx, uncovered, mergedIndex{ndx})
+		}
+	}
+
+	m, err := c.buildMergedIndex(ctx, name, base, uncovered, indexBlobs)
+	if err != nil {
+		log(ctx).Warningf("unable to build merged index, using %v individual indexes: %v", len(indexBlobs), err)
+		return c.openIndividualIndexes(ctx, indexBlobs, nil)
+	}
+
+	return m, nil
+}
+
+func (c *diskCommittedContentIndexCache) openIndividualIndexes(ctx context.Context, indexBlobs []blob.ID, result mergedIndex) (mergedIndex, error) {
+	for _, b := range indexBlobs {
+		ndx, err := c.openIndex(ctx, b)
+		if err != nil {
+			result.Close() //nolint:errcheck
+			return nil, errors.Wrapf(err, "unable to open pack index %q", b)
+		}
+
+		result = append(result, ndx)
+	}
+
+	return result, nil
+}
+
+func (c *diskCommittedContentIndexCache) openMergedIndexFile(ctx context.Context, name string) (packIndex, error) {
+	f, err := mmapOpenWithRetry(ctx, c.mergedIndexPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	ndx, err := openPackIndex(f, c.v1PerContentOverhead)
+	if err != nil {
+		f.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	return ndx, nil
+}
+
+// buildMergedIndex writes a new merged index file by merging the base merged index (if any)
+// with uncovered index blobs and returns it opened.
+func (c *diskCommittedContentIndexCache) buildMergedIndex(ctx context.Context, name string, base *mergedIndexFile, uncovered, indexBlobs []blob.ID) (mergedIndex, error) {
+	var sources mergedIndex
+
+	if base != nil {
+		ndx, err := c.openMergedIndexFile(ctx, base.name)
+		if err != nil {
+			log(ctx).Debugf("unable to open base merged index %v, rebuilding from scratch: %v", base.name, err)
+
+			uncovered = indexBlobs
+		} else {
+			sources = append(sources, ndx)
+		}
+	}
+
+	sources, err := c.openIndividualIndexes(ctx, uncovered, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	defer sources.Close() //nolint:errcheck
+
+	t0 := time.Now() // allow:no-inject-time
+
+	if err := c.writeMergedIndexFile(name, sources, indexBlobs); err != nil {
+		return nil, err
+	}
+
+	log(ctx).Debugf("built merged index %v from %v index blobs in %v", name, len(indexBlobs), time.Since(t0)) // allow:no-inject-time
+
+	ndx, err := c.openMergedIndexFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergedIndex{ndx}, nil
+}
+
+func (c *diskCommittedContentIndexCache) writeMergedIndexFile(name string, sources mergedIndex, indexBlobs []blob.ID) error {
+	tf, err := ioutil.TempFile(c.dirname, "tmp")
+	if err != nil {
+		return errors.Wrap(err, "can't create tmp file")
+	}
+
+	defer os.Remove(tf.Name()) //nolint:errcheck
+
+	if err := buildMerged(tf, sources); err != nil {
+		tf.Close() //nolint:errcheck
+		return errors.Wrap(err, "unable to write merged index")
+	}
+
+	if err := tf.Close(); err != nil {
+		return errors.Wrap(err, "can't close tmp file")
+	}
+
+	// the list of blobs is written first, so that the presence of the index implies the list is complete.
+	var buf bytes.Buffer
+
+	for _, b := range indexBlobs {
+		buf.WriteString(string(b) + "\n")
+	}
+
+	blobListFile, err := writeTempFileAtomic(c.dirname, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(blobListFile, c.mergedIndexBlobListPath(name)); err != nil {
+		os.Remove(blobListFile) //nolint:errcheck
+		return errors.Wrap(err, "unable to write merged index blob list")
+	}
+
+	if err := os.Rename(tf.Name(), c.mergedIndexPath(name)); err != nil {
+		return errors.Wrap(err, "unable to write merged index")
+	}
+
+	return nil
+}
+
+// findMergedIndexFiles returns the merged index file covering the largest subset of provided index blobs
+// (which can be used as a base for the incremental rebuild) and the list of all other merged index files.
+func (c *diskCommittedContentIndexCache) findMergedIndexFiles(indexBlobs []blob.ID) (best *mergedIndexFile, others []*mergedIndexFile, err error) {
+	entries, err := ioutil.ReadDir(c.dirname)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't list cache")
+	}
+
+	current := map[blob.ID]bool{}
+	for _, b := range indexBlobs {
+		current[b] = true
+	}
+
+	for _, ent := range entries {
+		if !strings.HasPrefix(ent.Name(), mergedIndexPrefix) || !strings.HasSuffix(ent.Name(), mergedIndexSuffix) {
+			continue
+		}
+
+		mf := &mergedIndexFile{
+			name:    strings.TrimSuffix(ent.Name(), mergedIndexSuffix),
+			modTime: ent.ModTime(),
+		}
+
+		mf.indexBlobs, err = c.readMergedIndexBlobList(mf.name)
+		if err == nil && isSubsetOf(mf.indexBlobs, current) && (best == nil || len(mf.indexBlobs) > len(best.indexBlobs)) {
+			if best != nil {
+				others = append(others, best)
+			}
+
+			best = mf
+		} else {
+			others = append(others, mf)
+		}
+	}
+
+	return best, others, nil
+}
+
+func (c *diskCommittedContentIndexCache) readMergedIndexBlobList(name string) (map[blob.ID]bool, error) {
+	f, err := os.Open(c.mergedIndexBlobListPath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	result := map[blob.ID]bool{}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if l := s.Text(); l != "" {
+			result[blob.ID(l)] = true
+		}
+	}
+
+	return result, s.Err()
+}
+
+func isSubsetOf(a, b map[blob.ID]bool) bool {
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// expireUnusedMergedIndexes removes merged indexes that can no longer be used as a base for
+// the provided set of index blobs.
+func (c *diskCommittedContentIndexCache) expireUnusedMergedIndexes(ctx context.Context, used []blob.ID) error {
+	_, others, err := c.findMergedIndexFiles(used)
+	if err != nil {
+		return err
+	}
+
+	current := mergedIndexName(used)
+
+	for _, mf := range others {
+		if mf.name == current || time.Since(mf.modTime) <= unusedCommittedContentIndexCleanupTime { // allow:no-inject-time
+			continue
+		}
+
+		log(ctx).Debugf("removing unused merged index %v %v", mf.name, mf.modTime)
+
+		if err := os.Remove(c.mergedIndexPath(mf.name)); err != nil {
+			log(ctx).Warningf("unable to remove unused merged index: %v", err)
+			continue
+		}
+
+		os.Remove(c.mergedIndexBlobListPath(mf.name)) //nolint:errcheck
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// addTestIndexBlobs adds index blobs [first,last) to the cache, each with an overlapping
+// set of contents with different timestamps and deletion markers.
+func addTestIndexBlobs(ctx context.Context, t *testing.T, c committedContentIndexCache, first, last int) []blob.ID {
+	t.Helper()
+
+	var result []blob.ID
+
+	for n := first; n < last; n++ {
+		b := packIndexBuilder{}
+
+		for i := 0; i < 50; i++ {
+			id := n*20 + i
+
+			b.Add(Info{
+				ID:               deterministicContentID("merged", id),
+				TimestampSeconds: int64(n*10 + i%3),
+				Deleted:          (n+i)%7 == 0,
+				PackBlobID:       deterministicPackBlobID(n),
+				PackOffset:       deterministicPackedOffset(id),
+				Length:           deterministicPackedLength(id),
+				OriginalLength:   deterministicPackedLength(id + 1),
+			})
+		}
+
+		var buf bytes.Buffer
+		if err := b.Build(&buf, indexVersion2); err != nil {
+			t.Fatalf("unable to build index: %v", err)
+		}
+
+		indexBlobID := blob.ID(fmt.Sprintf("n%05v", n))
+		if err := c.addContentToCache(ctx, indexBlobID, buf.Bytes()); err != nil {
+			t.Fatalf("unable to add index blob: %v", err)
+		}
+
+		result = append(result, indexBlobID)
+	}
+
+	return result
+}
+
+func allIndexContents(t *testing.T, m mergedIndex) []Info {
+	t.Helper()
+
+	var result []Info
+
+	if err := m.Iterate("", func(i Info) error {
+		result = append(result, i)
+		return nil
+	}); err != nil {
+		t.Fatalf("iterate error: %v", err)
+	}
+
+	return result
+}
+
+func mergedIndexFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, mergedIndexPrefix+"*"+mergedIndexSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return matches
+}
+
+func verifyMergedIndex(ctx context.Context, t *testing.T, c *diskCommittedContentIndexCache, indexBlobs []blob.ID, wantIndexes int) {
+	t.Helper()
+
+	m, err := c.openMergedIndex(ctx, indexBlobs)
+	if err != nil {
+		t.Fatalf("unable to open merged index: %v", err)
+	}
+	defer m.Close() //nolint:errcheck
+
+	if got := len(m); got != wantIndexes {
+		t.Errorf("unexpected number of indexes used: %v, want %v", got, wantIndexes)
+	}
+
+	individual, err := c.openIndividualIndexes(ctx, indexBlobs, nil)
+	if err != nil {
+		t.Fatalf("unable to open individual indexes: %v", err)
+	}
+	defer individual.Close() //nolint:errcheck
+
+	want := allIndexContents(t, individual)
+	if got := allIndexContents(t, m); !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged index contents differ from individual indexes (%v vs %v entries)", len(got), len(want))
+	}
+
+	for _, w := range want {
+		got, err := m.GetInfo(w.ID)
+		if err != nil || got == nil {
+			t.Fatalf("unable to get %v: %v", w.ID, err)
+		}
+
+		if !reflect.DeepEqual(*got, w) {
+			t.Errorf("invalid info for %v: %v, want %v", w.ID, *got, w)
+		}
+	}
+}
+
+func TestDiskCommittedContentIndexMerged(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	dir, err := ioutil.TempDir("", "merged-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &diskCommittedContentIndexCache{dirname: dir}
+
+	// small number of index blobs is used directly.
+	blobs := addTestIndexBlobs(ctx, t, c, 0, maxUnmergedIndexBlobs)
+	verifyMergedIndex(ctx, t, c, blobs, maxUnmergedIndexBlobs)
+
+	if got := mergedIndexFiles(t, dir); len(got) != 0 {
+		t.Errorf("unexpected merged indexes: %v", got)
+	}
+
+	// once there are more, they get merged into a single index.
+	blobs = append(blobs, addTestIndexBlobs(ctx, t, c, maxUnmergedIndexBlobs, 40)...)
+	verifyMergedIndex(ctx, t, c, blobs, 1)
+
+	// new index blobs are used on top of the merged index.
+	blobs = append(blobs, addTestIndexBlobs(ctx, t, c, 40, 43)...)
+	verifyMergedIndex(ctx, t, c, blobs, 4)
+
+	// until there are too many of them, at which point the merged index is rebuilt incrementally.
+	blobs = append(blobs, addTestIndexBlobs(ctx, t, c, 43, 70)...)
+	verifyMergedIndex(ctx, t, c, blobs, 1)
+
+	if got := mergedIndexFiles(t, dir); len(got) != 2 {
+		t.Errorf("unexpected merged indexes: %v", got)
+	}
+
+	// removal of index blobs (e.g. after compaction) forces full rebuild.
+	blobs = blobs[1:]
+	verifyMergedIndex(ctx, t, c, blobs, 1)
+
+	if got := mergedIndexFiles(t, dir); len(got) != 3 {
+		t.Errorf("unexpected merged indexes: %v", got)
+	}
+
+	// reopening the same set uses the existing merged index.
+	verifyMergedIndex(ctx, t, c, blobs, 1)
+
+	if got := mergedIndexFiles(t, dir); len(got) != 3 {
+		t.Errorf("unexpected merged indexes: %v", got)
+	}
+}
+
+const (
+	benchmarkContentCount   = 10000000
+	benchmarkIndexBlobCount = 1000
+)
+
+// prepareBenchmarkIndexCache populates the index cache with benchmarkContentCount contents spread
+// over benchmarkIndexBlobCount index blobs and returns the cache, the index blobs and some content IDs to look up.
+func prepareBenchmarkIndexCache(b *testing.B) (c *diskCommittedContentIndexCache, indexBlobs []blob.ID, lookupIDs []ID, cleanup func()) {
+	ctx := testlogging.Context(b)
+
+	contentCount := benchmarkContentCount
+	if testing.Short() {
+		contentCount /= 100
+	}
+
+	dir, err := ioutil.TempDir("", "merged-index-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c = &diskCommittedContentIndexCache{dirname: dir}
+
+	for n := 0; n < benchmarkIndexBlobCount; n++ {
+		bld := packIndexBuilder{}
+
+		for i := n; i < contentCount; i += benchmarkIndexBlobCount {
+			id := deterministicContentID("bench", i)
+
+			bld.Add(Info{
+				ID:               id,
+				TimestampSeconds: int64(i),
+				PackBlobID:       deterministicPackBlobID(i / 1000),
+				PackOffset:       uint32(i),
+				Length:           1000,
+				OriginalLength:   1000,
+			})
+
+			if i%(contentCount/1000) == 0 {
+				lookupIDs = append(lookupIDs, id)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := bld.Build(&buf, indexVersion2); err != nil {
+			b.Fatalf("unable to build index: %v", err)
+		}
+
+		indexBlobID := blob.ID(fmt.Sprintf("n%05v", n))
+		if err := c.addContentToCache(ctx, indexBlobID, buf.Bytes()); err != nil {
+			b.Fatalf("unable to add index blob: %v", err)
+		}
+
+		indexBlobs = append(indexBlobs, indexBlobID)
+	}
+
+	// build merged index.
+	m, err := c.openMergedIndex(ctx, indexBlobs)
+	if err != nil {
+		b.Fatalf("unable to build merged index: %v", err)
+	}
+
+	m.Close() //nolint:errcheck
+
+	return c, indexBlobs, lookupIDs, func() { os.RemoveAll(dir) } //nolint:errcheck
+}
+
+func BenchmarkCommittedContentIndexOpen(b *testing.B) {
+	ctx := testlogging.Context(b)
+	c, indexBlobs, _, cleanup := prepareBenchmarkIndexCache(b)
+
+	defer cleanup()
+
+	b.Run("Merged", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m, err := c.openMergedIndex(ctx, indexBlobs)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			m.Close() //nolint:errcheck
+		}
+	})
+
+	b.Run("Individual", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m, err := c.openIndividualIndexes(ctx, indexBlobs, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			m.Close() //nolint:errcheck
+		}
+	})
+}
+
+func BenchmarkCommittedContentIndexGetContent(b *testing.B) {
+	ctx := testlogging.Context(b)
+	c, indexBlobs, lookupIDs, cleanup := prepareBenchmarkIndexCache(b)
+
+	defer cleanup()
+
+	benchmarkGetContent := func(b *testing.B, m mergedIndex) {
+		defer m.Close() //nolint:errcheck
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if info, err := m.GetInfo(lookupIDs[i%len(lookupIDs)]); err != nil || info == nil {
+				b.Fatalf("unable to find content: %v", err)
+			}
+		}
+	}
+
+	b.Run("Merged", func(b *testing.B) {
+		m, err := c.openMergedIndex(ctx, indexBlobs)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		benchmarkGetContent(b, m)
+	})
+
+	b.Run("Individual", func(b *testing.B) {
+		m, err := c.openIndividualIndexes(ctx, indexBlobs, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		benchmarkGetContent(b, m)
+	})
+}
@@ -13,6 +13,8 @@ import (
 type memoryCommittedContentIndexCache struct {
 	mu       sync.Mutex
 	contents map[blob.ID]packIndex
+
+	v1PerContentOverhead uint32
 }
 
 func (m *memoryCommittedContentIndexCache) hasIndexBlobID(ctx context.Context, indexBlobID blob.ID) (bool, error) {
@@ -26,7 +28,7 @@ func (m *memoryCommittedContentIndexCache) addContentToCache(ctx context.Context
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	ndx, err := openPackIndex(bytes.NewReader(data))
+	ndx, err := openPackIndex(bytes.NewReader(data), m.v1PerContentOverhead)
 	if err != nil {
 		return err
 	}
@@ -48,6 +50,30 @@ func (m *memoryCommittedContentIndexCache) openIndex(ctx context.Context, indexB
 	return v, nil
 }
 
+func (m *memoryCommittedContentIndexCache) indexBlobVersion(ctx context.Context, indexBlobID blob.ID) (byte, error) {
+	ndx, err := m.openIndex(ctx, indexBlobID)
+	if err != nil {
+		return 0, err
+	}
+
+	return ndx.(*index).Version(), nil
+}
+
+func (m *memoryCommittedContentIndexCache) openMergedIndex(ctx context.Context, indexBlobs []blob.ID) (mergedIndex, error) {
+	var result mergedIndex
+
+	for _, b := range indexBlobs {
+		ndx, err := m.openIndex(ctx, b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to open pack index %q", b)
+		}
+
+		result = append(result, ndx)
+	}
+
+	return result, nil
+}
+
 func (m *memoryCommittedContentIndexCache) expireUnused(ctx context.Context, used []blob.ID) error {
 	return nil
 }
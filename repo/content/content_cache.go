@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -59,6 +60,7 @@ func (c *contentCache) getContent(ctx context.Context, cacheKey cacheKey, blobID
 	useCache := shouldUseContentCache(ctx) && c.cacheStorage != nil
 	if useCache {
 		if b := c.readAndVerifyCacheContent(ctx, cacheKey); b != nil {
+			atomic.AddInt64(&cacheHits, 1)
 			stats.Record(ctx,
 				metricContentCacheHitCount.M(1),
 				metricContentCacheHitBytes.M(int64(len(b))),
@@ -68,6 +70,10 @@ func (c *contentCache) getContent(ctx context.Context, cacheKey cacheKey, blobID
 		}
 	}
 
+	if useCache {
+		atomic.AddInt64(&cacheMisses, 1)
+	}
+
 	stats.Record(ctx, metricContentCacheMissCount.M(1))
 
 	b, err := c.st.GetBlob(ctx, blobID, offset, length)
@@ -123,6 +129,17 @@ func (c *contentCache) readAndVerifyCacheContent(ctx context.Context, cacheKey c
 	return nil
 }
 
+// removeContent removes the cached copy of the provided content, which is needed when the underlying blob is rewritten.
+func (c *contentCache) removeContent(ctx context.Context, cacheKey cacheKey) {
+	if c.cacheStorage == nil {
+		return
+	}
+
+	if err := c.cacheStorage.DeleteBlob(ctx, blob.ID(adjustCacheKey(cacheKey))); err != nil && err != blob.ErrBlobNotFound {
+		log(ctx).Warningf("unable to remove %v from cache: %v", cacheKey, err)
+	}
+}
+
 func (c *contentCache) close() {
 	close(c.closed)
 	c.asyncWG.Wait()
@@ -226,6 +243,8 @@ func newContentCache(ctx context.Context, st blob.Storage, caching CachingOption
 		cacheStorage, err = filesystem.New(ctxutil.Detach(ctx), &filesystem.Options{
 			Path:            contentCacheDir,
 			DirectoryShards: []int{2},
+			// cache contents can always be re-fetched, durability is not worth the cost of syncing.
+			DoNotSync: true,
 		})
 		if err != nil {
 			return nil, err
@@ -1,10 +1,17 @@
 package content
 
 import (
+	"sync/atomic"
+
+	"go.opencensus.io/metric"
+	"go.opencensus.io/metric/metricproducer"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 )
 
+// cacheHits and cacheMisses are used to compute the cache hit ratio, accessed atomically.
+var cacheHits, cacheMisses int64
+
 // content cache metrics
 var (
 	metricContentCacheHitCount = stats.Int64(
@@ -55,4 +62,33 @@ func init() {
 	); err != nil {
 		panic("unable to register opencensus views: " + err.Error())
 	}
+
+	registry := metric.NewRegistry()
+
+	hitRatio, err := registry.AddFloat64DerivedGauge(
+		"kopia/content/cache/hit_ratio",
+		metric.WithDescription("Fraction of content reads served from the cache"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		panic("unable to register opencensus metrics: " + err.Error())
+	}
+
+	if err := hitRatio.UpsertEntry(cacheHitRatio); err != nil {
+		panic("unable to register opencensus metrics: " + err.Error())
+	}
+
+	metricproducer.GlobalManager().AddProducer(registry)
+}
+
+// cacheHitRatio returns the fraction of cache lookups that were hits since the process started.
+func cacheHitRatio() float64 {
+	hits := atomic.LoadInt64(&cacheHits)
+	total := hits + atomic.LoadInt64(&cacheMisses)
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(hits) / float64(total)
 }
@@ -1,13 +1,63 @@
 package content
 
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/epoch"
+	"github.com/kopia/kopia/repo/compression"
+)
+
 // FormattingOptions describes the rules for formatting contents in repository.
 type FormattingOptions struct {
-	Version     int    `json:"version,omitempty"`     // version number, must be "1"
+	Version     int    `json:"version,omitempty"`     // format version number, see FormatVersion1..FormatVersion3
 	Hash        string `json:"hash,omitempty"`        // identifier of the hash algorithm used
 	Encryption  string `json:"encryption,omitempty"`  // identifier of the encryption algorithm used
 	HMACSecret  []byte `json:"secret,omitempty"`      // HMAC secret used to generate encryption keys
 	MasterKey   []byte `json:"masterKey,omitempty"`   // master encryption key (SIV-mode encryption only)
 	MaxPackSize int    `json:"maxPackSize,omitempty"` // maximum size of a pack object
+
+	// MetadataCompression is the compressor applied by the content manager to metadata contents
+	// (those with a prefix, such as directory listings and manifests). Data contents are compressed
+	// by the object manager according to compression policy.
+	MetadataCompression compression.Name `json:"metadataCompression,omitempty"`
+
+	// EpochParameters controls epoch-based management of index blobs, which doesn't rely on
+	// list-after-write consistency of the storage. Nil if index epochs are not enabled.
+	EpochParameters *epoch.Parameters `json:"epochParameters,omitempty"`
+
+	// EncryptionKeyID identifies MasterKey in the index entries of contents encrypted with it.
+	// It changes each time the master key is rotated.
+	EncryptionKeyID byte `json:"encryptionKeyID,omitempty"`
+
+	// PreviousMasterKeys are master keys that have been rotated out. They are no longer used to encrypt
+	// new contents, but are needed to decrypt contents written before key rotation, until retired.
+	PreviousMasterKeys []PreviousMasterKey `json:"previousMasterKeys,omitempty"`
+}
+
+// PreviousMasterKey is a master key that has been replaced with a new one, along with its key ID.
+type PreviousMasterKey struct {
+	KeyID     byte      `json:"keyID"`
+	MasterKey []byte    `json:"masterKey"`
+	RotatedAt time.Time `json:"rotatedAt"` // time when the key was replaced
+}
+
+// ValidateFormatVersion ensures that all features enabled in the options are supported by the format version.
+func (f *FormattingOptions) ValidateFormatVersion() error {
+	if f.MetadataCompression != "" && f.Version < FormatVersion2 {
+		return errors.Errorf("metadata compression requires format version %v", FormatVersion2)
+	}
+
+	if f.EpochParameters.IsEnabled() && f.Version < FormatVersion2 {
+		return errors.Errorf("index epochs require format version %v", FormatVersion2)
+	}
+
+	if (f.EncryptionKeyID != 0 || len(f.PreviousMasterKeys) > 0) && f.Version < FormatVersion3 {
+		return errors.Errorf("encryption key rotation requires format version %v", FormatVersion3)
+	}
+
+	return nil
 }
 
 // GetEncryptionAlgorithm implements encryption.Parameters
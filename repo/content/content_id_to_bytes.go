@@ -42,6 +42,11 @@ func contentIDToBytes(output []byte, c ID) []byte {
 
 	var hashBuf [maxHashSize]byte
 
+	if hex.DecodedLen(len(c)-skip) > len(hashBuf) {
+		// IDs read from corrupted indexes may be too long to decode.
+		return append([]byte{unpackedContentIDPrefix}, []byte(c)...)
+	}
+
 	n, err := hex.Decode(hashBuf[:], []byte(c[skip:]))
 	if err != nil {
 		// rare case
@@ -19,7 +19,7 @@ func (bm *Manager) RecoverIndexFromPackBlob(ctx context.Context, packFile blob.I
 		return nil, err
 	}
 
-	ndx, err := openPackIndex(bytes.NewReader(localIndexBytes))
+	ndx, err := openPackIndex(bytes.NewReader(localIndexBytes), bm.perContentOverhead())
 	if err != nil {
 		return nil, errors.Errorf("unable to open index in file %v", packFile)
 	}
@@ -158,7 +158,7 @@ func decodePostamble(payload []byte) *packContentPostamble {
 
 func (bm *lockFreeManager) buildLocalIndex(pending packIndexBuilder) ([]byte, error) {
 	var buf bytes.Buffer
-	if err := pending.Build(&buf); err != nil {
+	if err := pending.Build(&buf, bm.indexVersion()); err != nil {
 		return nil, errors.Wrap(err, "unable to build local index")
 	}
 
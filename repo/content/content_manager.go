@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -16,7 +17,9 @@ import (
 	"go.opencensus.io/stats"
 
 	"github.com/kopia/kopia/internal/buf"
+	"github.com/kopia/kopia/internal/epoch"
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/logging"
 )
 
@@ -48,15 +51,35 @@ const (
 	defaultMaxPreambleLength = 32
 	defaultPaddingUnit       = 4096
 
-	currentWriteVersion = 1
+	currentWriteVersion = FormatVersion3
 
-	minSupportedWriteVersion = 1
+	minSupportedWriteVersion = FormatVersion1
 	maxSupportedWriteVersion = currentWriteVersion
 
-	minSupportedReadVersion = 1
+	minSupportedReadVersion = FormatVersion1
 	maxSupportedReadVersion = currentWriteVersion
 
 	indexLoadAttempts = 10
+
+	spoolRetryInterval = 1 * time.Minute // minimum time between attempts to upload spooled packs
+)
+
+// Repository format versions. Clients refuse to open repositories in a format version they don't support,
+// so features that older clients would mishandle are only enabled in newer format versions.
+const (
+	// FormatVersion1 is the original repository format.
+	FormatVersion1 = 1
+
+	// FormatVersion2 adds v2 index blobs, which store original length, compression and encryption key of each content,
+	// metadata compression and epoch-based management of index blobs.
+	FormatVersion2 = 2
+
+	// FormatVersion3 adds rotation of master encryption keys and binary seek tables of large objects. Older clients
+	// would ignore the key ID of new contents and previous master keys, and can't read binary seek tables.
+	FormatVersion3 = 3
+
+	// DefaultFormatVersion is the format version of newly created repositories.
+	DefaultFormatVersion = currentWriteVersion
 )
 
 // ErrContentNotFound is returned when content is not found.
@@ -80,6 +103,16 @@ type Manager struct {
 	failedPacks      []*pendingPackInfo // list of packs that failed to write, will be retried
 	packIndexBuilder packIndexBuilder   // contents that are in index currently being built (all packs saved but not committed)
 
+	uncommittedSpooledPacks []blob.ID // spooled packs that have been uploaded but are not in any index blob yet
+	nextSpoolRetryTime      time.Time // time of the next attempt to upload spooled packs
+
+	uploadQueue            chan packUploadRequest // packs to be uploaded by background workers, nil when uploading synchronously
+	uploadWorkers          sync.WaitGroup
+	stoppingUploadWorkers  bool  // set when upload workers are being stopped, no more packs can be queued
+	sendingUploadRequests  int   // number of goroutines sending to uploadQueue without holding the lock
+	inFlightUploadBytes    int64 // total size of packs queued or being uploaded by background workers
+	maxInFlightUploadBytes int64
+
 	disableIndexFlushCount int
 	flushPackIndexesAfter  time.Time // time when those indexes should be flushed
 	closed                 chan struct{}
@@ -94,6 +127,7 @@ type pendingPackInfo struct {
 	currentPackItems map[ID]Info   // contents that are in the pack content currently being built (all inline)
 	currentPackData  *bytes.Buffer // total length of all items in the current pack content
 	finalized        bool          // indicates whether currentPackData has local index appended to it
+	spooled          bool          // indicates whether the pack has been persisted in the local spool
 }
 
 // DeleteContent marks the given contentID as deleted.
@@ -127,6 +161,13 @@ func (bm *Manager) DeleteContent(ctx context.Context, contentID ID) error {
 		return bm.deletePreexistingContent(*bi)
 	}
 
+	// contents of spooled packs that have not been uploaded yet will be committed to index when uploaded
+	if bm.spool != nil {
+		if bi, ok := bm.spool.getNotUploadedContentInfo(contentID); ok {
+			return bm.deletePreexistingContent(bi)
+		}
+	}
+
 	// see if the block existed before
 	bi, err := bm.committedContents.getContent(contentID)
 	if err != nil {
@@ -155,9 +196,22 @@ func (bm *Manager) deletePreexistingContent(ci Info) error {
 	return nil
 }
 
-func (bm *Manager) addToPackUnlocked(ctx context.Context, contentID ID, data []byte, isDeleted bool) error {
+// addToPackUnlocked adds the provided content to a pending pack. The index entry gets a timestamp of at least
+// minTimestampSeconds, which ensures that it supersedes the entry of a content being rewritten.
+// Metadata compression is applied only if allowCompression is true.
+func (bm *Manager) addToPackUnlocked(ctx context.Context, contentID ID, data []byte, allowCompression, isDeleted bool, minTimestampSeconds int64) error {
 	prefix := packPrefixForContentID(contentID)
 
+	payload, compressionHeaderID := data, compression.HeaderID(0)
+
+	if allowCompression {
+		var err error
+
+		if payload, compressionHeaderID, err = bm.maybeCompressContentData(contentID, data); err != nil {
+			return errors.Wrapf(err, "unable to compress %q", contentID)
+		}
+	}
+
 	bm.lock()
 
 	// do not start new uploads while flushing
@@ -168,19 +222,19 @@ func (bm *Manager) addToPackUnlocked(ctx context.Context, contentID ID, data []b
 
 	// see if we have any packs that have failed previously
 	// retry writing them now.
-	//
-	// we're making a copy of bm.failedPacks since bm.writePackAndAddToIndex()
-	// will remove from it on success.
-	fp := append([]*pendingPackInfo(nil), bm.failedPacks...)
-	for _, pp := range fp {
-		if err := bm.writePackAndAddToIndex(ctx, pp, true); err != nil {
-			bm.unlock()
-			return errors.Wrap(err, "error writing previously failed pack")
+	if err := bm.retryFailedPacksLocked(ctx); err != nil {
+		bm.unlock()
+		return err
+	}
+
+	if bm.spool != nil && bm.timeNow().After(bm.nextSpoolRetryTime) {
+		if err := bm.uploadSpooledPacksLocked(ctx); err != nil {
+			log(ctx).Warningf("unable to upload spooled packs, will retry later: %v", err)
 		}
 	}
 
 	if bm.timeNow().After(bm.flushPackIndexesAfter) {
-		if err := bm.flushPackIndexesLocked(ctx); err != nil {
+		if _, err := bm.flushPackIndexesOrKeepSpooledLocked(ctx); err != nil {
 			bm.unlock()
 			return err
 		}
@@ -191,16 +245,24 @@ func (bm *Manager) addToPackUnlocked(ctx context.Context, contentID ID, data []b
 		return errors.Wrap(err, "unable to create pending pack")
 	}
 
-	info := Info{
-		Deleted:          isDeleted,
-		ID:               contentID,
-		PackBlobID:       pp.packBlobID,
-		PackOffset:       uint32(pp.currentPackData.Len()),
-		TimestampSeconds: bm.timeNow().Unix(),
-		FormatVersion:    byte(bm.writeFormatVersion),
+	ts := bm.timeNow().Unix()
+	if ts < minTimestampSeconds {
+		ts = minTimestampSeconds
 	}
 
-	if err := bm.maybeEncryptContentDataForPacking(pp.currentPackData, data, contentID); err != nil {
+	info := Info{
+		Deleted:             isDeleted,
+		ID:                  contentID,
+		PackBlobID:          pp.packBlobID,
+		PackOffset:          uint32(pp.currentPackData.Len()),
+		TimestampSeconds:    ts,
+		FormatVersion:       byte(bm.writeFormatVersion),
+		OriginalLength:      uint32(len(data)),
+		CompressionHeaderID: compressionHeaderID,
+		EncryptionKeyID:     bm.Format.EncryptionKeyID,
+	}
+
+	if err := bm.maybeEncryptContentDataForPacking(pp.currentPackData, payload, contentID); err != nil {
 		return errors.Wrapf(err, "unable to encrypt %q", contentID)
 	}
 
@@ -218,12 +280,19 @@ func (bm *Manager) addToPackUnlocked(ctx context.Context, contentID ID, data []b
 
 	bm.unlock()
 
+	if !shouldWrite {
+		return nil
+	}
+
+	// upload workers will encrypt and save to storage in the background.
+	if bm.enqueuePackUpload(ctx, pp) {
+		return nil
+	}
+
 	// at this point we're unlocked so different goroutines can encrypt and
 	// save to storage in parallel.
-	if shouldWrite {
-		if err := bm.writePackAndAddToIndex(ctx, pp, false); err != nil {
-			return errors.Wrap(err, "unable to write pack")
-		}
+	if err := bm.writePackAndAddToIndex(ctx, pp, false); err != nil {
+		return errors.Wrap(err, "unable to write pack")
 	}
 
 	return nil
@@ -301,7 +370,7 @@ func (bm *Manager) flushPackIndexesLocked(ctx context.Context) error {
 	if len(bm.packIndexBuilder) > 0 {
 		var b bytes.Buffer
 
-		if err := bm.packIndexBuilder.Build(&b); err != nil {
+		if err := bm.packIndexBuilder.Build(&b, bm.indexVersion()); err != nil {
 			return errors.Wrap(err, "unable to build pack index")
 		}
 
@@ -318,6 +387,8 @@ func (bm *Manager) flushPackIndexesLocked(ctx context.Context) error {
 		}
 
 		bm.packIndexBuilder = make(packIndexBuilder)
+
+		bm.removeCommittedSpoolEntriesLocked(ctx)
 	}
 
 	bm.flushPackIndexesAfter = bm.timeNow().Add(flushPackIndexTimeout)
@@ -360,6 +431,19 @@ func (bm *Manager) writePackAndAddToIndex(ctx context.Context, pp *pendingPackIn
 			bm.packIndexBuilder.Add(*info)
 		}
 
+		if pp.spooled {
+			bm.uncommittedSpooledPacks = append(bm.uncommittedSpooledPacks, pp.packBlobID)
+		}
+
+		return nil
+	}
+
+	if pp.spooled {
+		// the pack is safely persisted in the spool, from which it will be uploaded later.
+		log(ctx).Warningf("unable to upload pack %v, keeping it in the local spool: %v", pp.packBlobID, err)
+		bm.spool.markNotUploaded(pp.packBlobID, packFileIndex)
+		bm.nextSpoolRetryTime = bm.timeNow().Add(spoolRetryInterval)
+
 		return nil
 	}
 
@@ -376,8 +460,17 @@ func (bm *Manager) prepareAndWritePackInternal(ctx context.Context, pp *pendingP
 	}
 
 	if pp.currentPackData.Len() > 0 {
+		if bm.spool != nil && !pp.spooled {
+			if err := bm.spool.add(pp.packBlobID, pp.currentPackData.Bytes(), packFileIndex); err != nil {
+				return nil, errors.Wrap(err, "unable to spool pack")
+			}
+
+			pp.spooled = true
+		}
+
 		if err := bm.writePackFileNotLocked(ctx, pp.packBlobID, pp.currentPackData.Bytes()); err != nil {
-			return nil, errors.Wrap(err, "can't save pack data content")
+			// return the index of the pack, which allows spooled packs to be uploaded later.
+			return packFileIndex, errors.Wrap(err, "can't save pack data content")
 		}
 
 		formatLog(ctx).Debugf("wrote pack file: %v (%v bytes)", pp.packBlobID, pp.currentPackData.Len())
@@ -400,12 +493,25 @@ func removePendingPack(slice []*pendingPackInfo, pp *pendingPackInfo) []*pending
 
 // Close closes the content manager.
 func (bm *Manager) Close(ctx context.Context) error {
+	// upload workers must be stopped even if flushing fails.
+	defer bm.stopPackUploadWorkers()
+
 	if err := bm.Flush(ctx); err != nil {
-		return errors.Wrap(err, "error flushing")
+		if !IsPacksSpooled(err) {
+			return errors.Wrap(err, "error flushing")
+		}
+
+		log(ctx).Warningf("some packs have not been uploaded, they will be uploaded from the local spool when the repository is opened again")
 	}
 
+	bm.stopPackUploadWorkers()
 	bm.contentCache.close()
 	bm.metadataCache.close()
+
+	if bm.spool != nil {
+		bm.spool.unlock()
+	}
+
 	close(bm.closed)
 	bm.encryptionBufferPool.Close()
 
@@ -414,7 +520,9 @@ func (bm *Manager) Close(ctx context.Context) error {
 
 // Flush completes writing any pending packs and writes pack indexes to the underlying storage.
 // Any pending writes completed before Flush() has started are guaranteed to be committed to the
-// repository before Flush() returns.
+// repository before Flush() returns, except when the pack spool is enabled, in which case packs
+// that could not be uploaded or indexed are kept in the local spool and committed once they are uploaded.
+// Flush returns ErrPacksSpooled in such case, which callers detect using IsPacksSpooled.
 func (bm *Manager) Flush(ctx context.Context) error {
 	bm.lock()
 	defer bm.unlock()
@@ -435,19 +543,36 @@ func (bm *Manager) Flush(ctx context.Context) error {
 		bm.cond.Wait()
 	}
 
+	// packs uploaded in the background may have failed
+	if err := bm.retryFailedPacksLocked(ctx); err != nil {
+		return err
+	}
+
 	// finish all new pending packs
 	if err := bm.finishAllPacksLocked(ctx); err != nil {
 		return errors.Wrap(err, "error writing pending content")
 	}
 
-	if err := bm.flushPackIndexesLocked(ctx); err != nil {
+	if bm.spool != nil {
+		if err := bm.uploadSpooledPacksLocked(ctx); err != nil {
+			log(ctx).Warningf("unable to upload spooled packs, will retry later: %v", err)
+		}
+	}
+
+	indexPending, err := bm.flushPackIndexesOrKeepSpooledLocked(ctx)
+	if err != nil {
 		return errors.Wrap(err, "error flushing indexes")
 	}
 
+	if indexPending || (bm.spool != nil && bm.spool.hasNotUploaded()) {
+		return ErrPacksSpooled
+	}
+
 	return nil
 }
 
 // RewriteContent causes reads and re-writes a given content using the most recent format.
+// Contents stored uncompressed are not compressed, since they may have been compressed by the writer.
 func (bm *Manager) RewriteContent(ctx context.Context, contentID ID) error {
 	log(ctx).Debugf("RewriteContent(%q)", contentID)
 
@@ -461,7 +586,7 @@ func (bm *Manager) RewriteContent(ctx context.Context, contentID ID) error {
 		return err
 	}
 
-	return bm.addToPackUnlocked(ctx, contentID, data, bi.Deleted)
+	return bm.addToPackUnlocked(ctx, contentID, data, bi.CompressionHeaderID != 0, bi.Deleted, bi.TimestampSeconds+1)
 }
 
 func packPrefixForContentID(contentID ID) blob.ID {
@@ -502,6 +627,16 @@ func (bm *Manager) getOrCreatePendingPackInfoLocked(prefix blob.ID) (*pendingPac
 // WriteContent saves a given content of data to a pack group with a provided name and returns a contentID
 // that's based on the contents of data written.
 func (bm *Manager) WriteContent(ctx context.Context, data []byte, prefix ID) (ID, error) {
+	return bm.writeContent(ctx, data, prefix, true)
+}
+
+// WriteCompressedContent is like WriteContent, but the data has already been compressed by the caller,
+// so it's stored without metadata compression.
+func (bm *Manager) WriteCompressedContent(ctx context.Context, data []byte, prefix ID) (ID, error) {
+	return bm.writeContent(ctx, data, prefix, false)
+}
+
+func (bm *Manager) writeContent(ctx context.Context, data []byte, prefix ID, allowCompression bool) (ID, error) {
 	stats.Record(ctx, metricContentWriteContentCount.M(1))
 	stats.Record(ctx, metricContentWriteContentBytes.M(int64(len(data))))
 
@@ -520,7 +655,7 @@ func (bm *Manager) WriteContent(ctx context.Context, data []byte, prefix ID) (ID
 		}
 	}
 
-	err := bm.addToPackUnlocked(ctx, contentID, data, false)
+	err := bm.addToPackUnlocked(ctx, contentID, data, allowCompression, false, 0)
 
 	return contentID, err
 }
@@ -575,6 +710,13 @@ func (bm *Manager) getOverlayContentInfo(contentID ID) (*pendingPackInfo, Info,
 		return nil, *ci, true
 	}
 
+	// added contents in packs that are in the local spool but have not been uploaded yet
+	if bm.spool != nil {
+		if ci, ok := bm.spool.getNotUploadedContentInfo(contentID); ok {
+			return nil, ci, true
+		}
+	}
+
 	return nil, Info{}, false
 }
 
@@ -630,6 +772,9 @@ func (bm *Manager) Refresh(ctx context.Context) (bool, error) {
 type ManagerOptions struct {
 	RepositoryFormatBytes []byte
 	TimeNow               func() time.Time // Time provider
+
+	PackUploadParallelism      int   // number of goroutines uploading packs in the background, 0 == upload synchronously
+	MaxInFlightPackUploadBytes int64 // maximum total size of packs queued for background upload, 0 == default
 }
 
 // NewManager creates new content manager with given packing options and a formatter.
@@ -639,7 +784,14 @@ func NewManager(ctx context.Context, st blob.Storage, f *FormattingOptions, cach
 		nowFn = time.Now // allow:no-inject-time
 	}
 
-	return newManagerWithOptions(ctx, st, f, caching, nowFn, options.RepositoryFormatBytes)
+	m, err := newManagerWithOptions(ctx, st, f, caching, nowFn, options.RepositoryFormatBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	m.startPackUploadWorkers(options.PackUploadParallelism, options.MaxInFlightPackUploadBytes)
+
+	return m, nil
 }
 
 func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOptions, caching CachingOptions, timeNow func() time.Time, repositoryFormatBytes []byte) (*Manager, error) {
@@ -651,11 +803,28 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 		return nil, errors.Errorf("can't handle repositories created using version %v (min supported %v, max supported %v)", f.Version, minSupportedWriteVersion, maxSupportedWriteVersion)
 	}
 
+	if err := f.ValidateFormatVersion(); err != nil {
+		return nil, err
+	}
+
 	hasher, encryptor, err := CreateHashAndEncryptor(f)
 	if err != nil {
 		return nil, err
 	}
 
+	previousEncryptors, err := createPreviousEncryptors(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadataCompressor compression.Compressor
+
+	if f.MetadataCompression != "" {
+		if metadataCompressor = compression.ByName[f.MetadataCompression]; metadataCompressor == nil {
+			return nil, errors.Errorf("unsupported metadata compression: %v", f.MetadataCompression)
+		}
+	}
+
 	contentCache, err := newContentCache(ctx, st, caching, caching.MaxCacheSizeBytes, "contents")
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to initialize content cache")
@@ -676,7 +845,27 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 		return nil, errors.Wrap(err, "unable to initialize list cache")
 	}
 
-	contentIndex := newCommittedContentIndex(caching)
+	if err := f.EpochParameters.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid epoch parameters")
+	}
+
+	var spool *packSpool
+
+	if caching.PackSpool {
+		if caching.CacheDirectory == "" {
+			log(ctx).Warningf("pack spool requires cache directory, not enabling")
+		} else {
+			spool = newPackSpool(filepath.Join(caching.CacheDirectory, "spool"))
+
+			if err := spool.lock(); err != nil {
+				log(ctx).Warningf("not enabling pack spool: %v", err)
+
+				spool = nil
+			}
+		}
+	}
+
+	contentIndex := newCommittedContentIndex(caching, uint32(encryptor.MaxOverhead()))
 
 	mu := &sync.RWMutex{}
 	m := &Manager{
@@ -686,6 +875,8 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 			timeNow:                 timeNow,
 			maxPackSize:             f.MaxPackSize,
 			encryptor:               encryptor,
+			previousEncryptors:      previousEncryptors,
+			metadataCompressor:      metadataCompressor,
 			hasher:                  hasher,
 			minPreambleLength:       defaultMinPreambleLength,
 			maxPreambleLength:       defaultMaxPreambleLength,
@@ -693,6 +884,7 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 			contentCache:            contentCache,
 			metadataCache:           metadataCache,
 			listCache:               listCache,
+			spool:                   spool,
 			st:                      st,
 			repositoryFormatBytes:   repositoryFormatBytes,
 			checkInvariantsOnUnlock: os.Getenv("KOPIA_VERIFY_INVARIANTS") != "",
@@ -715,9 +907,36 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 		},
 	}
 
-	if err := m.CompactIndexes(ctx, autoCompactionOptions); err != nil {
-		return nil, errors.Wrap(err, "error initializing content manager")
+	if f.EpochParameters.IsEnabled() {
+		m.epochMgr = epoch.NewManager(st, *f.EpochParameters, m.compactEpochIndexBlobs, timeNow)
+	}
+
+	if err := m.loadInitialIndexes(ctx); err != nil {
+		if m.spool == nil {
+			return nil, errors.Wrap(err, "error initializing content manager")
+		}
+
+		// offline clients can keep writing to the spool using indexes available locally.
+		log(ctx).Warningf("unable to load indexes from the storage, using locally available indexes: %v", err)
+	}
+
+	if m.spool != nil {
+		if err := m.replaySpool(ctx); err != nil {
+			m.spool.unlock()
+			return nil, errors.Wrap(err, "error replaying pack spool")
+		}
 	}
 
 	return m, nil
 }
+
+// loadInitialIndexes loads indexes when the manager is opened, compacting them unless index epochs are enabled,
+// in which case epoch maintenance is performed explicitly by 'kopia index optimize'.
+func (bm *Manager) loadInitialIndexes(ctx context.Context) error {
+	if bm.epochMgr != nil {
+		_, err := bm.Refresh(ctx)
+		return err
+	}
+
+	return bm.CompactIndexes(ctx, autoCompactionOptions)
+}
@@ -85,6 +85,14 @@ func (bm *Manager) snapshotUncommittedItems() packIndexBuilder {
 
 	overlay := bm.packIndexBuilder.clone()
 
+	if bm.spool != nil {
+		for _, ndx := range bm.spool.notUploadedPacks() {
+			for _, pi := range ndx {
+				overlay.Add(*pi)
+			}
+		}
+	}
+
 	for _, pp := range bm.pendingPacks {
 		for _, pi := range pp.currentPackItems {
 			overlay.Add(pi)
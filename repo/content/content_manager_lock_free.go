@@ -6,6 +6,7 @@ import (
 	"crypto/aes"
 	cryptorand "crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"strings"
 	"sync"
@@ -14,7 +15,9 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/buf"
+	"github.com/kopia/kopia/internal/epoch"
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/encryption"
 	"github.com/kopia/kopia/repo/hashing"
 )
@@ -25,6 +28,8 @@ type lockFreeManager struct {
 	Stats Stats
 
 	listCache      *listCache
+	epochMgr       *epoch.Manager // nil unless index epochs are enabled
+	spool          *packSpool     // nil unless pack spool is enabled
 	st             blob.Storage
 	Format         FormattingOptions
 	CachingOptions CachingOptions
@@ -37,19 +42,78 @@ type lockFreeManager struct {
 
 	writeFormatVersion int32 // format version to write
 
-	maxPackSize       int
-	hasher            hashing.HashFunc
-	encryptor         encryption.Encryptor
-	minPreambleLength int
-	maxPreambleLength int
-	paddingUnit       int
-	timeNow           func() time.Time
+	maxPackSize        int
+	hasher             hashing.HashFunc
+	encryptor          encryption.Encryptor
+	previousEncryptors map[byte]encryption.Encryptor // encryptors using previous master keys, by key ID
+	metadataCompressor compression.Compressor
+	minPreambleLength  int
+	maxPreambleLength  int
+	paddingUnit        int
+	timeNow            func() time.Time
 
 	repositoryFormatBytes []byte
 
 	encryptionBufferPool *buf.Pool
 }
 
+// indexVersion returns the version of index blobs written to the repository, which must be readable
+// by all clients supporting the format version of the repository.
+func (bm *lockFreeManager) indexVersion() byte {
+	if bm.Format.Version >= FormatVersion2 {
+		return indexVersion2
+	}
+
+	return indexVersion1
+}
+
+// perContentOverhead returns the number of bytes added to each content by encryption.
+func (bm *lockFreeManager) perContentOverhead() uint32 {
+	return uint32(bm.encryptor.MaxOverhead())
+}
+
+// maybeCompressContentData compresses metadata contents if enabled and returns the payload to store along with
+// the compression header ID to record in the index (zero if the content is stored uncompressed).
+func (bm *lockFreeManager) maybeCompressContentData(contentID ID, data []byte) ([]byte, compression.HeaderID, error) {
+	if bm.metadataCompressor == nil || !contentID.HasPrefix() || len(data) == 0 {
+		return data, 0, nil
+	}
+
+	var buf bytes.Buffer
+
+	if err := bm.metadataCompressor.Compress(&buf, data); err != nil {
+		return nil, 0, errors.Wrap(err, "compression error")
+	}
+
+	bm.Stats.compressed(bm.Format.MetadataCompression, len(data), buf.Len())
+
+	if buf.Len() >= len(data) {
+		// not worth it, store uncompressed.
+		return data, 0, nil
+	}
+
+	return buf.Bytes(), bm.metadataCompressor.HeaderID(), nil
+}
+
+func decompressContentData(data []byte, headerID compression.HeaderID) ([]byte, error) {
+	if headerID == 0 {
+		return data, nil
+	}
+
+	c := compression.ByHeaderID[headerID]
+	if c == nil {
+		return nil, errors.Errorf("unsupported compressor %x", uint32(headerID))
+	}
+
+	var buf bytes.Buffer
+
+	if err := c.Decompress(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "decompression error")
+	}
+
+	return buf.Bytes(), nil
+}
+
 func (bm *lockFreeManager) maybeEncryptContentDataForPacking(output *bytes.Buffer, data []byte, contentID ID) error {
 	var hashOutput [maxHashSize]byte
 
@@ -71,6 +135,19 @@ func (bm *lockFreeManager) maybeEncryptContentDataForPacking(output *bytes.Buffe
 	return nil
 }
 
+// encryptorForKeyID returns the encryptor using the master key with the provided ID.
+func (bm *lockFreeManager) encryptorForKeyID(keyID byte) (encryption.Encryptor, error) {
+	if keyID == bm.Format.EncryptionKeyID {
+		return bm.encryptor, nil
+	}
+
+	if e := bm.previousEncryptors[keyID]; e != nil {
+		return e, nil
+	}
+
+	return nil, errors.Errorf("unknown encryption key ID %v, the repository key may have been rotated since the repository was opened", keyID)
+}
+
 func writeToBuffer(b *bytes.Buffer, data []byte) {
 	// buffer writes never fail, safe to ignore errors
 	_, _ = b.Write(data)
@@ -103,7 +180,7 @@ func (bm *lockFreeManager) loadPackIndexesUnlocked(ctx context.Context) ([]Index
 			nextSleepTime *= 2
 		}
 
-		contents, err := bm.listCache.listIndexBlobs(ctx)
+		contents, err := bm.listIndexBlobs(ctx, true)
 		if err != nil {
 			return nil, false, err
 		}
@@ -235,7 +312,7 @@ func (bm *lockFreeManager) getContentDataUnlocked(ctx context.Context, pp *pendi
 	} else {
 		var err error
 
-		payload, err = bm.getCacheForContentID(bi.ID).getContent(ctx, cacheKey(bi.ID), bi.PackBlobID, int64(bi.PackOffset), int64(bi.Length))
+		payload, err = bm.readPackedContent(ctx, bi)
 		if err != nil {
 			return nil, err
 		}
@@ -250,7 +327,12 @@ func (bm *lockFreeManager) getContentDataUnlocked(ctx context.Context, pp *pendi
 		return nil, err
 	}
 
-	decrypted, err := bm.decryptAndVerify(payload, iv)
+	e, err := bm.encryptorForKeyID(bi.EncryptionKeyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt %v", bi.ID)
+	}
+
+	decrypted, err := bm.decryptDecompressAndVerify(e, payload, iv, bi.CompressionHeaderID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "invalid checksum at %v offset %v length %v", bi.PackBlobID, bi.PackOffset, len(payload))
 	}
@@ -258,15 +340,63 @@ func (bm *lockFreeManager) getContentDataUnlocked(ctx context.Context, pp *pendi
 	return decrypted, nil
 }
 
+// readPackedContent reads the packed data of a content from the pack blob or, if the pack has not been uploaded yet,
+// from the local spool.
+func (bm *lockFreeManager) readPackedContent(ctx context.Context, bi *Info) ([]byte, error) {
+	if bm.spool != nil && bm.spool.isNotUploaded(bi.PackBlobID) {
+		payload, err := bm.spool.readPackRange(bi.PackBlobID, int64(bi.PackOffset), int64(bi.Length))
+		if err == nil {
+			return payload, nil
+		}
+
+		// the pack may have been uploaded and removed from the spool in the meantime.
+		log(ctx).Debugf("unable to read %v from spooled pack: %v", bi.ID, err)
+	}
+
+	return bm.getCacheForContentID(bi.ID).getContent(ctx, contentCacheKey(bi), bi.PackBlobID, int64(bi.PackOffset), int64(bi.Length))
+}
+
+// contentCacheKey returns the key of the cached packed data of a content. Contents re-encrypted using a rotated key
+// are cached separately, as their packed data differs.
+func contentCacheKey(bi *Info) cacheKey {
+	if bi.EncryptionKeyID == 0 {
+		return cacheKey(bi.ID)
+	}
+
+	return cacheKey(fmt.Sprintf("%v.k%02x", bi.ID, bi.EncryptionKeyID))
+}
+
+// decryptAndVerify decrypts data that is not associated with an encryption key ID, such as index blobs,
+// using the current master key or, if that fails, any of the previous master keys.
 func (bm *lockFreeManager) decryptAndVerify(encrypted, iv []byte) ([]byte, error) {
-	decrypted, err := bm.encryptor.Decrypt(nil, encrypted, iv)
+	decrypted, err := bm.decryptDecompressAndVerify(bm.encryptor, encrypted, iv, 0)
+	if err == nil || len(bm.previousEncryptors) == 0 {
+		return decrypted, err
+	}
+
+	for _, e := range bm.previousEncryptors {
+		if d, perr := bm.decryptDecompressAndVerify(e, encrypted, iv, 0); perr == nil {
+			return d, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (bm *lockFreeManager) decryptDecompressAndVerify(e encryption.Encryptor, encrypted, iv []byte, compressionHeaderID compression.HeaderID) ([]byte, error) {
+	decrypted, err := e.Decrypt(nil, encrypted, iv)
 	if err != nil {
 		return nil, errors.Wrap(err, "decrypt")
 	}
 
 	bm.Stats.decrypted(len(decrypted))
 
-	if bm.encryptor.IsAuthenticated() {
+	decrypted, err = decompressContentData(decrypted, compressionHeaderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.IsAuthenticated() {
 		// already verified
 		return decrypted, nil
 	}
@@ -321,7 +451,45 @@ func (bm *lockFreeManager) preparePackDataContent(ctx context.Context, pp *pendi
 
 // IndexBlobs returns the list of active index blobs.
 func (bm *lockFreeManager) IndexBlobs(ctx context.Context) ([]IndexBlobInfo, error) {
-	return bm.listCache.listIndexBlobs(ctx)
+	return bm.listIndexBlobs(ctx, false)
+}
+
+// EpochManager returns the manager of index epochs, if index epochs are enabled.
+func (bm *lockFreeManager) EpochManager() (*epoch.Manager, bool) {
+	return bm.epochMgr, bm.epochMgr != nil
+}
+
+// listIndexBlobs returns the list of active index blobs, either from the list cache or, when index epochs
+// are enabled, from the epoch manager, which is refreshed first if requested. Index blobs not managed by epochs
+// are always included, until maintenance moves them into epochs.
+func (bm *lockFreeManager) listIndexBlobs(ctx context.Context, refresh bool) ([]IndexBlobInfo, error) {
+	legacyBlobs, err := bm.listCache.listIndexBlobs(ctx)
+	if err != nil || bm.epochMgr == nil {
+		return legacyBlobs, err
+	}
+
+	if refresh {
+		if err := bm.epochMgr.Refresh(ctx); err != nil {
+			return nil, errors.Wrap(err, "unable to refresh epochs")
+		}
+	}
+
+	bms, err := bm.epochMgr.GetCompleteIndexSet(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get index blobs")
+	}
+
+	result := append([]IndexBlobInfo(nil), legacyBlobs...)
+
+	for _, it := range bms {
+		result = append(result, IndexBlobInfo{
+			BlobID:    it.BlobID,
+			Timestamp: it.Timestamp,
+			Length:    it.Length,
+		})
+	}
+
+	return result, nil
 }
 
 func (bm *lockFreeManager) getIndexBlobInternal(ctx context.Context, blobID blob.ID) ([]byte, error) {
@@ -337,20 +505,41 @@ func (bm *lockFreeManager) getIndexBlobInternal(ctx context.Context, blobID blob
 
 	bm.Stats.readContent(len(payload))
 
-	payload, err = bm.encryptor.Decrypt(nil, payload, iv)
-	bm.Stats.decrypted(len(payload))
-
+	payload, err = bm.decryptAndVerify(payload, iv)
 	if err != nil {
 		return nil, errors.Wrap(err, "decrypt error")
 	}
 
-	// Since the encryption key is a function of data, we must be able to generate exactly the same key
-	// after decrypting the content. This serves as a checksum.
-	if err := bm.verifyChecksum(payload, iv); err != nil {
-		return nil, err
+	return payload, nil
+}
+
+// isEncryptedWithCurrentKey determines whether the provided index blob can be decrypted using the current master key.
+func (bm *lockFreeManager) isEncryptedWithCurrentKey(ctx context.Context, blobID blob.ID) (bool, error) {
+	// bypass the cache, which may hold a copy of a blob that has since been rewritten in place.
+	payload, err := bm.st.GetBlob(ctx, blobID, 0, -1)
+	if err != nil {
+		return false, err
 	}
 
-	return payload, nil
+	iv, err := getIndexBlobIV(blobID)
+	if err != nil {
+		return false, err
+	}
+
+	decrypted, err := bm.encryptor.Decrypt(nil, payload, iv)
+	if err != nil {
+		return false, nil
+	}
+
+	if bm.encryptor.IsAuthenticated() {
+		return true, nil
+	}
+
+	var hashOutput [maxHashSize]byte
+
+	expected := bm.hasher(hashOutput[:0], decrypted)
+
+	return bytes.HasSuffix(iv, expected[len(expected)-aes.BlockSize:]), nil
 }
 
 func getPackedContentIV(output []byte, contentID ID) ([]byte, error) {
@@ -371,8 +560,9 @@ func getIndexBlobIV(s blob.ID) ([]byte, error) {
 }
 
 func (bm *lockFreeManager) writePackFileNotLocked(ctx context.Context, packFile blob.ID, data []byte) error {
+	// packs don't affect the list of index blobs, so the list cache is kept, which allows offline clients
+	// with the pack spool to keep using it.
 	bm.Stats.wroteContent(len(data))
-	bm.listCache.deleteListCache()
 
 	return bm.st.PutBlob(ctx, packFile, data)
 }
@@ -414,7 +604,18 @@ func (bm *lockFreeManager) hashData(output, data []byte) []byte {
 }
 
 func (bm *lockFreeManager) writePackIndexesNew(ctx context.Context, data []byte) (blob.ID, error) {
-	return bm.encryptAndWriteBlobNotLocked(ctx, data, newIndexBlobPrefix)
+	prefix := blob.ID(newIndexBlobPrefix)
+
+	if bm.epochMgr != nil {
+		p, err := bm.epochMgr.WriteIndexBlobPrefix(ctx)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to determine current epoch")
+		}
+
+		prefix = p
+	}
+
+	return bm.encryptAndWriteBlobNotLocked(ctx, data, prefix)
 }
 
 func (bm *lockFreeManager) verifyChecksum(data, contentID []byte) error {
@@ -455,3 +656,26 @@ func CreateHashAndEncryptor(f *FormattingOptions) (hashing.HashFunc, encryption.
 
 	return h, e, nil
 }
+
+// createPreviousEncryptors returns encryptors using master keys that have been rotated out, by key ID.
+func createPreviousEncryptors(f *FormattingOptions) (map[byte]encryption.Encryptor, error) {
+	result := map[byte]encryption.Encryptor{}
+
+	for _, pk := range f.PreviousMasterKeys {
+		if pk.KeyID == f.EncryptionKeyID {
+			return nil, errors.Errorf("previous master key uses the current key ID %v", pk.KeyID)
+		}
+
+		fo := *f
+		fo.MasterKey = pk.MasterKey
+
+		e, err := encryption.CreateEncryptor(&fo)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create encryptor for key ID %v", pk.KeyID)
+		}
+
+		result[pk.KeyID] = e
+	}
+
+	return result, nil
+}
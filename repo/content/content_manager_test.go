@@ -8,7 +8,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
@@ -19,6 +21,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/epoch"
 	"github.com/kopia/kopia/internal/faketime"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo/blob"
@@ -1361,7 +1364,7 @@ func newTestContentManagerWithStorage(t *testing.T, st blob.Storage, timeFunc fu
 		Encryption:  "AES256-GCM-HMAC-SHA256",
 		HMACSecret:  hmacSecret,
 		MaxPackSize: maxPackSize,
-		Version:     1,
+		Version:     currentWriteVersion,
 	}, CachingOptions{}, timeFunc, nil)
 	if err != nil {
 		panic("can't create content manager: " + err.Error())
@@ -1501,3 +1504,778 @@ func dumpContentManagerData(ctx context.Context, t *testing.T, data blobtesting.
 
 	log(ctx).Infof("*** end of data")
 }
+
+func TestConvertV1Indexes(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	keyTime := map[blob.ID]time.Time{}
+	bm := newTestContentManager(t, data, keyTime, nil)
+
+	contentID := writeContentAndVerify(ctx, t, bm, seededRandomData(10, 100))
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	info, err := bm.ContentInfo(ctx, contentID)
+	if err != nil {
+		t.Fatalf("unable to get content info: %v", err)
+	}
+
+	if got, want := info.OriginalLength, uint32(100); got != want {
+		t.Errorf("unexpected original length %v, want %v", got, want)
+	}
+
+	// replace the index with an equivalent one in v1 format.
+	v2Blobs, err := bm.IndexBlobs(ctx)
+	if err != nil {
+		t.Fatalf("error listing index blobs: %v", err)
+	}
+
+	bld := make(packIndexBuilder)
+	bld.Add(info)
+
+	var buf bytes.Buffer
+	if err = bld.Build(&buf, indexVersion1); err != nil {
+		t.Fatalf("unable to build v1 index: %v", err)
+	}
+
+	if _, err = bm.writePackIndexesNew(ctx, buf.Bytes()); err != nil {
+		t.Fatalf("unable to write v1 index: %v", err)
+	}
+
+	for _, ib := range v2Blobs {
+		delete(data, ib.BlobID)
+	}
+
+	bm.Close(ctx)
+
+	bm = newTestContentManager(t, data, keyTime, nil)
+	defer bm.Close(ctx)
+
+	verifyIndexVersions(ctx, t, bm, indexVersion1)
+
+	// original length of v1 contents is derived from the packed length.
+	info, err = bm.ContentInfo(ctx, contentID)
+	if err != nil {
+		t.Fatalf("unable to get content info: %v", err)
+	}
+
+	if got, want := info.OriginalLength, uint32(100); got != want {
+		t.Errorf("unexpected original length %v, want %v", got, want)
+	}
+
+	if err := bm.CompactIndexes(ctx, CompactOptions{MaxSmallBlobs: 1, ConvertV1Indexes: true}); err != nil {
+		t.Fatalf("compaction error: %v", err)
+	}
+
+	verifyIndexVersions(ctx, t, bm, indexVersion2)
+	verifyContent(ctx, t, bm, contentID, seededRandomData(10, 100))
+}
+
+func TestIndexVersionFollowsFormatVersion(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: maxPackSize,
+		Version:     FormatVersion1,
+	}, CachingOptions{}, faketime.AutoAdvance(fakeTime, 1*time.Second), nil)
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+	defer bm.Close(ctx)
+
+	// clients supporting only format version 1 must be able to read the index.
+	writeContentAndVerify(ctx, t, bm, seededRandomData(10, 100))
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	verifyIndexVersions(ctx, t, bm, indexVersion1)
+
+	if err := bm.CompactIndexes(ctx, CompactOptions{ConvertV1Indexes: true}); err == nil {
+		t.Errorf("expected error when converting indexes of a v1 repository")
+	}
+}
+
+func verifyIndexVersions(ctx context.Context, t *testing.T, bm *Manager, want byte) {
+	t.Helper()
+
+	blobs, err := bm.IndexBlobs(ctx)
+	if err != nil {
+		t.Fatalf("error listing index blobs: %v", err)
+	}
+
+	if len(blobs) != 1 {
+		t.Fatalf("unexpected index blobs: %v", blobs)
+	}
+
+	d, err := bm.getIndexBlobInternal(ctx, blobs[0].BlobID)
+	if err != nil {
+		t.Fatalf("unable to read index blob: %v", err)
+	}
+
+	if d[0] != want {
+		t.Errorf("unexpected index version %v, want %v", d[0], want)
+	}
+}
+
+func TestMetadataCompression(t *testing.T) {
+	for _, enc := range []string{"AES256-GCM-HMAC-SHA256", "NONE"} {
+		enc := enc
+
+		t.Run(enc, func(t *testing.T) {
+			verifyMetadataCompression(t, enc)
+		})
+	}
+}
+
+func verifyMetadataCompression(t *testing.T, encryptionAlgorithm string) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	newManager := func() *Manager {
+		bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+			Hash:                "HMAC-SHA256",
+			Encryption:          encryptionAlgorithm,
+			HMACSecret:          hmacSecret,
+			MaxPackSize:         maxPackSize,
+			Version:             FormatVersion2,
+			MetadataCompression: "zstd",
+		}, CachingOptions{}, faketime.AutoAdvance(fakeTime, 1*time.Second), nil)
+		if err != nil {
+			t.Fatalf("can't create content manager: %v", err)
+		}
+
+		return bm
+	}
+
+	bm := newManager()
+
+	compressible := bytes.Repeat([]byte("compressible metadata "), 50)
+	incompressible := seededRandomData(1, 500)
+
+	// data already compressed by the writer is not compressed again.
+	precompressed := bytes.Repeat([]byte("compressed by the writer "), 50)
+
+	cases := []struct {
+		data           []byte
+		prefix         ID
+		precompressed  bool
+		wantCompressed bool
+	}{
+		{compressible, "k", false, true},
+		{compressible, "", false, false},
+		{incompressible, "k", false, false},
+		{precompressed, "k", true, false},
+	}
+
+	var contentIDs []ID
+
+	for _, tc := range cases {
+		writeContent := bm.WriteContent
+		if tc.precompressed {
+			writeContent = bm.WriteCompressedContent
+		}
+
+		contentID, err := writeContent(ctx, tc.data, tc.prefix)
+		if err != nil {
+			t.Fatalf("unable to write content: %v", err)
+		}
+
+		// verify reading from the pending pack.
+		verifyContent(ctx, t, bm, contentID, tc.data)
+
+		contentIDs = append(contentIDs, contentID)
+	}
+
+	cs := bm.Stats.Compression()["zstd"]
+	if cs.CompressedContents != 1 || cs.IncompressibleContents != 1 {
+		t.Errorf("unexpected compression stats: %+v", cs)
+	}
+
+	if cs.CompressedBytes >= cs.OriginalBytes {
+		t.Errorf("compression did not reduce size: %+v", cs)
+	}
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	bm.Close(ctx)
+
+	bm = newManager()
+	defer bm.Close(ctx)
+
+	for i, tc := range cases {
+		verifyContent(ctx, t, bm, contentIDs[i], tc.data)
+
+		info, err := bm.ContentInfo(ctx, contentIDs[i])
+		if err != nil {
+			t.Fatalf("unable to get content info: %v", err)
+		}
+
+		if got, want := info.CompressionHeaderID != 0, tc.wantCompressed; got != want {
+			t.Errorf("unexpected compression of %v: %v, want %v", contentIDs[i], info.CompressionHeaderID, want)
+		}
+
+		if got, want := info.OriginalLength, uint32(len(tc.data)); got != want {
+			t.Errorf("unexpected original length of %v: %v, want %v", contentIDs[i], got, want)
+		}
+
+		if tc.wantCompressed && info.Length >= info.OriginalLength {
+			t.Errorf("content %v was not stored compressed: %+v", contentIDs[i], info)
+		}
+	}
+
+	// rewriting preserves the data and its compression.
+	for i, tc := range cases {
+		if err := bm.RewriteContent(ctx, contentIDs[i]); err != nil {
+			t.Fatalf("unable to rewrite content: %v", err)
+		}
+
+		verifyContent(ctx, t, bm, contentIDs[i], tc.data)
+
+		info, err := bm.ContentInfo(ctx, contentIDs[i])
+		if err != nil {
+			t.Fatalf("unable to get content info: %v", err)
+		}
+
+		if got, want := info.CompressionHeaderID != 0, tc.wantCompressed; got != want {
+			t.Errorf("unexpected compression of rewritten %v: %v, want %v", contentIDs[i], info.CompressionHeaderID, want)
+		}
+	}
+}
+
+func TestUnsupportedMetadataCompression(t *testing.T) {
+	_, err := newManagerWithOptions(testlogging.Context(t), blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil), &FormattingOptions{
+		Hash:                "HMAC-SHA256",
+		Encryption:          "NONE",
+		HMACSecret:          hmacSecret,
+		MaxPackSize:         maxPackSize,
+		Version:             FormatVersion2,
+		MetadataCompression: "no-such-compressor",
+	}, CachingOptions{}, time.Now, nil)
+	if err == nil {
+		t.Fatalf("unexpected success")
+	}
+}
+
+func TestMetadataCompressionRequiresFormatVersion2(t *testing.T) {
+	_, err := newManagerWithOptions(testlogging.Context(t), blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil), &FormattingOptions{
+		Hash:                "HMAC-SHA256",
+		Encryption:          "NONE",
+		HMACSecret:          hmacSecret,
+		MaxPackSize:         maxPackSize,
+		Version:             FormatVersion1,
+		MetadataCompression: "zstd",
+	}, CachingOptions{}, time.Now, nil)
+	if err == nil {
+		t.Fatalf("unexpected success")
+	}
+}
+
+var testEpochParameters = &epoch.Parameters{
+	Enabled:                      true,
+	EpochRefreshFrequency:        10 * time.Minute,
+	MinEpochDuration:             time.Hour,
+	EpochAdvanceOnCountThreshold: 3,
+	CleanupSafetyMargin:          time.Hour,
+}
+
+func newEpochTestManager(ctx context.Context, t *testing.T, st blob.Storage, timeNow func() time.Time, params *epoch.Parameters) *Manager {
+	t.Helper()
+
+	bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:            "HMAC-SHA256",
+		Encryption:      "AES256-GCM-HMAC-SHA256",
+		HMACSecret:      hmacSecret,
+		MaxPackSize:     maxPackSize,
+		Version:         FormatVersion2,
+		EpochParameters: params,
+	}, CachingOptions{}, timeNow, nil)
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	return bm
+}
+
+func writeEpochTestContents(ctx context.Context, t *testing.T, bm *Manager, seed int, dataSet map[ID][]byte) {
+	t.Helper()
+
+	for j := 0; j < 3; j++ {
+		b := seededRandomData(seed*10+j, 100)
+
+		contentID, err := bm.WriteContent(ctx, b, "")
+		if err != nil {
+			t.Fatalf("unable to write content: %v", err)
+		}
+
+		dataSet[contentID] = b
+	}
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+}
+
+func TestEpochIndexes(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	ta := faketime.NewTimeAdvance(fakeTime)
+	st := blobtesting.NewMapStorage(data, nil, ta.NowFunc())
+
+	dataSet := map[ID][]byte{}
+
+	const flushCount = 30
+
+	for i := 0; i < flushCount; i++ {
+		bm := newEpochTestManager(ctx, t, st, ta.NowFunc(), testEpochParameters)
+		writeEpochTestContents(ctx, t, bm, i, dataSet)
+
+		// epoch maintenance is not performed on open.
+		if err := bm.CompactIndexes(ctx, CompactOptions{MaxSmallBlobs: 1}); err != nil {
+			t.Fatalf("unable to perform epoch maintenance: %v", err)
+		}
+
+		ta.Advance(10 * time.Minute)
+	}
+
+	bm := newEpochTestManager(ctx, t, st, ta.NowFunc(), testEpochParameters)
+	verifyContentManagerDataSet(ctx, t, bm, dataSet)
+
+	if err := bm.CompactIndexes(ctx, CompactOptions{AllIndexes: true}); err == nil {
+		t.Errorf("unexpected success compacting all indexes with index epochs")
+	}
+
+	counts := map[blob.ID]int{}
+
+	for blobID := range data {
+		counts[blobID[0:1]]++
+
+		if len(blobID) > 1 {
+			counts[blobID[0:2]]++
+		}
+	}
+
+	if counts[newIndexBlobPrefix] != 0 {
+		t.Errorf("unexpected legacy index blobs: %v", counts[newIndexBlobPrefix])
+	}
+
+	if counts[epoch.EpochMarkerIndexBlobPrefix] == 0 || counts[epoch.SingleEpochCompactionBlobPrefix] == 0 {
+		t.Errorf("epochs have not been advanced and compacted: %v", counts)
+	}
+
+	indexBlobs, err := bm.IndexBlobs(ctx)
+	if err != nil {
+		t.Fatalf("unable to list index blobs: %v", err)
+	}
+
+	if len(indexBlobs) >= flushCount/2 {
+		t.Errorf("too many index blobs after compaction: %v", len(indexBlobs))
+	}
+
+	if got, want := counts[epoch.UncompactedIndexBlobPrefix], flushCount; got >= want {
+		t.Errorf("compacted index blobs have not been cleaned up")
+	}
+}
+
+func TestPackSpool(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	var offline int32
+
+	offlineFault := func() *blobtesting.Fault {
+		return &blobtesting.Fault{
+			Repeat: 1000000,
+			ErrCallback: func() error {
+				if atomic.LoadInt32(&offline) != 0 {
+					return errors.New("storage offline")
+				}
+
+				return nil
+			},
+		}
+	}
+
+	faulty := &blobtesting.FaultyStorage{
+		Base: st,
+		Faults: map[string][]*blobtesting.Fault{
+			"PutBlob":   {offlineFault()},
+			"ListBlobs": {offlineFault()},
+		},
+	}
+
+	cacheDir, err := ioutil.TempDir("", "pack-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	newManager := func(s blob.Storage, caching CachingOptions) *Manager {
+		bm, err := newManagerWithOptions(ctx, s, &FormattingOptions{
+			Hash:        "HMAC-SHA256",
+			Encryption:  "AES256-GCM-HMAC-SHA256",
+			HMACSecret:  hmacSecret,
+			MaxPackSize: maxPackSize,
+			Version:     1,
+		}, caching, faketime.AutoAdvance(fakeTime, time.Second), nil)
+		if err != nil {
+			t.Fatalf("can't create content manager: %v", err)
+		}
+
+		return bm
+	}
+
+	spoolCaching := CachingOptions{CacheDirectory: cacheDir, PackSpool: true}
+
+	bm := newManager(faulty, spoolCaching)
+	dataSet := map[ID][]byte{}
+
+	// processes sharing the cache directory don't use the same spool.
+	if other := newManager(faulty, spoolCaching); other.spool != nil {
+		t.Fatalf("spool used by two managers at the same time")
+	}
+
+	atomic.StoreInt32(&offline, 1)
+
+	for i := 0; i < 100; i++ {
+		b := seededRandomData(i, 100)
+
+		contentID, err := bm.WriteContent(ctx, b, "")
+		if err != nil {
+			t.Fatalf("unable to write content while offline: %v", err)
+		}
+
+		dataSet[contentID] = b
+	}
+
+	if err := bm.Flush(ctx); !IsPacksSpooled(err) {
+		t.Fatalf("unexpected flush result while offline: %v", err)
+	}
+
+	// contents are readable from the spool.
+	verifyContentManagerDataSet(ctx, t, bm, dataSet)
+
+	if err := bm.Close(ctx); err != nil {
+		t.Fatalf("unable to close while offline: %v", err)
+	}
+
+	// repository can be opened while offline, spooled contents are still readable.
+	bm = newManager(faulty, spoolCaching)
+	verifyContentManagerDataSet(ctx, t, bm, dataSet)
+
+	// simulate crash, which releases the spool lock.
+	bm.spool.unlock()
+
+	if len(data) != 0 {
+		t.Fatalf("unexpected blobs written while offline: %v", len(data))
+	}
+
+	spooled, err := bm.spool.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spooled) == 0 {
+		t.Fatalf("no packs in the spool")
+	}
+
+	// simulate crash followed by storage becoming available again.
+	atomic.StoreInt32(&offline, 0)
+
+	bm2 := newManager(faulty, spoolCaching)
+	defer bm2.Close(ctx)
+
+	if spooled, err = bm2.spool.list(); err != nil || len(spooled) != 0 {
+		t.Fatalf("spool was not emptied after replay: %v %v", spooled, err)
+	}
+
+	// all contents are now visible to readers that don't use the spool.
+	bm3 := newManager(st, CachingOptions{})
+	defer bm3.Close(ctx)
+
+	verifyContentManagerDataSet(ctx, t, bm3, dataSet)
+}
+
+func TestPackSpoolIndexWriteFailure(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)
+
+	// pack upload succeeds, writing the index fails.
+	faulty := &blobtesting.FaultyStorage{
+		Base: st,
+		Faults: map[string][]*blobtesting.Fault{
+			"PutBlob": {
+				{},
+				{Err: errors.New("some index write error")},
+			},
+		},
+	}
+
+	cacheDir, err := ioutil.TempDir("", "pack-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	bm, err := newManagerWithOptions(ctx, faulty, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: maxPackSize,
+		Version:     FormatVersion2,
+	}, CachingOptions{CacheDirectory: cacheDir, PackSpool: true}, time.Now, nil)
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	defer bm.Close(ctx)
+
+	writeContentAndVerify(ctx, t, bm, seededRandomData(1, 100))
+
+	if err := bm.Flush(ctx); !IsPacksSpooled(err) {
+		t.Fatalf("unexpected flush result: %v", err)
+	}
+
+	if spooled, err := bm.spool.list(); err != nil || len(spooled) != 1 {
+		t.Fatalf("pack was not kept in the spool: %v %v", spooled, err)
+	}
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+
+	if spooled, err := bm.spool.list(); err != nil || len(spooled) != 0 {
+		t.Fatalf("spool was not emptied after writing index: %v %v", spooled, err)
+	}
+}
+
+func TestCloseStopsPackUploadWorkersOnFlushFailure(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)
+
+	fs := &blobtesting.FaultyStorage{
+		Base: st,
+		Faults: map[string][]*blobtesting.Fault{
+			"PutBlob": {
+				{Repeat: 1000000, Err: errors.New("some upload error")},
+			},
+		},
+	}
+
+	bm := newTestContentManagerWithStorage(t, fs, nil)
+	bm.startPackUploadWorkers(2, 0)
+
+	writeContentAndVerify(ctx, t, bm, seededRandomData(1, 100))
+
+	if err := bm.Close(ctx); err == nil {
+		t.Fatalf("unexpected success closing with failing storage")
+	}
+
+	if bm.uploadQueue != nil {
+		t.Fatalf("pack upload workers have not been stopped")
+	}
+}
+
+func TestBackgroundPackUploads(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	const maxInFlightBytes = 3 * maxPackSize
+
+	var bm *Manager
+
+	var maxQueuedBytes int64
+
+	// slow storage, which fails the first upload and records the largest observed upload queue.
+	fs := &blobtesting.FaultyStorage{
+		Base: st,
+		Faults: map[string][]*blobtesting.Fault{
+			"PutBlob": {
+				{Err: errors.New("some upload error")},
+				{
+					Repeat: 1000000000,
+					Sleep:  10 * time.Millisecond,
+					ErrCallback: func() error {
+						if _, b := bm.Stats.PackUploadQueue(); b > atomic.LoadInt64(&maxQueuedBytes) {
+							atomic.StoreInt64(&maxQueuedBytes, b)
+						}
+
+						return nil
+					},
+				},
+			},
+		},
+	}
+
+	bm = newTestContentManagerWithStorage(t, fs, nil)
+	bm.startPackUploadWorkers(4, maxInFlightBytes)
+
+	dataSet := map[ID][]byte{}
+
+	for i := 0; i < 200; i++ {
+		b := seededRandomData(i, 100)
+		dataSet[writeContentAndVerify(ctx, t, bm, b)] = b
+	}
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if got := atomic.LoadInt64(&maxQueuedBytes); got == 0 || got > maxInFlightBytes+maxPackSize {
+		t.Errorf("unexpected max upload queue size: %v", got)
+	}
+
+	if cnt, b := bm.Stats.PackUploadQueue(); cnt != 0 || b != 0 {
+		t.Errorf("upload queue not empty after flush: %v %v", cnt, b)
+	}
+
+	if err := bm.Close(ctx); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	bm2 := newTestContentManager(t, data, nil, nil)
+	defer bm2.Close(ctx)
+
+	verifyContentManagerDataSet(ctx, t, bm2, dataSet)
+}
+
+func TestEpochIndexesMigrateLegacyIndexBlobs(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	ta := faketime.NewTimeAdvance(fakeTime)
+	st := blobtesting.NewMapStorage(data, nil, ta.NowFunc())
+
+	dataSet := map[ID][]byte{}
+
+	for i := 0; i < 3; i++ {
+		writeEpochTestContents(ctx, t, newEpochTestManager(ctx, t, st, ta.NowFunc(), nil), i, dataSet)
+	}
+
+	bm := newEpochTestManager(ctx, t, st, ta.NowFunc(), testEpochParameters)
+
+	// index blobs written before epochs were enabled remain visible.
+	verifyContentManagerDataSet(ctx, t, bm, dataSet)
+	writeEpochTestContents(ctx, t, bm, 3, dataSet)
+
+	ta.Advance(time.Minute)
+
+	if err := bm.CompactIndexes(ctx, CompactOptions{}); err != nil {
+		t.Fatalf("unable to perform epoch maintenance: %v", err)
+	}
+
+	countBlobs := func(prefix string) int {
+		cnt := 0
+
+		for blobID := range data {
+			if strings.HasPrefix(string(blobID), prefix) {
+				cnt++
+			}
+		}
+
+		return cnt
+	}
+
+	// legacy index blobs are kept for the safety margin, so that clients with stale list of index blobs can read them.
+	if got, want := countBlobs(newIndexBlobPrefix), 3; got != want {
+		t.Errorf("unexpected number of legacy index blobs: %v, want %v", got, want)
+	}
+
+	verifyContentManagerDataSet(ctx, t, newEpochTestManager(ctx, t, st, ta.NowFunc(), testEpochParameters), dataSet)
+
+	ta.Advance(testEpochParameters.CleanupSafetyMargin)
+
+	if err := bm.CompactIndexes(ctx, CompactOptions{}); err != nil {
+		t.Fatalf("unable to perform epoch maintenance: %v", err)
+	}
+
+	if got := countBlobs(newIndexBlobPrefix); got != 0 {
+		t.Errorf("legacy index blobs have not been removed: %v", got)
+	}
+
+	// legacy index blobs were only migrated once.
+	if got, want := countBlobs(string(epoch.UncompactedIndexBlobPrefix)+"0_"+legacyIndexBlobMigrationMarker), 1; got != want {
+		t.Errorf("unexpected number of migrated index blobs: %v, want %v", got, want)
+	}
+
+	verifyContentManagerDataSet(ctx, t, newEpochTestManager(ctx, t, st, ta.NowFunc(), testEpochParameters), dataSet)
+}
+
+func TestEpochIndexesRequireFormatVersion2(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)
+
+	_, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:            "HMAC-SHA256",
+		Encryption:      "AES256-GCM-HMAC-SHA256",
+		HMACSecret:      hmacSecret,
+		MaxPackSize:     maxPackSize,
+		Version:         FormatVersion1,
+		EpochParameters: testEpochParameters,
+	}, CachingOptions{}, time.Now, nil)
+	if err == nil {
+		t.Fatalf("unexpected success")
+	}
+}
+
+func TestStopPackUploadWorkersWhileWriting(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	bm := newTestContentManagerWithStorage(t, st, nil)
+	bm.startPackUploadWorkers(4, 0)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		dataSet = map[ID][]byte{}
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				b := seededRandomData(i*1000+j, 100)
+
+				contentID, err := bm.WriteContent(ctx, b, "")
+				if err != nil {
+					t.Errorf("unable to write content: %v", err)
+					return
+				}
+
+				mu.Lock()
+				dataSet[contentID] = b
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	// packs written after the workers are stopped are uploaded synchronously.
+	bm.stopPackUploadWorkers()
+	wg.Wait()
+
+	if err := bm.Close(ctx); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	bm2 := newTestContentManager(t, data, nil, nil)
+	defer bm2.Close(ctx)
+
+	verifyContentManagerDataSet(ctx, t, bm2, dataSet)
+}
@@ -0,0 +1,141 @@
+package content
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/ctxutil"
+)
+
+const (
+	packUploadQueueCapacity = 100 // maximum number of packs waiting for an upload worker
+
+	// default in-flight budget expressed as the number of max-sized packs per upload worker.
+	defaultInFlightPacksPerUploadWorker = 2
+)
+
+type packUploadRequest struct {
+	ctx  context.Context
+	pp   *pendingPackInfo
+	size int64
+}
+
+// startPackUploadWorkers starts the provided number of goroutines that upload packs in the background
+// while at most maxInFlightBytes of pack data is queued or being uploaded.
+func (bm *Manager) startPackUploadWorkers(parallelism int, maxInFlightBytes int64) {
+	if parallelism <= 0 {
+		return
+	}
+
+	if maxInFlightBytes <= 0 {
+		maxInFlightBytes = int64(parallelism) * int64(bm.maxPackSize) * defaultInFlightPacksPerUploadWorker
+	}
+
+	bm.maxInFlightUploadBytes = maxInFlightBytes
+	bm.uploadQueue = make(chan packUploadRequest, packUploadQueueCapacity)
+
+	for i := 0; i < parallelism; i++ {
+		bm.uploadWorkers.Add(1)
+
+		go bm.packUploadWorker(bm.uploadQueue)
+	}
+}
+
+func (bm *Manager) stopPackUploadWorkers() {
+	bm.lock()
+
+	queue := bm.uploadQueue
+	if queue == nil {
+		bm.unlock()
+		return
+	}
+
+	// prevent new packs from being queued and wait for the ones being queued, so that the queue can be closed.
+	bm.stoppingUploadWorkers = true
+	bm.cond.Broadcast()
+
+	for bm.sendingUploadRequests > 0 {
+		bm.cond.Wait()
+	}
+
+	bm.uploadQueue = nil
+	bm.stoppingUploadWorkers = false
+	bm.unlock()
+
+	close(queue)
+	bm.uploadWorkers.Wait()
+}
+
+func (bm *Manager) packUploadWorker(queue <-chan packUploadRequest) {
+	defer bm.uploadWorkers.Done()
+
+	for req := range queue {
+		if err := bm.writePackAndAddToIndex(req.ctx, req.pp, false); err != nil {
+			log(req.ctx).Warningf("unable to upload pack %v, will retry: %v", req.pp.packBlobID, err)
+		}
+
+		bm.lock()
+		bm.inFlightUploadBytes -= req.size
+		bm.Stats.packUploadDequeued(int(req.size))
+		bm.cond.Broadcast()
+		bm.unlock()
+	}
+}
+
+// enqueuePackUpload schedules the provided pack, which must already be in writingPacks, for upload
+// by one of the upload workers, waiting until it fits in the in-flight byte budget. It returns false
+// if upload workers are not running or are being stopped, in which case the caller must write the pack.
+func (bm *Manager) enqueuePackUpload(ctx context.Context, pp *pendingPackInfo) bool {
+	size := int64(pp.currentPackData.Len())
+
+	bm.lock()
+
+	// always allow a single pack, regardless of its size.
+	for bm.canQueuePackUploadsLocked() && bm.inFlightUploadBytes > 0 && bm.inFlightUploadBytes+size > bm.maxInFlightUploadBytes {
+		formatLog(ctx).Debugf("waiting for %v bytes of in-flight pack uploads", bm.inFlightUploadBytes)
+		bm.cond.Wait()
+	}
+
+	if !bm.canQueuePackUploadsLocked() {
+		bm.unlock()
+		return false
+	}
+
+	bm.inFlightUploadBytes += size
+	bm.Stats.packUploadQueued(int(size))
+
+	// the queue can't be closed until the request is sent.
+	queue := bm.uploadQueue
+	bm.sendingUploadRequests++
+
+	bm.unlock()
+
+	// the upload outlives the caller, so it must not be cancelled along with the caller's context.
+	queue <- packUploadRequest{ctxutil.Detach(ctx), pp, size}
+
+	bm.lock()
+	bm.sendingUploadRequests--
+	bm.cond.Broadcast()
+	bm.unlock()
+
+	return true
+}
+
+func (bm *Manager) canQueuePackUploadsLocked() bool {
+	return bm.uploadQueue != nil && !bm.stoppingUploadWorkers
+}
+
+// retryFailedPacksLocked retries writing packs that failed to upload previously.
+func (bm *Manager) retryFailedPacksLocked(ctx context.Context) error {
+	// we're making a copy of bm.failedPacks since bm.writePackAndAddToIndex()
+	// will remove from it on success.
+	fp := append([]*pendingPackInfo(nil), bm.failedPacks...)
+	for _, pp := range fp {
+		if err := bm.writePackAndAddToIndex(ctx, pp, true); err != nil {
+			return errors.Wrap(err, "error writing previously failed pack")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,396 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	spoolPackSuffix  = ".pack"
+	spoolIndexSuffix = ".ndx"
+	spoolLockFile    = ".lock"
+)
+
+// ErrPacksSpooled is returned by Flush when some packs or their indexes could not be written to the storage
+// and remain in the local pack spool, from which they will be uploaded later.
+var ErrPacksSpooled = errors.New("some packs have not been uploaded yet and remain in the local pack spool")
+
+// IsPacksSpooled returns true if the provided error returned by Flush indicates that all pending writes have been
+// persisted, but some of them only in the local pack spool, from which they will be uploaded later.
+func IsPacksSpooled(err error) bool {
+	return errors.Cause(err) == ErrPacksSpooled
+}
+
+// errSpoolInUse is returned when the spool is locked by another process sharing the cache directory.
+var errSpoolInUse = errors.New("pack spool is in use by another process")
+
+// packSpool persists finalized packs along with their index fragments in the cache directory before
+// they are uploaded, so that they survive crashes and storage outages and can be uploaded later.
+//
+// A spool entry is complete when its index fragment exists, which is written after the pack data.
+// Entries are removed once the index blob referencing their contents has been written.
+//
+// The spool is locked by the process using it, so that processes sharing the cache directory don't replay
+// the same entries.
+type packSpool struct {
+	dirname  string
+	lockFile *os.File
+
+	mu sync.Mutex
+	// packs that have been spooled but not uploaded, along with their index fragments.
+	notUploaded map[blob.ID]packIndexBuilder
+	// contents of all packs in notUploaded.
+	notUploadedContents packIndexBuilder
+}
+
+func (s *packSpool) lockPath() string {
+	return filepath.Join(s.dirname, spoolLockFile)
+}
+
+// unlock releases the lock acquired by lock().
+func (s *packSpool) unlock() {
+	if s.lockFile != nil {
+		s.lockFile.Close() //nolint:errcheck
+		s.lockFile = nil
+	}
+}
+
+func (s *packSpool) packPath(packBlobID blob.ID) string {
+	return filepath.Join(s.dirname, string(packBlobID)+spoolPackSuffix)
+}
+
+func (s *packSpool) indexPath(packBlobID blob.ID) string {
+	return filepath.Join(s.dirname, string(packBlobID)+spoolIndexSuffix)
+}
+
+// add persists the provided pack data and its index fragment.
+func (s *packSpool) add(packBlobID blob.ID, packData []byte, ndx packIndexBuilder) error {
+	var indexData bytes.Buffer
+
+	// index fragments are only read locally, so they can always use the latest format.
+	if err := ndx.Build(&indexData, indexVersion2); err != nil {
+		return errors.Wrap(err, "unable to build index fragment")
+	}
+
+	if err := writeFileDurably(s.dirname, s.packPath(packBlobID), packData); err != nil {
+		return errors.Wrap(err, "unable to spool pack")
+	}
+
+	if err := writeFileDurably(s.dirname, s.indexPath(packBlobID), indexData.Bytes()); err != nil {
+		return errors.Wrap(err, "unable to spool index fragment")
+	}
+
+	return nil
+}
+
+// remove removes the spool entry of the provided pack.
+func (s *packSpool) remove(packBlobID blob.ID) error {
+	// remove index first, which makes the entry incomplete and ignored.
+	if err := os.Remove(s.indexPath(packBlobID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Remove(s.packPath(packBlobID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// list returns the IDs of packs with complete spool entries.
+func (s *packSpool) list() ([]blob.ID, error) {
+	entries, err := ioutil.ReadDir(s.dirname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "unable to list spool")
+	}
+
+	var result []blob.ID
+
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), spoolIndexSuffix) {
+			result = append(result, blob.ID(strings.TrimSuffix(e.Name(), spoolIndexSuffix)))
+		}
+	}
+
+	return result, nil
+}
+
+// readIndex reads the index fragment of the provided spooled pack.
+func (s *packSpool) readIndex(packBlobID blob.ID, v1PerContentOverhead uint32) (packIndexBuilder, error) {
+	data, err := ioutil.ReadFile(s.indexPath(packBlobID))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read spooled index fragment")
+	}
+
+	ndx, err := openPackIndex(bytes.NewReader(data), v1PerContentOverhead)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open spooled index fragment")
+	}
+
+	result := packIndexBuilder{}
+
+	if err := ndx.Iterate("", func(i Info) error {
+		result.Add(i)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to read spooled index fragment")
+	}
+
+	return result, nil
+}
+
+// readPack reads the data of the provided spooled pack.
+func (s *packSpool) readPack(packBlobID blob.ID) ([]byte, error) {
+	return ioutil.ReadFile(s.packPath(packBlobID))
+}
+
+// readPackRange reads a range of data of the provided spooled pack.
+func (s *packSpool) readPackRange(packBlobID blob.ID, offset, length int64) ([]byte, error) {
+	f, err := os.Open(s.packPath(packBlobID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	result := make([]byte, length)
+	if _, err := f.ReadAt(result, offset); err != nil {
+		return nil, errors.Wrapf(err, "unable to read spooled pack %v", packBlobID)
+	}
+
+	return result, nil
+}
+
+// markNotUploaded records that the provided spooled pack could not be uploaded yet.
+func (s *packSpool) markNotUploaded(packBlobID blob.ID, ndx packIndexBuilder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notUploaded[packBlobID] = ndx
+
+	for _, i := range ndx {
+		s.notUploadedContents.Add(*i)
+	}
+}
+
+// markUploaded records that the provided spooled pack has been uploaded.
+func (s *packSpool) markUploaded(packBlobID blob.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for contentID, i := range s.notUploaded[packBlobID] {
+		if ci := s.notUploadedContents[contentID]; ci != nil && ci.PackBlobID == i.PackBlobID {
+			delete(s.notUploadedContents, contentID)
+		}
+	}
+
+	delete(s.notUploaded, packBlobID)
+}
+
+// notUploadedPacks returns the packs that have not been uploaded yet along with their index fragments.
+func (s *packSpool) notUploadedPacks() map[blob.ID]packIndexBuilder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := map[blob.ID]packIndexBuilder{}
+	for k, v := range s.notUploaded {
+		result[k] = v
+	}
+
+	return result
+}
+
+// getNotUploadedContentInfo returns information about a content stored in a pack that has not been uploaded yet.
+func (s *packSpool) getNotUploadedContentInfo(contentID ID) (Info, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.notUploadedContents[contentID]; i != nil {
+		return *i, true
+	}
+
+	return Info{}, false
+}
+
+// hasNotUploaded returns true if there are packs in the spool that have not been uploaded yet.
+func (s *packSpool) hasNotUploaded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.notUploaded) > 0
+}
+
+// isNotUploaded returns true if the provided pack is in the spool and has not been uploaded yet.
+func (s *packSpool) isNotUploaded(packBlobID blob.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.notUploaded[packBlobID] != nil
+}
+
+// writeFileDurably atomically writes the file and flushes it, along with the directory entry, to stable storage.
+func writeFileDurably(dirname, filename string, data []byte) error {
+	if err := os.MkdirAll(dirname, 0700); err != nil {
+		return errors.Wrap(err, "unable to create directory")
+	}
+
+	tf, err := ioutil.TempFile(dirname, "tmp")
+	if err != nil {
+		return errors.Wrap(err, "can't create tmp file")
+	}
+
+	defer os.Remove(tf.Name()) //nolint:errcheck
+
+	if _, err := tf.Write(data); err != nil {
+		tf.Close() //nolint:errcheck
+		return errors.Wrap(err, "can't write to temp file")
+	}
+
+	if err := tf.Sync(); err != nil {
+		tf.Close() //nolint:errcheck
+		return errors.Wrap(err, "can't sync temp file")
+	}
+
+	if err := tf.Close(); err != nil {
+		return errors.Wrap(err, "can't close tmp file")
+	}
+
+	if err := os.Rename(tf.Name(), filename); err != nil {
+		return errors.Wrap(err, "can't rename tmp file")
+	}
+
+	return errors.Wrap(syncDir(dirname), "can't sync directory")
+}
+
+// uploadSpooledPacksLocked uploads packs from the local spool and adds their contents to the index being built.
+// It stops at the first failure.
+func (bm *Manager) uploadSpooledPacksLocked(ctx context.Context) error {
+	bm.nextSpoolRetryTime = bm.timeNow().Add(spoolRetryInterval)
+
+	for packBlobID, ndx := range bm.spool.notUploadedPacks() {
+		data, err := bm.spool.readPack(packBlobID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read spooled pack %v", packBlobID)
+		}
+
+		if err := bm.writePackFileNotLocked(ctx, packBlobID, data); err != nil {
+			return errors.Wrapf(err, "unable to upload spooled pack %v", packBlobID)
+		}
+
+		formatLog(ctx).Debugf("uploaded spooled pack: %v (%v bytes)", packBlobID, len(data))
+
+		for _, info := range ndx {
+			bm.packIndexBuilder.Add(*info)
+		}
+
+		bm.spool.markUploaded(packBlobID)
+		bm.uncommittedSpooledPacks = append(bm.uncommittedSpooledPacks, packBlobID)
+	}
+
+	return nil
+}
+
+// flushPackIndexesOrKeepSpooledLocked writes pack indexes just like flushPackIndexesLocked, except that failures are
+// tolerated when all pending index entries belong to spooled packs, whose indexes can always be written again
+// from the spool. It returns true when index entries remain pending because of such failure.
+func (bm *Manager) flushPackIndexesOrKeepSpooledLocked(ctx context.Context) (bool, error) {
+	err := bm.flushPackIndexesLocked(ctx)
+	if err == nil {
+		return false, nil
+	}
+
+	if bm.spool == nil || !bm.pendingIndexEntriesSpooledLocked() {
+		return false, err
+	}
+
+	log(ctx).Warningf("unable to write index of spooled packs, will retry later: %v", err)
+
+	bm.flushPackIndexesAfter = bm.timeNow().Add(flushPackIndexTimeout)
+
+	return true, nil
+}
+
+// pendingIndexEntriesSpooledLocked returns true if all pending index entries belong to packs in the spool.
+func (bm *Manager) pendingIndexEntriesSpooledLocked() bool {
+	spooled := map[blob.ID]bool{}
+	for _, packBlobID := range bm.uncommittedSpooledPacks {
+		spooled[packBlobID] = true
+	}
+
+	for _, i := range bm.packIndexBuilder {
+		if !spooled[i.PackBlobID] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// removeCommittedSpoolEntriesLocked removes spool entries of uploaded packs, after their contents have been
+// written to an index blob.
+func (bm *Manager) removeCommittedSpoolEntriesLocked(ctx context.Context) {
+	for _, packBlobID := range bm.uncommittedSpooledPacks {
+		if err := bm.spool.remove(packBlobID); err != nil {
+			log(ctx).Warningf("unable to remove spooled pack %v: %v", packBlobID, err)
+		}
+	}
+
+	bm.uncommittedSpooledPacks = nil
+}
+
+// replaySpool uploads and commits packs left in the local spool by previous sessions.
+func (bm *Manager) replaySpool(ctx context.Context) error {
+	packs, err := bm.spool.list()
+	if err != nil {
+		return err
+	}
+
+	if len(packs) == 0 {
+		return nil
+	}
+
+	log(ctx).Infof("uploading %v packs from the local spool", len(packs))
+
+	for _, packBlobID := range packs {
+		ndx, err := bm.spool.readIndex(packBlobID, bm.perContentOverhead())
+		if err != nil {
+			log(ctx).Warningf("ignoring invalid spool entry %v: %v", packBlobID, err)
+			continue
+		}
+
+		bm.spool.markNotUploaded(packBlobID, ndx)
+	}
+
+	bm.lock()
+	defer bm.unlock()
+
+	if err := bm.uploadSpooledPacksLocked(ctx); err != nil {
+		log(ctx).Warningf("unable to upload spooled packs, will retry later: %v", err)
+	}
+
+	if err := bm.flushPackIndexesLocked(ctx); err != nil {
+		log(ctx).Warningf("unable to write index of spooled packs, will retry later: %v", err)
+	}
+
+	return nil
+}
+
+func newPackSpool(dirname string) *packSpool {
+	return &packSpool{
+		dirname:             dirname,
+		notUploaded:         map[blob.ID]packIndexBuilder{},
+		notUploadedContents: packIndexBuilder{},
+	}
+}
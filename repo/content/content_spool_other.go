@@ -0,0 +1,13 @@
+// +build !linux,!darwin,!freebsd,!windows
+
+package content
+
+// lock is a no-op, processes sharing the cache directory must not use the spool concurrently.
+func (s *packSpool) lock() error {
+	return nil
+}
+
+// syncDir is a no-op.
+func syncDir(dirname string) error {
+	return nil
+}
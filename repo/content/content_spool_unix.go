@@ -0,0 +1,48 @@
+// +build linux darwin freebsd
+
+package content
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lock acquires an exclusive lock of the spool, which is released automatically when the process exits.
+func (s *packSpool) lock() error {
+	if err := os.MkdirAll(s.dirname, 0700); err != nil {
+		return errors.Wrap(err, "unable to create spool directory")
+	}
+
+	f, err := os.OpenFile(s.lockPath(), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return errors.Wrap(err, "unable to open spool lock file")
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close() //nolint:errcheck
+
+		if err == syscall.EWOULDBLOCK {
+			return errSpoolInUse
+		}
+
+		return errors.Wrap(err, "unable to lock spool")
+	}
+
+	s.lockFile = f
+
+	return nil
+}
+
+// syncDir flushes directory entries of the provided directory to stable storage.
+func syncDir(dirname string) error {
+	f, err := os.Open(dirname) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	return f.Sync()
+}
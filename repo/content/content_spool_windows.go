@@ -0,0 +1,37 @@
+package content
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// lock acquires an exclusive lock of the spool by keeping its lock file open, which prevents other processes
+// from removing it, while a lock file left behind by a crashed process can be removed.
+func (s *packSpool) lock() error {
+	if err := os.MkdirAll(s.dirname, 0700); err != nil {
+		return errors.Wrap(err, "unable to create spool directory")
+	}
+
+	if err := os.Remove(s.lockPath()); err != nil && !os.IsNotExist(err) {
+		return errSpoolInUse
+	}
+
+	f, err := os.OpenFile(s.lockPath(), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return errSpoolInUse
+		}
+
+		return errors.Wrap(err, "unable to create spool lock file")
+	}
+
+	s.lockFile = f
+
+	return nil
+}
+
+// syncDir is a no-op, since directories can't be synced on Windows.
+func syncDir(dirname string) error {
+	return nil
+}
@@ -4,13 +4,15 @@ import (
 	"encoding/binary"
 
 	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/compression"
 )
 
 // Format describes a format of a single pack index. The actual structure is not used,
 // it's purely for documentation purposes.
 // The struct is byte-aligned.
 type Format struct {
-	Version    byte   // format version number must be 0x01
+	Version    byte   // format version number must be 0x01 or 0x02
 	KeySize    byte   // size of each key in bytes
 	EntrySize  uint16 // size of each entry in bytes, big-endian
 	EntryCount uint32 // number of sorted (key,value) entries that follow
@@ -32,9 +34,14 @@ type entry struct {
 	packFileOffset    uint32 // 4 bytes, big endian, offset within index file where pack (blob) ID begins
 	packedOffset      uint32 // 4 bytes, big endian, offset within pack file where the contents begin
 	packedLength      uint32 // 4 bytes, big endian, content length
+
+	// version 2 only:
+	originalLength  uint32 // 4 bytes, big endian, length of the content before compression and encryption
+	compression     uint16 // 2 bytes, big endian, compression header ID or zero if not compressed
+	encryptionKeyID byte   // 1 byte, ID of the key used to encrypt the content
 }
 
-func (e *entry) parse(b []byte) error {
+func (e *entry) parse(b []byte, version byte) error {
 	if len(b) < entryFixedHeaderLength {
 		return errors.Errorf("invalid entry length: %v", len(b))
 	}
@@ -44,6 +51,18 @@ func (e *entry) parse(b []byte) error {
 	e.packedOffset = binary.BigEndian.Uint32(b[12:16])
 	e.packedLength = binary.BigEndian.Uint32(b[16:20])
 
+	if version < indexVersion2 {
+		return nil
+	}
+
+	if len(b) < entryV2Length {
+		return errors.Errorf("invalid v2 entry length: %v", len(b))
+	}
+
+	e.originalLength = binary.BigEndian.Uint32(b[20:24])
+	e.compression = binary.BigEndian.Uint16(b[24:26])
+	e.encryptionKeyID = b[26]
+
 	return nil
 }
 
@@ -74,3 +93,15 @@ func (e *entry) PackedOffset() uint32 {
 func (e *entry) PackedLength() uint32 {
 	return e.packedLength
 }
+
+func (e *entry) OriginalLength() uint32 {
+	return e.originalLength
+}
+
+func (e *entry) CompressionHeaderID() compression.HeaderID {
+	return compression.HeaderID(e.compression)
+}
+
+func (e *entry) EncryptionKeyID() byte {
+	return e.encryptionKeyID
+}
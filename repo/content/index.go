@@ -26,9 +26,14 @@ type packIndex interface {
 type index struct {
 	hdr      headerInfo
 	readerAt io.ReaderAt
+
+	// v1PerContentOverhead is the number of bytes added to each content by encryption,
+	// used to compute original length of contents in v1 indexes, which don't store it.
+	v1PerContentOverhead uint32
 }
 
 type headerInfo struct {
+	version    byte
 	keySize    int
 	valueSize  int
 	entryCount int
@@ -41,17 +46,18 @@ func readHeader(readerAt io.ReaderAt) (headerInfo, error) {
 		return headerInfo{}, errors.Wrap(err, "invalid header")
 	}
 
-	if header[0] != 1 {
+	if header[0] != indexVersion1 && header[0] != indexVersion2 {
 		return headerInfo{}, errors.Errorf("invalid header format: %v", header[0])
 	}
 
 	hi := headerInfo{
+		version:    header[0],
 		keySize:    int(header[1]),
 		valueSize:  int(binary.BigEndian.Uint16(header[2:4])),
 		entryCount: int(binary.BigEndian.Uint32(header[4:8])),
 	}
 
-	if hi.keySize <= 1 || hi.valueSize < 0 || hi.entryCount < 0 {
+	if hi.keySize <= 1 || hi.valueSize < minEntryLength(hi.version) || hi.entryCount < 0 {
 		return headerInfo{}, errors.Errorf("invalid header")
 	}
 
@@ -211,7 +217,7 @@ func (b *index) GetInfo(contentID ID) (*Info, error) {
 
 func (b *index) entryToInfo(contentID ID, entryData []byte) (Info, error) {
 	var e entry
-	if err := e.parse(entryData); err != nil {
+	if err := e.parse(entryData, b.hdr.version); err != nil {
 		return Info{}, err
 	}
 
@@ -222,15 +228,30 @@ func (b *index) entryToInfo(contentID ID, entryData []byte) (Info, error) {
 		return Info{}, errors.Wrap(err, "can't read pack content ID")
 	}
 
-	return Info{
-		ID:               contentID,
-		Deleted:          e.IsDeleted(),
-		TimestampSeconds: e.TimestampSeconds(),
-		FormatVersion:    e.PackedFormatVersion(),
-		PackOffset:       e.PackedOffset(),
-		Length:           e.PackedLength(),
-		PackBlobID:       blob.ID(packFile),
-	}, nil
+	i := Info{
+		ID:                  contentID,
+		Deleted:             e.IsDeleted(),
+		TimestampSeconds:    e.TimestampSeconds(),
+		FormatVersion:       e.PackedFormatVersion(),
+		PackOffset:          e.PackedOffset(),
+		Length:              e.PackedLength(),
+		PackBlobID:          blob.ID(packFile),
+		OriginalLength:      e.OriginalLength(),
+		CompressionHeaderID: e.CompressionHeaderID(),
+		EncryptionKeyID:     e.EncryptionKeyID(),
+	}
+
+	if b.hdr.version == indexVersion1 && i.Length >= b.v1PerContentOverhead {
+		// v1 contents are never compressed, so the original length can be derived from the packed length.
+		i.OriginalLength = i.Length - b.v1PerContentOverhead
+	}
+
+	return i, nil
+}
+
+// Version returns the format version of the index.
+func (b *index) Version() byte {
+	return b.hdr.version
 }
 
 // Close closes the index and the underlying reader.
@@ -243,11 +264,20 @@ func (b *index) Close() error {
 }
 
 // openPackIndex reads an Index from a given reader. The caller must call Close() when the index is no longer used.
-func openPackIndex(readerAt io.ReaderAt) (packIndex, error) {
+// v1PerContentOverhead is the encryption overhead used to compute original lengths of contents in v1 indexes.
+func openPackIndex(readerAt io.ReaderAt, v1PerContentOverhead uint32) (packIndex, error) {
 	h, err := readHeader(readerAt)
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid header")
 	}
 
-	return &index{hdr: h, readerAt: readerAt}, nil
+	return &index{hdr: h, readerAt: readerAt, v1PerContentOverhead: v1PerContentOverhead}, nil
+}
+
+func minEntryLength(version byte) int {
+	if version == indexVersion1 {
+		return entryFixedHeaderLength
+	}
+
+	return entryV2Length
 }
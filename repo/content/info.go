@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
 )
 
 // ID is an identifier of content in content-addressable storage.
@@ -32,6 +33,10 @@ type Info struct {
 	PackOffset       uint32  `json:"packOffset,omitempty"`
 	Deleted          bool    `json:"deleted"`
 	FormatVersion    byte    `json:"formatVersion"`
+
+	OriginalLength      uint32               `json:"originalLength"`
+	CompressionHeaderID compression.HeaderID `json:"compression,omitempty"`
+	EncryptionKeyID     byte                 `json:"encryptionKeyID,omitempty"`
 }
 
 // Timestamp returns the time when a content was created or deleted.
@@ -21,9 +21,14 @@ type listCache struct {
 	cacheFile         string
 	listCacheDuration time.Duration
 	hmacSecret        []byte
+
+	// useExpiredOnError enables using expired cached list when the storage can't be listed.
+	useExpiredOnError bool
 }
 
 func (c *listCache) listIndexBlobs(ctx context.Context) ([]IndexBlobInfo, error) {
+	var cached *cachedList
+
 	if c.cacheFile != "" {
 		ci, err := c.readContentsFromCache(ctx)
 		if err == nil {
@@ -32,12 +37,19 @@ func (c *listCache) listIndexBlobs(ctx context.Context) ([]IndexBlobInfo, error)
 				log(ctx).Debugf("retrieved list of index blobs from cache")
 				return ci.Contents, nil
 			}
+
+			cached = ci
 		} else if err != blob.ErrBlobNotFound {
 			log(ctx).Warningf("unable to open cache file: %v", err)
 		}
 	}
 
 	contents, err := listIndexBlobsFromStorage(ctx, c.st)
+	if err != nil && c.useExpiredOnError && cached != nil {
+		log(ctx).Warningf("unable to list index blobs, using list cached at %v: %v", cached.Timestamp, err)
+		return cached.Contents, nil
+	}
+
 	if err == nil {
 		c.saveListToCache(ctx, &cachedList{
 			Contents:  contents,
@@ -147,6 +159,7 @@ func newListCache(st blob.Storage, caching CachingOptions) (*listCache, error) {
 		cacheFile:         listCacheFile,
 		hmacSecret:        caching.HMACSecret,
 		listCacheDuration: time.Duration(caching.MaxListCacheDurationSec) * time.Second,
+		useExpiredOnError: caching.PackSpool,
 	}
 
 	if caching.IgnoreListCache {
@@ -93,9 +93,9 @@ func indexWithItems(items ...Info) (packIndex, error) {
 	}
 
 	var buf bytes.Buffer
-	if err := b.Build(&buf); err != nil {
+	if err := b.Build(&buf, indexVersion2); err != nil {
 		return nil, errors.Wrap(err, "build error")
 	}
 
-	return openPackIndex(bytes.NewReader(buf.Bytes()))
+	return openPackIndex(bytes.NewReader(buf.Bytes()), 0)
 }
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
 )
 
 func deterministicContentID(prefix string, id int) ID {
@@ -55,6 +56,14 @@ func deterministicFormatVersion(id int) byte {
 	return byte(id % 100)
 }
 
+func deterministicCompressionHeaderID(id int) compression.HeaderID {
+	if id%2 == 0 {
+		return 0
+	}
+
+	return compression.HeaderID(0x1000 + id%4)
+}
+
 func randomUnixTime() int64 {
 	return int64(rand.Int31())
 }
@@ -78,12 +87,15 @@ func TestPackIndex(t *testing.T) {
 	// non-deleted content
 	for i := 0; i < 100; i++ {
 		infos = append(infos, Info{
-			TimestampSeconds: randomUnixTime(),
-			ID:               deterministicContentID("packed", i),
-			PackBlobID:       deterministicPackBlobID(i),
-			PackOffset:       deterministicPackedOffset(i),
-			Length:           deterministicPackedLength(i),
-			FormatVersion:    deterministicFormatVersion(i),
+			TimestampSeconds:    randomUnixTime(),
+			ID:                  deterministicContentID("packed", i),
+			PackBlobID:          deterministicPackBlobID(i),
+			PackOffset:          deterministicPackedOffset(i),
+			Length:              deterministicPackedLength(i),
+			FormatVersion:       deterministicFormatVersion(i),
+			OriginalLength:      deterministicPackedLength(i + 100),
+			CompressionHeaderID: deterministicCompressionHeaderID(i),
+			EncryptionKeyID:     byte(i % 3),
 		})
 	}
 
@@ -101,15 +113,15 @@ func TestPackIndex(t *testing.T) {
 
 	var buf1, buf2, buf3 bytes.Buffer
 
-	if err := b1.Build(&buf1); err != nil {
+	if err := b1.Build(&buf1, indexVersion2); err != nil {
 		t.Errorf("unable to build: %v", err)
 	}
 
-	if err := b1.Build(&buf2); err != nil {
+	if err := b1.Build(&buf2, indexVersion2); err != nil {
 		t.Errorf("unable to build: %v", err)
 	}
 
-	if err := b1.Build(&buf3); err != nil {
+	if err := b1.Build(&buf3, indexVersion2); err != nil {
 		t.Errorf("unable to build: %v", err)
 	}
 
@@ -129,7 +141,7 @@ func TestPackIndex(t *testing.T) {
 		fuzzTestIndexOpen(data1)
 	})
 
-	ndx, err := openPackIndex(bytes.NewReader(data1))
+	ndx, err := openPackIndex(bytes.NewReader(data1), 0)
 	if err != nil {
 		t.Fatalf("can't open index: %v", err)
 	}
@@ -191,12 +203,60 @@ func TestPackIndex(t *testing.T) {
 	}
 }
 
+func TestPackIndexV1(t *testing.T) {
+	const overhead = 28
+
+	b := make(packIndexBuilder)
+	b.Add(Info{ID: "abcd", PackBlobID: "p1", PackOffset: 10, Length: 100 + overhead, TimestampSeconds: 1, FormatVersion: 1})
+	b.Add(Info{ID: "xabcd", PackBlobID: "p2", PackOffset: 20, Length: overhead, TimestampSeconds: 2, FormatVersion: 1, Deleted: true})
+
+	var buf bytes.Buffer
+	if err := b.Build(&buf, indexVersion1); err != nil {
+		t.Fatalf("unable to build: %v", err)
+	}
+
+	if got, want := buf.Bytes()[0], byte(indexVersion1); got != want {
+		t.Fatalf("unexpected index version %v, want %v", got, want)
+	}
+
+	ndx, err := openPackIndex(bytes.NewReader(buf.Bytes()), overhead)
+	if err != nil {
+		t.Fatalf("can't open index: %v", err)
+	}
+	defer ndx.Close()
+
+	for id, wantOriginal := range map[ID]uint32{"abcd": 100, "xabcd": 0} {
+		i, err := ndx.GetInfo(id)
+		if err != nil || i == nil {
+			t.Fatalf("unable to get %v: %v", id, err)
+		}
+
+		if i.OriginalLength != wantOriginal {
+			t.Errorf("unexpected original length of %v: %v, want %v", id, i.OriginalLength, wantOriginal)
+		}
+
+		if i.CompressionHeaderID != 0 || i.EncryptionKeyID != 0 {
+			t.Errorf("unexpected v2 fields of %v: %+v", id, i)
+		}
+	}
+}
+
+func TestPackIndexV2InvalidCompression(t *testing.T) {
+	b := make(packIndexBuilder)
+	b.Add(Info{ID: "abcd", PackBlobID: "p1", Length: 10, TimestampSeconds: 1, CompressionHeaderID: 0x10000})
+
+	var buf bytes.Buffer
+	if err := b.Build(&buf, indexVersion2); err == nil {
+		t.Fatalf("unexpected success building index with invalid compression header ID")
+	}
+}
+
 func fuzzTestIndexOpen(originalData []byte) {
 	// use consistent random
 	rnd := rand.New(rand.NewSource(12345))
 
 	fuzzTest(rnd, originalData, 50000, func(d []byte) {
-		ndx, err := openPackIndex(bytes.NewReader(d))
+		ndx, err := openPackIndex(bytes.NewReader(d), 0)
 		if err != nil {
 			return
 		}
@@ -1,7 +1,10 @@
 package content
 
 import (
+	"sync"
 	"sync/atomic"
+
+	"github.com/kopia/kopia/repo/compression"
 )
 
 // Stats exposes statistics about content operation.
@@ -14,11 +17,26 @@ type Stats struct {
 	encryptedBytes int64
 	hashedBytes    int64
 
+	// current size of the pack upload queue, not affected by Reset()
+	queuedPackBytes int64
+	queuedPacks     int32
+
 	readContents    uint32
 	writtenContents uint32
 	hashedContents  uint32
 	invalidContents uint32
 	validContents   uint32
+
+	compressionMutex sync.Mutex
+	compression      map[compression.Name]*CompressionStats
+}
+
+// CompressionStats contains statistics about contents compressed by the content manager using a single compressor.
+type CompressionStats struct {
+	CompressedContents     uint32 `json:"compressedContents"`
+	IncompressibleContents uint32 `json:"incompressibleContents"` // stored uncompressed because compression did not reduce size
+	OriginalBytes          int64  `json:"originalBytes"`
+	CompressedBytes        int64  `json:"compressedBytes"`
 }
 
 // Reset clears all content statistics.
@@ -34,6 +52,29 @@ func (s *Stats) Reset() {
 	atomic.StoreUint32(&s.hashedContents, 0)
 	atomic.StoreUint32(&s.invalidContents, 0)
 	atomic.StoreUint32(&s.validContents, 0)
+
+	s.compressionMutex.Lock()
+	s.compression = nil
+	s.compressionMutex.Unlock()
+}
+
+// Compression returns compression statistics for each compressor used by the content manager.
+func (s *Stats) Compression() map[compression.Name]CompressionStats {
+	s.compressionMutex.Lock()
+	defer s.compressionMutex.Unlock()
+
+	result := map[compression.Name]CompressionStats{}
+	for k, v := range s.compression {
+		result[k] = *v
+	}
+
+	return result
+}
+
+// PackUploadQueue returns the number and total size of packs that are waiting to be uploaded
+// or are being uploaded in the background.
+func (s *Stats) PackUploadQueue() (count int32, bytes int64) {
+	return atomic.LoadInt32(&s.queuedPacks), atomic.LoadInt64(&s.queuedPackBytes)
 }
 
 // ReadContent returns the approximate read content count and their total size in bytes
@@ -91,6 +132,41 @@ func (s *Stats) hashedContent(size int) (count uint32, sum int64) {
 	return updateCountSum(&s.hashedContents, &s.hashedBytes, size)
 }
 
+func (s *Stats) compressed(name compression.Name, originalSize, compressedSize int) {
+	s.compressionMutex.Lock()
+	defer s.compressionMutex.Unlock()
+
+	if s.compression == nil {
+		s.compression = map[compression.Name]*CompressionStats{}
+	}
+
+	cs := s.compression[name]
+	if cs == nil {
+		cs = &CompressionStats{}
+		s.compression[name] = cs
+	}
+
+	if compressedSize >= originalSize {
+		cs.IncompressibleContents++
+		compressedSize = originalSize
+	} else {
+		cs.CompressedContents++
+	}
+
+	cs.OriginalBytes += int64(originalSize)
+	cs.CompressedBytes += int64(compressedSize)
+}
+
+func (s *Stats) packUploadQueued(size int) {
+	atomic.AddInt32(&s.queuedPacks, 1)
+	atomic.AddInt64(&s.queuedPackBytes, int64(size))
+}
+
+func (s *Stats) packUploadDequeued(size int) {
+	atomic.AddInt32(&s.queuedPacks, -1)
+	atomic.AddInt64(&s.queuedPackBytes, -int64(size))
+}
+
 func (s *Stats) foundValidContent() uint32 {
 	return atomic.AddUint32(&s.validContents, 1)
 }
@@ -138,8 +138,9 @@ func TestCiphertextSamples(t *testing.T) {
 			samples: map[string]string{
 				"NONE": hex.EncodeToString([]byte("foo")),
 
-				"AES256-GCM-HMAC-SHA256":        "e43ba07f85a6d70c5f1102ca06cf19c597e5f91e527b21f00fb76e8bec3fd1",
-				"CHACHA20-POLY1305-HMAC-SHA256": "118359f3d4d589d939efbbc3168ae4c77c51bcebce6845fe6ef5d11342faa6",
+				"AES256-GCM-HMAC-SHA256":         "e43ba07f85a6d70c5f1102ca06cf19c597e5f91e527b21f00fb76e8bec3fd1",
+				"CHACHA20-POLY1305-HMAC-SHA256":  "118359f3d4d589d939efbbc3168ae4c77c51bcebce6845fe6ef5d11342faa6",
+				"XCHACHA20-POLY1305-HMAC-SHA256": "154195017fd095962726da7709f2e0519480d91be742159044bf72ef374fce5f2177204b07d5ed1ba1fab3",
 
 				// deprecated
 				"AES-128-CTR":  "54cd8d",
@@ -158,8 +159,9 @@ func TestCiphertextSamples(t *testing.T) {
 			samples: map[string]string{
 				"NONE": hex.EncodeToString([]byte("quick brown fox jumps over the lazy dog")),
 
-				"AES256-GCM-HMAC-SHA256":        "eaad755a238f1daa4052db2e5ccddd934790b6cca415b3ccfd46ac5746af33d9d30f4400ffa9eb3a64fb1ce21b888c12c043bf6787d4a5c15ad10f21f6a6027ee3afe0",
-				"CHACHA20-POLY1305-HMAC-SHA256": "836d2ba87892711077adbdbe1452d3b2c590bbfdf6fd3387dc6810220a32ec19de862e1a4f865575e328424b5f178afac1b7eeff11494f719d119b7ebb924d1d0846a3",
+				"AES256-GCM-HMAC-SHA256":         "eaad755a238f1daa4052db2e5ccddd934790b6cca415b3ccfd46ac5746af33d9d30f4400ffa9eb3a64fb1ce21b888c12c043bf6787d4a5c15ad10f21f6a6027ee3afe0",
+				"CHACHA20-POLY1305-HMAC-SHA256":  "836d2ba87892711077adbdbe1452d3b2c590bbfdf6fd3387dc6810220a32ec19de862e1a4f865575e328424b5f178afac1b7eeff11494f719d119b7ebb924d1d0846a3",
+				"XCHACHA20-POLY1305-HMAC-SHA256": "9f76b6e3fad7240c707935b7e5d4f0f6a4c05b4af8b655ce9d2f9dada0123894dd9f861083b8fc231ed86845eadb76a683889c1c167102c40b8106d9429f66864d7800008ded3bb35e81335754d09e",
 
 				// deprecated
 				"AES-128-CTR":  "974c5c1782076e3de7255deabe8706a509b5772a8b7a8e7f83d01de7098c945934417071ec5351",
@@ -155,19 +155,28 @@ func verifyFormatBlobChecksum(b []byte) ([]byte, bool) {
 }
 
 func writeFormatBlob(ctx context.Context, st blob.Storage, f *formatBlob) error {
+	b, err := serializeFormatBlob(f)
+	if err != nil {
+		return err
+	}
+
+	if err := st.PutBlob(ctx, FormatBlobID, b); err != nil {
+		return errors.Wrap(err, "unable to write format blob")
+	}
+
+	return nil
+}
+
+func serializeFormatBlob(f *formatBlob) ([]byte, error) {
 	var buf bytes.Buffer
 	e := json.NewEncoder(&buf)
 	e.SetIndent("", "  ")
 
 	if err := e.Encode(f); err != nil {
-		return errors.Wrap(err, "unable to marshal format blob")
-	}
-
-	if err := st.PutBlob(ctx, FormatBlobID, buf.Bytes()); err != nil {
-		return errors.Wrap(err, "unable to write format blob")
+		return nil, errors.Wrap(err, "unable to marshal format blob")
 	}
 
-	return nil
+	return buf.Bytes(), nil
 }
 
 func (f *formatBlob) decryptFormatBytes(masterKey []byte) (*repositoryObjectFormat, error) {
@@ -0,0 +1,29 @@
+package hashing
+
+import (
+	"hash"
+
+	"github.com/pkg/errors"
+	"lukechampine.com/blake3"
+)
+
+const blake3KeySize = 32
+
+// newBlake3 returns keyed BLAKE3 hash producing 256-bit output. BLAKE3 requires a key of exactly 32 bytes,
+// so shorter keys are padded with zeros, similar to BLAKE2 which accepts keys of up to 32 bytes.
+func newBlake3(key []byte) (hash.Hash, error) {
+	if len(key) > blake3KeySize {
+		return nil, errors.Errorf("invalid BLAKE3 key size %v, must be at most %v bytes", len(key), blake3KeySize)
+	}
+
+	var paddedKey [blake3KeySize]byte
+
+	copy(paddedKey[:], key)
+
+	return blake3.New(32, paddedKey[:]), nil //nolint:gomnd
+}
+
+func init() {
+	Register("BLAKE3-256", truncatedKeyedHashFuncFactory(newBlake3, 32))
+	Register("BLAKE3-256-128", truncatedKeyedHashFuncFactory(newBlake3, 16))
+}
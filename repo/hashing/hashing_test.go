@@ -3,6 +3,7 @@ package hashing_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
 	"testing"
 
 	"github.com/kopia/kopia/repo/hashing"
@@ -55,3 +56,83 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestHashSamples(t *testing.T) {
+	cases := []struct {
+		hmacSecret []byte
+		payload    []byte
+		samples    map[string]string
+	}{
+		{
+			hmacSecret: []byte("01234567890123456789012345678901"), // 32 bytes
+			payload:    []byte(""),
+
+			// samples of base16-encoded hashes of payload computed with hmacSecret
+			samples: map[string]string{
+				"BLAKE2B-256":     "1ff6aadca63f144cf3eb7bac625a5cce878171f7cd6fa0bdccc877e3208c2627",
+				"BLAKE2B-256-128": "1ff6aadca63f144cf3eb7bac625a5cce",
+				"BLAKE2S-128":     "38f45b49960b62c8b89085fc944726f5",
+				"BLAKE2S-256":     "e2f4118b0087fc90a4f46454ec01c32243de9ec16ee5964190f7bf919dc91827",
+				"BLAKE3-256":      "6531ff4050cd34b59789c4ab7a7e499c9d612dadd3dc82404c5fd1c0d4665608",
+				"BLAKE3-256-128":  "6531ff4050cd34b59789c4ab7a7e499c",
+				"HMAC-SHA224":     "cda5e0feb8c83cc0618c99868052b4626991fef8685c69789bcef4f2",
+				"HMAC-SHA256":     "0427d5bfaae59d1b082a98c2642c56be1e6263049269b457a57f572678ae3607",
+				"HMAC-SHA256-128": "0427d5bfaae59d1b082a98c2642c56be",
+				"HMAC-SHA3-224":   "3ef5b24191e6214905d358bd703522a986c18b9297488a683620f1e4",
+				"HMAC-SHA3-256":   "7b49ba505c5351094bf8028c39a4943aa8437c8baa418bb0500a38786c3560f4",
+			},
+		},
+		{
+			hmacSecret: []byte("01234567890123456789012345678901"), // 32 bytes
+			payload:    []byte("quick brown fox jumps over the lazy dog"),
+
+			// samples of base16-encoded hashes of payload computed with hmacSecret
+			samples: map[string]string{
+				"BLAKE2B-256":     "95afa46a854fda6e323183783c39befc2065174ffc2dbd014be7244fb8c56a56",
+				"BLAKE2B-256-128": "95afa46a854fda6e323183783c39befc",
+				"BLAKE2S-128":     "6d6ad4783611d492522dbe771721006f",
+				"BLAKE2S-256":     "02ebf3fe162dfbe5bad205c4a17570097ad99ec4e0962ae92481088846f44698",
+				"BLAKE3-256":      "ee9eb47095b5404ab4ee5bf49c819cc19ab6ad165697de6e764af64e467b42ba",
+				"BLAKE3-256-128":  "ee9eb47095b5404ab4ee5bf49c819cc1",
+				"HMAC-SHA224":     "45ca11c126847e7d62e879e3e0b09fef4b3e8f11f926b1d8261022b7",
+				"HMAC-SHA256":     "a4f80b7078ae6b6984714fdea294b515939d819a909fecb912c89687e12be709",
+				"HMAC-SHA256-128": "a4f80b7078ae6b6984714fdea294b515",
+				"HMAC-SHA3-224":   "166fe7bb69bf922b6fac4dda82dd08a06d4df5253fa422b256ba65e3",
+				"HMAC-SHA3-256":   "0525c7ff1bfc367a1a87bf4c61ba7c3408d3bde127c0eea7c3b79c55d7be01f9",
+			},
+		},
+		{
+			// test vector from the BLAKE3 reference implementation
+			hmacSecret: []byte("whats the Elvish word for friend"),
+			payload:    []byte(""),
+			samples: map[string]string{
+				"BLAKE3-256": "92b2b75604ed3c761f9d6f62392c8a9227ad0ea3f09573e783f1498a4ed60d26",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		for hashingAlgo, want := range tc.samples {
+			f, err := hashing.CreateHashFunc(parameters{hashingAlgo, tc.hmacSecret})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := hex.EncodeToString(f(nil, tc.payload)); got != want {
+				t.Errorf("invalid hash of %q for %v: %v, want %v", tc.payload, hashingAlgo, got, want)
+			}
+		}
+	}
+
+	for _, hashingAlgo := range hashing.SupportedAlgorithms() {
+		if _, ok := cases[0].samples[hashingAlgo]; !ok {
+			t.Errorf("missing hash sample for %q", hashingAlgo)
+		}
+	}
+}
+
+func TestBlake3InvalidKey(t *testing.T) {
+	if _, err := hashing.CreateHashFunc(parameters{"BLAKE3-256", make([]byte, 33)}); err == nil {
+		t.Errorf("expected error for invalid key size")
+	}
+}
@@ -56,13 +56,26 @@ func Initialize(ctx context.Context, st blob.Storage, opt *NewRepositoryOptions,
 	}
 
 	format := formatBlobFromOptions(opt)
+	repoConfig := repositoryObjectFormatFromOptions(opt)
+
+	if v := repoConfig.Version; v < content.FormatVersion1 || v > content.DefaultFormatVersion {
+		return errors.Errorf("unsupported format version: %v", v)
+	}
+
+	if err := repoConfig.ValidateFormatVersion(); err != nil {
+		return err
+	}
+
+	if err := validateMaxPackSize(repoConfig.MaxPackSize, repoConfig.Splitter); err != nil {
+		return errors.Wrap(err, "invalid max pack size")
+	}
 
 	masterKey, err := format.deriveMasterKeyFromPassword(password)
 	if err != nil {
 		return errors.Wrap(err, "unable to derive master key")
 	}
 
-	if err := encryptFormatBytes(format, repositoryObjectFormatFromOptions(opt), masterKey, format.UniqueID); err != nil {
+	if err := encryptFormatBytes(format, repoConfig, masterKey, format.UniqueID); err != nil {
 		return errors.Wrap(err, "unable to encrypt format bytes")
 	}
 
@@ -93,12 +106,15 @@ func formatBlobFromOptions(opt *NewRepositoryOptions) *formatBlob {
 func repositoryObjectFormatFromOptions(opt *NewRepositoryOptions) *repositoryObjectFormat {
 	f := &repositoryObjectFormat{
 		FormattingOptions: content.FormattingOptions{
-			Version:     1,
+			Version:     applyDefaultInt(opt.BlockFormat.Version, content.DefaultFormatVersion),
 			Hash:        applyDefaultString(opt.BlockFormat.Hash, hashing.DefaultAlgorithm),
 			Encryption:  applyDefaultString(opt.BlockFormat.Encryption, encryption.DefaultAlgorithm),
 			HMACSecret:  applyDefaultRandomBytes(opt.BlockFormat.HMACSecret, hmacSecretLength), //nolint:gomnd
 			MasterKey:   applyDefaultRandomBytes(opt.BlockFormat.MasterKey, masterKeyLength),   //nolint:gomnd
 			MaxPackSize: applyDefaultInt(opt.BlockFormat.MaxPackSize, 20<<20),                  //nolint:gomnd
+
+			MetadataCompression: opt.BlockFormat.MetadataCompression,
+			EpochParameters:     opt.BlockFormat.EpochParameters,
 		},
 		Format: object.Format{
 			Splitter: applyDefaultString(opt.ObjectFormat.Splitter, splitter.DefaultAlgorithm),
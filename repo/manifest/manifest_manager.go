@@ -402,7 +402,7 @@ func (m *Manager) maybeCompactLocked(ctx context.Context) error {
 		return errors.Wrap(err, "unable to compact manifest contents")
 	}
 
-	if err := m.b.Flush(ctx); err != nil {
+	if err := m.b.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
 		return errors.Wrap(err, "unable to flush contents after auto-compaction")
 	}
 
@@ -0,0 +1,62 @@
+package object
+
+import (
+	"math"
+)
+
+const (
+	// number and size of evenly spaced samples used to estimate entropy of a chunk.
+	entropySampleCount = 16
+	entropySampleSize  = 4096
+
+	// chunks with estimated entropy above this number of bits per byte are considered incompressible,
+	// which is the case for already compressed or encrypted data.
+	incompressibleEntropyBitsPerByte = 7.8
+
+	// DefaultMinCompressionSavingsPercent is the default minimum savings of compression in adaptive mode.
+	DefaultMinCompressionSavingsPercent = 5
+)
+
+// estimateEntropy returns the Shannon entropy of bytes in a sample of the provided data, in bits per byte.
+func estimateEntropy(data []byte) float64 {
+	var (
+		histogram [256]int
+		total     int
+	)
+
+	if len(data) <= entropySampleCount*entropySampleSize {
+		for _, b := range data {
+			histogram[b]++
+		}
+
+		total = len(data)
+	} else {
+		stride := len(data) / entropySampleCount
+
+		for i := 0; i < entropySampleCount; i++ {
+			for _, b := range data[i*stride : i*stride+entropySampleSize] {
+				histogram[b]++
+			}
+		}
+
+		total = entropySampleCount * entropySampleSize
+	}
+
+	var entropy float64
+
+	for _, n := range histogram {
+		if n == 0 {
+			continue
+		}
+
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// isLikelyIncompressible returns true if the provided data is unlikely to be compressible.
+func isLikelyIncompressible(data []byte) bool {
+	return estimateEntropy(data) > incompressibleEntropyBitsPerByte
+}
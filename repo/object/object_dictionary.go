@@ -0,0 +1,101 @@
+package object
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// DictionaryContentPrefix is the prefix of contents holding compression dictionaries.
+const DictionaryContentPrefix content.ID = "y"
+
+// dictionaryDecompressorBase is the compressor used to decompress data compressed with a dictionary,
+// since compression level does not affect decompression.
+const dictionaryDecompressorBase compression.Name = "zstd"
+
+// dictionaryHeaderID returns the compact representation of the dictionary ID stored in compression headers,
+// which consists of content prefix followed by the binary content hash.
+func dictionaryHeaderID(dictionaryID content.ID) (string, error) {
+	h, err := hex.DecodeString(string(dictionaryID[1:]))
+	if err != nil || dictionaryID.Prefix() != DictionaryContentPrefix {
+		return "", errors.Errorf("invalid dictionary ID: %v", dictionaryID)
+	}
+
+	return string(DictionaryContentPrefix) + string(h), nil
+}
+
+// dictionaryIDFromHeaderID is the inverse of dictionaryHeaderID().
+func dictionaryIDFromHeaderID(s string) (content.ID, error) {
+	if len(s) < 2 || content.ID(s[0:1]) != DictionaryContentPrefix {
+		return "", errors.Errorf("invalid dictionary ID in compression header")
+	}
+
+	return DictionaryContentPrefix + content.ID(hex.EncodeToString([]byte(s[1:]))), nil
+}
+
+// WriteDictionary stores the provided compression dictionary in the repository and returns its ID,
+// which can be passed to WriterOptions.
+func (om *Manager) WriteDictionary(ctx context.Context, data []byte) (content.ID, error) {
+	if len(data) == 0 {
+		return "", errors.New("empty dictionary")
+	}
+
+	return om.contentMgr.WriteContent(ctx, data, DictionaryContentPrefix)
+}
+
+// ReadDictionary returns the compression dictionary with the provided ID.
+func (om *Manager) ReadDictionary(ctx context.Context, dictionaryID content.ID) ([]byte, error) {
+	if dictionaryID.Prefix() != DictionaryContentPrefix {
+		return nil, errors.Errorf("invalid dictionary ID: %v", dictionaryID)
+	}
+
+	return om.contentMgr.GetContent(ctx, dictionaryID)
+}
+
+// dictionaryCompressor returns the compressor using the provided dictionary with compression level of the provided zstd compressor.
+func (om *Manager) dictionaryCompressor(ctx context.Context, base compression.Name, dictionaryID content.ID) (compression.Compressor, error) {
+	key := string(base) + "/" + string(dictionaryID)
+
+	om.dictionaryCompressorsMutex.Lock()
+	c := om.dictionaryCompressors[key]
+	om.dictionaryCompressorsMutex.Unlock()
+
+	if c != nil {
+		return c, nil
+	}
+
+	baseCompressor := compression.ByName[base]
+	if baseCompressor == nil {
+		return nil, errors.Errorf("unsupported compressor %v", base)
+	}
+
+	headerID, err := dictionaryHeaderID(dictionaryID)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, err := om.ReadDictionary(ctx, dictionaryID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read dictionary")
+	}
+
+	c, err = compression.NewDictionaryCompressor(baseCompressor, headerID, dict)
+	if err != nil {
+		return nil, err
+	}
+
+	om.dictionaryCompressorsMutex.Lock()
+	defer om.dictionaryCompressorsMutex.Unlock()
+
+	if om.dictionaryCompressors == nil {
+		om.dictionaryCompressors = map[string]compression.Compressor{}
+	}
+
+	om.dictionaryCompressors[key] = c
+
+	return c, nil
+}
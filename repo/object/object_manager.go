@@ -4,8 +4,9 @@ package object
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"io"
+	"io/ioutil"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -18,12 +19,17 @@ import (
 // maxCompressionOverheadPerSegment is maximum overhead that compression can incur.
 const maxCompressionOverheadPerSegment = 16384
 
+// defaultReadAheadMaxBytes is the default limit of total size of chunks fetched ahead by a single reader.
+const defaultReadAheadMaxBytes = 64 << 20
+
 // ErrObjectNotFound is returned when an object cannot be found.
 var ErrObjectNotFound = errors.New("object not found")
 
 // Reader allows reading, seeking, getting the length of and closing of a repository object.
+// ReadAt() does not affect the position used by Read() and Seek() and can be called concurrently.
 type Reader interface {
 	io.Reader
+	io.ReaderAt
 	io.Seeker
 	io.Closer
 	Length() int64
@@ -33,6 +39,7 @@ type contentManager interface {
 	ContentInfo(ctx context.Context, contentID content.ID) (content.Info, error)
 	GetContent(ctx context.Context, contentID content.ID) ([]byte, error)
 	WriteContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error)
+	WriteCompressedContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error)
 }
 
 // Format describes the format of objects in a repository.
@@ -49,7 +56,22 @@ type Manager struct {
 
 	newSplitter splitter.Factory
 
+	splittersMutex sync.Mutex
+	splitters      map[string]splitter.Factory // pooled factories of splitters requested in WriterOptions
+
 	bufferPool *buf.Pool
+
+	dictionaryCompressorsMutex sync.Mutex
+	dictionaryCompressors      map[string]compression.Compressor // by compressor name and dictionary ID
+
+	readAheadChunks   int
+	readAheadMaxBytes int64
+
+	// asyncWritesSemaphore limits the number of chunks written in the background by all writers, nil == disabled.
+	asyncWritesSemaphore chan struct{}
+
+	seekTables       *seekTableCache
+	binarySeekTables bool
 }
 
 // NewWriter creates an ObjectWriter for writing to the repository.
@@ -57,10 +79,22 @@ func (om *Manager) NewWriter(ctx context.Context, opt WriterOptions) Writer {
 	w := &objectWriter{
 		ctx:         ctx,
 		om:          om,
-		splitter:    om.newSplitter(),
+		splitter:    om.splitterFactory(opt.Splitter)(),
 		description: opt.Description,
 		prefix:      opt.Prefix,
-		compressor:  compression.ByName[opt.Compressor],
+		compressor:  om.compressor(ctx, opt),
+
+		adaptiveCompression:          opt.AdaptiveCompression,
+		minCompressionSavingsPercent: DefaultMinCompressionSavingsPercent,
+	}
+
+	if opt.MinCompressionSavingsPercent != nil {
+		w.minCompressionSavingsPercent = *opt.MinCompressionSavingsPercent
+	}
+
+	if om.asyncWritesSemaphore != nil {
+		w.asyncWritesSemaphore = om.asyncWritesSemaphore
+		w.asyncWriteOIDs = map[int]ID{}
 	}
 
 	w.initBuffer()
@@ -68,6 +102,51 @@ func (om *Manager) NewWriter(ctx context.Context, opt WriterOptions) Writer {
 	return w
 }
 
+// compressor returns the compressor requested in WriterOptions, which falls back to compressing
+// without the dictionary if the dictionary cannot be used.
+func (om *Manager) compressor(ctx context.Context, opt WriterOptions) compression.Compressor {
+	c := compression.ByName[opt.Compressor]
+	if c == nil || opt.CompressionDictionary == "" {
+		return c
+	}
+
+	dc, err := om.dictionaryCompressor(ctx, opt.Compressor, opt.CompressionDictionary)
+	if err != nil {
+		om.trace("unable to use compression dictionary %v, compressing without it: %v", opt.CompressionDictionary, err)
+		return c
+	}
+
+	return dc
+}
+
+// splitterFactory returns the factory of splitters with the provided name, which defaults to the repository splitter.
+func (om *Manager) splitterFactory(name string) splitter.Factory {
+	if name == "" || name == om.Format.Splitter {
+		return om.newSplitter
+	}
+
+	om.splittersMutex.Lock()
+	defer om.splittersMutex.Unlock()
+
+	if f := om.splitters[name]; f != nil {
+		return f
+	}
+
+	f := splitter.GetFactory(name)
+	if f == nil {
+		om.trace("unsupported splitter %q, using %q", name, om.Format.Splitter)
+		return om.newSplitter
+	}
+
+	if om.splitters == nil {
+		om.splitters = map[string]splitter.Factory{}
+	}
+
+	om.splitters[name] = splitter.Pooled(f)
+
+	return om.splitters[name]
+}
+
 // Open creates new ObjectReader for reading given object from a repository.
 func (om *Manager) Open(ctx context.Context, objectID ID) (Reader, error) {
 	return om.openAndAssertLength(ctx, objectID, -1)
@@ -75,13 +154,7 @@ func (om *Manager) Open(ctx context.Context, objectID ID) (Reader, error) {
 
 func (om *Manager) openAndAssertLength(ctx context.Context, objectID ID, assertLength int64) (Reader, error) {
 	if indexObjectID, ok := objectID.IndexObjectID(); ok {
-		rd, err := om.Open(ctx, indexObjectID)
-		if err != nil {
-			return nil, err
-		}
-		defer rd.Close() //nolint:errcheck
-
-		seekTable, err := om.flattenListChunk(rd)
+		seekTable, err := om.seekTable(ctx, indexObjectID)
 		if err != nil {
 			return nil, err
 		}
@@ -89,10 +162,13 @@ func (om *Manager) openAndAssertLength(ctx context.Context, objectID ID, assertL
 		totalLength := seekTable[len(seekTable)-1].endOffset()
 
 		return &objectReader{
-			ctx:         ctx,
-			repo:        om,
-			seekTable:   seekTable,
-			totalLength: totalLength,
+			ctx:               ctx,
+			repo:              om,
+			seekTable:         seekTable,
+			totalLength:       totalLength,
+			readAheadChunks:   om.readAheadChunks,
+			readAheadMaxBytes: om.readAheadMaxBytes,
+			lastOpenedChunk:   -1,
 		}, nil
 	}
 
@@ -116,13 +192,7 @@ func (om *Manager) verifyIndirectObjectInternal(ctx context.Context, indexObject
 		return errors.Wrap(err, "unable to read index")
 	}
 
-	rd, err := om.Open(ctx, indexObjectID)
-	if err != nil {
-		return err
-	}
-	defer rd.Close() //nolint:errcheck
-
-	seekTable, err := om.flattenListChunk(rd)
+	seekTable, err := om.seekTable(ctx, indexObjectID)
 	if err != nil {
 		return err
 	}
@@ -161,14 +231,36 @@ func nullTrace(message string, args ...interface{}) {
 // ManagerOptions specifies object manager options.
 type ManagerOptions struct {
 	Trace func(message string, args ...interface{})
+
+	ReadAheadChunks   int   // number of chunks of large objects fetched concurrently ahead of sequential readers, 0 == disabled
+	ReadAheadMaxBytes int64 // maximum total size of chunks fetched ahead by a single reader, 0 == default
+	WriteParallelism  int   // number of chunks compressed and written concurrently by all writers, 0 or 1 == sequential
+
+	SeekTableCacheMaxEntries int    // maximum total number of seek table entries cached in memory, 0 == default, -1 == disabled
+	SeekTableCacheDirectory  string // directory where seek tables of large objects are cached, empty == no disk cache
+	SeekTableCacheMaxBytes   int64  // maximum total size of seek tables cached on disk, 0 == default
+
+	BinarySeekTables bool // write seek tables in binary format, which requires repository format version 3
 }
 
 // NewObjectManager creates an ObjectManager with the specified content manager and format.
 func NewObjectManager(ctx context.Context, bm contentManager, f Format, opts ManagerOptions) (*Manager, error) {
 	om := &Manager{
-		contentMgr: bm,
-		Format:     f,
-		trace:      nullTrace,
+		contentMgr:        bm,
+		Format:            f,
+		trace:             nullTrace,
+		readAheadChunks:   opts.ReadAheadChunks,
+		readAheadMaxBytes: opts.ReadAheadMaxBytes,
+		seekTables:        newSeekTableCache(opts.SeekTableCacheMaxEntries, opts.SeekTableCacheDirectory, opts.SeekTableCacheMaxBytes),
+		binarySeekTables:  opts.BinarySeekTables,
+	}
+
+	if om.readAheadMaxBytes == 0 {
+		om.readAheadMaxBytes = defaultReadAheadMaxBytes
+	}
+
+	if opts.WriteParallelism > 1 {
+		om.asyncWritesSemaphore = make(chan struct{}, opts.WriteParallelism)
 	}
 
 	splitterID := f.Splitter
@@ -191,12 +283,19 @@ func NewObjectManager(ctx context.Context, bm contentManager, f Format, opts Man
 		om.trace = nullTrace
 	}
 
+	om.seekTables.startSweeping(om.trace)
+
 	return om, nil
 }
 
 // Close closes the object manager.
 func (om *Manager) Close() error {
 	om.bufferPool.Close()
+
+	if err := om.seekTables.close(); err != nil {
+		om.trace("unable to sweep seek table cache: %v", err)
+	}
+
 	return nil
 }
 
@@ -212,19 +311,41 @@ func (om *Manager) Close() error {
 ]}
 */
 
+// indirectObject is the JSON format of seek tables, which is written unless binary seek tables
+// are enabled and is always supported when reading.
 type indirectObject struct {
 	StreamID string                `json:"stream"`
 	Entries  []indirectObjectEntry `json:"entries"`
 }
 
-func (om *Manager) flattenListChunk(rawReader io.Reader) ([]indirectObjectEntry, error) {
-	var ind indirectObject
+// seekTable returns the parsed seek table stored in the provided index object, which is cached
+// to avoid reading and parsing it each time the object is opened.
+func (om *Manager) seekTable(ctx context.Context, indexObjectID ID) ([]indirectObjectEntry, error) {
+	if st, ok := om.seekTables.get(indexObjectID); ok {
+		return st, nil
+	}
 
-	if err := json.NewDecoder(rawReader).Decode(&ind); err != nil {
-		return nil, errors.Wrap(err, "invalid indirect object")
+	rd, err := om.Open(ctx, indexObjectID)
+	if err != nil {
+		return nil, err
 	}
+	defer rd.Close() //nolint:errcheck
 
-	return ind.Entries, nil
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read indirect object")
+	}
+
+	st, err := decodeSeekTable(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := om.seekTables.put(indexObjectID, st); err != nil {
+		om.trace("unable to cache seek table of %v: %v", indexObjectID, err)
+	}
+
+	return st, nil
 }
 
 func (om *Manager) newRawReader(ctx context.Context, objectID ID, assertLength int64) (Reader, error) {
@@ -241,7 +362,7 @@ func (om *Manager) newRawReader(ctx context.Context, objectID ID, assertLength i
 		if compressed {
 			var b bytes.Buffer
 
-			if err = om.decompress(&b, payload); err != nil {
+			if err = om.decompress(ctx, &b, payload); err != nil {
 				return nil, errors.Wrap(err, "decompression error")
 			}
 
@@ -258,7 +379,21 @@ func (om *Manager) newRawReader(ctx context.Context, objectID ID, assertLength i
 	return nil, errors.Errorf("unsupported object ID: %v", objectID)
 }
 
-func (om *Manager) decompress(output *bytes.Buffer, b []byte) error {
+func (om *Manager) decompress(ctx context.Context, output *bytes.Buffer, b []byte) error {
+	if headerID, ok := compression.DictionaryID(b); ok {
+		dictionaryID, err := dictionaryIDFromHeaderID(headerID)
+		if err != nil {
+			return err
+		}
+
+		c, err := om.dictionaryCompressor(ctx, dictionaryDecompressorBase, dictionaryID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to load compression dictionary %v", dictionaryID)
+		}
+
+		return c.Decompress(output, b)
+	}
+
 	compressorID, err := compression.IDFromHeader(b)
 	if err != nil {
 		return errors.Wrap(err, "invalid compression header")
@@ -273,7 +408,7 @@ func (om *Manager) decompress(output *bytes.Buffer, b []byte) error {
 }
 
 type readerWithData struct {
-	io.ReadSeeker
+	*bytes.Reader
 	length int64
 }
 
@@ -287,7 +422,7 @@ func (rwd *readerWithData) Length() int64 {
 
 func newObjectReaderWithData(data []byte) Reader {
 	return &readerWithData{
-		ReadSeeker: bytes.NewReader(data),
-		length:     int64(len(data)),
+		Reader: bytes.NewReader(data),
+		length: int64(len(data)),
 	}
 }
@@ -11,6 +11,8 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"reflect"
 	"runtime/debug"
 	"sync"
 	"testing"
@@ -51,6 +53,10 @@ func (f *fakeContentManager) WriteContent(ctx context.Context, data []byte, pref
 	return contentID, nil
 }
 
+func (f *fakeContentManager) WriteCompressedContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error) {
+	return f.WriteContent(ctx, data, prefix)
+}
+
 func (f *fakeContentManager) ContentInfo(ctx context.Context, contentID content.ID) (content.Info, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -154,8 +160,12 @@ func verifyIndirectBlock(ctx context.Context, t *testing.T, r *Manager, oid ID)
 		}
 		defer rd.Close()
 
-		var ind indirectObject
-		if err := json.NewDecoder(rd).Decode(&ind); err != nil {
+		b, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Errorf("unable to read %v: %v", oid.String(), err)
+		}
+
+		if _, err := decodeSeekTable(b); err != nil {
 			t.Errorf("cannot parse indirect stream: %v", err)
 		}
 	}
@@ -464,3 +474,574 @@ func TestSeek(t *testing.T) {
 		}
 	}
 }
+
+func TestReadAhead(t *testing.T) {
+	ctx := testlogging.Context(t)
+	_, om := setupTestWithData(t, map[content.ID][]byte{}, ManagerOptions{
+		ReadAheadChunks:   3,
+		ReadAheadMaxBytes: 5 << 19, // 2.5 chunks
+	})
+
+	randomData := make([]byte, 10500000)
+	cryptorand.Read(randomData) //nolint:errcheck
+
+	writer := om.NewWriter(ctx, WriterOptions{})
+	if _, err := writer.Write(randomData); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	objectID, err := writer.Result()
+	if err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	r, err := om.Open(ctx, objectID)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+
+	defer r.Close()
+
+	first := make([]byte, 1)
+	if _, err := r.Read(first); err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	// no chunks are fetched ahead until sequential access is detected.
+	if got, want := len(r.(*objectReader).prefetched), 0; got != want {
+		t.Errorf("unexpected number of prefetched chunks: %v, want %v", got, want)
+	}
+
+	second := make([]byte, 1<<20)
+	if _, err := io.ReadFull(r, second); err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	first = append(first, second...)
+
+	// the next chunk is being fetched, the one after it does not fit in the read-ahead window.
+	if got, want := len(r.(*objectReader).prefetched), 1; got != want {
+		t.Errorf("unexpected number of prefetched chunks: %v, want %v", got, want)
+	}
+
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	if !bytes.Equal(append(first, rest...), randomData) {
+		t.Errorf("invalid data read")
+	}
+
+	// seeking elsewhere cancels fetches outside of the new position.
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("seek error: %v", err)
+	}
+
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("seek error: %v", err)
+	}
+
+	if got, want := len(r.(*objectReader).prefetched), 0; got != want {
+		t.Errorf("unexpected number of prefetched chunks after seek: %v, want %v", got, want)
+	}
+
+	verify(ctx, t, om, objectID, randomData, "read-ahead")
+}
+
+func TestParallelWrites(t *testing.T) {
+	ctx := testlogging.Context(t)
+	_, sequential := setupTest(t)
+	_, parallel := setupTestWithData(t, map[content.ID][]byte{}, ManagerOptions{
+		WriteParallelism: 4,
+	})
+
+	for _, size := range []int{0, 1, 1 << 20, 15000000} {
+		randomData := make([]byte, size)
+		cryptorand.Read(randomData) //nolint:errcheck
+
+		var oids []ID
+
+		for _, om := range []*Manager{sequential, parallel} {
+			writer := om.NewWriter(ctx, WriterOptions{Compressor: "gzip"})
+			if _, err := writer.Write(randomData); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+
+			oid, err := writer.Result()
+			if err != nil {
+				t.Fatalf("unable to write: %v", err)
+			}
+
+			writer.Close()
+
+			oids = append(oids, oid)
+		}
+
+		if oids[0] != oids[1] {
+			t.Errorf("parallel writer produced different object ID for %v bytes: %v, want %v", size, oids[1], oids[0])
+		}
+
+		if size > 0 {
+			verify(ctx, t, parallel, oids[1], randomData, fmt.Sprintf("parallel %v", size))
+		}
+	}
+
+	// the limit of background writes applies to all writers together.
+	w1 := parallel.NewWriter(ctx, WriterOptions{}).(*objectWriter)
+	defer w1.Close()
+
+	w2 := parallel.NewWriter(ctx, WriterOptions{}).(*objectWriter)
+	defer w2.Close()
+
+	if w1.asyncWritesSemaphore == nil || w1.asyncWritesSemaphore != w2.asyncWritesSemaphore {
+		t.Errorf("writers don't share the limit of background writes")
+	}
+}
+
+func TestWriterSplitterOverride(t *testing.T) {
+	ctx := testlogging.Context(t)
+	_, om := setupTest(t)
+
+	randomData := make([]byte, 3<<20)
+	cryptorand.Read(randomData) //nolint:errcheck
+
+	cases := []struct {
+		splitter    string
+		indirection int
+	}{
+		{"", 1},
+		{"FIXED-1M", 1},
+		{"FIXED-4M", 0},
+		{"NO-SUCH-SPLITTER", 1},
+	}
+
+	for _, tc := range cases {
+		writer := om.NewWriter(ctx, WriterOptions{Splitter: tc.splitter})
+		if _, err := writer.Write(randomData); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+
+		oid, err := writer.Result()
+		if err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+
+		writer.Close()
+
+		if got := indirectionLevel(oid); got != tc.indirection {
+			t.Errorf("invalid indirection level for splitter %q: %v, want %v", tc.splitter, got, tc.indirection)
+		}
+
+		verify(ctx, t, om, oid, randomData, tc.splitter)
+	}
+}
+
+func TestAdaptiveCompression(t *testing.T) {
+	ctx := testlogging.Context(t)
+	_, om := setupTest(t)
+
+	const chunkSize = 1 << 20
+
+	randomData := make([]byte, chunkSize)
+	cryptorand.Read(randomData) //nolint:errcheck
+
+	// uniformly distributed bytes from a reduced alphabet, which compress by only a few percent.
+	poorlyCompressibleData := make([]byte, chunkSize)
+	for i := range poorlyCompressibleData {
+		poorlyCompressibleData[i] = byte(rand.Intn(200))
+	}
+
+	var data []byte
+	data = append(data, makeCompressibleData(chunkSize)...)
+	data = append(data, randomData...)
+	data = append(data, poorlyCompressibleData...)
+
+	noMinSavings := 0
+
+	cases := []struct {
+		adaptive   bool
+		minSavings *int
+		want       CompressionStats
+	}{
+		{false, nil, CompressionStats{CompressedBytes: 2 * chunkSize, IncompressibleBytes: chunkSize}},
+		{true, nil, CompressionStats{CompressedBytes: chunkSize, IncompressibleBytes: chunkSize, SkippedBytes: chunkSize}},
+		{true, &noMinSavings, CompressionStats{CompressedBytes: 2 * chunkSize, SkippedBytes: chunkSize}},
+	}
+
+	for _, tc := range cases {
+		writer := om.NewWriter(ctx, WriterOptions{
+			Compressor:                   "gzip-best-compression",
+			AdaptiveCompression:          tc.adaptive,
+			MinCompressionSavingsPercent: tc.minSavings,
+		})
+		if _, err := writer.Write(data); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+
+		oid, err := writer.Result()
+		if err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+
+		writer.Close()
+
+		got := writer.(CompressionStatsReporter).CompressionStats()
+		got.CompressedSize = 0
+
+		if got != tc.want {
+			t.Errorf("unexpected compression stats (adaptive=%v): %+v, want %+v", tc.adaptive, got, tc.want)
+		}
+
+		verify(ctx, t, om, oid, data, fmt.Sprintf("adaptive=%v", tc.adaptive))
+	}
+}
+
+func TestEstimateEntropy(t *testing.T) {
+	randomData := make([]byte, 10<<20)
+	cryptorand.Read(randomData) //nolint:errcheck
+
+	if !isLikelyIncompressible(randomData) {
+		t.Errorf("random data was not detected as incompressible, entropy %v", estimateEntropy(randomData))
+	}
+
+	if isLikelyIncompressible(makeCompressibleData(10 << 20)) {
+		t.Errorf("compressible data was detected as incompressible")
+	}
+
+	if got := estimateEntropy(bytes.Repeat([]byte{1}, 1000)); got != 0 {
+		t.Errorf("unexpected entropy of constant data: %v", got)
+	}
+}
+
+func TestCompressionDictionary(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data, om := setupTest(t)
+
+	dictionaryID, err := om.WriteDictionary(ctx, []byte(`{"name":"file.txt","type":"f","mode":"0644","mtime":"2020-01-01T00:00:00Z"}`))
+	if err != nil {
+		t.Fatalf("unable to write dictionary: %v", err)
+	}
+
+	if got := dictionaryID.Prefix(); got != DictionaryContentPrefix {
+		t.Fatalf("unexpected dictionary prefix: %v", got)
+	}
+
+	var payload []byte
+
+	for i := 0; i < 10; i++ {
+		payload = append(payload, fmt.Sprintf(`{"name":"file%v.txt","type":"f","mode":"0644","mtime":"2020-01-01T00:00:0%vZ"}`, i, i)...)
+	}
+
+	writeWithDictionary := func(om *Manager, comp compression.Name, dictionaryID content.ID) (ID, content.ID) {
+		w := om.NewWriter(ctx, WriterOptions{Compressor: comp, CompressionDictionary: dictionaryID})
+		defer w.Close()
+
+		if _, err := w.Write(payload); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+
+		oid, err := w.Result()
+		if err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+
+		contentID, _, _ := oid.ContentID()
+
+		headerID, ok := compression.DictionaryID(data[contentID])
+		if !ok {
+			return oid, ""
+		}
+
+		usedDictionaryID, err := dictionaryIDFromHeaderID(headerID)
+		if err != nil {
+			t.Fatalf("invalid dictionary ID in header: %v", err)
+		}
+
+		return oid, usedDictionaryID
+	}
+
+	oid, usedDictionaryID := writeWithDictionary(om, "zstd", dictionaryID)
+	if usedDictionaryID != dictionaryID {
+		t.Errorf("dictionary was not used: %q", usedDictionaryID)
+	}
+
+	verify(ctx, t, om, oid, payload, "with dictionary")
+
+	// fresh object manager must load the dictionary.
+	_, om2 := setupTestWithData(t, data, ManagerOptions{})
+	verify(ctx, t, om2, oid, payload, "with dictionary after reopen")
+
+	// dictionaries are not used with other compressors.
+	if _, usedDictionaryID = writeWithDictionary(om, "gzip", dictionaryID); usedDictionaryID != "" {
+		t.Errorf("unexpected dictionary used with gzip: %q", usedDictionaryID)
+	}
+
+	// missing dictionary falls back to compressing without it.
+	oid2, usedDictionaryID := writeWithDictionary(om, "zstd", DictionaryContentPrefix+"0123456789abcdef")
+	if usedDictionaryID != "" {
+		t.Errorf("unexpected dictionary used: %q", usedDictionaryID)
+	}
+
+	verify(ctx, t, om, oid2, payload, "with missing dictionary")
+
+	// reading data compressed with dictionary that has been lost fails.
+	delete(data, dictionaryID)
+
+	_, om3 := setupTestWithData(t, data, ManagerOptions{})
+	if r, err := om3.Open(ctx, oid); err == nil {
+		r.Close()
+		t.Errorf("unexpected success reading object compressed with missing dictionary")
+	}
+}
+
+func TestSeekTableEncoding(t *testing.T) {
+	entries := []indirectObjectEntry{
+		{Start: 0, Length: 1000, Object: "D13ea27f9ad891ad4a2edfa983906863d"},
+		{Start: 1000, Length: 1 << 20, Object: "Zkde8ca8327cd3af5f4edbd5ed1009c525e"},
+		{Start: 1000 + 1<<20, Length: 1, Object: "abc"},
+		{Start: 1001 + 1<<20, Length: 77777, Object: "D6B6EB48ca53"},
+		{Start: 1001 + 1<<20 + 77777, Length: 5, Object: "xyz"},
+	}
+
+	b := encodeSeekTable(entries)
+
+	legacy, err := json.Marshal(indirectObject{StreamID: "kopia:indirect", Entries: entries})
+	if err != nil {
+		t.Fatalf("unable to marshal: %v", err)
+	}
+
+	if len(b) >= len(legacy)/2 {
+		t.Errorf("binary seek table is not compact: %v bytes, JSON: %v bytes", len(b), len(legacy))
+	}
+
+	for _, enc := range [][]byte{b, legacy} {
+		got, err := decodeSeekTable(enc)
+		if err != nil {
+			t.Fatalf("unable to decode seek table: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, entries) {
+			t.Errorf("invalid seek table %v, want %v", got, entries)
+		}
+	}
+
+	invalid := [][]byte{
+		nil,
+		[]byte(seekTableMagic),
+		[]byte(seekTableMagic + "\x00"),
+		[]byte(`{"stream":"kopia:indirect","entries":[]}`),
+		b[0 : len(b)-1],
+		append(append([]byte(nil), b...), 0),
+		append([]byte(seekTableMagic+"\xff\xff\xff\xff\x0f"), b[len(seekTableMagic)+1:]...),
+	}
+
+	for _, enc := range invalid {
+		if _, err := decodeSeekTable(enc); err == nil {
+			t.Errorf("unexpected success decoding %x", enc)
+		}
+	}
+}
+
+func TestSeekTableCache(t *testing.T) {
+	ctx := testlogging.Context(t)
+	cacheDir, err := ioutil.TempDir("", "seektables")
+
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(cacheDir)
+
+	data, om := setupTestWithData(t, map[content.ID][]byte{}, ManagerOptions{
+		SeekTableCacheDirectory: cacheDir,
+	})
+
+	payload := make([]byte, 5<<20)
+	cryptorand.Read(payload) //nolint:errcheck
+
+	writer := om.NewWriter(ctx, WriterOptions{})
+	if _, err := writer.Write(payload); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	oid, err := writer.Result()
+	if err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	verify(ctx, t, om, oid, payload, "before caching")
+
+	indexObjectID, _ := oid.IndexObjectID()
+	indexContentID, _, _ := indexObjectID.ContentID()
+
+	// remove the index object, so that it can only be opened using cached seek table.
+	delete(data, indexContentID)
+
+	verify(ctx, t, om, oid, payload, "cached in memory")
+
+	if err := om.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	_, om2 := setupTestWithData(t, data, ManagerOptions{
+		SeekTableCacheDirectory: cacheDir,
+	})
+	verify(ctx, t, om2, oid, payload, "cached on disk")
+
+	_, om3 := setupTestWithData(t, data, ManagerOptions{
+		SeekTableCacheMaxEntries: -1,
+	})
+
+	if r, err := om3.Open(ctx, oid); err == nil {
+		r.Close()
+		t.Errorf("unexpected success opening object with missing index without cache")
+	}
+
+	if err := om2.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// the disk cache is swept when opened.
+	_, om4 := setupTestWithData(t, data, ManagerOptions{
+		SeekTableCacheDirectory: cacheDir,
+		SeekTableCacheMaxBytes:  1,
+	})
+	defer om4.Close()
+
+	if files, err := ioutil.ReadDir(cacheDir); err != nil || len(files) != 0 {
+		t.Errorf("unexpected files in swept seek table cache: %v %v", len(files), err)
+	}
+}
+
+func TestBinarySeekTables(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	for _, binary := range []bool{false, true} {
+		data, om := setupTestWithData(t, map[content.ID][]byte{}, ManagerOptions{BinarySeekTables: binary})
+
+		payload := make([]byte, 3<<20)
+		cryptorand.Read(payload) //nolint:errcheck
+
+		writer := om.NewWriter(ctx, WriterOptions{})
+		if _, err := writer.Write(payload); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+
+		oid, err := writer.Result()
+		if err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+
+		indexObjectID, _ := oid.IndexObjectID()
+		indexContentID, _, _ := indexObjectID.ContentID()
+
+		// binary seek tables can only be read by clients supporting repository format version 3.
+		if got, want := bytes.HasPrefix(data[indexContentID], []byte(seekTableMagic)), binary; got != want {
+			t.Errorf("unexpected binary seek table: %v, want %v", got, want)
+		}
+
+		verify(ctx, t, om, oid, payload, fmt.Sprintf("binary=%v", binary))
+	}
+}
+
+func TestSeekTableCacheEviction(t *testing.T) {
+	c := newSeekTableCache(5, "", 0)
+
+	for i := 0; i < 4; i++ {
+		c.addToMemory(ID(fmt.Sprintf("D%v", i)), make([]indirectObjectEntry, 2))
+	}
+
+	// D0 and D1 have been evicted.
+	for i, want := range []bool{false, false, true, true} {
+		if _, ok := c.get(ID(fmt.Sprintf("D%v", i))); ok != want {
+			t.Errorf("unexpected presence of D%v: %v, want %v", i, ok, want)
+		}
+	}
+
+	if got, want := c.totalEntries, 4; got != want {
+		t.Errorf("unexpected number of cached entries: %v, want %v", got, want)
+	}
+
+	// seek tables larger than the cache are not retained.
+	c.addToMemory("Dlarge", make([]indirectObjectEntry, 6))
+
+	if _, ok := c.get("Dlarge"); ok {
+		t.Errorf("unexpected presence of large seek table")
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	ctx := testlogging.Context(t)
+	_, om := setupTest(t)
+
+	for _, size := range []int{100, 5<<20 + 12345} {
+		payload := make([]byte, size)
+		cryptorand.Read(payload) //nolint:errcheck
+
+		writer := om.NewWriter(ctx, WriterOptions{})
+		if _, err := writer.Write(payload); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+
+		oid, err := writer.Result()
+		if err != nil {
+			t.Fatalf("unable to write: %v", err)
+		}
+
+		r, err := om.Open(ctx, oid)
+		if err != nil {
+			t.Fatalf("open error: %v", err)
+		}
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+
+			go func(seed int64) {
+				defer wg.Done()
+
+				rnd := rand.New(rand.NewSource(seed))
+
+				for j := 0; j < 50; j++ {
+					off := rnd.Intn(size)
+					buf := make([]byte, rnd.Intn(3<<20))
+
+					n, err := r.ReadAt(buf, int64(off))
+
+					want := payload[off:]
+					if len(want) > len(buf) {
+						want = want[0:len(buf)]
+					}
+
+					if n < len(buf) && err != io.EOF {
+						t.Errorf("unexpected error from short read at %v: %v", off, err)
+					}
+
+					if n == len(buf) && err != nil && err != io.EOF {
+						t.Errorf("unexpected error from read at %v: %v", off, err)
+					}
+
+					if !bytes.Equal(buf[0:n], want) {
+						t.Errorf("invalid data read at %v", off)
+					}
+				}
+			}(int64(i))
+		}
+
+		wg.Wait()
+
+		if n, err := r.ReadAt(make([]byte, 1), int64(size)); n != 0 || err != io.EOF {
+			t.Errorf("unexpected result of read at the end: %v %v", n, err)
+		}
+
+		// ReadAt does not affect the current position.
+		all, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(all, payload) {
+			t.Errorf("invalid data read after ReadAt: %v", err)
+		}
+
+		r.Close()
+	}
+}
@@ -3,10 +3,14 @@ package object
 import (
 	"context"
 	"io"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
+// maxRandomAccessChunks is the number of most recently used chunks retained by ReadAt().
+const maxRandomAccessChunks = 4
+
 func (i *indirectObjectEntry) endOffset() int64 {
 	return i.Start + i.Length
 }
@@ -23,6 +27,23 @@ type objectReader struct {
 	currentChunkIndex    int    // Index of current chunk in the seek table
 	currentChunkData     []byte // Current chunk data
 	currentChunkPosition int    // Read position in the current chunk
+
+	readAheadChunks   int                 // number of chunks to fetch ahead of the current one
+	readAheadMaxBytes int64               // maximum total size of chunks being fetched
+	prefetched        map[int]*chunkFetch // chunks being fetched, keyed by index in the seek table
+	lastOpenedChunk   int                 // index of the most recently opened chunk, -1 == none, used to detect sequential access
+
+	randomAccessMutex  sync.Mutex
+	randomAccessChunks map[int]*chunkFetch // chunks recently used by ReadAt(), keyed by index in the seek table
+	randomAccessOrder  []int               // indexes of chunks in randomAccessChunks, least recently used first
+}
+
+// chunkFetch represents a chunk that is being fetched in the background.
+type chunkFetch struct {
+	done   chan struct{}
+	cancel context.CancelFunc // cancels the fetch when the chunk is no longer needed
+	data   []byte
+	err    error
 }
 
 func (r *objectReader) Read(buffer []byte) (int, error) {
@@ -76,25 +97,201 @@ func (r *objectReader) Read(buffer []byte) (int, error) {
 	return readBytes, nil
 }
 
+// ReadAt implements io.ReaderAt. It does not use or modify the current position of the reader,
+// so it can be called concurrently with other calls to ReadAt() and with Read() and Seek().
+func (r *objectReader) ReadAt(buffer []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("invalid offset %v", off)
+	}
+
+	readBytes := 0
+
+	for readBytes < len(buffer) {
+		pos := off + int64(readBytes)
+		if pos >= r.totalLength {
+			return readBytes, io.EOF
+		}
+
+		index, err := r.findChunkIndexForOffset(pos)
+		if err != nil {
+			return readBytes, err
+		}
+
+		b, err := r.randomAccessChunk(index)
+		if err != nil {
+			return readBytes, err
+		}
+
+		readBytes += copy(buffer[readBytes:], b[pos-r.seekTable[index].Start:])
+	}
+
+	return readBytes, nil
+}
+
+// randomAccessChunk returns the data of the chunk with the provided index, reusing recently
+// used chunks. Concurrent callers requesting the same chunk wait for a single fetch.
+func (r *objectReader) randomAccessChunk(index int) ([]byte, error) {
+	r.randomAccessMutex.Lock()
+
+	f := r.randomAccessChunks[index]
+	if f != nil {
+		r.markRandomAccessChunkUsedLocked(index)
+		r.randomAccessMutex.Unlock()
+
+		<-f.done
+
+		return f.data, f.err
+	}
+
+	if r.randomAccessChunks == nil {
+		r.randomAccessChunks = map[int]*chunkFetch{}
+	}
+
+	if len(r.randomAccessOrder) >= maxRandomAccessChunks {
+		delete(r.randomAccessChunks, r.randomAccessOrder[0])
+		r.randomAccessOrder = r.randomAccessOrder[1:]
+	}
+
+	f = &chunkFetch{done: make(chan struct{})}
+	r.randomAccessChunks[index] = f
+	r.randomAccessOrder = append(r.randomAccessOrder, index)
+	r.randomAccessMutex.Unlock()
+
+	f.data, f.err = r.readChunk(r.ctx, index)
+	close(f.done)
+
+	if f.err != nil {
+		// do not retain failures, so that the chunk can be fetched again.
+		r.randomAccessMutex.Lock()
+		if r.randomAccessChunks[index] == f {
+			delete(r.randomAccessChunks, index)
+			r.removeRandomAccessChunkOrderLocked(index)
+		}
+		r.randomAccessMutex.Unlock()
+	}
+
+	return f.data, f.err
+}
+
+func (r *objectReader) markRandomAccessChunkUsedLocked(index int) {
+	r.removeRandomAccessChunkOrderLocked(index)
+	r.randomAccessOrder = append(r.randomAccessOrder, index)
+}
+
+func (r *objectReader) removeRandomAccessChunkOrderLocked(index int) {
+	for i, v := range r.randomAccessOrder {
+		if v == index {
+			r.randomAccessOrder = append(r.randomAccessOrder[0:i], r.randomAccessOrder[i+1:]...)
+			return
+		}
+	}
+}
+
 func (r *objectReader) openCurrentChunk() error {
-	st := r.seekTable[r.currentChunkIndex]
+	var (
+		b   []byte
+		err error
+	)
+
+	// read ahead only when chunks are being read sequentially.
+	sequential := r.lastOpenedChunk >= 0 && r.currentChunkIndex == r.lastOpenedChunk+1
+
+	if r.readAheadChunks > 0 {
+		b, err = r.prefetchedChunk(r.currentChunkIndex, sequential)
+	} else {
+		b, err = r.readChunk(r.ctx, r.currentChunkIndex)
+	}
 
-	rd, err := r.repo.openAndAssertLength(r.ctx, st.Object, st.Length)
 	if err != nil {
 		return err
 	}
 
+	r.lastOpenedChunk = r.currentChunkIndex
+
+	r.currentChunkData = b
+	r.currentChunkPosition = 0
+
+	return nil
+}
+
+func (r *objectReader) readChunk(ctx context.Context, index int) ([]byte, error) {
+	st := r.seekTable[index]
+
+	rd, err := r.repo.openAndAssertLength(ctx, st.Object, st.Length)
+	if err != nil {
+		return nil, err
+	}
+
 	defer rd.Close() //nolint:errcheck
 
 	b := make([]byte, st.Length)
 	if _, err := io.ReadFull(rd, b); err != nil {
-		return err
+		return nil, err
 	}
 
-	r.currentChunkData = b
-	r.currentChunkPosition = 0
+	return b, nil
+}
 
-	return nil
+// prefetchedChunk returns the data of the chunk with the provided index. When reading ahead, it also makes sure that
+// the following chunks that fit in the read-ahead window are being fetched in the background.
+func (r *objectReader) prefetchedChunk(index int, readAhead bool) ([]byte, error) {
+	if r.prefetched == nil {
+		r.prefetched = map[int]*chunkFetch{}
+	}
+
+	last := index
+	if readAhead {
+		last = index + r.readAheadChunks
+	}
+
+	// abandon chunks outside of the new window, which can happen after seeking.
+	r.cancelPrefetchesOutside(index, last)
+
+	var windowBytes int64
+
+	for i := index; i <= last && i < len(r.seekTable); i++ {
+		windowBytes += r.seekTable[i].Length
+
+		// always fetch the requested chunk, regardless of its size.
+		if i > index && windowBytes > r.readAheadMaxBytes {
+			break
+		}
+
+		if r.prefetched[i] == nil {
+			r.prefetched[i] = r.startChunkFetch(i)
+		}
+	}
+
+	f := r.prefetched[index]
+	delete(r.prefetched, index)
+
+	<-f.done
+	f.cancel()
+
+	return f.data, f.err
+}
+
+func (r *objectReader) startChunkFetch(index int) *chunkFetch {
+	ctx, cancel := context.WithCancel(r.ctx)
+	f := &chunkFetch{done: make(chan struct{}), cancel: cancel}
+
+	go func() {
+		defer close(f.done)
+
+		f.data, f.err = r.readChunk(ctx, index)
+	}()
+
+	return f
+}
+
+// cancelPrefetchesOutside cancels fetches of chunks with indexes outside of the provided range.
+func (r *objectReader) cancelPrefetchesOutside(first, last int) {
+	for i, f := range r.prefetched {
+		if i < first || i > last {
+			f.cancel()
+			delete(r.prefetched, i)
+		}
+	}
 }
 
 func (r *objectReader) closeCurrentChunk() {
@@ -134,6 +331,7 @@ func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
 	}
 
 	if offset >= r.totalLength {
+		r.cancelPrefetchesOutside(0, -1)
 		r.currentChunkIndex = len(r.seekTable)
 		r.currentChunkData = nil
 		r.currentPosition = offset
@@ -151,6 +349,9 @@ func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
 	if index != r.currentChunkIndex {
 		r.closeCurrentChunk()
 		r.currentChunkIndex = index
+
+		// chunks prefetched for sequential reading from the previous position are no longer needed.
+		r.cancelPrefetchesOutside(index, index+r.readAheadChunks)
 	}
 
 	if r.currentChunkData == nil {
@@ -166,6 +367,14 @@ func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (r *objectReader) Close() error {
+	// cancel chunks that are still being fetched.
+	r.cancelPrefetchesOutside(0, -1)
+
+	r.randomAccessMutex.Lock()
+	r.randomAccessChunks = nil
+	r.randomAccessOrder = nil
+	r.randomAccessMutex.Unlock()
+
 	return nil
 }
 
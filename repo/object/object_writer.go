@@ -3,7 +3,6 @@ package object
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"io"
 	"sync"
 
@@ -23,6 +22,12 @@ type Writer interface {
 	Result() (ID, error)
 }
 
+// CompressionStatsReporter is implemented by writers that keep statistics about compression of written data.
+type CompressionStatsReporter interface {
+	// CompressionStats returns statistics about compression of the data written so far.
+	CompressionStats() CompressionStats
+}
+
 type contentIDTracker struct {
 	mu       sync.Mutex
 	contents map[content.ID]bool
@@ -57,6 +62,14 @@ type objectWriter struct {
 
 	compressor compression.Compressor
 
+	// in adaptive mode, chunks are stored uncompressed when compression is not expected to save at least
+	// minCompressionSavingsPercent of their size.
+	adaptiveCompression          bool
+	minCompressionSavingsPercent int
+
+	statsMutex       sync.Mutex
+	compressionStats CompressionStats
+
 	prefix      content.ID
 	buf         buf.Buf
 	buffer      *bytes.Buffer
@@ -68,6 +81,16 @@ type objectWriter struct {
 	description string
 
 	splitter splitter.Splitter
+
+	// when asyncWritesSemaphore is not nil, chunks completed before the end of the object are compressed and written
+	// in the background by goroutines, each owning the buffer of its chunk. The semaphore is shared by all writers
+	// of the object manager, chunks are written synchronously when it is exhausted.
+	asyncWritesSemaphore chan struct{}
+	asyncWritesWG        sync.WaitGroup
+
+	asyncWritesMutex sync.Mutex
+	asyncWriteError  error      // first error encountered by background writes
+	asyncWriteOIDs   map[int]ID // object IDs of chunks written in the background, keyed by chunk index
 }
 
 func (w *objectWriter) initBuffer() {
@@ -76,6 +99,8 @@ func (w *objectWriter) initBuffer() {
 }
 
 func (w *objectWriter) Close() error {
+	// background writes may still be using buffers from the pool.
+	w.asyncWritesWG.Wait()
 	w.buf.Release()
 
 	if w.splitter != nil {
@@ -95,7 +120,7 @@ func (w *objectWriter) Write(data []byte) (n int, err error) {
 		}
 
 		if w.splitter.ShouldSplit(d) {
-			if err := w.flushBuffer(); err != nil {
+			if err := w.flushBuffer(true); err != nil {
 				return 0, err
 			}
 		}
@@ -104,7 +129,8 @@ func (w *objectWriter) Write(data []byte) (n int, err error) {
 	return dataLen, nil
 }
 
-func (w *objectWriter) flushBuffer() error {
+// flushBuffer writes the current buffer as the next chunk of the object, possibly in the background if allowed.
+func (w *objectWriter) flushBuffer(allowAsync bool) error {
 	length := w.buffer.Len()
 	chunkID := len(w.indirectIndex)
 	w.indirectIndex = append(w.indirectIndex, indirectObjectEntry{})
@@ -112,21 +138,113 @@ func (w *objectWriter) flushBuffer() error {
 	w.indirectIndex[chunkID].Length = int64(length)
 	w.currentPosition += int64(length)
 
-	b := w.om.bufferPool.Allocate(length)
+	if allowAsync && w.asyncWritesSemaphore != nil {
+		if err := w.getAsyncWriteError(); err != nil {
+			return err
+		}
+
+		select {
+		case w.asyncWritesSemaphore <- struct{}{}:
+			w.flushBufferAsync(chunkID)
+			return nil
+
+		default:
+			// all background write slots are in use, write synchronously.
+		}
+	}
+
+	oid, err := w.writeChunk(chunkID, w.buffer.Bytes())
+	w.buffer.Reset()
+
+	if err != nil {
+		return err
+	}
+
+	w.indirectIndex[chunkID].Object = oid
+
+	return nil
+}
+
+// flushBufferAsync hands the current buffer over to a background goroutine that writes it as the provided chunk
+// and allocates a new buffer. The caller must have acquired a slot of asyncWritesSemaphore, which is released
+// once the chunk is written.
+func (w *objectWriter) flushBufferAsync(chunkID int) {
+	chunkBuf, chunkData := w.buf, w.buffer.Bytes()
+
+	w.asyncWritesWG.Add(1)
+
+	go func() {
+		defer func() {
+			chunkBuf.Release()
+			<-w.asyncWritesSemaphore
+			w.asyncWritesWG.Done()
+		}()
+
+		oid, err := w.writeChunk(chunkID, chunkData)
+
+		w.asyncWritesMutex.Lock()
+		defer w.asyncWritesMutex.Unlock()
+
+		if err != nil {
+			if w.asyncWriteError == nil {
+				w.asyncWriteError = err
+			}
+
+			return
+		}
+
+		w.asyncWriteOIDs[chunkID] = oid
+	}()
+
+	w.initBuffer()
+}
+
+func (w *objectWriter) getAsyncWriteError() error {
+	w.asyncWritesMutex.Lock()
+	defer w.asyncWritesMutex.Unlock()
+
+	return w.asyncWriteError
+}
+
+// waitForAsyncWrites waits for all background writes to complete and fills in object IDs of their chunks.
+func (w *objectWriter) waitForAsyncWrites() error {
+	if w.asyncWriteOIDs == nil {
+		return nil
+	}
+
+	w.asyncWritesWG.Wait()
+
+	if err := w.getAsyncWriteError(); err != nil {
+		return err
+	}
+
+	for chunkID, oid := range w.asyncWriteOIDs {
+		w.indirectIndex[chunkID].Object = oid
+	}
+
+	return nil
+}
+
+func (w *objectWriter) writeChunk(chunkID int, data []byte) (ID, error) {
+	b := w.om.bufferPool.Allocate(len(data))
 	defer b.Release()
 
 	compressedBuf := bytes.NewBuffer(b.Data[:0])
 
-	contentBytes, isCompressed, err := maybeCompressedContentBytes(w.compressor, compressedBuf, w.buffer.Bytes())
+	contentBytes, isCompressed, err := w.maybeCompressedContentBytes(compressedBuf, data)
 	if err != nil {
-		return errors.Wrap(err, "unable to prepare content bytes")
+		return "", errors.Wrap(err, "unable to prepare content bytes")
 	}
 
-	contentID, err := w.om.contentMgr.WriteContent(w.ctx, contentBytes, w.prefix)
-	w.buffer.Reset()
+	writeContent := w.om.contentMgr.WriteContent
+	if isCompressed {
+		// don't let the content manager compress metadata contents again.
+		writeContent = w.om.contentMgr.WriteCompressedContent
+	}
 
+	contentID, err := writeContent(w.ctx, contentBytes, w.prefix)
 	if err != nil {
-		return errors.Wrapf(err, "error when flushing chunk %d of %s", chunkID, w.description)
+		return "", errors.Wrapf(err, "error when flushing chunk %d of %s", chunkID, w.description)
 	}
 
 	oid := DirectObjectID(contentID)
@@ -135,32 +253,69 @@ func (w *objectWriter) flushBuffer() error {
 		oid = Compressed(oid)
 	}
 
-	w.indirectIndex[chunkID].Object = oid
-
-	return nil
+	return oid, nil
 }
 
-func maybeCompressedContentBytes(comp compression.Compressor, output *bytes.Buffer, input []byte) (data []byte, isCompressed bool, err error) {
-	if comp != nil {
-		if err := comp.Compress(output, input); err != nil {
-			return nil, false, errors.Wrap(err, "compression error")
-		}
+func (w *objectWriter) maybeCompressedContentBytes(output *bytes.Buffer, input []byte) (data []byte, isCompressed bool, err error) {
+	if w.compressor == nil {
+		return input, false, nil
+	}
 
-		if output.Len() < len(input) {
-			return output.Bytes(), true, nil
-		}
+	if w.adaptiveCompression && isLikelyIncompressible(input) {
+		w.updateCompressionStats(func(s *CompressionStats) { s.SkippedBytes += int64(len(input)) })
+		return input, false, nil
 	}
 
-	return input, false, nil
+	if err := w.compressor.Compress(output, input); err != nil {
+		return nil, false, errors.Wrap(err, "compression error")
+	}
+
+	maxCompressedSize := len(input) - 1
+	if w.adaptiveCompression {
+		maxCompressedSize = len(input) * (100 - w.minCompressionSavingsPercent) / 100 //nolint:gomnd
+	}
+
+	if output.Len() > maxCompressedSize {
+		w.updateCompressionStats(func(s *CompressionStats) { s.IncompressibleBytes += int64(len(input)) })
+		return input, false, nil
+	}
+
+	w.updateCompressionStats(func(s *CompressionStats) {
+		s.CompressedBytes += int64(len(input))
+		s.CompressedSize += int64(output.Len())
+	})
+
+	return output.Bytes(), true, nil
+}
+
+func (w *objectWriter) updateCompressionStats(update func(s *CompressionStats)) {
+	w.statsMutex.Lock()
+	defer w.statsMutex.Unlock()
+
+	update(&w.compressionStats)
+}
+
+// CompressionStats implements CompressionStatsReporter.
+func (w *objectWriter) CompressionStats() CompressionStats {
+	w.statsMutex.Lock()
+	defer w.statsMutex.Unlock()
+
+	return w.compressionStats
 }
 
 func (w *objectWriter) Result() (ID, error) {
+	// the last chunk is written synchronously, which avoids starting a goroutine for objects
+	// that fit in a single chunk.
 	if w.buffer.Len() > 0 || len(w.indirectIndex) == 0 {
-		if err := w.flushBuffer(); err != nil {
+		if err := w.flushBuffer(false); err != nil {
 			return "", err
 		}
 	}
 
+	if err := w.waitForAsyncWrites(); err != nil {
+		return "", err
+	}
+
 	if len(w.indirectIndex) == 1 {
 		return w.indirectIndex[0].Object, nil
 	}
@@ -178,12 +333,7 @@ func (w *objectWriter) Result() (ID, error) {
 
 	defer iw.Close() //nolint:errcheck
 
-	ind := indirectObject{
-		StreamID: "kopia:indirect",
-		Entries:  w.indirectIndex,
-	}
-
-	if err := json.NewEncoder(iw).Encode(ind); err != nil {
+	if err := writeSeekTable(iw, w.indirectIndex, w.om.binarySeekTables); err != nil {
 		return "", errors.Wrap(err, "unable to write indirect object index")
 	}
 
@@ -200,4 +350,22 @@ type WriterOptions struct {
 	Description string
 	Prefix      content.ID // empty string or a single-character ('g'..'z')
 	Compressor  compression.Name
+	Splitter    string // name of the splitter to use instead of the one configured for the repository
+
+	// CompressionDictionary is the ID of the dictionary used with zstd compressors, which are used without
+	// the dictionary if it can't be loaded.
+	CompressionDictionary content.ID
+
+	// AdaptiveCompression stores chunks uncompressed when they are estimated to be incompressible
+	// or compression saves less than MinCompressionSavingsPercent (default 5% when nil) of their size.
+	AdaptiveCompression          bool
+	MinCompressionSavingsPercent *int
+}
+
+// CompressionStats contains statistics about compression of chunks written by a Writer.
+type CompressionStats struct {
+	CompressedBytes     int64 // size of chunks stored compressed, before compression
+	CompressedSize      int64 // size of chunks stored compressed, after compression
+	IncompressibleBytes int64 // size of chunks stored uncompressed because compression did not save enough
+	SkippedBytes        int64 // size of chunks stored uncompressed without attempting compression
 }
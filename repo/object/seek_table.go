@@ -0,0 +1,185 @@
+package object
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// seekTableMagic is the header of binary-encoded seek tables, which distinguishes them from JSON-encoded
+// seek tables written by previous versions, which always start with '{'.
+const seekTableMagic = "KST1"
+
+// minSeekTableEntrySize is the minimum number of bytes used by a single entry of a binary-encoded seek table.
+const minSeekTableEntrySize = 3
+
+// writeSeekTable writes the seek table of an indirect object either in binary format or in JSON format,
+// which is readable by clients that don't support binary seek tables.
+func writeSeekTable(w io.Writer, entries []indirectObjectEntry, binary bool) error {
+	if binary {
+		_, err := w.Write(encodeSeekTable(entries))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(indirectObject{
+		StreamID: "kopia:indirect",
+		Entries:  entries,
+	})
+}
+
+// encodeSeekTable returns the compact binary representation of the provided seek table.
+//
+// The format is:
+//
+//	"KST1" uvarint(entryCount) entry*
+//
+// where each entry is:
+//
+//	uvarint(length) uvarint(len(prefix)) prefix uvarint(len(hexSuffix)/2) hexSuffix-decoded
+//
+// Offsets of entries are not stored, since entries are contiguous. Object IDs are split into a prefix
+// (such as 'Z' or content prefix) and a hexadecimal suffix, which is stored in binary.
+func encodeSeekTable(entries []indirectObjectEntry) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+
+	result := append([]byte(nil), seekTableMagic...)
+
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(tmp[:], v)
+		result = append(result, tmp[0:n]...)
+	}
+
+	putUvarint(uint64(len(entries)))
+
+	for _, e := range entries {
+		prefix, suffix := splitObjectID(e.Object)
+
+		putUvarint(uint64(e.Length))
+		putUvarint(uint64(len(prefix)))
+		result = append(result, prefix...)
+		putUvarint(uint64(len(suffix)))
+		result = append(result, suffix...)
+	}
+
+	return result
+}
+
+// splitObjectID splits the provided object ID into a prefix and the longest suffix that can be
+// losslessly represented in binary - lowercase hexadecimal digits of even length.
+func splitObjectID(oid ID) (prefix string, suffix []byte) {
+	s := string(oid)
+
+	p := len(s)
+	for p > 0 && isLowercaseHexDigit(s[p-1]) {
+		p--
+	}
+
+	if (len(s)-p)%2 != 0 {
+		p++
+	}
+
+	b, err := hex.DecodeString(s[p:])
+	if err != nil {
+		return s, nil
+	}
+
+	return s[0:p], b
+}
+
+func isLowercaseHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')
+}
+
+// decodeSeekTable parses the seek table stored in an index object, which can be either in binary or JSON format.
+func decodeSeekTable(b []byte) ([]indirectObjectEntry, error) {
+	var (
+		entries []indirectObjectEntry
+		err     error
+	)
+
+	if bytes.HasPrefix(b, []byte(seekTableMagic)) {
+		entries, err = decodeBinarySeekTable(b[len(seekTableMagic):])
+	} else {
+		entries, err = decodeJSONSeekTable(b)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.Errorf("invalid indirect object: no entries")
+	}
+
+	return entries, nil
+}
+
+func decodeJSONSeekTable(b []byte) ([]indirectObjectEntry, error) {
+	var ind indirectObject
+
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&ind); err != nil {
+		return nil, errors.Wrap(err, "invalid indirect object")
+	}
+
+	return ind.Entries, nil
+}
+
+func decodeBinarySeekTable(b []byte) ([]indirectObjectEntry, error) {
+	rd := bytes.NewReader(b)
+
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(rd)
+		if err != nil || n > uint64(rd.Len()) {
+			return nil, errors.Errorf("invalid length")
+		}
+
+		v := make([]byte, n)
+		rd.Read(v) //nolint:errcheck
+
+		return v, nil
+	}
+
+	count, err := binary.ReadUvarint(rd)
+	if err != nil || count > uint64(rd.Len()/minSeekTableEntrySize) {
+		return nil, errors.Errorf("invalid indirect object: invalid number of entries")
+	}
+
+	entries := make([]indirectObjectEntry, count)
+
+	var start int64
+
+	for i := range entries {
+		length, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, errors.Errorf("invalid indirect object: invalid length of entry %v", i)
+		}
+
+		prefix, err := readBytes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid indirect object: invalid prefix of entry %v", i)
+		}
+
+		suffix, err := readBytes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid indirect object: invalid object ID of entry %v", i)
+		}
+
+		entries[i] = indirectObjectEntry{
+			Start:  start,
+			Length: int64(length),
+			Object: ID(string(prefix) + hex.EncodeToString(suffix)),
+		}
+
+		start += int64(length)
+	}
+
+	if rd.Len() != 0 {
+		return nil, errors.Errorf("invalid indirect object: unexpected trailing data")
+	}
+
+	return entries, nil
+}
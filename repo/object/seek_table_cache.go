@@ -0,0 +1,239 @@
+package object
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSeekTableCacheMaxEntries is the default limit of the total number of seek table entries cached in memory.
+	defaultSeekTableCacheMaxEntries = 100000
+
+	// defaultSeekTableCacheMaxBytes is the default limit of the total size of seek tables cached on disk.
+	defaultSeekTableCacheMaxBytes = 50 << 20
+
+	// seekTableCacheSweepFrequency is the interval between sweeps of the disk cache, which matches other caches.
+	seekTableCacheSweepFrequency = 1 * time.Minute
+)
+
+// seekTableCache caches parsed seek tables of indirect objects keyed by the ID of their index object.
+// Since objects are immutable, cached seek tables never need to be invalidated.
+type seekTableCache struct {
+	mu           sync.Mutex
+	maxEntries   int
+	totalEntries int
+	lru          *list.List // of *seekTableCacheItem, most recently used first
+	items        map[ID]*list.Element
+
+	dirname  string // directory where binary-encoded seek tables are stored, empty == no disk cache
+	maxBytes int64
+
+	closed  chan struct{}
+	sweepWG sync.WaitGroup
+}
+
+type seekTableCacheItem struct {
+	indexObjectID ID
+	entries       []indirectObjectEntry
+}
+
+func newSeekTableCache(maxEntries int, dirname string, maxBytes int64) *seekTableCache {
+	if maxEntries == 0 {
+		maxEntries = defaultSeekTableCacheMaxEntries
+	}
+
+	if maxBytes == 0 {
+		maxBytes = defaultSeekTableCacheMaxBytes
+	}
+
+	return &seekTableCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		items:      map[ID]*list.Element{},
+		dirname:    dirname,
+		maxBytes:   maxBytes,
+		closed:     make(chan struct{}),
+	}
+}
+
+// startSweeping sweeps the disk cache and keeps sweeping it periodically until the cache is closed.
+func (c *seekTableCache) startSweeping(trace func(message string, args ...interface{})) {
+	if c.dirname == "" {
+		return
+	}
+
+	if err := c.sweep(); err != nil {
+		trace("unable to sweep seek table cache: %v", err)
+	}
+
+	c.sweepWG.Add(1)
+
+	go func() {
+		defer c.sweepWG.Done()
+
+		for {
+			select {
+			case <-c.closed:
+				return
+
+			case <-time.After(seekTableCacheSweepFrequency):
+				if err := c.sweep(); err != nil {
+					trace("unable to sweep seek table cache: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// close stops periodic sweeping and sweeps the disk cache for the last time.
+func (c *seekTableCache) close() error {
+	close(c.closed)
+	c.sweepWG.Wait()
+
+	return c.sweep()
+}
+
+// get returns the cached seek table of the provided index object. The returned slice must not be modified.
+func (c *seekTableCache) get(indexObjectID ID) ([]indirectObjectEntry, bool) {
+	c.mu.Lock()
+	if e := c.items[indexObjectID]; e != nil {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+
+		return e.Value.(*seekTableCacheItem).entries, true
+	}
+	c.mu.Unlock()
+
+	if c.dirname == "" {
+		return nil, false
+	}
+
+	fname := c.fileName(indexObjectID)
+
+	b, err := ioutil.ReadFile(fname) //nolint:gosec
+	if err != nil {
+		return nil, false
+	}
+
+	entries, err := decodeSeekTable(b)
+	if err != nil {
+		// disregard corrupted cache file, it will be overwritten.
+		return nil, false
+	}
+
+	// bump modification time so that recently used seek tables are retained when sweeping.
+	now := time.Now()
+	os.Chtimes(fname, now, now) //nolint:errcheck
+
+	c.addToMemory(indexObjectID, entries)
+
+	return entries, true
+}
+
+// put adds the provided seek table to the cache. The seek table must not be modified afterwards.
+func (c *seekTableCache) put(indexObjectID ID, entries []indirectObjectEntry) error {
+	c.addToMemory(indexObjectID, entries)
+
+	if c.dirname == "" {
+		return nil
+	}
+
+	return c.writeFile(indexObjectID, encodeSeekTable(entries))
+}
+
+func (c *seekTableCache) addToMemory(indexObjectID ID, entries []indirectObjectEntry) {
+	if c.maxEntries < 0 || len(entries) > c.maxEntries {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items[indexObjectID] != nil {
+		return
+	}
+
+	c.items[indexObjectID] = c.lru.PushFront(&seekTableCacheItem{indexObjectID, entries})
+	c.totalEntries += len(entries)
+
+	for c.totalEntries > c.maxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(*seekTableCacheItem)
+		delete(c.items, oldest.indexObjectID)
+		c.totalEntries -= len(oldest.entries)
+	}
+}
+
+func (c *seekTableCache) fileName(indexObjectID ID) string {
+	return filepath.Join(c.dirname, string(indexObjectID))
+}
+
+func (c *seekTableCache) writeFile(indexObjectID ID, data []byte) error {
+	if err := os.MkdirAll(c.dirname, 0700); err != nil {
+		return errors.Wrap(err, "unable to create seek table cache directory")
+	}
+
+	// write to a temp file and rename it to avoid races between processes writing the same seek table.
+	tf, err := ioutil.TempFile(c.dirname, "tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary file")
+	}
+
+	_, err = tf.Write(data)
+
+	if cerr := tf.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tf.Name(), c.fileName(indexObjectID))
+	}
+
+	if err != nil {
+		os.Remove(tf.Name()) //nolint:errcheck
+		return errors.Wrap(err, "unable to write seek table cache file")
+	}
+
+	return nil
+}
+
+// sweep removes least recently used seek tables from the disk cache until it fits in the size limit.
+func (c *seekTableCache) sweep() error {
+	if c.dirname == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(c.dirname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.Wrap(err, "unable to list seek table cache directory")
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+
+	var totalBytes int64
+
+	for _, fi := range files {
+		totalBytes += fi.Size()
+		if totalBytes <= c.maxBytes {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(c.dirname, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "unable to remove seek table cache file")
+		}
+	}
+
+	return nil
+}
@@ -1,16 +1,20 @@
 package repo
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/natefinch/atomic"
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/repo/blob"
 	loggingwrapper "github.com/kopia/kopia/repo/blob/logging"
+	"github.com/kopia/kopia/repo/blob/storagemetrics"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/logging"
 	"github.com/kopia/kopia/repo/manifest"
@@ -19,11 +23,18 @@ import (
 
 var log = logging.GetContextLoggerFunc("kopia/repo")
 
+// formatBlobCacheDuration is the maximum age of the locally-cached format blob, after which clients reconnecting
+// to the repository pick up changes to its configuration, such as rotated encryption keys.
+const formatBlobCacheDuration = 15 * time.Minute
+
 // Options provides configuration parameters for connection to a repository.
 type Options struct {
 	TraceStorage         func(f string, args ...interface{}) // Logs all storage access using provided Printf-style function
 	ObjectManagerOptions object.ManagerOptions
 	TimeNowFunc          func() time.Time // Time provider
+
+	PackUploadParallelism      int   // number of goroutines uploading packs in the background, 0 == upload synchronously
+	MaxInFlightPackUploadBytes int64 // maximum total size of packs queued for background upload, 0 == default
 }
 
 // ErrInvalidPassword is returned when repository password is invalid.
@@ -56,6 +67,8 @@ func Open(ctx context.Context, configFile, password string, options *Options) (r
 		return nil, errors.Wrap(err, "cannot open storage")
 	}
 
+	st = storagemetrics.NewWrapper(st)
+
 	if options.TraceStorage != nil {
 		st = loggingwrapper.NewWrapper(st, options.TraceStorage, "[STORAGE] ")
 	}
@@ -95,6 +108,8 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 		return nil, errors.Wrap(err, "can't parse format blob")
 	}
 
+	formatBlobBytes := fb
+
 	fb, err = addFormatBlobChecksumAndLength(fb)
 	if err != nil {
 		return nil, errors.Errorf("unable to add checksum")
@@ -122,6 +137,9 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 	cmOpts := content.ManagerOptions{
 		RepositoryFormatBytes: fb,
 		TimeNow:               defaultTime(options.TimeNowFunc),
+
+		PackUploadParallelism:      options.PackUploadParallelism,
+		MaxInFlightPackUploadBytes: options.MaxInFlightPackUploadBytes,
 	}
 
 	cm, err := content.NewManager(ctx, st, fo, caching, cmOpts)
@@ -129,7 +147,18 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 		return nil, errors.Wrap(err, "unable to open content manager")
 	}
 
-	om, err := object.NewObjectManager(ctx, cm, repoConfig.Format, options.ObjectManagerOptions)
+	omOpts := options.ObjectManagerOptions
+	if omOpts.SeekTableCacheDirectory == "" && caching.CacheDirectory != "" {
+		omOpts.SeekTableCacheDirectory = filepath.Join(caching.CacheDirectory, "seektables")
+	}
+
+	if omOpts.SeekTableCacheMaxBytes == 0 {
+		omOpts.SeekTableCacheMaxBytes = caching.MaxSeekTableCacheSizeBytes
+	}
+
+	omOpts.BinarySeekTables = fo.Version >= content.FormatVersion3
+
+	om, err := object.NewObjectManager(ctx, cm, repoConfig.Format, omOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open object manager")
 	}
@@ -146,9 +175,10 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 		Manifests: manifests,
 		UniqueID:  f.UniqueID,
 
-		formatBlob: f,
-		masterKey:  masterKey,
-		timeNow:    cmOpts.TimeNow,
+		formatBlob:      f,
+		formatBlobBytes: formatBlobBytes,
+		masterKey:       masterKey,
+		timeNow:         cmOpts.TimeNow,
 	}, nil
 }
 
@@ -175,19 +205,50 @@ func (r *Repository) SetCachingConfig(ctx context.Context, opt content.CachingOp
 	return nil
 }
 
+// SetStorageConnectionInfo replaces the storage connection information in the repository configuration file.
+// The new configuration takes effect the next time the repository is opened.
+func (r *Repository) SetStorageConnectionInfo(ctx context.Context, ci blob.ConnectionInfo) error {
+	lc, err := loadConfigFromFile(r.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	lc.Storage = ci
+
+	d, err := json.MarshalIndent(&lc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write atomically, so that the configuration is never left half-written.
+	if err := atomic.WriteFile(r.ConfigFile, bytes.NewReader(d)); err != nil {
+		return errors.Wrap(err, "unable to write config file")
+	}
+
+	return nil
+}
+
 func readAndCacheFormatBlobBytes(ctx context.Context, st blob.Storage, cacheDirectory string) ([]byte, error) {
-	cachedFile := filepath.Join(cacheDirectory, "kopia.repository")
+	cachedFile := filepath.Join(cacheDirectory, FormatBlobID)
+
+	var cached []byte
 
 	if cacheDirectory != "" {
-		b, err := ioutil.ReadFile(cachedFile) //nolint:gosec
-		if err == nil {
-			// read from cache.
-			return b, nil
+		if fi, err := os.Stat(cachedFile); err == nil {
+			if cached, err = ioutil.ReadFile(cachedFile); err == nil && time.Since(fi.ModTime()) < formatBlobCacheDuration { //nolint:gosec
+				// read from cache.
+				return cached, nil
+			}
 		}
 	}
 
 	b, err := st.GetBlob(ctx, FormatBlobID, 0, -1)
 	if err != nil {
+		if cached != nil && err != blob.ErrBlobNotFound {
+			log(ctx).Warningf("unable to read format blob, using cached copy: %v", err)
+			return cached, nil
+		}
+
 		return nil, err
 	}
 
@@ -25,14 +25,15 @@ type Repository struct {
 	Hostname string // connected (localhost) hostname
 	Username string // connected username
 
-	timeNow    func() time.Time
-	formatBlob *formatBlob
-	masterKey  []byte
+	timeNow         func() time.Time
+	formatBlob      *formatBlob
+	formatBlobBytes []byte // contents of the format blob the repository has been opened with
+	masterKey       []byte
 }
 
 // Close closes the repository and releases all resources.
 func (r *Repository) Close(ctx context.Context) error {
-	if err := r.Flush(ctx); err != nil {
+	if err := r.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
 		return errors.Wrap(err, "error flushing")
 	}
 
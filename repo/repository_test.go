@@ -5,13 +5,17 @@ import (
 	"context"
 	"io/ioutil"
 	"math/rand"
+	"reflect"
 	"runtime/debug"
 	"testing"
+	"time"
 
 	"github.com/kopia/kopia/internal/repotesting"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob/filesystem"
 	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/encryption"
 	"github.com/kopia/kopia/repo/object"
 )
 
@@ -178,6 +182,230 @@ func TestUpgrade(t *testing.T) {
 	}
 }
 
+func TestSetMaxPackSize(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	// splitter used in test environment produces 1MB segments.
+	if err := env.Repository.SetMaxPackSize(ctx, 500000); err == nil {
+		t.Errorf("expected error when setting pack size smaller than splitter segment size")
+	}
+
+	if err := env.Repository.SetMaxPackSize(ctx, 1<<30); err == nil {
+		t.Errorf("expected error when setting too large pack size")
+	}
+
+	if err := env.Repository.SetMaxPackSize(ctx, 64<<20); err != nil {
+		t.Fatalf("unable to set max pack size: %v", err)
+	}
+
+	env.MustReopen(t)
+
+	if got, want := env.Repository.Content.Format.MaxPackSize, 64<<20; got != want {
+		t.Errorf("unexpected max pack size after reopen: %v, want %v", got, want)
+	}
+}
+
+func TestSetMaxPackSizeChangedByAnotherClient(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	other := env.MustOpenAnother(t)
+	defer other.Close(ctx)
+
+	if err := other.SetMaxPackSize(ctx, 32<<20); err != nil {
+		t.Fatalf("unable to set max pack size: %v", err)
+	}
+
+	// the format blob this repository has been opened with is stale.
+	if err := env.Repository.SetMaxPackSize(ctx, 64<<20); err == nil {
+		t.Fatalf("unexpected success updating stale repository configuration")
+	}
+
+	env.MustReopen(t)
+
+	if got, want := env.Repository.Content.Format.MaxPackSize, 32<<20; got != want {
+		t.Errorf("unexpected max pack size after reopen: %v, want %v", got, want)
+	}
+
+	if err := env.Repository.SetMaxPackSize(ctx, 64<<20); err != nil {
+		t.Fatalf("unable to set max pack size after reopen: %v", err)
+	}
+}
+
+func TestValidateSplitter(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	if err := env.Repository.SetMaxPackSize(ctx, 2<<20); err != nil {
+		t.Fatalf("unable to set max pack size: %v", err)
+	}
+
+	env.MustReopen(t)
+
+	if err := env.Repository.ValidateSplitter("FIXED-2M"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, s := range []string{"FIXED-4M", "DYNAMIC-2M-FASTCDC", "NO-SUCH-SPLITTER"} {
+		if err := env.Repository.ValidateSplitter(s); err == nil {
+			t.Errorf("expected error validating splitter %v", s)
+		}
+	}
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t, func(opt *repo.NewRepositoryOptions) {
+		opt.BlockFormat.Encryption = encryption.DefaultAlgorithm
+	}).Close(ctx, t)
+
+	data1 := []byte("written before key rotation")
+	oid1 := writeObject(ctx, t, env.Repository, data1, "before")
+
+	if err := env.Repository.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	keyID, err := env.Repository.RotateEncryptionKey(ctx)
+	if err != nil {
+		t.Fatalf("unable to rotate key: %v", err)
+	}
+
+	if keyID != 1 {
+		t.Errorf("unexpected key ID: %v", keyID)
+	}
+
+	env.MustReopen(t)
+
+	if got, want := env.Repository.Content.Format.EncryptionKeyID, keyID; got != want {
+		t.Errorf("unexpected key ID after reopen: %v, want %v", got, want)
+	}
+
+	if got, want := len(env.Repository.Content.Format.PreviousMasterKeys), 1; got != want {
+		t.Errorf("unexpected number of previous keys: %v, want %v", got, want)
+	}
+
+	data2 := []byte("written after key rotation")
+	oid2 := writeObject(ctx, t, env.Repository, data2, "after")
+
+	verify(ctx, t, env.Repository, oid1, data1, "before")
+	verify(ctx, t, env.Repository, oid2, data2, "after")
+
+	if err := env.Repository.RetirePreviousEncryptionKeys(ctx, 0); err == nil {
+		t.Fatalf("expected error retiring keys still in use")
+	}
+
+	// re-encrypt contents written using the previous key.
+	if err := env.Repository.Content.IterateContents(ctx, content.IterateOptions{}, func(ci content.Info) error {
+		if ci.EncryptionKeyID != keyID {
+			return env.Repository.Content.RewriteContent(ctx, ci.ID)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatalf("unable to rewrite contents: %v", err)
+	}
+
+	// clients connected before the rotation may still be writing contents using the previous key.
+	if err := env.Repository.RetirePreviousEncryptionKeys(ctx, time.Hour); err == nil {
+		t.Fatalf("expected error retiring keys rotated out within the grace period")
+	}
+
+	if err := env.Repository.RetirePreviousEncryptionKeys(ctx, 0); err != nil {
+		t.Fatalf("unable to retire keys: %v", err)
+	}
+
+	env.MustReopen(t)
+
+	if got := len(env.Repository.Content.Format.PreviousMasterKeys); got != 0 {
+		t.Errorf("unexpected number of previous keys after retiring: %v", got)
+	}
+
+	if blobs, err := env.Repository.Content.IndexBlobsEncryptedWithPreviousKeys(ctx); err != nil || len(blobs) != 0 {
+		t.Errorf("unexpected index blobs encrypted with previous keys: %v %v", blobs, err)
+	}
+
+	verify(ctx, t, env.Repository, oid1, data1, "before")
+	verify(ctx, t, env.Repository, oid2, data2, "after")
+}
+
+func TestRotateEncryptionKeyRequiresFormatVersion3(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t, func(opt *repo.NewRepositoryOptions) {
+		opt.BlockFormat.Encryption = encryption.DefaultAlgorithm
+		opt.BlockFormat.Version = content.FormatVersion2
+	}).Close(ctx, t)
+
+	if _, err := env.Repository.RotateEncryptionKey(ctx); err == nil {
+		t.Fatalf("expected error rotating key of format version 2 repository")
+	}
+
+	if err := env.Repository.UpgradeFormatVersion(ctx); err != nil {
+		t.Fatalf("unable to upgrade format version: %v", err)
+	}
+
+	env.MustReopen(t)
+
+	if _, err := env.Repository.RotateEncryptionKey(ctx); err != nil {
+		t.Fatalf("unable to rotate key after upgrade: %v", err)
+	}
+}
+
+func TestRetireEncryptionKeysAfterConcurrentRotation(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t, func(opt *repo.NewRepositoryOptions) {
+		opt.BlockFormat.Encryption = encryption.DefaultAlgorithm
+	}).Close(ctx, t)
+
+	if _, err := env.Repository.RotateEncryptionKey(ctx); err != nil {
+		t.Fatalf("unable to rotate key: %v", err)
+	}
+
+	env.MustReopen(t)
+
+	other := env.MustOpenAnother(t)
+	defer other.Close(ctx)
+
+	if _, err := other.RotateEncryptionKey(ctx); err != nil {
+		t.Fatalf("unable to rotate key: %v", err)
+	}
+
+	// this repository is unaware of the newest key and must not retire the one it's using.
+	if err := env.Repository.RetirePreviousEncryptionKeys(ctx, 0); err == nil {
+		t.Fatalf("unexpected success retiring keys after concurrent rotation")
+	}
+
+	env.MustReopen(t)
+
+	if got, want := len(env.Repository.Content.Format.PreviousMasterKeys), 2; got != want {
+		t.Errorf("unexpected number of previous keys: %v, want %v", got, want)
+	}
+}
+
+func TestRotateEncryptionKeyUnencrypted(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	if _, err := env.Repository.RotateEncryptionKey(ctx); err == nil {
+		t.Errorf("expected error rotating key of unencrypted repository")
+	}
+}
+
 func TestReaderStoredBlockNotFound(t *testing.T) {
 	var env repotesting.Environment
 
@@ -319,3 +547,28 @@ func TestFormats(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenFromURL(t *testing.T) {
+	tok, err := repo.TokenFromURL("file:///tmp/some-repo?shards=flat", "some-password")
+	if err != nil {
+		t.Fatalf("unable to create token: %v", err)
+	}
+
+	ci, pass, err := repo.DecodeToken(tok)
+	if err != nil {
+		t.Fatalf("unable to decode token: %v", err)
+	}
+
+	if got, want := pass, "some-password"; got != want {
+		t.Errorf("invalid password: %v, want %v", got, want)
+	}
+
+	want := &filesystem.Options{Path: "/tmp/some-repo", DirectoryShards: []int{}}
+	if ci.Type != "filesystem" || !reflect.DeepEqual(ci.Config, want) {
+		t.Errorf("invalid connection info: %v %#v", ci.Type, ci.Config)
+	}
+
+	if _, err := repo.TokenFromURL("no-such-scheme://foo", ""); err == nil {
+		t.Errorf("expected error for unsupported URL")
+	}
+}
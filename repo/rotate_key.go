@@ -0,0 +1,143 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/encryption"
+)
+
+// maxEncryptionKeyIDs is the number of distinct key IDs that can be recorded in index entries.
+const maxEncryptionKeyIDs = 256
+
+// RotateEncryptionKey replaces the master key used to encrypt new contents with a new random key and returns its ID.
+// The previous key is kept in the repository configuration, so that existing contents remain readable until
+// they are re-encrypted and the key is retired using RetirePreviousEncryptionKeys().
+// The repository must be reopened for the new key to take effect, other clients pick it up when they reconnect.
+// Key rotation requires format version 3, which clients unaware of previous keys refuse to open.
+func (r *Repository) RotateEncryptionKey(ctx context.Context) (byte, error) {
+	var newKeyID byte
+
+	err := r.updateRepositoryConfig(ctx, func(repoConfig *repositoryObjectFormat) error {
+		if repoConfig.Encryption == encryption.NoneAlgorithm {
+			return errors.Errorf("repository is not encrypted")
+		}
+
+		keyID, err := nextEncryptionKeyID(&repoConfig.FormattingOptions)
+		if err != nil {
+			return err
+		}
+
+		repoConfig.PreviousMasterKeys = append(repoConfig.PreviousMasterKeys, content.PreviousMasterKey{
+			KeyID:     repoConfig.EncryptionKeyID,
+			MasterKey: repoConfig.MasterKey,
+			RotatedAt: r.Time(),
+		})
+
+		repoConfig.MasterKey = randomBytes(masterKeyLength)
+		repoConfig.EncryptionKeyID = keyID
+		newKeyID = keyID
+
+		return repoConfig.ValidateFormatVersion()
+	})
+
+	return newKeyID, err
+}
+
+// nextEncryptionKeyID returns the key ID following the current one. Key IDs are never reused, even after
+// the key has been retired, because locally cached contents are identified by the ID of the key they are
+// encrypted with, which limits the number of rotations to maxEncryptionKeyIDs-1.
+func nextEncryptionKeyID(f *content.FormattingOptions) (byte, error) {
+	if int(f.EncryptionKeyID)+1 >= maxEncryptionKeyIDs {
+		return 0, errors.Errorf("the encryption key has been rotated the maximum number of times (%v)", maxEncryptionKeyIDs-1)
+	}
+
+	return f.EncryptionKeyID + 1, nil
+}
+
+// RetirePreviousEncryptionKeys destroys master keys that have been rotated out, after ensuring that no contents
+// or index blobs are encrypted using them. Deleted contents encrypted using previous keys become unrecoverable.
+//
+// Clients that were connected when a key was rotated out keep writing contents encrypted using it until they
+// reconnect, so keys can only be retired once they have been rotated out for at least the provided grace period.
+// It can be zero if all clients are known to have reconnected since the last rotation.
+func (r *Repository) RetirePreviousEncryptionKeys(ctx context.Context, gracePeriod time.Duration) error {
+	// fails if the key has been rotated since the repository was opened.
+	_, repoConfig, err := r.readCurrentRepositoryConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(repoConfig.PreviousMasterKeys) == 0 {
+		return nil
+	}
+
+	for _, pk := range repoConfig.PreviousMasterKeys {
+		if age := r.Time().Sub(pk.RotatedAt); age < gracePeriod {
+			return errors.Errorf("key %v has been rotated out only %v ago, clients connected before may still be using it", pk.KeyID, age.Truncate(time.Second))
+		}
+	}
+
+	// spooled packs are encrypted using the current key.
+	if err := r.Content.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
+		return errors.Wrap(err, "unable to flush contents")
+	}
+
+	if _, err := r.Content.Refresh(ctx); err != nil {
+		return errors.Wrap(err, "unable to refresh indexes")
+	}
+
+	cnt, err := r.CountContentsEncryptedWithPreviousKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cnt > 0 {
+		return errors.Errorf("%v contents are still encrypted using previous keys", cnt)
+	}
+
+	if _, ok := r.Content.EpochManager(); !ok {
+		if err := r.Content.CompactIndexes(ctx, content.CompactOptions{ReencryptIndexes: true}); err != nil {
+			return errors.Wrap(err, "unable to re-encrypt indexes")
+		}
+	}
+
+	remaining, err := r.Content.IndexBlobsEncryptedWithPreviousKeys(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to verify index blobs")
+	}
+
+	if len(remaining) > 0 {
+		if _, ok := r.Content.EpochManager(); ok {
+			return errors.Errorf("%v index blobs are still encrypted using previous keys, index blobs of sealed epochs are not re-encrypted", len(remaining))
+		}
+
+		return errors.Errorf("%v index blobs are still encrypted using previous keys", len(remaining))
+	}
+
+	// fails if the key has been rotated concurrently.
+	return r.updateRepositoryConfig(ctx, func(repoConfig *repositoryObjectFormat) error {
+		repoConfig.PreviousMasterKeys = nil
+
+		return nil
+	})
+}
+
+// CountContentsEncryptedWithPreviousKeys returns the number of non-deleted contents which are encrypted
+// using master keys other than the current one.
+func (r *Repository) CountContentsEncryptedWithPreviousKeys(ctx context.Context) (int, error) {
+	var cnt int
+
+	err := r.Content.IterateContents(ctx, content.IterateOptions{}, func(ci content.Info) error {
+		if ci.EncryptionKeyID != r.Content.Format.EncryptionKeyID {
+			cnt++
+		}
+
+		return nil
+	})
+
+	return cnt, errors.Wrap(err, "unable to iterate contents")
+}
@@ -0,0 +1,22 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/repo/content"
+)
+
+func TestNextEncryptionKeyID(t *testing.T) {
+	f := &content.FormattingOptions{EncryptionKeyID: 7}
+
+	if id, err := nextEncryptionKeyID(f); err != nil || id != 8 {
+		t.Errorf("unexpected next key ID: %v %v", id, err)
+	}
+
+	// key IDs never wrap around to previously used ones, including 0.
+	f.EncryptionKeyID = maxEncryptionKeyIDs - 1
+
+	if id, err := nextEncryptionKeyID(f); err == nil {
+		t.Errorf("unexpected success rotating past the last key ID: %v", id)
+	}
+}
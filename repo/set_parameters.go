@@ -0,0 +1,168 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/epoch"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/splitter"
+)
+
+const maxAllowedPackSize = 256 << 20 // packs are assembled in memory
+
+// validateMaxPackSize ensures that packs of the provided size can hold the largest object chunk
+// produced by the provided splitter.
+func validateMaxPackSize(maxPackSize int, splitterName string) error {
+	if maxPackSize > maxAllowedPackSize {
+		return errors.Errorf("max pack size %v must not exceed %v", maxPackSize, maxAllowedPackSize)
+	}
+
+	return validateSplitterMaxSegmentSize(splitterName, maxPackSize)
+}
+
+func validateSplitterMaxSegmentSize(splitterName string, maxPackSize int) error {
+	fact := splitter.GetFactory(splitterName)
+	if fact == nil {
+		return errors.Errorf("unknown splitter: %v", splitterName)
+	}
+
+	s := fact()
+	defer s.Close()
+
+	if maxPackSize < s.MaxSegmentSize() {
+		return errors.Errorf("max pack size %v must not be smaller than the maximum segment size of %v splitter (%v)", maxPackSize, splitterName, s.MaxSegmentSize())
+	}
+
+	return nil
+}
+
+// ValidateSplitter ensures that chunks produced by the provided splitter fit in the packs of the repository.
+func (r *Repository) ValidateSplitter(splitterName string) error {
+	return validateSplitterMaxSegmentSize(splitterName, r.Content.Format.MaxPackSize)
+}
+
+// SetMaxPackSize changes the maximum size of packs written to the repository by rewriting the format blob.
+// The new size must fit chunks of the repository splitter and of the provided additional splitters,
+// such as the ones selected by policies. Other clients pick up the new value when they reconnect.
+func (r *Repository) SetMaxPackSize(ctx context.Context, maxPackSize int, additionalSplitters ...string) error {
+	return r.updateRepositoryConfig(ctx, func(repoConfig *repositoryObjectFormat) error {
+		if err := validateMaxPackSize(maxPackSize, repoConfig.Splitter); err != nil {
+			return err
+		}
+
+		for _, n := range additionalSplitters {
+			if err := validateSplitterMaxSegmentSize(n, maxPackSize); err != nil {
+				return err
+			}
+		}
+
+		repoConfig.MaxPackSize = maxPackSize
+
+		return nil
+	})
+}
+
+// UpgradeFormatVersion upgrades the repository to the latest format version, which enables features
+// that clients supporting only older versions can't handle. Such clients will be unable to open the repository,
+// other clients pick up the new format version when they reconnect.
+func (r *Repository) UpgradeFormatVersion(ctx context.Context) error {
+	return r.updateRepositoryConfig(ctx, func(repoConfig *repositoryObjectFormat) error {
+		if repoConfig.Version >= content.DefaultFormatVersion {
+			return errors.Errorf("repository already uses format version %v", repoConfig.Version)
+		}
+
+		repoConfig.Version = content.DefaultFormatVersion
+
+		return nil
+	})
+}
+
+// EnableIndexEpochs enables epoch-based management of index blobs with default parameters. Existing index blobs
+// are moved into epochs by the next index maintenance, other clients pick up the change when they reconnect.
+func (r *Repository) EnableIndexEpochs(ctx context.Context) error {
+	return r.updateRepositoryConfig(ctx, func(repoConfig *repositoryObjectFormat) error {
+		if repoConfig.EpochParameters.IsEnabled() {
+			return errors.Errorf("index epochs are already enabled")
+		}
+
+		p := epoch.DefaultParameters()
+		repoConfig.EpochParameters = &p
+
+		return repoConfig.ValidateFormatVersion()
+	})
+}
+
+// updateRepositoryConfig applies the provided modification to the repository configuration and rewrites the format blob.
+// The format blob is re-read from the storage first and the update fails if it has been changed by another client
+// since the repository was opened. The storage doesn't support conditional writes, so concurrent updates
+// can still race, but only within the duration of this call.
+func (r *Repository) updateRepositoryConfig(ctx context.Context, modify func(repoConfig *repositoryObjectFormat) error) error {
+	f, repoConfig, err := r.readCurrentRepositoryConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := modify(repoConfig); err != nil {
+		return err
+	}
+
+	if err := encryptFormatBytes(f, repoConfig, r.masterKey, f.UniqueID); err != nil {
+		return errors.Wrap(err, "unable to encrypt format bytes")
+	}
+
+	b, err := serializeFormatBlob(f)
+	if err != nil {
+		return err
+	}
+
+	if err := r.Blobs.PutBlob(ctx, FormatBlobID, b); err != nil {
+		return errors.Wrap(err, "unable to write format blob")
+	}
+
+	r.formatBlob = f
+	r.formatBlobBytes = b
+
+	// remove locally-cached copy of the format blob, so that it is re-read on next open.
+	r.removeCachedFormatBlob(ctx)
+
+	return nil
+}
+
+// readCurrentRepositoryConfig reads the repository configuration from the storage and fails if it has been changed
+// by another client since the repository was opened.
+func (r *Repository) readCurrentRepositoryConfig(ctx context.Context) (*formatBlob, *repositoryObjectFormat, error) {
+	current, err := r.Blobs.GetBlob(ctx, FormatBlobID, 0, -1)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read format blob")
+	}
+
+	if !bytes.Equal(current, r.formatBlobBytes) {
+		r.removeCachedFormatBlob(ctx)
+		return nil, nil, errors.Errorf("repository configuration has been changed by another client, reopen the repository and try again")
+	}
+
+	f, err := parseFormatBlob(current)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't parse format blob")
+	}
+
+	repoConfig, err := f.decryptFormatBytes(r.masterKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to decrypt repository config")
+	}
+
+	return f, repoConfig, nil
+}
+
+func (r *Repository) removeCachedFormatBlob(ctx context.Context) {
+	if cd := r.Content.CachingOptions.CacheDirectory; cd != "" {
+		if err := os.Remove(filepath.Join(cd, FormatBlobID)); err != nil && !os.IsNotExist(err) {
+			log(ctx).Warningf("unable to remove cached format blob: %v", err)
+		}
+	}
+}
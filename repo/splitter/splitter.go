@@ -51,6 +51,11 @@ var splitterFactories = map[string]Factory{
 	"DYNAMIC-4M-RABINKARP": newRabinKarp64SplitterFactory(megabytes(4)), //nolint:gomnd
 	"DYNAMIC-8M-RABINKARP": newRabinKarp64SplitterFactory(megabytes(8)), //nolint:gomnd
 
+	"DYNAMIC-1M-FASTCDC": newFastCDCSplitterFactory(megabytes(1)), //nolint:gomnd
+	"DYNAMIC-2M-FASTCDC": newFastCDCSplitterFactory(megabytes(2)), //nolint:gomnd
+	"DYNAMIC-4M-FASTCDC": newFastCDCSplitterFactory(megabytes(4)), //nolint:gomnd
+	"DYNAMIC-8M-FASTCDC": newFastCDCSplitterFactory(megabytes(8)), //nolint:gomnd
+
 	// handle deprecated legacy names to splitters of arbitrary size
 	"FIXED": Fixed(4 << 20), //nolint:gomnd
 
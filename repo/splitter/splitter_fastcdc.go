@@ -0,0 +1,106 @@
+package splitter
+
+import (
+	"math/bits"
+)
+
+// fastCDCNormalizationLevel is the number of mask bits added before and removed after
+// the average chunk size, which narrows the distribution of chunk sizes.
+const fastCDCNormalizationLevel = 2
+
+// fastCDCGear is the table of random values used by the gear hash, generated deterministically
+// so that chunk boundaries never change.
+var fastCDCGear = func() (result [256]uint64) {
+	// splitmix64
+	var state uint64 = 0x6b6f706961666364
+
+	for i := range result {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		result[i] = z ^ (z >> 31)
+	}
+
+	return
+}()
+
+// fastCDCSplitter implements FastCDC content-defined chunking with normalized chunking,
+// as described in "FastCDC: a Fast and Efficient Content-Defined Chunking Approach for Data Deduplication"
+// by Wen Xia et al.
+type fastCDCSplitter struct {
+	fp    uint64
+	count int
+
+	minSize    int
+	normalSize int
+	maxSize    int
+
+	maskS uint64 // harder to match, used for chunks smaller than normalSize
+	maskL uint64 // easier to match, used for chunks larger than normalSize
+}
+
+func (s *fastCDCSplitter) Close() {
+}
+
+func (s *fastCDCSplitter) Reset() {
+	s.fp = 0
+	s.count = 0
+}
+
+func (s *fastCDCSplitter) ShouldSplit(b byte) bool {
+	s.count++
+
+	// bytes before the minimum chunk size don't affect chunk boundaries.
+	if s.count <= s.minSize {
+		return false
+	}
+
+	s.fp = (s.fp << 1) + fastCDCGear[b]
+
+	mask := s.maskL
+	if s.count < s.normalSize {
+		mask = s.maskS
+	}
+
+	if s.fp&mask == 0 || s.count >= s.maxSize {
+		s.Reset()
+		return true
+	}
+
+	return false
+}
+
+func (s *fastCDCSplitter) MaxSegmentSize() int {
+	return s.maxSize
+}
+
+// fastCDCMask returns a mask with the provided number of most significant bits set,
+// which depend on the last 64 bytes hashed by the gear hash.
+func fastCDCMask(numBits int) uint64 {
+	if numBits <= 0 {
+		return 0
+	}
+
+	return ^uint64(0) << (64 - numBits) //nolint:gomnd
+}
+
+// FastCDC returns a factory that creates FastCDC splitters producing chunks between minSize and maxSize
+// bytes long, with sizes normalized around avgSize.
+func FastCDC(minSize, avgSize, maxSize int) Factory {
+	avgBits := bits.Len(uint(avgSize)) - 1
+
+	return func() Splitter {
+		return &fastCDCSplitter{
+			minSize:    minSize,
+			normalSize: avgSize,
+			maxSize:    maxSize,
+			maskS:      fastCDCMask(avgBits + fastCDCNormalizationLevel),
+			maskL:      fastCDCMask(avgBits - fastCDCNormalizationLevel),
+		}
+	}
+}
+
+func newFastCDCSplitterFactory(avgSize int) Factory {
+	return FastCDC(avgSize/4, avgSize, avgSize*2) //nolint:gomnd
+}
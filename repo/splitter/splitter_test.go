@@ -14,6 +14,7 @@ func TestSplitters(t *testing.T) {
 	}{
 		{"rolling buzhash with 3 bits", newBuzHash32SplitterFactory(8)},
 		{"rolling buzhash with 5 bits", newBuzHash32SplitterFactory(32)},
+		{"fastcdc with 5 bits", newFastCDCSplitterFactory(32)},
 	}
 
 	for _, tc := range cases {
@@ -59,6 +60,10 @@ func TestSplitterStability(t *testing.T) {
 		{newRabinKarp64SplitterFactory(2048)(), 1887, 2649, 1028, 4096},
 		{newRabinKarp64SplitterFactory(32768)(), 121, 41322, 16896, 65536},
 		{newRabinKarp64SplitterFactory(65536)(), 53, 94339, 35875, 131072},
+		{newFastCDCSplitterFactory(32)(), 140185, 35, 9, 64},
+		{newFastCDCSplitterFactory(1024)(), 4277, 1169, 258, 2048},
+		{newFastCDCSplitterFactory(2048)(), 2156, 2319, 514, 4096},
+		{newFastCDCSplitterFactory(32768)(), 136, 36764, 8767, 65536},
 	}
 
 	for _, tc := range cases {
@@ -10,20 +10,39 @@ import (
 )
 
 type tokenInfo struct {
-	Version  string              `json:"version"`
-	Storage  blob.ConnectionInfo `json:"storage"`
-	Password string              `json:"password,omitempty"`
+	Version  string               `json:"version"`
+	Storage  *blob.ConnectionInfo `json:"storage,omitempty"`
+	URL      string               `json:"url,omitempty"` // storage URL, used when Storage is not set
+	Password string               `json:"password,omitempty"`
 }
 
 // Token returns an opaque token that contains repository connection information
 // and optionally the provided password.
 func (r *Repository) Token(password string) (string, error) {
-	ti := &tokenInfo{
+	ci := r.Blobs.ConnectionInfo()
+
+	return encodeToken(&tokenInfo{
 		Version:  "1",
-		Storage:  r.Blobs.ConnectionInfo(),
+		Storage:  &ci,
 		Password: password,
+	})
+}
+
+// TokenFromURL returns an opaque token that contains the provided storage URL
+// and optionally the provided password.
+func TokenFromURL(storageURL, password string) (string, error) {
+	if _, err := blob.ParseURL(storageURL); err != nil {
+		return "", err
 	}
 
+	return encodeToken(&tokenInfo{
+		Version:  "1",
+		URL:      storageURL,
+		Password: password,
+	})
+}
+
+func encodeToken(ti *tokenInfo) (string, error) {
 	v, err := json.Marshal(ti)
 	if err != nil {
 		return "", errors.Wrap(err, "marshal token")
@@ -49,5 +68,19 @@ func DecodeToken(token string) (blob.ConnectionInfo, string, error) {
 		return blob.ConnectionInfo{}, "", errors.New("unsupported token version")
 	}
 
-	return t.Storage, t.Password, nil
+	switch {
+	case t.Storage != nil:
+		return *t.Storage, t.Password, nil
+
+	case t.URL != "":
+		ci, err := blob.ParseURL(t.URL)
+		if err != nil {
+			return blob.ConnectionInfo{}, "", errors.Wrap(err, "invalid storage URL in token")
+		}
+
+		return ci, t.Password, nil
+
+	default:
+		return blob.ConnectionInfo{}, "", errors.New("token does not contain storage information")
+	}
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/kopia/kopia/internal/stats"
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/logging"
 	"github.com/kopia/kopia/repo/manifest"
@@ -74,8 +75,23 @@ func findInUseContentIDs(ctx context.Context, rep *repo.Repository, used *sync.M
 }
 
 // Run performs garbage collection on all the snapshots in the repository.
-// nolint:gocognit
 func Run(ctx context.Context, rep *repo.Repository, minContentAge time.Duration, gcDelete bool) (Stats, error) {
+	st, err := run(ctx, rep, minContentAge, gcDelete)
+	recordRunMetrics(ctx, rep.Time(), st, err)
+
+	if err != nil {
+		return st, err
+	}
+
+	if st.UnusedCount > 0 && !gcDelete {
+		return st, errors.Errorf("Not deleting because '--delete' flag was not set")
+	}
+
+	return st, nil
+}
+
+// nolint:gocognit
+func run(ctx context.Context, rep *repo.Repository, minContentAge time.Duration, gcDelete bool) (Stats, error) {
 	var used sync.Map
 
 	var st Stats
@@ -86,22 +102,35 @@ func Run(ctx context.Context, rep *repo.Repository, minContentAge time.Duration,
 
 	var unused, inUse, system, tooRecent stats.CountSum
 
+	// packs containing system, in-use or recent contents, which must be retained, along with the timestamp
+	// of their newest content, which is the earliest time the pack could have been written.
+	referencedPacks := map[blob.ID]int64{}
+
+	referencePack := func(ci content.Info) {
+		if ci.TimestampSeconds > referencedPacks[ci.PackBlobID] {
+			referencedPacks[ci.PackBlobID] = ci.TimestampSeconds
+		}
+	}
+
 	log(ctx).Infof("looking for unreferenced contents")
 
 	err := rep.Content.IterateContents(ctx, content.IterateOptions{}, func(ci content.Info) error {
-		if manifest.ContentPrefix == ci.ID.Prefix() {
+		if p := ci.ID.Prefix(); p == manifest.ContentPrefix || p == object.DictionaryContentPrefix {
 			system.Add(int64(ci.Length))
+			referencePack(ci)
 			return nil
 		}
 
 		if _, ok := used.Load(ci.ID); ok {
 			inUse.Add(int64(ci.Length))
+			referencePack(ci)
 			return nil
 		}
 
 		if rep.Time().Sub(ci.Timestamp()) < minContentAge {
 			log(ctx).Debugf("recent unreferenced content %v (%v bytes, modified %v)", ci.ID, ci.Length, ci.Timestamp())
 			tooRecent.Add(int64(ci.Length))
+			referencePack(ci)
 			return nil
 		}
 
@@ -117,7 +146,7 @@ func Run(ctx context.Context, rep *repo.Repository, minContentAge time.Duration,
 		if cnt%100000 == 0 {
 			log(ctx).Infof("... found %v unused contents so far (%v bytes)", cnt, units.BytesStringBase2(totalSize))
 			if gcDelete {
-				if err := rep.Flush(ctx); err != nil {
+				if err := rep.Flush(ctx); err != nil && !content.IsPacksSpooled(err) {
 					return errors.Wrap(err, "flush error")
 				}
 			}
@@ -135,8 +164,14 @@ func Run(ctx context.Context, rep *repo.Repository, minContentAge time.Duration,
 		return st, errors.Wrap(err, "error iterating contents")
 	}
 
-	if st.UnusedCount > 0 && !gcDelete {
-		return st, errors.Errorf("Not deleting because '--delete' flag was not set")
+	// retention only needs to be extended when blobs are being deleted.
+	if gcDelete {
+		extended, err := extendRetention(ctx, rep, referencedPacks, minContentAge)
+		st.RetentionExtendedCount = uint32(extended)
+
+		if err != nil {
+			return st, errors.Wrap(err, "error extending retention")
+		}
 	}
 
 	return st, nil
@@ -0,0 +1,98 @@
+package gc
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// maintenance metrics
+var (
+	metricLastRunTime = stats.Int64(
+		"kopia/maintenance/gc_last_run_time",
+		"Time of the last garbage collection run (seconds since Unix epoch)",
+		stats.UnitSeconds,
+	)
+
+	metricLastSuccessTime = stats.Int64(
+		"kopia/maintenance/gc_last_success_time",
+		"Time of the last successful garbage collection run (seconds since Unix epoch)",
+		stats.UnitSeconds,
+	)
+
+	metricLastRunSucceeded = stats.Int64(
+		"kopia/maintenance/gc_last_run_succeeded",
+		"Whether the last garbage collection run succeeded (1) or failed (0)",
+		stats.UnitDimensionless,
+	)
+
+	metricFailureCount = stats.Int64(
+		"kopia/maintenance/gc_failure_count",
+		"Number of failed garbage collection runs",
+		stats.UnitDimensionless,
+	)
+
+	metricUnusedCount = stats.Int64(
+		"kopia/maintenance/gc_unused_count",
+		"Number of unreferenced contents found by the last successful garbage collection run",
+		stats.UnitDimensionless,
+	)
+
+	metricUnusedBytes = stats.Int64(
+		"kopia/maintenance/gc_unused_bytes",
+		"Number of bytes in unreferenced contents found by the last successful garbage collection run",
+		stats.UnitBytes,
+	)
+
+	metricInUseBytes = stats.Int64(
+		"kopia/maintenance/gc_in_use_bytes",
+		"Number of bytes in contents referenced by snapshots, as of the last successful garbage collection run",
+		stats.UnitBytes,
+	)
+)
+
+func simpleAggregation(m stats.Measure, agg *view.Aggregation) *view.View {
+	return &view.View{
+		Name:        m.Name(),
+		Aggregation: agg,
+		Description: m.Description(),
+		Measure:     m,
+	}
+}
+
+func init() {
+	if err := view.Register(
+		simpleAggregation(metricLastRunTime, view.LastValue()),
+		simpleAggregation(metricLastSuccessTime, view.LastValue()),
+		simpleAggregation(metricLastRunSucceeded, view.LastValue()),
+		simpleAggregation(metricFailureCount, view.Count()),
+		simpleAggregation(metricUnusedCount, view.LastValue()),
+		simpleAggregation(metricUnusedBytes, view.LastValue()),
+		simpleAggregation(metricInUseBytes, view.LastValue()),
+	); err != nil {
+		panic("unable to register opencensus views: " + err.Error())
+	}
+}
+
+func recordRunMetrics(ctx context.Context, now time.Time, st Stats, err error) {
+	if err != nil {
+		stats.Record(ctx,
+			metricLastRunTime.M(now.Unix()),
+			metricLastRunSucceeded.M(0),
+			metricFailureCount.M(1),
+		)
+
+		return
+	}
+
+	stats.Record(ctx,
+		metricLastRunTime.M(now.Unix()),
+		metricLastSuccessTime.M(now.Unix()),
+		metricLastRunSucceeded.M(1),
+		metricUnusedCount.M(int64(st.UnusedCount)),
+		metricUnusedBytes.M(st.UnusedBytes),
+		metricInUseBytes.M(st.InUseBytes),
+	)
+}
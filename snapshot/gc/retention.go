@@ -0,0 +1,181 @@
+package gc
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+// retentionRenewalFraction determines when the retention of blobs is renewed - once less than
+// 1/retentionRenewalFraction of the retention period remains.
+const retentionRenewalFraction = 2
+
+// retentionStateLabels are the labels of the manifest that records the last extension of blob retention.
+var retentionStateLabels = map[string]string{
+	manifest.TypeLabelKey: "gcRetention",
+}
+
+// retentionState records the last extension of blob retention.
+// All blobs retained by GC that were written before ExtendedAt are protected at least until RetainUntil.
+type retentionState struct {
+	ExtendedAt  time.Time `json:"extendedAt"`
+	RetainUntil time.Time `json:"retainUntil"`
+}
+
+// maxRetentionPeriod returns the longest time-based retention period of all policies in the repository.
+func maxRetentionPeriod(ctx context.Context, rep *repo.Repository) (time.Duration, error) {
+	global, _, err := policy.GetEffectivePolicy(ctx, rep, policy.GlobalPolicySourceInfo)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get global policy")
+	}
+
+	result := global.RetentionPolicy.MaxRetentionPeriod()
+
+	policies, err := policy.ListPolicies(ctx, rep)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to list policies")
+	}
+
+	for _, p := range policies {
+		rp := p.RetentionPolicy
+		rp.Merge(global.RetentionPolicy)
+
+		if d := rp.MaxRetentionPeriod(); d > result {
+			result = d
+		}
+	}
+
+	return result, nil
+}
+
+func loadRetentionState(ctx context.Context, rep *repo.Repository) (*retentionState, []*manifest.EntryMetadata, error) {
+	entries, err := rep.Manifests.Find(ctx, retentionStateLabels)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to find retention state")
+	}
+
+	var result *retentionState
+
+	// in the unlikely case of concurrent GC runs, use the state that guarantees the least.
+	for _, em := range entries {
+		rs := &retentionState{}
+		if err := rep.Manifests.Get(ctx, em.ID, rs); err != nil {
+			return nil, nil, errors.Wrap(err, "unable to load retention state")
+		}
+
+		if result == nil {
+			result = rs
+			continue
+		}
+
+		if rs.ExtendedAt.Before(result.ExtendedAt) {
+			result.ExtendedAt = rs.ExtendedAt
+		}
+
+		if rs.RetainUntil.Before(result.RetainUntil) {
+			result.RetainUntil = rs.RetainUntil
+		}
+	}
+
+	if result == nil {
+		result = &retentionState{}
+	}
+
+	return result, entries, nil
+}
+
+func saveRetentionState(ctx context.Context, rep *repo.Repository, rs *retentionState, previous []*manifest.EntryMetadata) error {
+	if _, err := rep.Manifests.Put(ctx, retentionStateLabels, rs); err != nil {
+		return errors.Wrap(err, "unable to save retention state")
+	}
+
+	for _, em := range previous {
+		if err := rep.Manifests.Delete(ctx, em.ID); err != nil {
+			return errors.Wrap(err, "unable to delete previous retention state")
+		}
+	}
+
+	return nil
+}
+
+// extendRetention extends the retention of the provided packs which are retained by GC, along with
+// index and format blobs, so that they remain protected for as long as snapshots referencing them
+// may be kept by the snapshot retention policies, but no shorter than the retention period of the storage.
+//
+// Retention of all retained blobs is renewed together, once less than 1/retentionRenewalFraction of the period
+// remains. Between renewals only blobs written after the previous extension are extended, to the same time.
+// Packs whose newest content is more recent than minContentAge may not be fully known yet and are
+// considered again on the next run.
+func extendRetention(ctx context.Context, rep *repo.Repository, packs map[blob.ID]int64, minContentAge time.Duration) (int, error) {
+	storagePeriod := blob.BlobRetentionPeriod(rep.Blobs)
+	if storagePeriod == 0 {
+		return 0, nil
+	}
+
+	period, err := maxRetentionPeriod(ctx, rep)
+	if err != nil {
+		return 0, err
+	}
+
+	if period < storagePeriod {
+		period = storagePeriod
+	}
+
+	rs, previous, err := loadRetentionState(ctx, rep)
+	if err != nil {
+		return 0, err
+	}
+
+	now := rep.Time()
+	renew := rs.RetainUntil.Before(now.Add(period / retentionRenewalFraction))
+
+	if renew {
+		rs.RetainUntil = now.Add(period)
+	}
+
+	// blobs written after the last extension are only protected for the storage retention period after they were written.
+	needsExtension := func(written time.Time) bool {
+		if renew {
+			return true
+		}
+
+		return written.After(rs.ExtendedAt) && written.Add(storagePeriod).Before(rs.RetainUntil)
+	}
+
+	blobIDs := []blob.ID{repo.FormatBlobID}
+
+	indexBlobs, err := rep.Content.IndexBlobs(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to list index blobs")
+	}
+
+	for _, ib := range indexBlobs {
+		if needsExtension(ib.Timestamp) {
+			blobIDs = append(blobIDs, ib.BlobID)
+		}
+	}
+
+	for packID, ts := range packs {
+		if needsExtension(time.Unix(ts, 0)) {
+			blobIDs = append(blobIDs, packID)
+		}
+	}
+
+	log(ctx).Infof("extending retention of %v blobs until %v", len(blobIDs), rs.RetainUntil.Format(time.RFC3339))
+
+	for i, blobID := range blobIDs {
+		if err := blob.ExtendBlobRetention(ctx, rep.Blobs, blobID, rs.RetainUntil); err != nil {
+			return i, errors.Wrapf(err, "unable to extend retention of %v", blobID)
+		}
+	}
+
+	rs.ExtendedAt = now.Add(-minContentAge)
+
+	return len(blobIDs), saveRetentionState(ctx, rep, rs, previous)
+}
@@ -0,0 +1,93 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// retentionStorage records retention extensions of the underlying storage.
+type retentionStorage struct {
+	blob.Storage
+
+	period   time.Duration
+	extended map[blob.ID]time.Time
+}
+
+func (s *retentionStorage) BlobRetentionPeriod() time.Duration {
+	return s.period
+}
+
+func (s *retentionStorage) ExtendBlobRetention(ctx context.Context, id blob.ID, until time.Time) error {
+	s.extended[id] = until
+	return nil
+}
+
+func TestExtendRetention(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	defer env.Setup(t).Close(ctx, t)
+
+	rep := env.Repository
+
+	if _, err := rep.Content.WriteContent(ctx, []byte("hello"), ""); err != nil {
+		t.Fatalf("unable to write content: %v", err)
+	}
+
+	if err := rep.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	packs := map[blob.ID]int64{}
+
+	if err := rep.Content.IterateContents(ctx, content.IterateOptions{}, func(ci content.Info) error {
+		packs[ci.PackBlobID] = ci.TimestampSeconds
+		return nil
+	}); err != nil {
+		t.Fatalf("unable to iterate contents: %v", err)
+	}
+
+	st := &retentionStorage{Storage: rep.Blobs, period: time.Hour, extended: map[blob.ID]time.Time{}}
+	rep.Blobs = st
+
+	defer func() { rep.Blobs = st.Storage }()
+
+	// the first run renews retention of all blobs, to the period of the default snapshot retention policy.
+	n, err := extendRetention(ctx, rep, packs, 0)
+	if err != nil {
+		t.Fatalf("unable to extend retention: %v", err)
+	}
+
+	if n < len(packs)+1 {
+		t.Fatalf("unexpected number of extended blobs: %v", n)
+	}
+
+	for packID := range packs {
+		if until := st.extended[packID]; until.Before(rep.Time().Add(24 * time.Hour)) {
+			t.Errorf("retention of %v not extended according to the snapshot retention policy: %v", packID, until)
+		}
+	}
+
+	if err := rep.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	st.extended = map[blob.ID]time.Time{}
+
+	// blobs extended by the previous run are still protected and are skipped.
+	if _, err = extendRetention(ctx, rep, packs, 0); err != nil {
+		t.Fatalf("unable to extend retention: %v", err)
+	}
+
+	for packID := range packs {
+		if _, ok := st.extended[packID]; ok {
+			t.Errorf("retention of %v was extended again", packID)
+		}
+	}
+}
@@ -7,4 +7,7 @@ type Stats struct {
 	// Also results in a smaller struct size
 	UnusedBytes, InUseBytes, SystemBytes, TooRecentBytes int64
 	UnusedCount, InUseCount, SystemCount, TooRecentCount uint32
+
+	// RetentionExtendedCount is the number of blobs whose retention was extended.
+	RetentionExtendedCount uint32
 }
@@ -3,9 +3,11 @@ package policy
 import (
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
 )
 
 // CompressionPolicy specifies compression policy.
@@ -15,8 +17,20 @@ type CompressionPolicy struct {
 	NeverCompress  []string         `json:"neverCompress,omitempty"`
 	MinSize        int64            `json:"minSize,omitempty"`
 	MaxSize        int64            `json:"maxSize,omitempty"`
+
+	// Adaptive stores chunks that are estimated to be incompressible or compress poorly without compression.
+	Adaptive *bool `json:"adaptive,omitempty"`
+
+	// MinSavingsPercent is the minimum reduction of chunk size for the compressed chunk to be stored in adaptive mode.
+	MinSavingsPercent *int `json:"minSavingsPercent,omitempty"`
+
+	// Dictionary is the ID of the dictionary used with zstd compressors, which also enables compression of directories.
+	Dictionary content.ID `json:"dictionary,omitempty"`
 }
 
+// compressor used for directories when a dictionary is set, unless zstd compressor is selected for files.
+const defaultDictionaryCompressor compression.Name = "zstd"
+
 // CompressorForFile returns compression name to be used for compressing a given file according to policy, using attributes such as name or size.
 func (p *CompressionPolicy) CompressorForFile(e fs.File) compression.Name {
 	ext := filepath.Ext(e.Name())
@@ -45,6 +59,33 @@ func (p *CompressionPolicy) CompressorForFile(e fs.File) compression.Name {
 	return p.CompressorName
 }
 
+// CompressorForDirectory returns compression name to be used for compressing directory objects, which are
+// only compressed using a dictionary.
+func (p *CompressionPolicy) CompressorForDirectory() compression.Name {
+	if p.Dictionary == "" {
+		return ""
+	}
+
+	if supportsDictionary(p.CompressorName) {
+		return p.CompressorName
+	}
+
+	return defaultDictionaryCompressor
+}
+
+// DictionaryFor returns the ID of the compression dictionary to be used with the provided compressor.
+func (p *CompressionPolicy) DictionaryFor(comp compression.Name) content.ID {
+	if !supportsDictionary(comp) {
+		return ""
+	}
+
+	return p.Dictionary
+}
+
+func supportsDictionary(comp compression.Name) bool {
+	return strings.HasPrefix(string(comp), "zstd")
+}
+
 // Merge applies default values from the provided policy.
 // nolint:gocritic
 func (p *CompressionPolicy) Merge(src CompressionPolicy) {
@@ -60,10 +101,43 @@ func (p *CompressionPolicy) Merge(src CompressionPolicy) {
 		p.MaxSize = src.MaxSize
 	}
 
+	if p.Dictionary == "" {
+		p.Dictionary = src.Dictionary
+	}
+
+	if p.Adaptive == nil && src.Adaptive != nil {
+		p.Adaptive = newBool(*src.Adaptive)
+	}
+
+	if p.MinSavingsPercent == nil && src.MinSavingsPercent != nil {
+		v := *src.MinSavingsPercent
+		p.MinSavingsPercent = &v
+	}
+
 	p.OnlyCompress = mergeStrings(p.OnlyCompress, src.OnlyCompress)
 	p.NeverCompress = mergeStrings(p.NeverCompress, src.NeverCompress)
 }
 
+// AdaptiveOrDefault returns the adaptive compression setting if it is set,
+// and returns the passed default if not.
+func (p *CompressionPolicy) AdaptiveOrDefault(def bool) bool {
+	if p.Adaptive == nil {
+		return def
+	}
+
+	return *p.Adaptive
+}
+
+// MinSavingsPercentOrDefault returns the minimum savings of adaptive compression if it is set,
+// and returns the passed default if not.
+func (p *CompressionPolicy) MinSavingsPercentOrDefault(def int) int {
+	if p.MinSavingsPercent == nil {
+		return def
+	}
+
+	return *p.MinSavingsPercent
+}
+
 var defaultCompressionPolicy = CompressionPolicy{
 	CompressorName: "none",
 }
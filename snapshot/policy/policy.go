@@ -19,6 +19,7 @@ type Policy struct {
 	ErrorHandlingPolicy ErrorHandlingPolicy `json:"errorHandling,omitempty"`
 	SchedulingPolicy    SchedulingPolicy    `json:"scheduling,omitempty"`
 	CompressionPolicy   CompressionPolicy   `json:"compression,omitempty"`
+	SplitterPolicy      SplitterPolicy      `json:"splitter,omitempty"`
 	NoParent            bool                `json:"noParent,omitempty"`
 }
 
@@ -63,6 +64,7 @@ func MergePolicies(policies []*Policy) *Policy {
 		merged.ErrorHandlingPolicy.Merge(p.ErrorHandlingPolicy)
 		merged.SchedulingPolicy.Merge(p.SchedulingPolicy)
 		merged.CompressionPolicy.Merge(p.CompressionPolicy)
+		merged.SplitterPolicy.Merge(p.SplitterPolicy)
 	}
 
 	// Merge default expiration policy.
@@ -71,6 +73,7 @@ func MergePolicies(policies []*Policy) *Policy {
 	merged.ErrorHandlingPolicy.Merge(defaultErrorHandlingPolicy)
 	merged.SchedulingPolicy.Merge(defaultSchedulingPolicy)
 	merged.CompressionPolicy.Merge(defaultCompressionPolicy)
+	merged.SplitterPolicy.Merge(defaultSplitterPolicy)
 
 	return &merged
 }
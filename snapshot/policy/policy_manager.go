@@ -125,6 +125,12 @@ func GetDefinedPolicy(ctx context.Context, rep *repo.Repository, si snapshot.Sou
 
 // SetPolicy sets the policy on a given source.
 func SetPolicy(ctx context.Context, rep *repo.Repository, si snapshot.SourceInfo, pol *Policy) error {
+	if n := pol.SplitterPolicy.SplitterName; n != "" {
+		if err := rep.ValidateSplitter(n); err != nil {
+			return errors.Wrap(err, "invalid splitter")
+		}
+	}
+
 	md, err := rep.Manifests.Find(ctx, labelsForSource(si))
 	if err != nil {
 		return errors.Wrapf(err, "unable to load manifests for %v", si)
@@ -202,6 +208,25 @@ func ListPolicies(ctx context.Context, rep *repo.Repository) ([]*Policy, error)
 	return policies, nil
 }
 
+// SetMaxPackSize changes the maximum size of packs written to the repository after ensuring that
+// it can hold chunks produced by splitters selected by all policies.
+func SetMaxPackSize(ctx context.Context, rep *repo.Repository, maxPackSize int) error {
+	policies, err := ListPolicies(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to list policies")
+	}
+
+	var splitters []string
+
+	for _, pol := range policies {
+		if n := pol.SplitterPolicy.SplitterName; n != "" {
+			splitters = append(splitters, n)
+		}
+	}
+
+	return rep.SetMaxPackSize(ctx, maxPackSize, splitters...)
+}
+
 // SubdirectoryPolicyMap implements Getter for a static mapping of relative paths to Policy for subdirectories
 type SubdirectoryPolicyMap map[string]*Policy
 
@@ -0,0 +1,46 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+func TestSplitterPolicyMustFitPacks(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	if err := env.Repository.SetMaxPackSize(ctx, 4<<20); err != nil {
+		t.Fatalf("unable to set max pack size: %v", err)
+	}
+
+	env.MustReopen(t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/some/path"}
+
+	if err := policy.SetPolicy(ctx, env.Repository, si, &policy.Policy{
+		SplitterPolicy: policy.SplitterPolicy{SplitterName: "FIXED-8M"},
+	}); err == nil {
+		t.Fatalf("expected error when setting policy with splitter producing chunks larger than packs")
+	}
+
+	if err := policy.SetPolicy(ctx, env.Repository, si, &policy.Policy{
+		SplitterPolicy: policy.SplitterPolicy{SplitterName: "FIXED-4M"},
+	}); err != nil {
+		t.Fatalf("unable to set policy: %v", err)
+	}
+
+	// repository splitter produces 1MB segments, but the policy splitter needs 4MB packs.
+	if err := policy.SetMaxPackSize(ctx, env.Repository, 2<<20); err == nil {
+		t.Fatalf("expected error when setting pack size smaller than segments of policy splitter")
+	}
+
+	if err := policy.SetMaxPackSize(ctx, env.Repository, 8<<20); err != nil {
+		t.Fatalf("unable to set max pack size: %v", err)
+	}
+}
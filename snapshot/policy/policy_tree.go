@@ -7,6 +7,7 @@ var DefaultPolicy = &Policy{
 	FilesPolicy:         defaultFilesPolicy,
 	RetentionPolicy:     defaultRetentionPolicy,
 	CompressionPolicy:   defaultCompressionPolicy,
+	SplitterPolicy:      defaultSplitterPolicy,
 	ErrorHandlingPolicy: defaultErrorHandlingPolicy,
 	SchedulingPolicy:    defaultSchedulingPolicy,
 }
@@ -104,6 +104,33 @@ func (r *RetentionPolicy) getRetentionReasons(i int, s *snapshot.Manifest, cutof
 	return keepReasons
 }
 
+// MaxRetentionPeriod returns the maximum age of a snapshot that can be retained by the policy
+// based on its time-based settings (hourly, daily, weekly, monthly and annual).
+func (r *RetentionPolicy) MaxRetentionPeriod() time.Duration {
+	var result time.Duration
+
+	for _, c := range []struct {
+		setting *int
+		period  time.Duration
+	}{
+		{r.KeepHourly, time.Hour},
+		{r.KeepDaily, 24 * time.Hour},        //nolint:gomnd
+		{r.KeepWeekly, 7 * 24 * time.Hour},   //nolint:gomnd
+		{r.KeepMonthly, 31 * 24 * time.Hour}, //nolint:gomnd
+		{r.KeepAnnual, 366 * 24 * time.Hour}, //nolint:gomnd
+	} {
+		if c.setting == nil {
+			continue
+		}
+
+		if d := time.Duration(*c.setting) * c.period; d > result {
+			result = d
+		}
+	}
+
+	return result
+}
+
 type cutoffTimes struct {
 	annual  time.Time
 	monthly time.Time
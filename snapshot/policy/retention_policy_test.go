@@ -0,0 +1,28 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxRetentionPeriod(t *testing.T) {
+	const day = 24 * time.Hour
+
+	cases := []struct {
+		policy RetentionPolicy
+		want   time.Duration
+	}{
+		{RetentionPolicy{}, 0},
+		{RetentionPolicy{KeepLatest: intPtr(100)}, 0},
+		{RetentionPolicy{KeepHourly: intPtr(48)}, 48 * time.Hour},
+		{RetentionPolicy{KeepHourly: intPtr(48), KeepDaily: intPtr(7)}, 7 * day},
+		{RetentionPolicy{KeepDaily: intPtr(30), KeepWeekly: intPtr(2)}, 30 * day},
+		{defaultRetentionPolicy, 3 * 366 * day},
+	}
+
+	for _, tc := range cases {
+		if got := tc.policy.MaxRetentionPeriod(); got != tc.want {
+			t.Errorf("invalid max retention period of %v: %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}
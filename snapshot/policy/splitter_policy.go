@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"path/filepath"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// SplitterPolicy specifies the splitter used to break files into chunks, overriding the one configured for the repository.
+type SplitterPolicy struct {
+	SplitterName    string   `json:"splitterName,omitempty"`
+	OnlyExtensions  []string `json:"onlyExtensions,omitempty"`
+	NeverExtensions []string `json:"neverExtensions,omitempty"`
+	MinSize         int64    `json:"minSize,omitempty"`
+	MaxSize         int64    `json:"maxSize,omitempty"`
+}
+
+// SplitterForFile returns the name of the splitter to be used for a given file according to policy, using attributes
+// such as name or size. Empty string indicates the repository splitter.
+func (p *SplitterPolicy) SplitterForFile(e fs.File) string {
+	ext := filepath.Ext(e.Name())
+	size := e.Size()
+
+	if p.SplitterName == "" {
+		return ""
+	}
+
+	if v := p.MinSize; v > 0 && size < v {
+		return ""
+	}
+
+	if v := p.MaxSize; v > 0 && size > v {
+		return ""
+	}
+
+	if len(p.OnlyExtensions) > 0 && !isInSortedSlice(ext, p.OnlyExtensions) {
+		return ""
+	}
+
+	if isInSortedSlice(ext, p.NeverExtensions) {
+		return ""
+	}
+
+	return p.SplitterName
+}
+
+// Merge applies default values from the provided policy.
+// nolint:gocritic
+func (p *SplitterPolicy) Merge(src SplitterPolicy) {
+	if p.SplitterName == "" {
+		p.SplitterName = src.SplitterName
+	}
+
+	if p.MinSize == 0 {
+		p.MinSize = src.MinSize
+	}
+
+	if p.MaxSize == 0 {
+		p.MaxSize = src.MaxSize
+	}
+
+	p.OnlyExtensions = mergeStrings(p.OnlyExtensions, src.OnlyExtensions)
+	p.NeverExtensions = mergeStrings(p.NeverExtensions, src.NeverExtensions)
+}
+
+var defaultSplitterPolicy = SplitterPolicy{}
@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/internal/mockfs"
+)
+
+func TestSplitterForFile(t *testing.T) {
+	dir := mockfs.NewDirectory()
+	small := dir.AddFile("small.vmdk", make([]byte, 10), 0644)
+	large := dir.AddFile("large.vmdk", make([]byte, 1000), 0644)
+	text := dir.AddFile("large.txt", make([]byte, 1000), 0644)
+
+	var p SplitterPolicy
+
+	p.Merge(SplitterPolicy{
+		SplitterName:   "FIXED-4M",
+		OnlyExtensions: []string{".iso", ".vmdk"},
+		MinSize:        100,
+	})
+
+	cases := []struct {
+		policy SplitterPolicy
+		file   *mockfs.File
+		want   string
+	}{
+		{SplitterPolicy{}, large, ""},
+		{p, large, "FIXED-4M"},
+		{p, small, ""},
+		{p, text, ""},
+		{SplitterPolicy{SplitterName: "FIXED-4M", NeverExtensions: []string{".txt"}}, large, "FIXED-4M"},
+		{SplitterPolicy{SplitterName: "FIXED-4M", NeverExtensions: []string{".txt"}}, text, ""},
+		{SplitterPolicy{SplitterName: "FIXED-4M", MaxSize: 100}, large, ""},
+		{SplitterPolicy{SplitterName: "FIXED-4M", MaxSize: 100}, small, "FIXED-4M"},
+	}
+
+	for i, tc := range cases {
+		tc := tc
+
+		if got := tc.policy.SplitterForFile(tc.file); got != tc.want {
+			t.Errorf("case %v: invalid splitter for %v: %q, want %q", i, tc.file.Name(), got, tc.want)
+		}
+	}
+}
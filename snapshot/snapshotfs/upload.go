@@ -50,10 +50,16 @@ type Uploader struct {
 	// Number of files to hash and upload in parallel.
 	ParallelUploads int
 
+	// abort the upload when free space in the storage falls below this number of bytes (0 = don't check)
+	MinFreeSpaceBytes int64
+
 	repo *repo.Repository
 
-	stats    snapshot.Stats
-	canceled int32
+	statsMutex    sync.Mutex // protects fields of stats that are not updated atomically by parallel uploads
+	stats         snapshot.Stats
+	canceled      int32
+	outOfSpace    int32
+	outOfSpaceErr error // valid when outOfSpace != 0
 
 	uploadBufPool sync.Pool
 }
@@ -68,6 +74,10 @@ func (u *Uploader) cancelReason() string {
 		return "canceled"
 	}
 
+	if atomic.LoadInt32(&u.outOfSpace) != 0 {
+		return "insufficient storage space"
+	}
+
 	_, wb := u.repo.Content.Stats.WrittenContent()
 	if mub := u.MaxUploadBytes; mub > 0 && wb > mub {
 		return "limit reached"
@@ -86,9 +96,16 @@ func (u *Uploader) uploadFileInternal(ctx context.Context, relativePath string,
 	}
 	defer file.Close() //nolint:errcheck
 
+	comp := pol.CompressionPolicy.CompressorForFile(f)
+
 	writer := u.repo.Objects.NewWriter(ctx, object.WriterOptions{
 		Description: "FILE:" + f.Name(),
-		Compressor:  pol.CompressionPolicy.CompressorForFile(f),
+		Compressor:  comp,
+		Splitter:    pol.SplitterPolicy.SplitterForFile(f),
+
+		CompressionDictionary:        pol.CompressionPolicy.DictionaryFor(comp),
+		AdaptiveCompression:          pol.CompressionPolicy.AdaptiveOrDefault(false),
+		MinCompressionSavingsPercent: pol.CompressionPolicy.MinSavingsPercent,
 	})
 	defer writer.Close() //nolint:errcheck
 
@@ -107,6 +124,12 @@ func (u *Uploader) uploadFileInternal(ctx context.Context, relativePath string,
 		return nil, err
 	}
 
+	if cs, ok := writer.(object.CompressionStatsReporter); ok {
+		u.statsMutex.Lock()
+		u.stats.AddCompressionStats(cs.CompressionStats())
+		u.statsMutex.Unlock()
+	}
+
 	de, err := newDirEntry(fi2, r)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create dir entry")
@@ -172,6 +195,10 @@ func (u *Uploader) copyWithProgress(dst io.Writer, src io.Reader, completed, len
 				completed += int64(wroteBytes)
 				u.Progress.HashedBytes(int64(wroteBytes))
 
+				if qp, ok := u.Progress.(PackUploadQueueProgress); ok {
+					qp.PackUploadQueue(u.repo.Content.Stats.PackUploadQueue())
+				}
+
 				if length < completed {
 					length = completed
 				}
@@ -639,9 +666,13 @@ func uploadDirInternal(
 
 	// at this point dirManifest is ready to go
 
+	compressionPolicy := policyTree.EffectivePolicy().CompressionPolicy
+
 	writer := u.repo.Objects.NewWriter(ctx, object.WriterOptions{
-		Description: "DIR:" + dirRelativePath,
-		Prefix:      "k",
+		Description:           "DIR:" + dirRelativePath,
+		Prefix:                "k",
+		Compressor:            compressionPolicy.CompressorForDirectory(),
+		CompressionDictionary: compressionPolicy.Dictionary,
 	})
 
 	defer writer.Close() //nolint:errcheck
@@ -745,6 +776,15 @@ func (u *Uploader) Upload(
 		}
 	}
 
+	atomic.StoreInt32(&u.outOfSpace, 0)
+
+	if err := u.checkFreeSpace(ctx); err != nil {
+		return nil, err
+	}
+
+	stopMonitoring := u.monitorFreeSpace(ctx)
+	defer stopMonitoring()
+
 	u.Progress.UploadStarted(maxPreviousFileCount, maxPreviousTotalFileSize)
 	defer u.Progress.UploadFinished()
 
@@ -780,6 +820,10 @@ func (u *Uploader) Upload(
 		return nil, err
 	}
 
+	if atomic.LoadInt32(&u.outOfSpace) != 0 {
+		return nil, u.outOfSpaceErr
+	}
+
 	s.IncompleteReason = u.cancelReason()
 	s.EndTime = u.repo.Time()
 	s.Stats = u.stats
@@ -0,0 +1,78 @@
+package snapshotfs
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// ErrInsufficientStorageSpace is returned by Upload when free space in the storage falls below Uploader.MinFreeSpaceBytes.
+var ErrInsufficientStorageSpace = errors.New("insufficient free space in the storage")
+
+// freeSpaceCheckInterval is the interval between free space checks during upload.
+var freeSpaceCheckInterval = 30 * time.Second
+
+// checkFreeSpace returns ErrInsufficientStorageSpace if the storage reports less free space than required.
+// Storage that does not report capacity and transient errors are ignored.
+func (u *Uploader) checkFreeSpace(ctx context.Context) error {
+	if u.MinFreeSpaceBytes <= 0 {
+		return nil
+	}
+
+	c, err := blob.GetCapacity(ctx, u.repo.Blobs)
+	if err == blob.ErrCapacityNotSupported {
+		return nil
+	}
+
+	if err != nil {
+		log(ctx).Warningf("unable to determine storage capacity: %v", err)
+		return nil
+	}
+
+	if c.FreeB < uint64(u.MinFreeSpaceBytes) {
+		return errors.Wrapf(ErrInsufficientStorageSpace, "%v available, %v required",
+			units.BytesStringBase10(int64(c.FreeB)),
+			units.BytesStringBase10(u.MinFreeSpaceBytes))
+	}
+
+	return nil
+}
+
+// monitorFreeSpace periodically checks free space in the storage and cancels the upload when it's insufficient.
+// The returned function stops monitoring.
+func (u *Uploader) monitorFreeSpace(ctx context.Context) func() {
+	if u.MinFreeSpaceBytes <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(freeSpaceCheckInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+
+			case <-ticker.C:
+				if err := u.checkFreeSpace(ctx); err != nil {
+					log(ctx).Warningf("aborting upload: %v", err)
+
+					u.outOfSpaceErr = err
+					atomic.StoreInt32(&u.outOfSpace, 1)
+
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
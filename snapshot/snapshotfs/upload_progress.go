@@ -68,6 +68,13 @@ func (p *NullUploadProgress) FinishedDirectory(dirname string) {}
 
 var _ UploadProgress = (*NullUploadProgress)(nil)
 
+// PackUploadQueueProgress is an optional interface that can be implemented by UploadProgress
+// to receive the state of background pack uploads.
+type PackUploadQueueProgress interface {
+	// PackUploadQueue is emitted periodically with the number and total size of packs waiting to be uploaded.
+	PackUploadQueue(numPacks int32, numBytes int64)
+}
+
 // UploadCounters represents a snapshot of upload counters.
 type UploadCounters struct {
 	TotalCachedBytes int64 `json:"cachedBytes"`
@@ -3,6 +3,7 @@ package snapshotfs
 import (
 	"context"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -13,6 +14,7 @@ import (
 	"github.com/kopia/kopia/internal/mockfs"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/blob/filesystem"
 	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
@@ -268,3 +270,29 @@ func TestUpload_SymlinkBecameDirectory(t *testing.T) {
 
 func TestUpload_SymlinkBecameFile(t *testing.T) {
 }
+
+func TestUploadInsufficientFreeSpace(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	if _, err := blob.GetCapacity(ctx, th.repo.Blobs); err != nil {
+		t.Skipf("storage capacity not available: %v", err)
+	}
+
+	policyTree := policy.BuildTree(nil, policy.DefaultPolicy)
+
+	u := NewUploader(th.repo)
+	u.MinFreeSpaceBytes = math.MaxInt64
+
+	if _, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{}); errors.Cause(err) != ErrInsufficientStorageSpace {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	u.MinFreeSpaceBytes = 1
+
+	if _, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
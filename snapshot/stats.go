@@ -2,6 +2,7 @@ package snapshot
 
 import (
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo/object"
 )
 
 // Stats keeps track of snapshot generation statistics.
@@ -18,6 +19,12 @@ type Stats struct {
 	NonCachedFiles int32 `json:"nonCachedFiles"`
 
 	ReadErrors int `json:"readErrors"`
+
+	// compression of uploaded file contents
+	CompressedBytes         int64 `json:"compressedBytes"`
+	CompressedSize          int64 `json:"compressedSize"`
+	IncompressibleBytes     int64 `json:"incompressibleBytes"`
+	CompressionSkippedBytes int64 `json:"compressionSkippedBytes"`
 }
 
 // AddExcluded adds the information about excluded file to the statistics.
@@ -29,3 +36,11 @@ func (s *Stats) AddExcluded(md fs.Entry) {
 		s.ExcludedTotalFileSize += md.Size()
 	}
 }
+
+// AddCompressionStats adds the information about compression of a file to the statistics.
+func (s *Stats) AddCompressionStats(cs object.CompressionStats) {
+	s.CompressedBytes += cs.CompressedBytes
+	s.CompressedSize += cs.CompressedSize
+	s.IncompressibleBytes += cs.IncompressibleBytes
+	s.CompressionSkippedBytes += cs.SkippedBytes
+}